@@ -0,0 +1,334 @@
+package runcore
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Replies, reactions, edits and deletions. A reply is a normal message with the parent's
+// message ID in FIELD_THREAD (as used by Sideband), so every LXMF client receives it.
+// Reactions, edits and deletion requests have no LXMF field of their own; they are control
+// messages (see control.go) sent only to peers advertising CapMessageActions. All of them
+// are applied to the message store and reported through SetMessageEventHandler.
+
+const (
+	// CapMessageActions: accepts reaction, edit and delete control messages.
+	CapMessageActions = "message_actions"
+
+	reactionType      = "runcore.reaction"
+	editType          = "runcore.edit"
+	deleteRequestType = "runcore.delete"
+
+	maxReactionLen       = 32 // bytes; one emoji, possibly with modifiers
+	maxReactionsPerEmoji = 256
+	maxMessageEditLen    = 32 << 10
+	maxMessageEdits      = 32
+)
+
+func init() { registerCapability(CapMessageActions) }
+
+// ErrPeerUnsupported is returned when a peer does not advertise the capability an
+// operation needs.
+var ErrPeerUnsupported = errors.New("not supported by peer")
+
+// Message event kinds.
+const (
+	MessageEventReply    = "reply"
+	MessageEventReaction = "reaction"
+	MessageEventEdit     = "edit"
+	MessageEventDelete   = "delete"
+)
+
+// MessageEvent reports a reply, reaction, edit or deletion received from a peer.
+// MessageIDHex is the new reply for "reply" and the affected message otherwise.
+type MessageEvent struct {
+	Kind               string `json:"kind"`
+	DestinationHashHex string `json:"destination_hash_hex"`
+	MessageIDHex       string `json:"message_id_hex"`
+	ActorHashHex       string `json:"actor_hash_hex,omitempty"`
+	ReplyToHex         string `json:"reply_to,omitempty"`
+	Emoji              string `json:"emoji,omitempty"`
+	Removed            bool   `json:"removed,omitempty"`
+	Title              string `json:"title,omitempty"`
+	Content            string `json:"content,omitempty"`
+	At                 int64  `json:"at,omitempty"`
+}
+
+// SetMessageEventHandler sets the callback for message events. It runs on a router
+// goroutine and must not block for long.
+func (n *Node) SetMessageEventHandler(cb func(MessageEvent)) {
+	n.stateMu.Lock()
+	n.onMessageEvent = cb
+	n.stateMu.Unlock()
+}
+
+func (n *Node) emitMessageEvent(ev MessageEvent) {
+	n.stateMu.RLock()
+	cb := n.onMessageEvent
+	n.stateMu.RUnlock()
+	if cb != nil {
+		cb(ev)
+	}
+}
+
+// SendReply sends msg as a reply to a message of the conversation with destinationHashHex.
+func (n *Node) SendReply(destinationHashHex, replyToIDHex string, msg SendOptions) (*lxmf.LXMessage, error) {
+	replyTo := strings.ToLower(strings.TrimSpace(replyToIDHex))
+	if !validHashHex(replyTo, lxmfMessageIDLen) {
+		return nil, errors.New("invalid message id")
+	}
+	id, _ := hex.DecodeString(replyTo)
+	fields := make(map[any]any, len(msg.Fields)+1)
+	for k, v := range msg.Fields {
+		fields[k] = v
+	}
+	fields[lxmf.FieldThread] = id
+	msg.Fields = fields
+	return n.SendHex(destinationHashHex, msg)
+}
+
+// React adds (or with remove, withdraws) an emoji reaction to a message of the
+// conversation with destinationHashHex.
+func (n *Node) React(destinationHashHex, messageIDHex, emoji string, remove bool) error {
+	destHex, idHex, err := n.actionTarget(destinationHashHex, messageIDHex)
+	if err != nil {
+		return err
+	}
+	emoji, ok := wireText(emoji, maxReactionLen)
+	if !ok || emoji == "" {
+		return errors.New("invalid reaction")
+	}
+	id, _ := hex.DecodeString(idHex)
+	data := map[any]any{"id": id, "e": emoji}
+	if remove {
+		data["r"] = true
+	}
+	if err := n.sendControl(destHex, reactionType, data); err != nil {
+		return err
+	}
+	n.updateMessage(destHex, idHex, func(m *StoredMessage) bool {
+		return applyReaction(m, n.DestinationHashHex(), emoji, remove)
+	})
+	return nil
+}
+
+// EditMessage replaces the content of a message we sent to destinationHashHex. The
+// previous version is kept in the message's edit history.
+func (n *Node) EditMessage(destinationHashHex, messageIDHex, content string) error {
+	destHex, idHex, err := n.actionTarget(destinationHashHex, messageIDHex)
+	if err != nil {
+		return err
+	}
+	if len(content) > maxMessageEditLen {
+		return errors.New("content too long")
+	}
+	if err := n.ownMessage(destHex, idHex); err != nil {
+		return err
+	}
+	id, _ := hex.DecodeString(idHex)
+	now := time.Now().Unix()
+	if err := n.sendControl(destHex, editType, map[any]any{"id": id, "c": content, "u": now}); err != nil {
+		return err
+	}
+	n.updateMessage(destHex, idHex, func(m *StoredMessage) bool { return applyEdit(m, content, now) })
+	return nil
+}
+
+// DeleteMessage deletes a message we sent to destinationHashHex from the store and asks
+// the peer to delete its copy. Peers may ignore the request.
+func (n *Node) DeleteMessage(destinationHashHex, messageIDHex string) error {
+	destHex, idHex, err := n.actionTarget(destinationHashHex, messageIDHex)
+	if err != nil {
+		return err
+	}
+	if err := n.ownMessage(destHex, idHex); err != nil {
+		return err
+	}
+	id, _ := hex.DecodeString(idHex)
+	if err := n.sendControl(destHex, deleteRequestType, map[any]any{"id": id, "u": time.Now().Unix()}); err != nil {
+		return err
+	}
+	n.updateMessage(destHex, idHex, applyDelete)
+	n.removeStoredAttachments(destHex, idHex)
+	return nil
+}
+
+// actionTarget validates the arguments of React, EditMessage and DeleteMessage and checks
+// that the peer accepts message actions.
+func (n *Node) actionTarget(destinationHashHex, messageIDHex string) (string, string, error) {
+	if n == nil {
+		return "", "", errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	idHex := strings.ToLower(strings.TrimSpace(messageIDHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return "", "", errors.New("invalid destination hash")
+	}
+	if !validHashHex(idHex, lxmfMessageIDLen) {
+		return "", "", errors.New("invalid message id")
+	}
+	info, err := n.contactInfo(nil, destHex)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.Supports(CapMessageActions) {
+		return "", "", ErrPeerUnsupported
+	}
+	return destHex, idHex, nil
+}
+
+// ownMessage checks that idHex is a message we sent to destHex.
+func (n *Node) ownMessage(destHex, idHex string) error {
+	m, ok := n.StoredMessageHex(destHex, idHex)
+	if !ok {
+		return errors.New("unknown message")
+	}
+	if !m.Outgoing {
+		return errors.New("not our message")
+	}
+	if m.Deleted {
+		return errors.New("message deleted")
+	}
+	return nil
+}
+
+func applyReaction(m *StoredMessage, actorHex, emoji string, remove bool) bool {
+	actors := m.Reactions[emoji]
+	for i, a := range actors {
+		if a != actorHex {
+			continue
+		}
+		if !remove {
+			return false
+		}
+		actors = append(actors[:i:i], actors[i+1:]...)
+		if len(actors) == 0 {
+			delete(m.Reactions, emoji)
+		} else {
+			m.Reactions[emoji] = actors
+		}
+		return true
+	}
+	if remove || len(actors) >= maxReactionsPerEmoji {
+		return false
+	}
+	if m.Reactions == nil {
+		m.Reactions = make(map[string][]string)
+	}
+	m.Reactions[emoji] = append(actors, actorHex)
+	return true
+}
+
+func applyEdit(m *StoredMessage, content string, at int64) bool {
+	if m.Deleted || at < m.Edited || content == m.Content {
+		return false
+	}
+	m.Edits = append(m.Edits, MessageEdit{Title: m.Title, Content: m.Content, At: max(m.Edited, m.Timestamp)})
+	if len(m.Edits) > maxMessageEdits {
+		m.Edits = m.Edits[len(m.Edits)-maxMessageEdits:]
+	}
+	m.Content = content
+	m.Edited = at
+	return true
+}
+
+func applyDelete(m *StoredMessage) bool {
+	if m.Deleted {
+		return false
+	}
+	m.Deleted = true
+	m.Title, m.Content, m.Edits, m.Attachments = "", "", nil, nil
+	return true
+}
+
+// handleReaction applies a peer's reaction to a message of our conversation with it.
+func (n *Node) handleReaction(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	a, err := decodeMessageAction(raw)
+	if err != nil || a.Emoji == "" {
+		return
+	}
+	idHex := hex.EncodeToString(a.ID)
+	changed := false
+	if _, ok := n.updateMessage(srcHex, idHex, func(s *StoredMessage) bool {
+		changed = applyReaction(s, srcHex, a.Emoji, a.Remove)
+		return changed
+	}); !ok || !changed {
+		return
+	}
+	n.emitMessageEvent(MessageEvent{
+		Kind:               MessageEventReaction,
+		DestinationHashHex: srcHex,
+		MessageIDHex:       idHex,
+		ActorHashHex:       srcHex,
+		Emoji:              a.Emoji,
+		Removed:            a.Remove,
+		At:                 int64(m.Timestamp),
+	})
+}
+
+// handleEdit applies an edit to a message the peer sent us.
+func (n *Node) handleEdit(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	a, err := decodeMessageAction(raw)
+	if err != nil || !a.HasContent {
+		return
+	}
+	idHex := hex.EncodeToString(a.ID)
+	at := a.At
+	if at == 0 {
+		at = int64(m.Timestamp)
+	}
+	changed := false
+	edited, ok := n.updateMessage(srcHex, idHex, func(s *StoredMessage) bool {
+		// Only the sender of a message may edit it.
+		changed = !s.Outgoing && applyEdit(s, a.Content, at)
+		return changed
+	})
+	if !ok || !changed {
+		return
+	}
+	n.emitMessageEvent(MessageEvent{
+		Kind:               MessageEventEdit,
+		DestinationHashHex: srcHex,
+		MessageIDHex:       idHex,
+		ActorHashHex:       srcHex,
+		Title:              edited.Title,
+		Content:            edited.Content,
+		At:                 at,
+	})
+}
+
+// handleDeleteRequest deletes a message the peer sent us.
+func (n *Node) handleDeleteRequest(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	a, err := decodeMessageAction(raw)
+	if err != nil {
+		return
+	}
+	idHex := hex.EncodeToString(a.ID)
+	changed := false
+	if _, ok := n.updateMessage(srcHex, idHex, func(s *StoredMessage) bool {
+		changed = !s.Outgoing && applyDelete(s)
+		return changed
+	}); !ok || !changed {
+		return
+	}
+	n.removeStoredAttachments(srcHex, idHex)
+	rns.Logf(rns.LOG_DEBUG, "message store: %s deleted %s", srcHex, idHex)
+	n.emitMessageEvent(MessageEvent{
+		Kind:               MessageEventDelete,
+		DestinationHashHex: srcHex,
+		MessageIDHex:       idHex,
+		ActorHashHex:       srcHex,
+		At:                 a.At,
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/svanichkin/go-reticulum/rns"
-	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
 )
 
 type AnnounceEntry struct {
@@ -33,12 +32,24 @@ func (h *announceLogger) AspectFilter() string {
 	return h.aspectFilter
 }
 
+// ReceivePathResponses opts into path responses, so waiters wake when a requested path arrives.
+func (h *announceLogger) ReceivePathResponses() bool {
+	return true
+}
+
 func (h *announceLogger) ReceivedAnnounce(destinationHash []byte, announcedIdentity *rns.Identity, appData []byte) {
 	if h == nil || h.node == nil {
 		return
 	}
+	defer h.node.notifyDestination(destinationHash)
 	destHex := hex.EncodeToString(destinationHash)
-	displayName := announceDisplayName(appData)
+	h.node.forgetPushedProfile(destHex)
+	// Not counted: the handler also sees announces of other aspects with other app-data layouts.
+	data, err := parseAnnounceAppData(appData)
+	displayName := data.DisplayName
+	if err == nil {
+		h.node.refreshCachedAvatar(destHex, data)
+	}
 	h.node.recordAnnounce(AnnounceEntry{
 		DestinationHashHex: destHex,
 		DisplayName:        displayName,
@@ -102,26 +113,3 @@ func (n *Node) AnnouncesJSON() string {
 	}
 	return string(b)
 }
-
-func announceDisplayName(appData []byte) string {
-	if len(appData) == 0 {
-		return ""
-	}
-	// Mirror LXMF announce app-data parsing: msgpack([display_name_bytes, stamp_cost?, avatar?]).
-	var unpacked []any
-	if err := umsgpack.Unpackb(appData, &unpacked); err != nil {
-		return ""
-	}
-	if len(unpacked) == 0 {
-		return ""
-	}
-	switch v := unpacked[0].(type) {
-	case []byte:
-		if len(v) > 0 {
-			return string(v)
-		}
-	case string:
-		return v
-	}
-	return ""
-}
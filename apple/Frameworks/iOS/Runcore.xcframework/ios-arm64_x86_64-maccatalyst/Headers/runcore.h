@@ -20,7 +20,8 @@ typedef void (*runcore_inbound_cb)(
 );
 
 // Called on outbound message status updates.
-// `state` corresponds to lxmf.LXMessage.State (eg. 0x08 = delivered).
+// `state` corresponds to lxmf.LXMessage.State (eg. 0x08 = delivered), or 0x10 (read) when the
+// recipient sent a read receipt.
 // All strings are UTF-8, valid only for the duration of the call.
 typedef void (*runcore_message_status_cb)(
     void* user_data,
@@ -29,6 +30,41 @@ typedef void (*runcore_message_status_cb)(
     int32_t state
 );
 
+// Called once when an async request (runcore_*_async) completes, fails, times out or is cancelled.
+// `json` has the same shape as the matching *_json call plus "request_id" and, if cancelled,
+// "cancelled":true. Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_request_cb)(void* user_data, uint64_t request_id, const char* json);
+
+// Called when a cached contact avatar changes: a new hash was fetched (also by the automatic
+// refresh after an announce) or the contact cleared its avatar.
+// `json`: {"destination_hash_hex":"..","hash_hex":"..","mime":"..","path":"/abs/path","thumbnail":bool,"fetched":0,"cleared":bool}.
+// Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_avatar_updated_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+
+// Called when a contact's presence changes (typing started/stopped, came online, went offline).
+// `json`: {"destination_hash_hex":"..","online":bool,"typing":bool,"last_active":unix}.
+// Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_presence_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+
+// Called when a contact replies to a message, reacts, edits or deletes one of its messages.
+// `json`: {"kind":"reply|reaction|edit|delete","destination_hash_hex":"..","message_id_hex":"..",
+// "actor_hash_hex":"..","reply_to":"..","emoji":"..","removed":bool,"title":"..","content":"..","at":unix}
+// (fields present as relevant). Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_message_event_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+
+// Called on group events. `json`: {"kind":"invite|joined|updated|removed|message","group":{"id","name",
+// "admin","members":[..],"version","created"},"sender":"..","message_id_hex":"..","title":"..","content":".."}.
+// Group messages are reported here instead of runcore_inbound_cb.
+// Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_group_event_cb)(void* user_data, const char* group_id_hex, const char* json);
+
+// Called when telemetry (Sideband FIELD_TELEMETRY, or relayed by a collector in
+// FIELD_TELEMETRY_STREAM) arrives for a peer. `json`: {"destination_hash_hex":"..","time":unix,
+// "location":{"latitude","longitude","altitude","speed","bearing","accuracy","updated_at"},
+// "battery":{"charge_percent","charging","temperature"},"received_at":unix,"via_hex":".."}
+// (sensors present as received). Valid only for the duration of the call. Invoked on a library thread.
+typedef void (*runcore_telemetry_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+
 // Called for every internal log line. The line includes timestamp prefix.
 typedef void (*runcore_log_cb)(void* user_data, int32_t level, const char* line);
 
@@ -55,6 +91,21 @@ void runcore_set_inbound_cb(runcore_handle_t handle, runcore_inbound_cb cb, void
 // Set outbound message status callback. Pass NULL to disable.
 void runcore_set_message_status_cb(runcore_handle_t handle, runcore_message_status_cb cb, void* user_data);
 
+// Set contact avatar update callback. Pass NULL to disable.
+void runcore_set_avatar_updated_cb(runcore_handle_t handle, runcore_avatar_updated_cb cb, void* user_data);
+
+// Set/replace the presence callback for a running node. Pass NULL to disable.
+void runcore_set_presence_cb(runcore_handle_t handle, runcore_presence_cb cb, void* user_data);
+
+// Set/replace the message event callback for a running node. Pass NULL to disable.
+void runcore_set_message_event_cb(runcore_handle_t handle, runcore_message_event_cb cb, void* user_data);
+
+// Set/replace the group event callback for a running node. Pass NULL to disable.
+void runcore_set_group_event_cb(runcore_handle_t handle, runcore_group_event_cb cb, void* user_data);
+
+// Set/replace the telemetry callback for a running node. Pass NULL to disable.
+void runcore_set_telemetry_cb(runcore_handle_t handle, runcore_telemetry_cb cb, void* user_data);
+
 // Returns this node's LXMF delivery destination hash as hex (32 chars).
 // The returned pointer is owned by the library and remains valid until runcore_stop().
 const char* runcore_destination_hash_hex(runcore_handle_t handle);
@@ -67,6 +118,64 @@ int32_t runcore_send(runcore_handle_t handle, const char* dest_hash_hex, const c
 // The returned pointer must be freed with runcore_free_string().
 char* runcore_send_result_json(runcore_handle_t handle, const char* dest_hash_hex, const char* title, const char* content);
 
+// Like runcore_send_result_json, as a reply to `reply_to_msg_id_hex` (sent in FIELD_THREAD,
+// understood by Sideband). rc 6: invalid message id.
+char* runcore_send_reply_json(runcore_handle_t handle, const char* dest_hash_hex, const char* reply_to_msg_id_hex, const char* title, const char* content);
+
+// Like runcore_send_result_json, with a voice note in FIELD_AUDIO (as Sideband sends them):
+// `mode` is an LXMF audio mode (0x01-0x09 Codec2 raw frames, 0x10 Ogg Opus, 0x11-0x19 Opus),
+// sent with the direct method. Stored audio attachments carry "audio_mode", "mime" and
+// "duration_ms" in runcore_conversation_json(). rc 2: invalid arguments.
+char* runcore_send_audio_json(runcore_handle_t handle, const char* dest_hash_hex, int32_t mode, const unsigned char* data, int32_t data_len, const char* content);
+
+// Paper message: encrypt a message for `dest_hash_hex` (identity must be known) as an lxm://
+// URI and a PNG QR code of it, for offline transfer. The message is stored as sent with state 5.
+// Response: {"rc":0,"uri":"lxm://..","png_base64":".."} or {"rc":3,"error":".."} (eg too large).
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_encode_paper_message_json(runcore_handle_t handle, const char* dest_hash_hex, const char* title, const char* content);
+
+// Deliver a scanned/pasted lxm:// URI addressed to this node as an inbound message.
+// Returns 0 on success, 3 if invalid, not for this node or already received.
+int32_t runcore_ingest_paper_message(runcore_handle_t handle, const char* uri);
+
+// Contact card: this node's identity as an lxmf://<hash>?name=..&key=.. URI and a PNG QR code.
+// Response: {"rc":0,"uri":"lxmf://..","png_base64":"..","card":{"destination_hash_hex",
+// "display_name","public_key"}}. The returned pointer must be freed with runcore_free_string().
+char* runcore_contact_card_json(runcore_handle_t handle);
+
+// Verify a scanned/pasted contact card and remember its identity, so the contact can be
+// messaged without waiting for an announce. Response: {"rc":0,"card":{..}} or
+// {"rc":3,"error":".."} (malformed, or key and hash do not match).
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_import_contact_card_json(runcore_handle_t handle, const char* uri);
+
+// React to a message of the conversation with `dest_hash_hex` (remove != 0 withdraws it), edit
+// or delete a message we sent to it. Deletion asks the peer to delete its copy too.
+// Returns 0 on success, 3 for unknown/invalid messages, 4 if the peer does not support it.
+int32_t runcore_react(runcore_handle_t handle, const char* dest_hash_hex, const char* msg_id_hex, const char* emoji, int32_t remove);
+int32_t runcore_edit_message(runcore_handle_t handle, const char* dest_hash_hex, const char* msg_id_hex, const char* content);
+int32_t runcore_delete_message(runcore_handle_t handle, const char* dest_hash_hex, const char* msg_id_hex);
+
+// Stored messages of the conversation with `dest_hash_hex`, oldest first: at most `limit`
+// (0 = all), older than `before` (unix seconds, 0 = now). JSON array of
+// {"id","peer","outgoing","title","content","timestamp","state","reply_to","replies":[..],
+// "reactions":{"emoji":[dest_hash_hex..]},"edits":[{"title","content","at"}],"edited","deleted",
+// "attachments":[{"kind","name","format","file","path","size"}]}.
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_conversation_json(runcore_handle_t handle, const char* dest_hash_hex, int32_t limit, int64_t before);
+
+// One stored message (same JSON as the array items above), or NULL if unknown.
+char* runcore_message_json(runcore_handle_t handle, const char* dest_hash_hex, const char* msg_id_hex);
+
+// Export stored conversations to `dir` as "maildir", "jsonl" or "html". `peers_csv` lists
+// destination hashes / group IDs to export (NULL or "" = all).
+// Response: {"rc":0,"count":N} or {"rc":3,"error":".."}. Free with runcore_free_string().
+char* runcore_export_conversations_json(runcore_handle_t handle, const char* dir, const char* format, const char* peers_csv);
+
+// Import LXMF message files (comma-separated files or directories) into the message store.
+// Response: {"rc":0,"count":N} (messages added) or {"rc":3,"error":".."}. Free with runcore_free_string().
+char* runcore_import_messages_json(runcore_handle_t handle, const char* paths_csv);
+
 // Announce this node's delivery destination. Returns 0 on success.
 int32_t runcore_announce(runcore_handle_t handle);
 
@@ -87,6 +196,83 @@ int32_t runcore_set_avatar_png(runcore_handle_t handle, const unsigned char* png
 // Set profile avatar bytes with explicit mime (eg. "image/heic").
 int32_t runcore_set_avatar_image(runcore_handle_t handle, const char* mime, const unsigned char* data, int32_t data_len);
 
+// Mark a received message as read. If read receipts are enabled for its sender (and the sender
+// supports them), a receipt is sent shortly after. The message must have been received since
+// runcore_start(); use runcore_mark_read_from() otherwise. Returns 0 on success (also when no
+// receipt is sent because of privacy settings), 3 for unknown or invalid ids.
+int32_t runcore_mark_read(runcore_handle_t handle, const char* msg_id_hex);
+int32_t runcore_mark_read_from(runcore_handle_t handle, const char* src_hash_hex, const char* msg_id_hex);
+
+// Enable/disable sending read receipts (default off; persisted under config_dir). Returns 0 on success.
+int32_t runcore_set_read_receipts(runcore_handle_t handle, int32_t enabled);
+
+// Per-contact read receipt override: 0 = follow the global setting, 1 = always, 2 = never.
+// Persisted under config_dir. Returns 0 on success.
+int32_t runcore_set_contact_read_receipts(runcore_handle_t handle, const char* dest_hash_hex, int32_t policy);
+
+// Groups (at most 32 members). Create a group administered by this node and invite the
+// comma-separated destination hashes in `members_csv` (may be NULL).
+// Response: {"rc":0,"group":{..},"error":".."}; "error" lists invitations that failed.
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_create_group_json(runcore_handle_t handle, const char* name, const char* members_csv);
+
+// Membership. Invite/remove need the group admin; leaving as admin disbands the group.
+// Returns 0 on success, 3 on errors, 4 if the invitee does not support groups.
+int32_t runcore_invite_to_group(runcore_handle_t handle, const char* group_id_hex, const char* dest_hash_hex);
+int32_t runcore_accept_group_invite(runcore_handle_t handle, const char* group_id_hex);
+int32_t runcore_decline_group_invite(runcore_handle_t handle, const char* group_id_hex);
+int32_t runcore_leave_group(runcore_handle_t handle, const char* group_id_hex);
+int32_t runcore_remove_group_member(runcore_handle_t handle, const char* group_id_hex, const char* dest_hash_hex);
+
+// {"groups":[group..],"invites":[group..]}. Free with runcore_free_string().
+char* runcore_groups_json(runcore_handle_t handle);
+
+// Send a message to every other member. Response: {"rc":0,"message_id":"..","message_ids":[..],
+// "error":".."}: message_id is the group-level ID the message is stored under, message_ids the
+// LXMF IDs of the copies sent (rc 2 if no member could be sent to). Stored messages of a group: runcore_conversation_json()
+// with the group ID. Free with runcore_free_string().
+char* runcore_send_group_json(runcore_handle_t handle, const char* group_id_hex, const char* title, const char* content);
+
+// Telemetry compatible with Sideband. `telemetry_json` is {"time","location","battery"} as in
+// runcore_telemetry_cb. runcore_set_telemetry_json() sets what is sent to contacts allowed with
+// runcore_set_contact_telemetry_access() when they request it (nobody by default; NULL clears,
+// not persisted). runcore_send_telemetry_json() sends it to a contact unasked,
+// runcore_request_telemetry() asks a contact for theirs (the answer arrives at runcore_telemetry_cb).
+// Telemetry streams (other peers' telemetry relayed by a collector) are only accepted from the
+// collector set with runcore_set_telemetry_collector() (NULL clears, persisted) or in answer to a
+// request. Return 0 on success, 2 on invalid arguments, 3 on errors.
+int32_t runcore_set_telemetry_json(runcore_handle_t handle, const char* telemetry_json);
+int32_t runcore_send_telemetry_json(runcore_handle_t handle, const char* dest_hash_hex, const char* telemetry_json);
+int32_t runcore_request_telemetry(runcore_handle_t handle, const char* dest_hash_hex);
+int32_t runcore_set_contact_telemetry_access(runcore_handle_t handle, const char* dest_hash_hex, int32_t allowed);
+int32_t runcore_set_telemetry_collector(runcore_handle_t handle, const char* dest_hash_hex);
+
+// {"peers":[last telemetry of each peer, newest first],"allowed":[dest_hash_hex..],"collector":".."}.
+// Free with runcore_free_string().
+char* runcore_telemetry_json(runcore_handle_t handle);
+
+// Presence over a link to the contact's runcore.profile destination (contacts without the
+// "presence" capability are skipped; returns 0). runcore_open_presence() while a chat is open
+// keeps the contact informed that we are online; the link is closed after 2 minutes without
+// runcore_open_presence()/runcore_set_typing() calls, or by runcore_close_presence().
+// runcore_set_typing() is rate limited; call it on every edit and with typing=0 on send/clear.
+int32_t runcore_open_presence(runcore_handle_t handle, const char* dest_hash_hex);
+int32_t runcore_close_presence(runcore_handle_t handle, const char* dest_hash_hex);
+int32_t runcore_set_typing(runcore_handle_t handle, const char* dest_hash_hex, int32_t typing);
+
+// Last known presence of a contact (same JSON as runcore_presence_cb).
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_presence_json(runcore_handle_t handle, const char* dest_hash_hex);
+
+// Enable/disable profile push (default off): after a display name, avatar or profile change,
+// send a small control message to contacts messaged within the last 7 days that support it,
+// so they learn about the change even if they miss announces. Returns 0 on success.
+int32_t runcore_set_profile_push(runcore_handle_t handle, int32_t enabled);
+
+// Set profile avatar plus a thumbnail (at most 64px / 64 KiB) for formats runcore cannot decode
+// (eg HEIC). For PNG/JPEG avatars runcore generates the thumbnail itself; thumb_data may be NULL.
+int32_t runcore_set_avatar_image_with_thumbnail(runcore_handle_t handle, const char* mime, const unsigned char* data, int32_t data_len, const char* thumb_mime, const unsigned char* thumb_data, int32_t thumb_len);
+
 // Clear profile avatar. Returns 0 on success.
 int32_t runcore_clear_avatar(runcore_handle_t handle);
 
@@ -118,17 +304,51 @@ char* runcore_configured_interfaces_json(runcore_handle_t handle);
 // The returned pointer must be freed with runcore_free_string().
 char* runcore_announces_json(runcore_handle_t handle);
 
+// Returns JSON with counts of rejected (malformed or oversized) peer input per decoder.
+// Response: {"decode_errors":{"announce_app_data":0,"avatar_request":0,...}}.
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_wire_stats_json(runcore_handle_t handle);
+
+// Set this node's extended profile (served over /profile, hash advertised in announces).
+// `profile_json`: {"status":"..","bio":"..","pronouns":"..","links":[{"label":"..","url":".."}]}.
+// NULL or an empty profile clears it. Returns 0 on success, 2 on bad JSON, 3 if a field is too long.
+int32_t runcore_set_profile_json(runcore_handle_t handle, const char* profile_json);
+
+// Returns this node's extended profile as JSON (same shape as runcore_set_profile_json plus "updated").
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_profile_json(runcore_handle_t handle);
+
+// Returns JSON with a contact's extended profile. timeout_ms <= 0 only reads the local cache;
+// otherwise the profile is fetched when the announced profile hash differs from the cached one.
+// Response: {"status":"..","bio":"..","pronouns":"..","links":[...],"updated":0,"hash_hex":"..","fetched":0,"not_present":bool,"error":".."}.
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_contact_profile_json(runcore_handle_t handle, const char* dest_hash_hex, int32_t timeout_ms);
+
 // Returns JSON with best-effort contact info for `dest_hash_hex` (32 hex chars).
-// Response: {"display_name":"...", "avatar":{...}?, "error":"..."}.
+// Response: {"display_name":"...", "avatar":{...}?, "protocol":1, "capabilities":["avatar",...]?, "profile_hash_hex":".."?, "error":"..."}.
+// protocol 0 means a legacy runcore peer or a plain LXMF client; check capabilities before
+// using runcore-only features (avatar, attachments, ...).
 // The returned pointer must be freed with runcore_free_string().
 char* runcore_contact_info_json(runcore_handle_t handle, const char* dest_hash_hex, int32_t timeout_ms);
 
 // Returns JSON with best-effort contact avatar for `dest_hash_hex` (32 hex chars).
 // Request: known_avatar_hash_hex may be NULL/empty to always fetch.
-// Response: {"hash_hex":"..","png_base64":"..","unchanged":bool,"not_present":bool,"error":".."}.
+// Response: {"hash_hex":"..","png_base64":"..","mime":"..","thumbnail":bool,"unchanged":bool,"not_present":bool,"error":".."}.
 // The returned pointer must be freed with runcore_free_string().
 char* runcore_contact_avatar_json(runcore_handle_t handle, const char* dest_hash_hex, const char* known_avatar_hash_hex, int32_t timeout_ms);
 
+// Returns the path of the cached avatar for `dest_hash_hex` (full image if cached, else the
+// thumbnail), or NULL if none is cached. Never touches the network. Avatars fetched with
+// runcore_contact_avatar_* are cached under config_dir/avatars and refreshed automatically
+// when the contact announces a new one.
+// The returned pointer must be freed with runcore_free_string().
+char* runcore_contact_avatar_path(runcore_handle_t handle, const char* dest_hash_hex);
+
+// Like runcore_contact_avatar_json but asks for the 64px thumbnail (cheap on slow links).
+// Peers without a thumbnail send the full image ("thumbnail":false).
+// known_thumb_hash_hex is avatar.thumb_hash_hex from runcore_contact_info_json (may be NULL/empty).
+char* runcore_contact_avatar_thumbnail_json(runcore_handle_t handle, const char* dest_hash_hex, const char* known_thumb_hash_hex, int32_t timeout_ms);
+
 // Store an outgoing attachment payload on disk and return JSON with hash_hex.
 // Response: {"rc":0,"hash_hex":"..","mime":"..","name":"..","size":123,"updated":1700000000,"error":".."}.
 // The returned pointer must be freed with runcore_free_string().
@@ -139,6 +359,22 @@ char* runcore_store_attachment_json(runcore_handle_t handle, const char* mime, c
 // The returned pointer must be freed with runcore_free_string().
 char* runcore_contact_attachment_json(runcore_handle_t handle, const char* dest_hash_hex, const char* attachment_hash_hex, int32_t timeout_ms);
 
+// Async variants of the blocking calls above. They return immediately with a request id
+// (0 on failure) and report the result through `cb`. timeout_ms <= 0 means no timeout.
+// Cancelling tears down links and in-flight transfers. runcore_stop() cancels pending requests.
+uint64_t runcore_contact_info_async(runcore_handle_t handle, const char* dest_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+uint64_t runcore_contact_avatar_async(runcore_handle_t handle, const char* dest_hash_hex, const char* known_avatar_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+uint64_t runcore_contact_avatar_thumbnail_async(runcore_handle_t handle, const char* dest_hash_hex, const char* known_thumb_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+uint64_t runcore_contact_profile_async(runcore_handle_t handle, const char* dest_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+uint64_t runcore_contact_attachment_async(runcore_handle_t handle, const char* dest_hash_hex, const char* attachment_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+
+// Wait for a destination's identity (requests a path if needed).
+// Response: {"known":bool,"error":"..","request_id":123}.
+uint64_t runcore_wait_for_identity_async(runcore_handle_t handle, const char* dest_hash_hex, int32_t timeout_ms, runcore_request_cb cb, void* user_data);
+
+// Cancel an async request. Returns 0 if it was pending, 1 if unknown or already finished.
+int32_t runcore_cancel(uint64_t request_id);
+
 
 // Enable/disable an interface by config section name (eg "Default Interface").
 // Returns 0 on success.
@@ -1,6 +1,7 @@
 package runcore
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -166,17 +167,10 @@ func (n *Node) registerAttachmentRequestHandler(dest *rns.Destination) error {
 			if remoteIdentity != nil {
 				remoteHex = remoteIdentity.HexHash
 			}
-			var reqHash []byte
-			if m, ok := data.(map[any]any); ok {
-				if hv, ok := m["h"]; ok {
-					if b, ok := hv.([]byte); ok && len(b) > 0 {
-						reqHash = append([]byte(nil), b...)
-					}
-				}
-			}
-			if len(reqHash) == 0 {
-				rns.Logf(rns.LOG_NOTICE, "attachment req: missing hash remote=%s", remoteHex)
-				return map[any]any{"ok": false, "error": "missing hash"}
+			reqHash, err := decodeAttachmentRequest(data)
+			if err != nil {
+				rns.Logf(rns.LOG_NOTICE, "attachment req: rejected remote=%s err=%v", remoteHex, err)
+				return map[any]any{"ok": false, "error": "bad request"}
 			}
 			hashHex := hex.EncodeToString(reqHash)
 			info, bytes, err := n.loadOutgoingAttachmentByHashHex(hashHex)
@@ -213,11 +207,22 @@ func (n *Node) registerAttachmentRequestHandler(dest *rns.Destination) error {
 }
 
 func (n *Node) ContactAttachmentPathHex(destinationHashHex, attachmentHashHex string, timeout time.Duration) (AttachmentFetch, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.ContactAttachmentPathHexContext(ctx, destinationHashHex, attachmentHashHex)
+}
+
+// ContactAttachmentPathHexContext fetches (or returns the cached) attachment until ctx is done.
+// Cancelling ctx tears down the link and discards a partially written cache file.
+func (n *Node) ContactAttachmentPathHexContext(ctx context.Context, destinationHashHex, attachmentHashHex string) (AttachmentFetch, error) {
 	if n == nil || n.identity == nil {
 		return AttachmentFetch{}, errors.New("node not started")
 	}
-	if timeout <= 0 {
-		timeout = 10 * time.Second
+	if ctx == nil {
+		ctx = context.Background()
 	}
 	remote := strings.ToLower(strings.TrimSpace(destinationHashHex))
 	hashHex := strings.ToLower(strings.TrimSpace(attachmentHashHex))
@@ -234,7 +239,7 @@ func (n *Node) ContactAttachmentPathHex(destinationHashHex, attachmentHashHex st
 	}
 
 	// Self-hit: allow loopback by using local outgoing attachment.
-	if n.deliveryDestIn != nil && strings.EqualFold(remote, n.DestinationHashHex()) {
+	if self := n.DestinationHashHex(); self != "" && strings.EqualFold(remote, self) {
 		info, _, err := n.loadOutgoingAttachmentByHashHex(hashHex)
 		if err == nil {
 			binPath := filepath.Join(n.outgoingAttachmentsDir(), hashHex+".bin")
@@ -247,7 +252,7 @@ func (n *Node) ContactAttachmentPathHex(destinationHashHex, attachmentHashHex st
 		return AttachmentFetch{}, errors.New("invalid attachment hash")
 	}
 
-	id, err := n.WaitForIdentityHex(remote, timeout)
+	id, err := n.WaitForIdentityHexContext(ctx, remote)
 	if err != nil {
 		return AttachmentFetch{}, err
 	}
@@ -264,17 +269,22 @@ func (n *Node) ContactAttachmentPathHex(destinationHashHex, attachmentHashHex st
 		{app: lxmf.AppName, aspect: "delivery", label: "lxmf.delivery"},
 		{app: profileAppName, aspect: profileAspect, label: "runcore.profile"},
 	}
-	for _, spec := range destinations {
+	for i, spec := range destinations {
 		rns.Logf(rns.LOG_NOTICE, "attachment fetch: try %s dest=%s hash=%s", spec.label, remote, hashHex)
 		outDest, err := rns.NewDestination(id, rns.DestinationOUT, rns.DestinationSINGLE, spec.app, spec.aspect)
 		if err != nil {
 			lastErr = fmt.Errorf("create %s outbound destination: %w", spec.label, err)
 			continue
 		}
-		resp, err := n.fetchAttachmentViaDestination(outDest, remote, hashBytes, timeout)
+		attemptCtx, cancel := attemptContext(ctx, len(destinations)-i)
+		resp, err := n.fetchAttachmentViaDestination(attemptCtx, outDest, remote, hashBytes)
+		cancel()
 		if err == nil {
 			return resp, nil
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return AttachmentFetch{}, ctxErr
+		}
 		lastErr = err
 	}
 	if lastErr != nil {
@@ -283,44 +293,21 @@ func (n *Node) ContactAttachmentPathHex(destinationHashHex, attachmentHashHex st
 	return AttachmentFetch{}, errors.New("attachment request failed")
 }
 
-func (n *Node) fetchAttachmentViaDestination(outDest *rns.Destination, remoteHashHex string, hashBytes []byte, timeout time.Duration) (AttachmentFetch, error) {
+func (n *Node) fetchAttachmentViaDestination(ctx context.Context, outDest *rns.Destination, remoteHashHex string, hashBytes []byte) (AttachmentFetch, error) {
 	if outDest == nil {
 		return AttachmentFetch{}, errors.New("nil destination")
 	}
 	if len(hashBytes) == 0 {
 		return AttachmentFetch{}, errors.New("empty hash")
 	}
+	timeout := requestTimeout(ctx, 10*time.Second)
 
-	established := make(chan struct{})
-	closed := make(chan struct{})
-	link, err := rns.NewOutgoingLink(outDest, -1, func(*rns.Link) {
-		select {
-		case <-established:
-		default:
-			close(established)
-		}
-	}, func(*rns.Link) {
-		select {
-		case <-closed:
-		default:
-			close(closed)
-		}
-	})
+	link, _, err := openLink(ctx, outDest)
 	if err != nil {
-		return AttachmentFetch{}, fmt.Errorf("open link: %w", err)
+		return AttachmentFetch{}, err
 	}
 	defer link.Teardown()
 
-	deadline := time.NewTimer(timeout)
-	defer deadline.Stop()
-	select {
-	case <-established:
-	case <-closed:
-		return AttachmentFetch{}, errors.New("link closed before establishment")
-	case <-deadline.C:
-		return AttachmentFetch{}, errors.New("timeout establishing link")
-	}
-
 	link.Identify(n.identity)
 
 	req := map[any]any{"h": hashBytes}
@@ -354,31 +341,26 @@ func (n *Node) fetchAttachmentViaDestination(outDest *rns.Destination, remoteHas
 	for {
 		select {
 		case resp := <-respCh:
-			switch v := resp.(type) {
-			case map[any]any:
-				ok, _ := v["ok"].(bool)
-				if !ok {
-					return AttachmentFetch{HashHex: hashHex, NotPresent: true}, nil
-				}
-				if tv, ok := v["t"].(string); ok {
-					respMime = tv
-				}
-				if nv, ok := v["n"].(string); ok {
-					respName = nv
-				}
-			case []byte:
+			v, err := decodeAttachmentResponse(resp)
+			if err != nil {
+				return AttachmentFetch{}, err
+			}
+			if v.Raw != nil {
 				// Compatibility: handler may return raw bytes.
 				cachePath := filepath.Join(n.incomingAttachmentsDir(remoteHashHex), hashHex+".bin")
 				if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
 					return AttachmentFetch{}, err
 				}
-				if err := os.WriteFile(cachePath, v, 0o644); err != nil {
+				if err := os.WriteFile(cachePath, v.Raw, 0o644); err != nil {
 					return AttachmentFetch{}, err
 				}
-				return AttachmentFetch{HashHex: hashHex, Path: cachePath, Mime: respMime, Name: respName, Size: len(v)}, nil
-			default:
-				return AttachmentFetch{}, errors.New("unexpected attachment response type")
+				return AttachmentFetch{HashHex: hashHex, Path: cachePath, Mime: respMime, Name: respName, Size: len(v.Raw)}, nil
+			}
+			if !v.OK {
+				return AttachmentFetch{HashHex: hashHex, NotPresent: true}, nil
 			}
+			respMime = v.Meta.Mime
+			respName = v.Meta.Name
 		case res := <-resCh:
 			if res == nil {
 				return AttachmentFetch{}, errors.New("attachment resource nil")
@@ -386,16 +368,15 @@ func (n *Node) fetchAttachmentViaDestination(outDest *rns.Destination, remoteHas
 			if res.Status() != rns.ResourceComplete {
 				return AttachmentFetch{}, errors.New("attachment resource failed")
 			}
-			meta := res.Metadata()
-			kind, _ := meta["kind"].(string)
-			if kind != "" && kind != attachmentResKind {
-				return AttachmentFetch{}, errors.New("unexpected attachment resource kind")
+			meta, err := decodeAttachmentResourceMeta(res.Metadata())
+			if err != nil {
+				return AttachmentFetch{}, err
 			}
-			if tv, ok := meta["t"].(string); ok && tv != "" {
-				respMime = tv
+			if meta.Mime != "" {
+				respMime = meta.Mime
 			}
-			if nv, ok := meta["n"].(string); ok && nv != "" {
-				respName = nv
+			if meta.Name != "" {
+				respName = meta.Name
 			}
 
 			cachePath := filepath.Join(n.incomingAttachmentsDir(remoteHashHex), hashHex+".bin")
@@ -413,9 +394,14 @@ func (n *Node) fetchAttachmentViaDestination(outDest *rns.Destination, remoteHas
 			}
 			if _, err := io.Copy(dst, src); err != nil {
 				_ = dst.Close()
+				_ = os.Remove(cachePath)
 				return AttachmentFetch{}, fmt.Errorf("write attachment cache: %w", err)
 			}
 			_ = dst.Close()
+			if ctx.Err() != nil {
+				_ = os.Remove(cachePath)
+				return AttachmentFetch{}, ctx.Err()
+			}
 
 			if respMime != "" {
 				_ = os.WriteFile(filepath.Join(n.incomingAttachmentsDir(remoteHashHex), hashHex+".mime"), []byte(respMime), 0o644)
@@ -432,8 +418,8 @@ func (n *Node) fetchAttachmentViaDestination(outDest *rns.Destination, remoteHas
 			return AttachmentFetch{HashHex: hashHex, Path: cachePath, Mime: respMime, Name: respName, Size: sz}, nil
 		case <-failCh:
 			return AttachmentFetch{}, errors.New("attachment request failed")
-		case <-deadline.C:
-			return AttachmentFetch{}, errors.New("attachment request timeout")
+		case <-ctx.Done():
+			return AttachmentFetch{}, ctx.Err()
 		}
 	}
 }
@@ -0,0 +1,231 @@
+package runcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+)
+
+// Audio messages: FIELD_AUDIO is [audio_mode, bytes], as sent by Sideband for voice notes.
+// Codec2 modes carry raw concatenated Codec2 frames, AMOpusOgg an Ogg Opus file. runcore
+// stores received audio with its mime type and duration but has no codec of its own: a
+// pure Go Codec2 decoder is a port of the whole codec2 library, so hosts that want WAV
+// output register a decoder (eg a cgo binding to libcodec2) with RegisterAudioDecoder.
+
+// Audio is an LXMF audio field.
+type Audio struct {
+	Mode byte
+	Data []byte
+}
+
+// AudioFormat describes an LXMF audio mode.
+type AudioFormat struct {
+	Mode byte   `json:"mode"`
+	Name string `json:"name"` // eg "codec2_1200", "opus_ogg"
+	Mime string `json:"mime"`
+	Ext  string `json:"ext"`
+
+	// Codec2 framing: bytes and duration of one frame (0 for Opus).
+	frameBytes int
+	frameTime  time.Duration
+}
+
+var audioFormats = func() map[byte]AudioFormat {
+	m := map[byte]AudioFormat{}
+	codec2 := func(mode byte, name string, frameBytes int, frameTime time.Duration) {
+		m[mode] = AudioFormat{Mode: mode, Name: "codec2_" + name, Mime: "audio/codec2", Ext: "c2", frameBytes: frameBytes, frameTime: frameTime}
+	}
+	codec2(lxmf.AMCodec2450PWB, "450pwb", 3, 40*time.Millisecond)
+	codec2(lxmf.AMCodec2450, "450", 3, 40*time.Millisecond)
+	codec2(lxmf.AMCodec2700C, "700c", 4, 40*time.Millisecond)
+	codec2(lxmf.AMCodec21200, "1200", 6, 40*time.Millisecond)
+	codec2(lxmf.AMCodec21300, "1300", 7, 40*time.Millisecond)
+	codec2(lxmf.AMCodec21400, "1400", 7, 40*time.Millisecond)
+	codec2(lxmf.AMCodec21600, "1600", 8, 40*time.Millisecond)
+	codec2(lxmf.AMCodec22400, "2400", 6, 20*time.Millisecond)
+	codec2(lxmf.AMCodec23200, "3200", 8, 20*time.Millisecond)
+	m[lxmf.AMOpusOgg] = AudioFormat{Mode: lxmf.AMOpusOgg, Name: "opus_ogg", Mime: "audio/ogg", Ext: "ogg"}
+	for mode, name := range map[byte]string{
+		lxmf.AMOpusLBW:       "lbw",
+		lxmf.AMOpusMBW:       "mbw",
+		lxmf.AMOpusPTT:       "ptt",
+		lxmf.AMOpusRTHDX:     "rthdx",
+		lxmf.AMOpusRTFDX:     "rtfdx",
+		lxmf.AMOpusStandard:  "standard",
+		lxmf.AMOpusHQ:        "hq",
+		lxmf.AMOpusBroadcast: "broadcast",
+		lxmf.AMOpusLossless:  "lossless",
+	} {
+		m[mode] = AudioFormat{Mode: mode, Name: "opus_" + name, Mime: "audio/opus", Ext: "opus"}
+	}
+	return m
+}()
+
+// AudioFormatOf returns the format of an LXMF audio mode.
+func AudioFormatOf(mode byte) (AudioFormat, bool) {
+	f, ok := audioFormats[mode]
+	return f, ok
+}
+
+// AudioFormatOrDefault is AudioFormatOf with a fallback for unknown modes: the mode number
+// as name and application/octet-stream as mime, so the data can still be stored.
+func AudioFormatOrDefault(mode byte) AudioFormat {
+	if f, ok := audioFormats[mode]; ok {
+		return f
+	}
+	return AudioFormat{Mode: mode, Name: fmt.Sprint(mode), Mime: "application/octet-stream"}
+}
+
+// AudioFromField reads a FIELD_AUDIO value.
+func AudioFromField(v any) (Audio, bool) {
+	pair, ok := v.([]any)
+	if !ok || len(pair) < 2 {
+		return Audio{}, false
+	}
+	mode, ok := wireInt(pair[0])
+	if !ok || mode < 0 || mode > 0xff {
+		return Audio{}, false
+	}
+	data, ok := pair[1].([]byte)
+	if !ok {
+		return Audio{}, false
+	}
+	return Audio{Mode: byte(mode), Data: data}, true
+}
+
+func (a *Audio) validate() error {
+	if _, ok := audioFormats[a.Mode]; !ok {
+		return fmt.Errorf("unknown audio mode 0x%02x", a.Mode)
+	}
+	if len(a.Data) == 0 {
+		return errors.New("empty audio")
+	}
+	return nil
+}
+
+func (a *Audio) field() []any {
+	return []any{int(a.Mode), a.Data}
+}
+
+// Duration returns the play time of a, or 0 if it cannot be told without decoding.
+func (a Audio) Duration() time.Duration {
+	f, ok := audioFormats[a.Mode]
+	switch {
+	case !ok:
+		return 0
+	case f.frameBytes > 0:
+		return time.Duration(len(a.Data)/f.frameBytes) * f.frameTime
+	default:
+		return oggOpusDuration(a.Data)
+	}
+}
+
+// oggOpusDuration walks the Ogg pages of an Opus stream and returns the last granule
+// position (48 kHz samples) less the pre-skip from OpusHead.
+func oggOpusDuration(b []byte) time.Duration {
+	var granule, preSkip int64
+	for off := 0; off+27 <= len(b) && string(b[off:off+4]) == "OggS"; {
+		segments := int(b[off+26])
+		body := off + 27 + segments
+		if body > len(b) {
+			break
+		}
+		size := 0
+		for _, l := range b[off+27 : body] {
+			size += int(l)
+		}
+		if g := int64(binary.LittleEndian.Uint64(b[off+6:])); g > 0 {
+			granule = g
+		}
+		if end := min(body+size, len(b)); bytes.HasPrefix(b[body:end], []byte("OpusHead")) && end-body >= 12 {
+			preSkip = int64(binary.LittleEndian.Uint16(b[body+10:]))
+		}
+		off = body + size
+	}
+	if granule <= preSkip {
+		return 0
+	}
+	return time.Duration(granule-preSkip) * time.Second / 48000
+}
+
+// AudioDecoder decodes audio of one mode to mono 16-bit PCM.
+type AudioDecoder func(mode byte, data []byte) (pcm []int16, sampleRate int, err error)
+
+// ErrNoAudioDecoder is returned by DecodeAudioWAV for modes without a registered decoder.
+var ErrNoAudioDecoder = errors.New("no decoder for audio mode")
+
+var (
+	audioDecodersMu sync.RWMutex
+	audioDecoders   = map[byte]AudioDecoder{}
+)
+
+// RegisterAudioDecoder sets the decoder used by DecodeAudioWAV for mode (nil removes it).
+func RegisterAudioDecoder(mode byte, dec AudioDecoder) {
+	audioDecodersMu.Lock()
+	defer audioDecodersMu.Unlock()
+	if dec == nil {
+		delete(audioDecoders, mode)
+	} else {
+		audioDecoders[mode] = dec
+	}
+}
+
+// DecodeAudioWAV decodes a to a WAV file with the decoder registered for its mode.
+func DecodeAudioWAV(a Audio) ([]byte, error) {
+	audioDecodersMu.RLock()
+	dec := audioDecoders[a.Mode]
+	audioDecodersMu.RUnlock()
+	if dec == nil {
+		return nil, fmt.Errorf("%w 0x%02x", ErrNoAudioDecoder, a.Mode)
+	}
+	pcm, rate, err := dec(a.Mode, a.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decode audio: %w", err)
+	}
+	if rate <= 0 {
+		return nil, errors.New("decode audio: invalid sample rate")
+	}
+	return wavFile(pcm, rate), nil
+}
+
+// StoredAudioWAV decodes the stored audio attachment a to WAV (see DecodeAudioWAV).
+func (n *Node) StoredAudioWAV(a StoredAttachment) ([]byte, error) {
+	if a.Kind != "audio" || a.AudioMode == 0 {
+		return nil, errors.New("not an audio attachment")
+	}
+	path := n.StoredAttachmentPath(a)
+	if path == "" {
+		return nil, errors.New("attachment not stored")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAudioWAV(Audio{Mode: byte(a.AudioMode), Data: data})
+}
+
+// wavFile wraps mono 16-bit PCM in a RIFF/WAVE header.
+func wavFile(pcm []int16, rate int) []byte {
+	le := binary.LittleEndian
+	dataLen := uint32(len(pcm) * 2)
+	b := make([]byte, 0, 44+len(pcm)*2)
+	b = le.AppendUint32(append(b, "RIFF"...), 36+dataLen)
+	b = le.AppendUint32(append(b, "WAVEfmt "...), 16)
+	b = le.AppendUint16(b, 1) // PCM
+	b = le.AppendUint16(b, 1) // mono
+	b = le.AppendUint32(b, uint32(rate))
+	b = le.AppendUint32(b, uint32(rate*2)) // byte rate
+	b = le.AppendUint16(b, 2)              // block align
+	b = le.AppendUint16(b, 16)             // bits per sample
+	b = le.AppendUint32(append(b, "data"...), dataLen)
+	for _, v := range pcm {
+		b = le.AppendUint16(b, uint16(v))
+	}
+	return b
+}
@@ -0,0 +1,363 @@
+package runcore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Contact avatars fetched through ContactAvatar* are cached on disk:
+//
+//	Dir/avatars/<peer_hex>/<avatar_hash_hex>   image bytes
+//	Dir/avatars/<peer_hex>/index.json          current hash per variant
+//
+// A fetch whose hash matches the announced one is served from the cache, and announces
+// that advertise a new hash refresh the variants already cached for that peer.
+
+const (
+	avatarVariantFull  = "full"
+	avatarVariantThumb = "thumb"
+
+	avatarRefreshTimeout = 60 * time.Second
+)
+
+// CachedAvatar is a contact avatar stored in the local cache.
+type CachedAvatar struct {
+	DestinationHashHex string `json:"destination_hash_hex"`
+	HashHex            string `json:"hash_hex,omitempty"`
+	Mime               string `json:"mime,omitempty"`
+	Path               string `json:"path,omitempty"`
+	Thumbnail          bool   `json:"thumbnail,omitempty"`
+	Fetched            int64  `json:"fetched,omitempty"`
+	// Cleared is set on update events when the peer no longer has an avatar.
+	Cleared bool `json:"cleared,omitempty"`
+}
+
+type avatarCacheEntry struct {
+	HashHex string `json:"hash_hex"`
+	Mime    string `json:"mime,omitempty"`
+	Fetched int64  `json:"fetched,omitempty"`
+}
+
+// avatarCacheIndex maps variant (avatarVariantFull/Thumb) to the cached entry.
+type avatarCacheIndex map[string]avatarCacheEntry
+
+// SetAvatarUpdatedHandler sets the callback for contact avatar cache changes (new hash
+// fetched or avatar cleared). It runs on a library goroutine and must not block for long.
+func (n *Node) SetAvatarUpdatedHandler(cb func(CachedAvatar)) {
+	n.stateMu.Lock()
+	n.onAvatarUpdated = cb
+	n.stateMu.Unlock()
+}
+
+func (n *Node) notifyAvatarUpdated(a CachedAvatar) {
+	n.stateMu.RLock()
+	cb := n.onAvatarUpdated
+	n.stateMu.RUnlock()
+	if cb != nil {
+		cb(a)
+	}
+}
+
+// ContactAvatarPath returns the cached avatar file for a contact (the full image if cached,
+// else the thumbnail), or "" if none is cached. It never touches the network.
+func (n *Node) ContactAvatarPath(destinationHashHex string) string {
+	a, ok := n.ContactCachedAvatar(destinationHashHex)
+	if !ok {
+		return ""
+	}
+	return a.Path
+}
+
+// ContactCachedAvatar is like ContactAvatarPath but also reports hash and mime.
+func (n *Node) ContactCachedAvatar(destinationHashHex string) (CachedAvatar, bool) {
+	if n == nil {
+		return CachedAvatar{}, false
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, 16) {
+		return CachedAvatar{}, false
+	}
+	n.avatarCacheMu.Lock()
+	defer n.avatarCacheMu.Unlock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	for _, variant := range []string{avatarVariantFull, avatarVariantThumb} {
+		if e, ok := idx[variant]; ok {
+			return n.cachedAvatar(destHex, variant, e), true
+		}
+	}
+	return CachedAvatar{}, false
+}
+
+func (n *Node) cachedAvatar(destHex, variant string, e avatarCacheEntry) CachedAvatar {
+	return CachedAvatar{
+		DestinationHashHex: destHex,
+		HashHex:            e.HashHex,
+		Mime:               e.Mime,
+		Path:               n.avatarCacheFile(destHex, e.HashHex),
+		Thumbnail:          variant == avatarVariantThumb,
+		Fetched:            e.Fetched,
+	}
+}
+
+func (n *Node) avatarCacheDir(destHex string) string {
+	return filepath.Join(n.opts.Dir, "avatars", destHex)
+}
+
+func (n *Node) avatarCacheFile(destHex, hashHex string) string {
+	return filepath.Join(n.avatarCacheDir(destHex), hashHex)
+}
+
+// loadAvatarCacheIndex returns a copy of the peer's index. The indexes of all cached peers
+// are read once and then kept in memory, so announces from peers never fetched do not touch
+// the disk. Callers hold avatarCacheMu.
+func (n *Node) loadAvatarCacheIndex(destHex string) avatarCacheIndex {
+	if n.avatarIndexes == nil {
+		n.avatarIndexes = make(map[string]avatarCacheIndex)
+		entries, _ := os.ReadDir(filepath.Join(n.opts.Dir, "avatars"))
+		for _, de := range entries {
+			if de.IsDir() && validHashHex(de.Name(), 16) {
+				if idx := n.readAvatarCacheIndex(de.Name()); len(idx) > 0 {
+					n.avatarIndexes[de.Name()] = idx
+				}
+			}
+		}
+	}
+	idx := maps.Clone(n.avatarIndexes[destHex])
+	if idx == nil {
+		idx = avatarCacheIndex{}
+	}
+	return idx
+}
+
+// readAvatarCacheIndex reads the peer's index.json; entries whose file is gone are dropped.
+func (n *Node) readAvatarCacheIndex(destHex string) avatarCacheIndex {
+	idx := avatarCacheIndex{}
+	b, err := os.ReadFile(filepath.Join(n.avatarCacheDir(destHex), "index.json"))
+	if err != nil {
+		return idx
+	}
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return avatarCacheIndex{}
+	}
+	for variant, e := range idx {
+		if !validHashHex(e.HashHex, avatarHashLen) {
+			delete(idx, variant)
+			continue
+		}
+		if _, err := os.Stat(n.avatarCacheFile(destHex, e.HashHex)); err != nil {
+			delete(idx, variant)
+		}
+	}
+	return idx
+}
+
+// saveAvatarCacheIndex writes idx and removes image files no variant refers to.
+// Callers hold avatarCacheMu.
+func (n *Node) saveAvatarCacheIndex(destHex string, idx avatarCacheIndex) error {
+	dir := n.avatarCacheDir(destHex)
+	if len(idx) == 0 {
+		delete(n.avatarIndexes, destHex)
+		return os.RemoveAll(dir)
+	}
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "index.json"), b); err != nil {
+		return err
+	}
+	if n.avatarIndexes != nil {
+		n.avatarIndexes[destHex] = maps.Clone(idx)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	keep := map[string]bool{"index.json": true}
+	for _, e := range idx {
+		keep[e.HashHex] = true
+	}
+	for _, de := range entries {
+		if !keep[de.Name()] && !strings.HasPrefix(de.Name(), ".") {
+			_ = os.Remove(filepath.Join(dir, de.Name()))
+		}
+	}
+	return nil
+}
+
+// lookupCachedAvatar returns the cached variant for pixels (0 = full). A thumbnail request
+// falls back to the full image, which peers without a thumbnail serve anyway.
+func (n *Node) lookupCachedAvatar(destHex string, pixels int) (CachedAvatar, bool) {
+	n.avatarCacheMu.Lock()
+	defer n.avatarCacheMu.Unlock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	variants := []string{avatarVariantFull}
+	if pixels > 0 {
+		variants = []string{avatarVariantThumb, avatarVariantFull}
+	}
+	for _, variant := range variants {
+		if e, ok := idx[variant]; ok {
+			return n.cachedAvatar(destHex, variant, e), true
+		}
+	}
+	return CachedAvatar{}, false
+}
+
+// storeCachedAvatar caches a fetched avatar and emits an update event if its hash changed.
+func (n *Node) storeCachedAvatar(destHex string, fetch ContactAvatarFetch) {
+	data, err := base64.StdEncoding.DecodeString(fetch.DataBase64)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	hashHex := fetch.HashHex
+	if !validHashHex(hashHex, avatarHashLen) {
+		// Legacy raw responses carry no hash; use the same truncated sha256 as peers do.
+		sum := sha256.Sum256(data)
+		hashHex = hex.EncodeToString(sum[:avatarHashLen])
+	}
+	mime := fetch.Mime
+	if mime == "" {
+		mime = detectAvatarMime(data)
+	}
+	variant := avatarVariantFull
+	if fetch.Thumbnail {
+		variant = avatarVariantThumb
+	}
+
+	n.avatarCacheMu.Lock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	prev, had := idx[variant]
+	entry := avatarCacheEntry{HashHex: hashHex, Mime: mime, Fetched: time.Now().Unix()}
+	err = os.MkdirAll(n.avatarCacheDir(destHex), 0o755)
+	if err == nil {
+		err = writeFileAtomic(n.avatarCacheFile(destHex, hashHex), data)
+	}
+	if err == nil {
+		idx[variant] = entry
+		err = n.saveAvatarCacheIndex(destHex, idx)
+	}
+	n.avatarCacheMu.Unlock()
+	if err != nil {
+		rns.Logf(rns.LOG_NOTICE, "avatar cache: store %s failed: %v", destHex, err)
+		return
+	}
+	if !had || prev.HashHex != hashHex {
+		n.notifyAvatarUpdated(n.cachedAvatar(destHex, variant, entry))
+	}
+}
+
+// touchCachedAvatar records that a cached variant was confirmed current.
+func (n *Node) touchCachedAvatar(destHex string, a CachedAvatar) {
+	variant := avatarVariantFull
+	if a.Thumbnail {
+		variant = avatarVariantThumb
+	}
+	n.avatarCacheMu.Lock()
+	defer n.avatarCacheMu.Unlock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	if e, ok := idx[variant]; ok && e.HashHex == a.HashHex {
+		e.Fetched = time.Now().Unix()
+		idx[variant] = e
+		_ = n.saveAvatarCacheIndex(destHex, idx)
+	}
+}
+
+// clearCachedAvatar drops all cached variants of a peer and emits a Cleared event.
+func (n *Node) clearCachedAvatar(destHex string) {
+	n.avatarCacheMu.Lock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	had := len(idx) > 0
+	_ = n.saveAvatarCacheIndex(destHex, nil)
+	n.avatarCacheMu.Unlock()
+	if had {
+		n.notifyAvatarUpdated(CachedAvatar{DestinationHashHex: destHex, Cleared: true})
+	}
+}
+
+// cachedAvatarFetch turns a cache entry into a fetch result. knownHex is the caller's hash.
+func cachedAvatarFetch(a CachedAvatar, knownHex string) (ContactAvatarFetch, error) {
+	out := ContactAvatarFetch{HashHex: a.HashHex, Mime: a.Mime, Thumbnail: a.Thumbnail}
+	if strings.EqualFold(knownHex, a.HashHex) {
+		out.Unchanged = true
+		return out, nil
+	}
+	data, err := os.ReadFile(a.Path)
+	if err != nil {
+		return ContactAvatarFetch{}, err
+	}
+	b64 := base64.StdEncoding.EncodeToString(data)
+	out.DataBase64 = b64
+	out.PNGBase64 = b64
+	return out, nil
+}
+
+// refreshCachedAvatar is called for every delivery announce. It re-fetches the variants
+// cached for the peer when the announced hash differs, and clears the cache when a runcore
+// peer stops announcing an avatar. Peers never fetched are left alone.
+func (n *Node) refreshCachedAvatar(destHex string, data announceData) {
+	if data.Avatar == nil && data.Profile == nil {
+		// Not a runcore announce (or a legacy one without avatar): nothing to compare.
+		return
+	}
+	n.avatarCacheMu.Lock()
+	idx := n.loadAvatarCacheIndex(destHex)
+	if len(idx) == 0 || n.avatarRefreshing[destHex] {
+		n.avatarCacheMu.Unlock()
+		return
+	}
+	if data.Avatar == nil {
+		n.avatarCacheMu.Unlock()
+		n.clearCachedAvatar(destHex)
+		return
+	}
+	var stale []int
+	if e, ok := idx[avatarVariantFull]; ok && e.HashHex != hex.EncodeToString(data.Avatar.Hash) {
+		stale = append(stale, 0)
+	}
+	if e, ok := idx[avatarVariantThumb]; ok && len(data.Avatar.ThumbHash) > 0 && e.HashHex != hex.EncodeToString(data.Avatar.ThumbHash) {
+		stale = append(stale, AvatarThumbnailSize)
+	}
+	if len(stale) == 0 {
+		n.avatarCacheMu.Unlock()
+		return
+	}
+	if n.avatarRefreshing == nil {
+		n.avatarRefreshing = make(map[string]bool)
+	}
+	n.avatarRefreshing[destHex] = true
+	n.avatarCacheMu.Unlock()
+
+	go func() {
+		defer func() {
+			n.avatarCacheMu.Lock()
+			delete(n.avatarRefreshing, destHex)
+			n.avatarCacheMu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), avatarRefreshTimeout)
+		defer cancel()
+		for _, pixels := range stale {
+			if _, err := n.fetchContactAvatar(ctx, destHex, "", pixels); err != nil {
+				rns.Logf(rns.LOG_DEBUG, "avatar cache: refresh %s px=%d failed: %v", destHex, pixels, err)
+				return
+			}
+		}
+	}()
+}
+
+func validHashHex(s string, size int) bool {
+	if len(s) != size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,327 @@
+// Package bot routes inbound LXMF messages of a runcore.Node to command handlers.
+//
+//	b := bot.New(node, bot.Options{})
+//	b.Handle("ping", "replies pong", func(c *bot.Context) error { return c.Reply("pong") })
+//	b.Start()
+//
+// Messages whose content starts with the command prefix ("/" by default) are dispatched to
+// the handler registered for the first word; other messages go to the Default handler, or
+// are ignored without one. Other messages replying to one the bot sent are always dropped,
+// so two bots (or a bot and an auto-responder) do not answer each other forever.
+// "/help" is built in. Handlers run on a bounded worker pool, never on the router
+// goroutine, and each sender is rate limited.
+package bot
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+const (
+	defaultPrefix        = "/"
+	defaultRatePerMinute = 20
+	defaultBurst         = 5
+	defaultWorkers       = 4
+	defaultQueue         = 256
+	defaultStateTTL      = 24 * time.Hour
+)
+
+// Handler handles one message. A returned error is logged, and sent back to the sender
+// when Options.ReplyErrors is set.
+type Handler func(c *Context) error
+
+// Options configures a Bot. Zero values select the defaults.
+type Options struct {
+	// Prefix starts a command (default "/"). Commands are matched case-insensitively.
+	Prefix string
+
+	// RatePerMinute and Burst limit messages handled per sender (default 20/min, burst 5).
+	// Messages over the limit are dropped; the sender is told once per minute.
+	RatePerMinute int
+	Burst         int
+
+	// Workers is the number of concurrently running handlers (default 4); Queue is the
+	// number of messages waiting for a worker (default 256) before new ones are dropped.
+	Workers int
+	Queue   int
+
+	// StateTTL drops per-sender state unused for this long (default 24h).
+	StateTTL time.Duration
+
+	// ReplyErrors sends handler errors back to the sender (default: only logged).
+	ReplyErrors bool
+}
+
+type command struct {
+	name    string
+	help    string
+	handler Handler
+}
+
+// Bot dispatches inbound messages to handlers.
+type Bot struct {
+	node *runcore.Node
+	opts Options
+
+	mu       sync.RWMutex
+	commands map[string]*command
+	fallback Handler
+
+	sendersMu sync.Mutex
+	senders   map[string]*sender
+
+	// queue holds work for the workers: dispatches and rate limit notices.
+	queue chan func()
+	once  sync.Once
+}
+
+// sender is the per-sender rate limit and conversation state.
+type sender struct {
+	tokens   float64
+	last     time.Time
+	warned   time.Time
+	state    *State
+	lastSeen time.Time
+}
+
+// New creates a bot for n. Call Start to begin handling messages.
+func New(n *runcore.Node, opts Options) *Bot {
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.RatePerMinute <= 0 {
+		opts.RatePerMinute = defaultRatePerMinute
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = defaultBurst
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.Queue <= 0 {
+		opts.Queue = defaultQueue
+	}
+	if opts.StateTTL <= 0 {
+		opts.StateTTL = defaultStateTTL
+	}
+	b := &Bot{
+		node:     n,
+		opts:     opts,
+		commands: map[string]*command{},
+		senders:  map[string]*sender{},
+		queue:    make(chan func(), opts.Queue),
+	}
+	b.Handle("help", "lists commands", b.help)
+	return b
+}
+
+// Node returns the bot's node.
+func (b *Bot) Node() *runcore.Node { return b.node }
+
+// Handle registers h for the command name (without prefix). help is shown by /help.
+// Registering a name again replaces the handler.
+func (b *Bot) Handle(name, help string, h Handler) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), b.opts.Prefix))
+	if name == "" || h == nil {
+		return
+	}
+	b.mu.Lock()
+	b.commands[name] = &command{name: name, help: help, handler: h}
+	b.mu.Unlock()
+}
+
+// Default sets the handler for messages that are not commands (and unknown commands).
+// Without one, unknown commands get a pointer to /help and other messages are ignored.
+// It is not called for non-command replies to the bot's own messages.
+func (b *Bot) Default(h Handler) {
+	b.mu.Lock()
+	b.fallback = h
+	b.mu.Unlock()
+}
+
+// Start installs the bot as the node's inbound handler and starts the workers.
+// It replaces any inbound handler set before.
+func (b *Bot) Start() {
+	b.once.Do(func() {
+		for i := 0; i < b.opts.Workers; i++ {
+			go b.worker()
+		}
+		go b.expireStates()
+	})
+	b.node.SetInboundHandler(b.HandleMessage)
+}
+
+// HandleMessage queues m for dispatch. Start installs it as the inbound handler; call it
+// directly to chain the bot after another handler.
+func (b *Bot) HandleMessage(m *lxmf.LXMessage) {
+	if m == nil || len(m.SourceHash) == 0 {
+		return
+	}
+	src := hex.EncodeToString(m.SourceHash)
+	if src == b.node.DestinationHashHex() {
+		return // never answer ourselves
+	}
+	st, ok, warn := b.admit(src)
+	if warn {
+		b.enqueue(src, func() { _ = b.send(src, "", "Too many messages, slow down.") })
+	}
+	if !ok {
+		return
+	}
+	c := newContext(b, m, st)
+	b.enqueue(src, func() { b.dispatch(c) })
+}
+
+// enqueue hands job to the workers; it is dropped when the queue is full.
+func (b *Bot) enqueue(src string, job func()) {
+	select {
+	case b.queue <- job:
+	default:
+		rns.Logf(rns.LOG_NOTICE, "bot: queue full, dropped message from %s", src)
+	}
+}
+
+// admit applies the sender's rate limit and returns its state. warn is set when a
+// rate-limited sender should be told (at most once per minute).
+func (b *Bot) admit(src string) (st *State, ok, warn bool) {
+	now := time.Now()
+	b.sendersMu.Lock()
+	s := b.senders[src]
+	if s == nil {
+		s = &sender{tokens: float64(b.opts.Burst), last: now, state: newState()}
+		b.senders[src] = s
+	}
+	s.tokens += now.Sub(s.last).Minutes() * float64(b.opts.RatePerMinute)
+	if s.tokens > float64(b.opts.Burst) {
+		s.tokens = float64(b.opts.Burst)
+	}
+	s.last = now
+	s.lastSeen = now
+	if s.tokens < 1 {
+		warn = now.Sub(s.warned) >= time.Minute
+		if warn {
+			s.warned = now
+		}
+		b.sendersMu.Unlock()
+		rns.Logf(rns.LOG_DEBUG, "bot: rate limited %s", src)
+		return nil, false, warn
+	}
+	s.tokens--
+	st = s.state
+	b.sendersMu.Unlock()
+	return st, true, false
+}
+
+func (b *Bot) expireStates() {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for range t.C {
+		cutoff := time.Now().Add(-b.opts.StateTTL)
+		b.sendersMu.Lock()
+		for k, s := range b.senders {
+			if s.lastSeen.Before(cutoff) {
+				delete(b.senders, k)
+			}
+		}
+		b.sendersMu.Unlock()
+	}
+}
+
+func (b *Bot) worker() {
+	for job := range b.queue {
+		job()
+	}
+}
+
+func (b *Bot) dispatch(c *Context) {
+	if c.Command == "" && b.repliesToOwn(c) {
+		rns.Logf(rns.LOG_DEBUG, "bot: ignored reply from %s to our message %s", c.Sender, c.ReplyTo)
+		return
+	}
+	b.mu.RLock()
+	var h Handler
+	if c.Command != "" {
+		if cmd := b.commands[c.Command]; cmd != nil {
+			h = cmd.handler
+		}
+	}
+	if h == nil {
+		h = b.fallback
+	}
+	b.mu.RUnlock()
+	if h == nil {
+		h = b.unknown
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		return h(c)
+	}()
+	if err == nil {
+		return
+	}
+	rns.Logf(rns.LOG_NOTICE, "bot: %s from %s: %v", c.commandLabel(), c.Sender, err)
+	if b.opts.ReplyErrors {
+		_ = c.Reply("Error: " + err.Error())
+	}
+}
+
+func (b *Bot) help(c *Context) error {
+	b.mu.RLock()
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString("Commands:")
+	for _, name := range names {
+		cmd := b.commands[name]
+		fmt.Fprintf(&sb, "\n%s%s", b.opts.Prefix, name)
+		if cmd.help != "" {
+			sb.WriteString(" - " + cmd.help)
+		}
+	}
+	b.mu.RUnlock()
+	return c.Reply(sb.String())
+}
+
+// repliesToOwn reports whether c replies to a message the bot's node sent.
+func (b *Bot) repliesToOwn(c *Context) bool {
+	if c.ReplyTo == "" {
+		return false
+	}
+	parent, ok := b.node.StoredMessageHex(c.Sender, c.ReplyTo)
+	return ok && parent.Outgoing
+}
+
+func (b *Bot) unknown(c *Context) error {
+	if c.Command == "" {
+		return nil
+	}
+	return c.Reply("Unknown command " + b.opts.Prefix + c.Command + ". Send " + b.opts.Prefix + "help for a list of commands.")
+}
+
+func (b *Bot) send(dest, replyTo, text string) error {
+	opts := runcore.SendOptions{Method: lxmf.MethodDirect, Content: text}
+	var err error
+	if replyTo != "" {
+		_, err = b.node.SendReply(dest, replyTo, opts)
+	} else {
+		_, err = b.node.SendHex(dest, opts)
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+
+	"runcore"
+)
+
+// Context is one inbound message as seen by a handler.
+type Context struct {
+	Bot     *Bot
+	Message *lxmf.LXMessage
+
+	// Sender is the sender's delivery destination hash (hex); MessageID the LXMF message ID.
+	Sender    string
+	MessageID string
+	Title     string
+	Content   string
+	Received  time.Time
+
+	// ReplyTo is the ID of the message this one replies to (FIELD_THREAD), if any.
+	ReplyTo string
+
+	// Command is the lower-cased command without prefix ("" if the message is not a
+	// command); Args are the whitespace-separated words after it and ArgText the raw rest.
+	Command string
+	Args    []string
+	ArgText string
+
+	// Fields are the message's LXMF fields; Attachments the files, image and audio
+	// carried inline in them.
+	Fields      map[any]any
+	Attachments []Attachment
+
+	// State is the sender's conversation state, kept across messages.
+	State *State
+}
+
+// Attachment is a file, image or audio carried inline in a message.
+type Attachment = runcore.MessageAttachment
+
+func newContext(b *Bot, m *lxmf.LXMessage, st *State) *Context {
+	c := &Context{
+		Bot:       b,
+		Message:   m,
+		Sender:    hex.EncodeToString(m.SourceHash),
+		MessageID: hex.EncodeToString(m.MessageID),
+		Title:     m.TitleAsString(),
+		Content:   m.ContentAsString(),
+		Received:  time.Now(),
+		Fields:    m.Fields,
+		State:     st,
+	}
+	if c.MessageID == "" {
+		c.MessageID = hex.EncodeToString(m.Hash)
+	}
+	// The node stores inbound messages before handing them on, with the thread parsed.
+	if sm, ok := b.node.StoredMessageHex(c.Sender, c.MessageID); ok {
+		c.ReplyTo = sm.ReplyToHex
+	}
+	text := strings.TrimSpace(c.Content)
+	if rest, ok := strings.CutPrefix(text, b.opts.Prefix); ok && rest != "" {
+		name, args, _ := strings.Cut(rest, " ")
+		// "/cmd@botname" style suffixes are accepted and ignored.
+		name, _, _ = strings.Cut(name, "@")
+		c.Command = strings.ToLower(name)
+		c.ArgText = strings.TrimSpace(args)
+		c.Args = strings.Fields(c.ArgText)
+	}
+	c.Attachments = runcore.MessageAttachments(m.Fields)
+	return c
+}
+
+func (c *Context) commandLabel() string {
+	if c.Command == "" {
+		return "message"
+	}
+	return c.Bot.opts.Prefix + c.Command
+}
+
+// Reply answers the message as a reply (FIELD_THREAD) with the given text.
+func (c *Context) Reply(text string) error {
+	return c.Bot.send(c.Sender, c.MessageID, text)
+}
+
+// Replyf is Reply with fmt.Sprintf formatting.
+func (c *Context) Replyf(format string, args ...any) error {
+	return c.Reply(fmt.Sprintf(format, args...))
+}
+
+// Send sends a message to the sender without threading it as a reply.
+func (c *Context) Send(msg runcore.SendOptions) error {
+	if msg.Method == 0 {
+		msg.Method = lxmf.MethodDirect
+	}
+	_, err := c.Bot.node.SendHex(c.Sender, msg)
+	return err
+}
+
+// React adds an emoji reaction to the message (peers without reaction support are skipped).
+func (c *Context) React(emoji string) error {
+	err := c.Bot.node.React(c.Sender, c.MessageID, emoji, false)
+	if err == runcore.ErrPeerUnsupported {
+		return nil
+	}
+	return err
+}
+
+// FetchAttachment downloads an attachment the sender serves by hash (see
+// runcore.Node.ContactAttachmentPathHexContext).
+func (c *Context) FetchAttachment(ctx context.Context, hashHex string) (runcore.AttachmentFetch, error) {
+	return c.Bot.node.ContactAttachmentPathHexContext(ctx, c.Sender, hashHex)
+}
+
+// State is per-sender conversation state, safe for concurrent use. It is kept in memory
+// and dropped after Options.StateTTL without messages from the sender.
+type State struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func newState() *State { return &State{values: map[string]any{}} }
+
+// Get returns the value stored under key.
+func (s *State) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set stores a value under key.
+func (s *State) Set(key string, v any) {
+	s.mu.Lock()
+	s.values[key] = v
+	s.mu.Unlock()
+}
+
+// Delete removes key.
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+}
+
+// Update atomically replaces the value under key with fn(old).
+func (s *State) Update(key string, fn func(old any) any) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := fn(s.values[key])
+	s.values[key] = v
+	return v
+}
@@ -0,0 +1,229 @@
+package runcore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// ProfileProtocolVersion is the runcore profile protocol version advertised in announce
+// slot 3 and /capabilities. Bump it only for incompatible changes; new features are
+// advertised as capabilities. See docs/PROFILE.md.
+const ProfileProtocolVersion = 1
+
+const profileCapabilitiesReq = "/capabilities"
+
+// Capabilities a runcore peer can advertise. Unknown capabilities must be ignored.
+const (
+	CapAvatar       = "avatar"       // serves /avatar
+	CapAttachments  = "attachments"  // serves /attachment
+	CapCapabilities = "capabilities" // serves /capabilities
+)
+
+// capabilityBits gives known capabilities a fixed bit in the announced "b" mask, so an
+// announce stays small however many names there are. Bits are never reused; capabilities
+// without a bit are only listed by /capabilities.
+var capabilityBits = []string{
+	CapAvatar,
+	CapAttachments,
+	CapCapabilities,
+	CapProfile,
+	CapAvatarThumbnail,
+	CapProfilePush,
+	CapReadReceipts,
+	CapPresence,
+	CapMessageActions,
+	CapGroups,
+}
+
+// legacyCapabilities are implied for peers that announce avatar metadata (slot 2) but
+// predate the versioned profile map (protocol 0).
+var legacyCapabilities = []string{CapAvatar, CapAttachments}
+
+var (
+	localCapsMu sync.RWMutex
+	localCaps   = map[string]struct{}{
+		CapAvatar:       {},
+		CapAttachments:  {},
+		CapCapabilities: {},
+	}
+)
+
+// registerCapability adds a capability advertised by every node in this process.
+func registerCapability(name string) {
+	localCapsMu.Lock()
+	localCaps[name] = struct{}{}
+	localCapsMu.Unlock()
+}
+
+// Capabilities returns the capabilities this node advertises, sorted.
+func (n *Node) Capabilities() []string {
+	localCapsMu.RLock()
+	out := make([]string, 0, len(localCaps))
+	for name := range localCaps {
+		out = append(out, name)
+	}
+	localCapsMu.RUnlock()
+	sort.Strings(out)
+	return out
+}
+
+// profileInfo is the versioned profile map: announce slot 3 and the /capabilities response.
+type profileInfo struct {
+	Version      int
+	Capabilities []string
+	ProfileHash  []byte // hash of the extended profile (/profile), if any
+}
+
+func (n *Node) localProfileInfo() profileInfo {
+	return profileInfo{
+		Version:      ProfileProtocolVersion,
+		Capabilities: n.Capabilities(),
+		ProfileHash:  n.userProfileSnapshot().hash,
+	}
+}
+
+// wire returns the msgpack form {"v": version, "c": [capabilities], "p": profile_hash?}.
+func (p profileInfo) wire() map[any]any {
+	caps := make([]any, 0, len(p.Capabilities))
+	for _, c := range p.Capabilities {
+		caps = append(caps, c)
+	}
+	m := map[any]any{"v": p.Version, "c": caps}
+	if len(p.ProfileHash) > 0 {
+		m["p"] = p.ProfileHash
+	}
+	return m
+}
+
+// announceWire returns the compact form for announce slot 3: {"v": version, "b": bitmask,
+// "p": profile_hash?}, with bit i set for capabilityBits[i].
+func (p profileInfo) announceWire() map[any]any {
+	var bits int64
+	for _, c := range p.Capabilities {
+		if i := slices.Index(capabilityBits, c); i >= 0 {
+			bits |= 1 << i
+		}
+	}
+	m := map[any]any{"v": p.Version, "b": bits}
+	if len(p.ProfileHash) > 0 {
+		m["p"] = p.ProfileHash
+	}
+	return m
+}
+
+// Supports reports whether the contact advertised capability name.
+func (c ContactInfo) Supports(name string) bool {
+	for _, cap := range c.Capabilities {
+		if cap == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) registerCapabilitiesRequestHandler(dest *rns.Destination) error {
+	if n == nil || dest == nil {
+		return nil
+	}
+	return dest.RegisterRequestHandler(
+		profileCapabilitiesReq,
+		func(path string, data any, requestID []byte, linkID []byte, remoteIdentity *rns.Identity, requestedAt time.Time) any {
+			return n.localProfileInfo().wire()
+		},
+		rns.DestinationALLOW_ALL,
+		nil,
+		true,
+	)
+}
+
+// ContactCapabilitiesHexContext asks a peer for its profile protocol version and capabilities
+// over a link (useful when no announce with the profile map was seen yet).
+// Peers without /capabilities yield a ContactInfo with Protocol 0 and no capabilities.
+func (n *Node) ContactCapabilitiesHexContext(ctx context.Context, destinationHashHex string) (ContactInfo, error) {
+	if n == nil || n.identity == nil {
+		return ContactInfo{}, errors.New("node not started")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	id, err := n.WaitForIdentityHexContext(ctx, destinationHashHex)
+	if err != nil {
+		return ContactInfo{}, err
+	}
+	if id == nil {
+		return ContactInfo{}, errors.New("unknown destination identity")
+	}
+	outDest, err := rns.NewDestination(id, rns.DestinationOUT, rns.DestinationSINGLE, lxmf.AppName, "delivery")
+	if err != nil {
+		return ContactInfo{}, fmt.Errorf("create outbound destination: %w", err)
+	}
+	resp, err := n.linkRequest(ctx, outDest, profileCapabilitiesReq, nil, 5*time.Second)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ContactInfo{}, ctx.Err()
+		}
+		// Older runcore versions and plain LXMF clients have no /capabilities handler.
+		rns.Logf(rns.LOG_DEBUG, "capabilities req: dest=%s err=%v", destinationHashHex, err)
+		return ContactInfo{}, nil
+	}
+	info, err := decodeProfileInfo(resp)
+	if err != nil {
+		return ContactInfo{}, err
+	}
+	return ContactInfo{Protocol: info.Version, Capabilities: info.Capabilities}, nil
+}
+
+// linkRequest opens a link to outDest, identifies, sends one request and returns its response.
+func (n *Node) linkRequest(ctx context.Context, outDest *rns.Destination, path string, data any, def time.Duration) (any, error) {
+	if outDest == nil {
+		return nil, errors.New("nil destination")
+	}
+	timeout := requestTimeout(ctx, def)
+	if !rns.TransportHasPath(outDest.Hash()) {
+		n.RequestPath(outDest.Hash())
+		pathCtx, cancel := context.WithTimeout(ctx, minDuration(timeout, 4*time.Second))
+		n.awaitPath(pathCtx, outDest.Hash())
+		cancel()
+	}
+
+	link, closed, err := openLink(ctx, outDest)
+	if err != nil {
+		return nil, err
+	}
+	defer link.Teardown()
+
+	link.Identify(n.identity)
+
+	respCh := make(chan any, 1)
+	failCh := make(chan struct{}, 1)
+	rr := link.Request(
+		path,
+		data,
+		func(rr *rns.RequestReceipt) { respCh <- rr.Response() },
+		func(rr *rns.RequestReceipt) { failCh <- struct{}{} },
+		nil,
+		requestTimeout(ctx, def).Seconds(),
+	)
+	if rr == nil {
+		return nil, fmt.Errorf("failed to send %s request", path)
+	}
+	select {
+	case resp := <-respCh:
+		return resp, nil
+	case <-failCh:
+		return nil, fmt.Errorf("%s request failed dest=%s", path, hex.EncodeToString(outDest.Hash()))
+	case <-closed:
+		return nil, errors.New("link closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,83 @@
+// Command runcore-bot is an example bot built on package runcore/bot. It answers /ping,
+// echoes /echo, reports /status and counts the messages of each sender.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"runcore"
+	"runcore/bot"
+)
+
+func main() {
+	dir := flag.String("config", ".runcore-bot", "runcore state directory")
+	name := flag.String("name", "runcore bot", "display name")
+	logLevel := flag.Int("loglevel", 4, "Reticulum log level (0..7)")
+	flag.Parse()
+
+	node, err := runcore.Start(runcore.Options{
+		Dir:         *dir,
+		DisplayName: *name,
+		LogLevel:    *logLevel,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "start:", err)
+		os.Exit(1)
+	}
+	defer node.Close()
+
+	started := time.Now()
+	b := bot.New(node, bot.Options{ReplyErrors: true})
+
+	// count wraps a handler so every message a sender sends is counted in its state.
+	count := func(h bot.Handler) bot.Handler {
+		return func(c *bot.Context) error {
+			c.State.Update("count", func(old any) any {
+				n, _ := old.(int)
+				return n + 1
+			})
+			return h(c)
+		}
+	}
+	b.Handle("ping", "replies pong", count(func(c *bot.Context) error {
+		return c.Reply("pong")
+	}))
+	b.Handle("echo", "repeats the text after it", count(func(c *bot.Context) error {
+		if c.ArgText == "" {
+			return fmt.Errorf("usage: /echo <text>")
+		}
+		return c.Reply(c.ArgText)
+	}))
+	b.Handle("status", "shows uptime and your message count", count(func(c *bot.Context) error {
+		n, _ := c.State.Get("count")
+		return c.Replyf("Up %s. You sent %v message(s).", time.Since(started).Round(time.Second), n)
+	}))
+	b.Default(count(func(c *bot.Context) error {
+		var parts []string
+		for _, a := range c.Attachments {
+			name := a.Name
+			if name == "" {
+				name = a.Format
+			}
+			parts = append(parts, fmt.Sprintf("%s %s (%d bytes)", a.Kind, name, len(a.Data)))
+		}
+		// Plain text gets no answer, so the bot never ends up talking to another bot.
+		if len(parts) == 0 {
+			return nil
+		}
+		return c.Reply("Received " + strings.Join(parts, ", ") + ".")
+	}))
+	b.Start()
+	node.AnnounceDeliveryWithReason("start")
+	fmt.Println("runcore-bot listening on", node.DestinationHashHex())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
@@ -0,0 +1,22 @@
+// Command runcore-testnode is the child process used by package runcoretest.
+// It runs one runcore node and serves control requests on stdin, answering on fd 3.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"runcore/runcoretest"
+)
+
+func main() {
+	ctrl := os.NewFile(3, "control")
+	if ctrl == nil {
+		fmt.Fprintln(os.Stderr, "runcore-testnode: fd 3 (control channel) is not open; run via runcoretest")
+		os.Exit(2)
+	}
+	if err := runcoretest.RunAgent(os.Args[1:], os.Stdin, ctrl); err != nil {
+		fmt.Fprintln(os.Stderr, "runcore-testnode:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+// archiveJob is a one-shot import and/or export (-import, -export); the daemon exits
+// when it is done. Imports run first, so "-import <config>/storage/messages -export DIR"
+// converts the raw message files the daemon keeps.
+type archiveJob struct {
+	importPaths []string
+	exportDir   string
+	format      runcore.ExportFormat
+	peers       []string
+}
+
+func newArchiveJob(importPaths, exportDir, format, peers string) *archiveJob {
+	if importPaths == "" && exportDir == "" {
+		return nil
+	}
+	return &archiveJob{
+		importPaths: splitList(importPaths),
+		exportDir:   exportDir,
+		format:      runcore.ExportFormat(strings.ToLower(format)),
+		peers:       splitList(peers),
+	}
+}
+
+func (j *archiveJob) run(n *runcore.Node) error {
+	if len(j.importPaths) > 0 {
+		added, err := n.ImportMessageFiles(j.importPaths...)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		rns.Log(fmt.Sprintf("Imported %d message(s)", added), rns.LOG_NOTICE)
+	}
+	if j.exportDir != "" {
+		count, err := n.ExportConversations(j.exportDir, j.format, j.peers...)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		rns.Log(fmt.Sprintf("Exported %d message(s) as %s to %s", count, j.format, j.exportDir), rns.LOG_NOTICE)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+// Hooks ([hooks] in the config, or -on-inbound). on_inbound runs for every received
+// message with the stored message file as its argument; on_outbound_status runs when a
+// message sent by the daemon is delivered or fails. Both get a JSON description of the
+// event on stdin and the main values as RUNCORE_* environment variables. Hooks run on a
+// bounded worker pool, never on the router goroutine; a run that exits non-zero or
+// exceeds the timeout is retried after retry_delay (doubling) up to retries times.
+
+const (
+	hookEnvValueLimit = 32 * 1024 // longer values are truncated in the environment only
+	attachmentsSubdir = "attachments"
+)
+
+type hookConfig struct {
+	OnInbound        string
+	OnOutboundStatus string
+	Workers          int
+	Queue            int
+	Timeout          time.Duration
+	Retries          int
+	RetryDelay       time.Duration
+}
+
+type hookJob struct {
+	event   string
+	command string
+	args    []string
+	env     []string
+	stdin   []byte
+	attempt int
+}
+
+type hookRunner struct {
+	cfg   hookConfig
+	queue chan hookJob
+}
+
+// hookMessage is the JSON document passed on stdin.
+type hookMessage struct {
+	Event              string           `json:"event"`
+	MessageID          string           `json:"message_id"`
+	Source             string           `json:"source,omitempty"`
+	Destination        string           `json:"destination,omitempty"`
+	Title              string           `json:"title,omitempty"`
+	Content            string           `json:"content,omitempty"`
+	Timestamp          float64          `json:"timestamp,omitempty"`
+	Method             string           `json:"method,omitempty"`
+	SignatureValidated bool             `json:"signature_validated,omitempty"`
+	State              string           `json:"state,omitempty"`
+	Path               string           `json:"path,omitempty"`
+	Fields             map[string]any   `json:"fields,omitempty"`
+	Attachments        []hookAttachment `json:"attachments,omitempty"`
+}
+
+type hookAttachment struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Format string `json:"format,omitempty"`
+	Path   string `json:"path"`
+	Size   int    `json:"size"`
+	// Audio only.
+	Mime       string `json:"mime,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+func loadHookConfig(onInbound string) hookConfig {
+	cfg := hookConfig{
+		// lxmd reads on_inbound from [lxmf]; [hooks] and the flag take precedence.
+		OnInbound:        stringKey("hooks", "on_inbound", stringKey("lxmf", "on_inbound", "")),
+		OnOutboundStatus: stringKey("hooks", "on_outbound_status", ""),
+		Workers:          intKey("hooks", "workers", 2),
+		Queue:            intKey("hooks", "queue", 256),
+		Timeout:          time.Duration(intKey("hooks", "timeout", 30)) * time.Second,
+		Retries:          intKey("hooks", "retries", 2),
+		RetryDelay:       time.Duration(intKey("hooks", "retry_delay", 10)) * time.Second,
+	}
+	if onInbound != "" {
+		cfg.OnInbound = onInbound
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = 10 * time.Second
+	}
+	return cfg
+}
+
+// startHooks returns nil when no hook is configured.
+func startHooks(cfg hookConfig) *hookRunner {
+	if cfg.OnInbound == "" && cfg.OnOutboundStatus == "" {
+		return nil
+	}
+	h := &hookRunner{
+		cfg:   cfg,
+		queue: make(chan hookJob, cfg.Queue),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		go h.worker()
+	}
+	return h
+}
+
+// describeInbound builds the hook/webhook description of m, stored at path. Inline
+// attachments point at the node's message store (group messages included); only if the
+// store has no copy are they written under storage/attachments/<message id>/.
+func describeInbound(m *lxmf.LXMessage, path string) hookMessage {
+	msg := hookMessage{
+		Event:              "inbound",
+		MessageID:          messageIDHex(m),
+		Source:             hex.EncodeToString(m.SourceHash),
+		Destination:        hex.EncodeToString(m.DestinationHash),
+		Title:              m.TitleAsString(),
+		Content:            m.ContentAsString(),
+		Timestamp:          m.Timestamp,
+		Method:             methodName(m.Method),
+		SignatureValidated: m.SignatureValidated,
+		Path:               path,
+		Fields:             hookFields(m.Fields),
+	}
+	if stored, ok := storedInbound(m, msg); ok && len(stored.Attachments) > 0 {
+		for _, a := range stored.Attachments {
+			msg.Attachments = append(msg.Attachments, hookAttachment{
+				Kind:       a.Kind,
+				Name:       a.Name,
+				Format:     a.Format,
+				Path:       a.Path,
+				Size:       a.Size,
+				Mime:       a.Mime,
+				DurationMs: a.DurationMs,
+			})
+		}
+		return msg
+	}
+	var err error
+	msg.Attachments, err = saveAttachments(filepath.Join(storageDir, attachmentsSubdir, msg.MessageID), m.Fields)
+	if err != nil {
+		rns.Log("Could not save attachments of "+msg.MessageID+": "+err.Error(), rns.LOG_ERROR)
+	}
+	return msg
+}
+
+// storedInbound returns the node's stored copy of m. Group messages are stored under
+// the group ID and the group message ID; anything else under the source and LXMF ID.
+func storedInbound(m *lxmf.LXMessage, msg hookMessage) (runcore.StoredMessage, bool) {
+	if gid := runcore.GroupIDHex(m); gid != "" {
+		if stored, ok := node.StoredMessageHex(gid, runcore.GroupMessageIDHex(m)); ok {
+			return stored, true
+		}
+	}
+	return node.StoredMessageHex(msg.Source, msg.MessageID)
+}
+
+// watchOutbound reports the delivery outcome of m, sent by the daemon, to the
+// on_outbound_status hook and the webhook.
+func watchOutbound(m *lxmf.LXMessage) {
+	if m == nil || (hooks == nil || hooks.cfg.OnOutboundStatus == "") && webhooks == nil {
+		return
+	}
+	report := func(lxm *lxmf.LXMessage) {
+		msg := hookMessage{
+			Event:       "outbound_status",
+			MessageID:   messageIDHex(lxm),
+			Destination: hex.EncodeToString(lxm.DestinationHash),
+			Title:       lxm.TitleAsString(),
+			Timestamp:   lxm.Timestamp,
+			Method:      methodName(lxm.Method),
+			State:       stateName(lxm.State),
+		}
+		hooks.outboundStatus(msg)
+		webhooks.deliver(msg)
+	}
+	runcore.ChainMessageCallbacks(m, report, report)
+}
+
+// inbound queues the on_inbound hook for a message described by describeInbound.
+func (h *hookRunner) inbound(msg hookMessage) {
+	if h == nil || h.cfg.OnInbound == "" {
+		return
+	}
+	h.enqueue(h.cfg.OnInbound, []string{msg.Path}, msg)
+}
+
+func (h *hookRunner) outboundStatus(msg hookMessage) {
+	if h == nil || h.cfg.OnOutboundStatus == "" {
+		return
+	}
+	h.enqueue(h.cfg.OnOutboundStatus, []string{msg.MessageID, msg.State}, msg)
+}
+
+func (h *hookRunner) enqueue(command string, args []string, msg hookMessage) {
+	stdin, err := json.Marshal(msg)
+	if err != nil {
+		rns.Log("Could not encode "+msg.Event+" hook input: "+err.Error(), rns.LOG_ERROR)
+		return
+	}
+	env := []string{
+		"RUNCORE_EVENT=" + msg.Event,
+		"RUNCORE_MESSAGE_ID=" + msg.MessageID,
+		"RUNCORE_SOURCE=" + msg.Source,
+		"RUNCORE_DESTINATION=" + msg.Destination,
+		"RUNCORE_TITLE=" + envValue(msg.Title),
+		"RUNCORE_CONTENT=" + envValue(msg.Content),
+		"RUNCORE_TIMESTAMP=" + strconv.FormatFloat(msg.Timestamp, 'f', -1, 64),
+		"RUNCORE_METHOD=" + msg.Method,
+		"RUNCORE_STATE=" + msg.State,
+		"RUNCORE_MESSAGE_PATH=" + msg.Path,
+	}
+	if len(msg.Fields) > 0 {
+		if b, err := json.Marshal(msg.Fields); err == nil {
+			env = append(env, "RUNCORE_FIELDS="+envValue(string(b)))
+		}
+	}
+	if len(msg.Attachments) > 0 {
+		paths := make([]string, len(msg.Attachments))
+		for i, a := range msg.Attachments {
+			paths[i] = a.Path
+		}
+		env = append(env, "RUNCORE_ATTACHMENTS="+strings.Join(paths, string(os.PathListSeparator)))
+	}
+	h.submit(hookJob{event: msg.Event, command: command, args: args, env: env, stdin: stdin, attempt: 1})
+}
+
+func (h *hookRunner) submit(job hookJob) {
+	select {
+	case h.queue <- job:
+	default:
+		rns.Log("Hook queue full, dropped "+job.event+" hook", rns.LOG_ERROR)
+	}
+}
+
+func (h *hookRunner) worker() {
+	for job := range h.queue {
+		err := h.run(job)
+		if err == nil {
+			continue
+		}
+		if job.attempt > h.cfg.Retries {
+			rns.Log(fmt.Sprintf("%s hook failed after %d attempt(s): %v", job.event, job.attempt, err), rns.LOG_ERROR)
+			continue
+		}
+		delay := h.cfg.RetryDelay << (job.attempt - 1)
+		rns.Log(fmt.Sprintf("%s hook failed (attempt %d), retrying in %s: %v", job.event, job.attempt, delay, err), rns.LOG_NOTICE)
+		job.attempt++
+		time.AfterFunc(delay, func() { h.submit(job) })
+	}
+}
+
+func (h *hookRunner) run(job hookJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, job.command, job.args...)
+	cmd.Env = append(os.Environ(), job.env...)
+	cmd.Env = append(cmd.Env, "RUNCORE_ATTEMPT="+strconv.Itoa(job.attempt))
+	cmd.Stdin = bytes.NewReader(job.stdin)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", h.cfg.Timeout)
+	}
+	return err
+}
+
+// saveAttachments writes the files, image and audio carried in fields to dir.
+func saveAttachments(dir string, fields map[any]any) ([]hookAttachment, error) {
+	var out []hookAttachment
+	save := func(kind, name, format, ext string, data []byte) error {
+		if len(out) == 0 {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+		}
+		file := filepath.Base(filepath.Clean("/" + name))
+		if file == "/" || file == "." || name == "" {
+			file = fmt.Sprintf("%s-%d", kind, len(out)+1)
+			if ext != "" {
+				file += "." + filepath.Base(ext)
+			}
+		}
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+		out = append(out, hookAttachment{Kind: kind, Name: name, Format: format, Path: path, Size: len(data)})
+		return nil
+	}
+	for _, a := range runcore.MessageAttachments(fields) {
+		if err := save(a.Kind, a.Name, a.Format, a.Ext, a.Data); err != nil {
+			return out, err
+		}
+		if a.Kind == "audio" {
+			out[len(out)-1].Mime = a.Mime
+			out[len(out)-1].DurationMs = a.Duration.Milliseconds()
+		}
+	}
+	return out, nil
+}
+
+// hookFields converts LXMF fields to JSON: keys become decimal strings, byte strings that
+// are not UTF-8 are base64 encoded, and the attachment fields (already saved to disk)
+// are left out.
+func hookFields(fields map[any]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range fields {
+		switch key, _ := runcore.LXMFFieldKey(k); key {
+		case lxmf.FieldFileAttachments, lxmf.FieldImage, lxmf.FieldAudio:
+			continue
+		}
+		out[fmt.Sprint(k)] = jsonValue(v)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func jsonValue(v any) any {
+	switch x := v.(type) {
+	case []byte:
+		if utf8.Valid(x) {
+			return string(x)
+		}
+		return base64.StdEncoding.EncodeToString(x)
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = jsonValue(e)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[fmt.Sprint(jsonValue(k))] = jsonValue(e)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[k] = jsonValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+func envValue(s string) string {
+	s = strings.ReplaceAll(s, "\x00", "")
+	if len(s) <= hookEnvValueLimit {
+		return s
+	}
+	s = s[:hookEnvValueLimit]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func messageIDHex(m *lxmf.LXMessage) string {
+	if len(m.MessageID) > 0 {
+		return hex.EncodeToString(m.MessageID)
+	}
+	return hex.EncodeToString(m.Hash)
+}
+
+func methodName(method byte) string {
+	switch method {
+	case lxmf.MethodOpportunistic:
+		return "opportunistic"
+	case lxmf.MethodDirect:
+		return "direct"
+	case lxmf.MethodPropagated:
+		return "propagated"
+	}
+	return strconv.Itoa(int(method))
+}
+
+func stateName(state byte) string {
+	switch state {
+	case lxmf.MessageSent:
+		return "sent"
+	case lxmf.MessageDelivered:
+		return "delivered"
+	case lxmf.MessageRejected:
+		return "rejected"
+	case lxmf.MessageCancelled:
+		return "cancelled"
+	case lxmf.MessageFailed:
+		return "failed"
+	}
+	return strconv.Itoa(int(state))
+}
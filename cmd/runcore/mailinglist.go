@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+// Mailing-list mode ([list] enable = yes or -list). Anyone may subscribe and unsubscribe
+// by messaging "subscribe" / "unsubscribe"; messages from the configured senders are
+// re-sent to every subscriber. Subscribers and their delivery counters persist in
+// <config>/list_subscribers.json. Outgoing list traffic goes through one queue that sends
+// at most send_rate messages per minute.
+
+const (
+	listSubscribersFile = "list_subscribers.json"
+	listQueueLimit      = 10000
+	listReplyInterval   = time.Minute // per sender, for all command replies
+)
+
+type listConfig struct {
+	Name           string
+	Senders        map[string]bool
+	OpenSubscribe  bool
+	SendRate       int // messages per minute
+	Method         byte
+	WelcomeMessage string
+}
+
+// listSubscriber is a subscriber with per-subscriber delivery tracking.
+type listSubscriber struct {
+	Since         int64  `json:"since"`
+	Sent          int    `json:"sent"`
+	Delivered     int    `json:"delivered"`
+	Failed        int    `json:"failed"`
+	LastMessageID string `json:"last_message_id,omitempty"`
+	LastState     int    `json:"last_state,omitempty"`
+	LastAttempt   int64  `json:"last_attempt,omitempty"`
+}
+
+type listOutgoing struct {
+	dest    string
+	opts    runcore.SendOptions
+	tracked bool // counts towards the subscriber's delivery stats
+}
+
+type mailingList struct {
+	node *runcore.Node
+	cfg  listConfig
+	path string
+
+	mu          sync.Mutex
+	subscribers map[string]*listSubscriber
+	lastReply   map[string]time.Time
+
+	// saveMu serializes save: it runs from inbound handling, the send loop and delivery
+	// callbacks, which would otherwise race on the temporary file.
+	saveMu sync.Mutex
+
+	queue chan listOutgoing
+}
+
+func loadListConfig(force bool) (listConfig, bool) {
+	if !force && !boolKey("list", "enable", false) {
+		return listConfig{}, false
+	}
+	cfg := listConfig{
+		Name:           stringKey("list", "name", activeConfig.DisplayName),
+		Senders:        map[string]bool{},
+		OpenSubscribe:  boolKey("list", "open_subscription", true),
+		SendRate:       intKey("list", "send_rate", 30),
+		Method:         lxmf.MethodDirect,
+		WelcomeMessage: stringKey("list", "welcome", ""),
+	}
+	if sec := getSection("list"); sec != nil {
+		for _, s := range sec.AsList("senders") {
+			s = strings.ToLower(strings.Trim(strings.TrimSpace(s), "<>"))
+			if _, err := hex.DecodeString(s); err == nil && len(s) == lxmf.DestinationLength*2 {
+				cfg.Senders[s] = true
+			}
+		}
+	}
+	switch strings.ToLower(stringKey("list", "method", "direct")) {
+	case "opportunistic":
+		cfg.Method = lxmf.MethodOpportunistic
+	case "propagated":
+		cfg.Method = lxmf.MethodPropagated
+	}
+	if cfg.SendRate <= 0 {
+		cfg.SendRate = 30
+	}
+	return cfg, true
+}
+
+func startMailingList(n *runcore.Node, configDir string, cfg listConfig) *mailingList {
+	l := &mailingList{
+		node:        n,
+		cfg:         cfg,
+		path:        filepath.Join(configDir, listSubscribersFile),
+		subscribers: map[string]*listSubscriber{},
+		lastReply:   map[string]time.Time{},
+		queue:       make(chan listOutgoing, listQueueLimit),
+	}
+	if b, err := os.ReadFile(l.path); err == nil {
+		if err := json.Unmarshal(b, &l.subscribers); err != nil {
+			rns.Log("Could not parse "+listSubscribersFile+": "+err.Error(), rns.LOG_ERROR)
+		}
+	}
+	if len(cfg.Senders) == 0 {
+		rns.Log("Mailing list has no senders configured; only subscriptions are handled", rns.LOG_NOTICE)
+	}
+	go l.sendLoop()
+	rns.Log(fmt.Sprintf("Mailing list %q active with %d subscriber(s)", cfg.Name, len(l.subscribers)), rns.LOG_NOTICE)
+	return l
+}
+
+func (l *mailingList) save() {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+	l.mu.Lock()
+	b, err := json.MarshalIndent(l.subscribers, "", "  ")
+	l.mu.Unlock()
+	if err == nil {
+		tmp := l.path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o644); err == nil {
+			err = os.Rename(tmp, l.path)
+		}
+	}
+	if err != nil {
+		rns.Log("Could not save list subscribers: "+err.Error(), rns.LOG_ERROR)
+	}
+}
+
+// handleInbound handles commands and redistributes posts. It never blocks on sending.
+func (l *mailingList) handleInbound(m *lxmf.LXMessage) {
+	src := hex.EncodeToString(m.SourceHash)
+	cmd := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(m.ContentAsString()), "/"))
+	switch cmd {
+	case "subscribe", "join":
+		l.subscribe(src)
+		return
+	case "unsubscribe", "leave", "stop":
+		l.unsubscribe(src)
+		return
+	case "status":
+		l.reply(src, l.statusText(src))
+		return
+	case "help":
+		l.reply(src, l.helpText())
+		return
+	}
+	if !l.cfg.Senders[src] {
+		l.reply(src, l.helpText())
+		return
+	}
+	l.post(src, m)
+}
+
+func (l *mailingList) subscribe(src string) {
+	l.mu.Lock()
+	_, known := l.subscribers[src]
+	allowed := l.cfg.OpenSubscribe || l.cfg.Senders[src]
+	if !known && allowed {
+		l.subscribers[src] = &listSubscriber{Since: time.Now().Unix()}
+	}
+	l.mu.Unlock()
+	switch {
+	case known:
+		l.reply(src, "You are already subscribed to "+l.cfg.Name+".")
+	case !allowed:
+		l.reply(src, "Subscriptions to "+l.cfg.Name+" are closed.")
+	default:
+		l.save()
+		rns.Log("List: subscribed "+src, rns.LOG_NOTICE)
+		text := "Subscribed to " + l.cfg.Name + ". Send \"unsubscribe\" to leave."
+		if l.cfg.WelcomeMessage != "" {
+			text = l.cfg.WelcomeMessage + "\n\n" + text
+		}
+		l.reply(src, text)
+	}
+}
+
+func (l *mailingList) unsubscribe(src string) {
+	l.mu.Lock()
+	_, known := l.subscribers[src]
+	delete(l.subscribers, src)
+	l.mu.Unlock()
+	if !known {
+		l.reply(src, "You are not subscribed to "+l.cfg.Name+".")
+		return
+	}
+	l.save()
+	rns.Log("List: unsubscribed "+src, rns.LOG_NOTICE)
+	l.reply(src, "Unsubscribed from "+l.cfg.Name+".")
+}
+
+// post queues m for every subscriber except its author.
+func (l *mailingList) post(src string, m *lxmf.LXMessage) {
+	title := m.TitleAsString()
+	if title == "" {
+		title = l.cfg.Name
+	}
+	opts := runcore.SendOptions{
+		Method:  l.cfg.Method,
+		Title:   title,
+		Content: m.ContentAsString(),
+		Fields:  listForwardFields(m.Fields),
+	}
+	l.mu.Lock()
+	dests := make([]string, 0, len(l.subscribers))
+	for dest := range l.subscribers {
+		if dest != src {
+			dests = append(dests, dest)
+		}
+	}
+	l.mu.Unlock()
+	sort.Strings(dests)
+	queued := 0
+	for _, dest := range dests {
+		select {
+		case l.queue <- listOutgoing{dest: dest, opts: opts, tracked: true}:
+			queued++
+		default:
+			rns.Log("List: queue full, dropped delivery to "+dest, rns.LOG_ERROR)
+		}
+	}
+	rns.Log(fmt.Sprintf("List: post from %s queued for %d subscriber(s)", src, queued), rns.LOG_NOTICE)
+}
+
+// listForwardFields keeps the fields worth redistributing (attachments, images, audio).
+func listForwardFields(fields map[any]any) map[any]any {
+	out := map[any]any{}
+	for _, key := range []int{lxmf.FieldFileAttachments, lxmf.FieldImage, lxmf.FieldAudio} {
+		if v, ok := runcore.LXMFField(fields, key); ok {
+			out[key] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// reply queues a short answer. Replies are sent at most once per listReplyInterval per
+// sender, so commands cannot crowd list posts out of the queue.
+func (l *mailingList) reply(dest, text string) {
+	l.mu.Lock()
+	if time.Since(l.lastReply[dest]) < listReplyInterval {
+		l.mu.Unlock()
+		return
+	}
+	for d, t := range l.lastReply {
+		if time.Since(t) >= listReplyInterval {
+			delete(l.lastReply, d)
+		}
+	}
+	l.lastReply[dest] = time.Now()
+	l.mu.Unlock()
+	select {
+	case l.queue <- listOutgoing{dest: dest, opts: runcore.SendOptions{Method: l.cfg.Method, Title: l.cfg.Name, Content: text}}:
+	default:
+	}
+}
+
+func (l *mailingList) helpText() string {
+	return l.cfg.Name + " commands: subscribe, unsubscribe, status, help."
+}
+
+func (l *mailingList) statusText(src string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	text := fmt.Sprintf("%s: %d subscriber(s).", l.cfg.Name, len(l.subscribers))
+	if s := l.subscribers[src]; s != nil {
+		text += fmt.Sprintf(" You are subscribed since %s: %d sent, %d delivered, %d failed.",
+			time.Unix(s.Since, 0).UTC().Format("2006-01-02"), s.Sent, s.Delivered, s.Failed)
+	} else {
+		text += " You are not subscribed."
+	}
+	return text
+}
+
+// sendLoop sends queued messages at no more than cfg.SendRate per minute.
+func (l *mailingList) sendLoop() {
+	tick := time.NewTicker(time.Minute / time.Duration(l.cfg.SendRate))
+	defer tick.Stop()
+	for out := range l.queue {
+		<-tick.C
+		lxm, err := l.node.SendHex(out.dest, out.opts)
+		if err == nil {
+			watchOutbound(lxm)
+		}
+		if !out.tracked {
+			if err != nil {
+				rns.Log("List: reply to "+out.dest+" failed: "+err.Error(), rns.LOG_DEBUG)
+			}
+			continue
+		}
+		l.track(out.dest, lxm, err)
+	}
+}
+
+// track records a delivery attempt and follows its outcome.
+func (l *mailingList) track(dest string, lxm *lxmf.LXMessage, err error) {
+	l.mu.Lock()
+	s := l.subscribers[dest]
+	if s == nil {
+		l.mu.Unlock()
+		return
+	}
+	s.Sent++
+	s.LastAttempt = time.Now().Unix()
+	if err != nil || lxm == nil {
+		s.Failed++
+		s.LastState = int(lxmf.MessageFailed)
+		l.mu.Unlock()
+		rns.Log(fmt.Sprintf("List: delivery to %s failed: %v", dest, err), rns.LOG_NOTICE)
+		l.save()
+		return
+	}
+	msgID := hex.EncodeToString(lxm.MessageID)
+	s.LastMessageID = msgID
+	s.LastState = int(lxm.State)
+	l.mu.Unlock()
+
+	update := func(m *lxmf.LXMessage) {
+		l.mu.Lock()
+		s := l.subscribers[dest]
+		if s != nil {
+			if m.State == lxmf.MessageDelivered || m.State == lxmf.MessageSent {
+				s.Delivered++
+			} else {
+				s.Failed++
+			}
+			if s.LastMessageID == msgID {
+				s.LastState = int(m.State)
+			}
+		}
+		l.mu.Unlock()
+		l.save()
+	}
+	runcore.ChainMessageCallbacks(lxm, update, update)
+}
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
@@ -18,7 +17,6 @@ import (
 
 const (
 	deferredJobsDelay = 10 * time.Second
-	jobsInterval      = 5 * time.Second
 )
 
 // Mostly copied from go-lxmf/cmd/lxmd.go for behavioural parity.
@@ -72,6 +70,63 @@ delivery_transfer_max_accepted_size = 1000
 
 [logging]
 loglevel = 4
+
+# Mailing-list mode (runcore extension). Anyone can
+# send "subscribe" or "unsubscribe"; messages from the
+# listed senders are re-sent to every subscriber.
+
+[list]
+
+enable = no
+
+# name = Announcements
+# senders = <dest hash hex>, <dest hash hex>
+# open_subscription = yes
+# welcome = Welcome to the list.
+
+# Maximum list messages sent per minute, and the
+# delivery method (direct, opportunistic, propagated).
+
+# send_rate = 30
+# method = direct
+
+# Commands run for received messages and for the
+# delivery outcome of messages this daemon sends.
+# They get the message as JSON on stdin and as
+# RUNCORE_* environment variables.
+
+[hooks]
+
+# on_inbound = /path/to/script
+# on_outbound_status = /path/to/script
+
+# Concurrent runs, timeout and retries (seconds).
+
+# workers = 2
+# timeout = 30
+# retries = 2
+# retry_delay = 10
+
+# HTTP webhook: received messages and delivery
+# outcomes are POSTed as JSON to url, signed with
+# HMAC-SHA256 of the body when a secret is set.
+# Deliveries that keep failing are written to
+# dead_letter. With listen set, POST /send on that
+# address sends a message.
+
+[webhook]
+
+# url = http://127.0.0.1:8123/api/webhook/lxmf
+# secret = change-me
+# listen = 127.0.0.1:8844
+
+# Request timeout, retries and initial backoff
+# (seconds, doubling per retry).
+
+# timeout = 10
+# retries = 5
+# backoff = 5
+# dead_letter = /path/to/dead-letter/dir
 `
 
 type activeConfiguration struct {
@@ -79,7 +134,6 @@ type activeConfiguration struct {
 	PeerAnnounceAtStart             bool
 	PeerAnnounceInterval            time.Duration
 	DeliveryTransferMaxAcceptedSize int
-	OnInbound                       string
 
 	EnablePropagationNode              bool
 	NodeName                           string
@@ -109,10 +163,10 @@ var (
 	lxmdConfig     *configobj.Config
 	activeConfig   = activeConfiguration{}
 
-	node *runcore.Node
-
-	lastPeerAnnounce time.Time
-	lastNodeAnnounce time.Time
+	node     *runcore.Node
+	list     *mailingList
+	hooks    *hookRunner
+	webhooks *webhookSender
 )
 
 func getSection(name string) *configobj.Section {
@@ -196,7 +250,7 @@ func applyConfig() error {
 	return nil
 }
 
-func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onInbound string, verbosity, quietness int, service bool, resetLXMF bool) {
+func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onInbound string, verbosity, quietness int, service bool, resetLXMF bool, forceList bool, archive *archiveJob) {
 	if configDir == "" {
 		home, _ := os.UserHomeDir()
 		if home != "" {
@@ -251,13 +305,20 @@ func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onI
 		logDest = rns.LOG_FILE
 	}
 
+	if forcePropagationNode {
+		activeConfig.EnablePropagationNode = true
+	}
 	opts := runcore.Options{
-		Dir:            configDir,
-		RNSConfigDir:   rnsConfigDir,
-		DisplayName:    activeConfig.DisplayName,
-		LogLevel:       level,
-		LogDest:        logDest,
-		ResetLXMFState: resetLXMF,
+		Dir:              configDir,
+		RNSConfigDir:     rnsConfigDir,
+		DisplayName:      activeConfig.DisplayName,
+		LogLevel:         level,
+		LogDest:          logDest,
+		ResetLXMFState:   resetLXMF,
+		AnnounceInterval: announceIntervalOption(activeConfig.PeerAnnounceInterval),
+	}
+	if activeConfig.EnablePropagationNode {
+		opts.PropagationAnnounceInterval = announceIntervalOption(activeConfig.NodeAnnounceInterval)
 	}
 	node, err = runcore.Start(opts)
 	if err != nil {
@@ -265,6 +326,16 @@ func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onI
 		os.Exit(1)
 	}
 
+	if archive != nil {
+		err := archive.run(node)
+		node.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	router := node.Router()
 	router.DeliveryPerTransferLimit = activeConfig.DeliveryTransferMaxAcceptedSize
 	router.AutoPeer = activeConfig.AutoPeer
@@ -273,8 +344,12 @@ func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onI
 		router.MaxPeers = activeConfig.MaxPeers
 	}
 
-	if onInbound != "" {
-		activeConfig.OnInbound = onInbound
+	hooks = startHooks(loadHookConfig(onInbound))
+	if cfg, ok := loadWebhookConfig(configDir); ok {
+		webhooks = startWebhook(cfg)
+	}
+	if cfg, ok := loadListConfig(forceList); ok {
+		list = startMailingList(node, configDir, cfg)
 	}
 
 	node.SetInboundHandler(func(m *lxmf.LXMessage) {
@@ -287,22 +362,19 @@ func programSetup(configDir, rnsConfigDir string, forcePropagationNode bool, onI
 			return
 		}
 		rns.Log("Received "+m.String()+" written to "+written, rns.LOG_INFO)
-		if activeConfig.OnInbound != "" {
-			cmd := exec.Command(activeConfig.OnInbound, written)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				rns.Log("Inbound action failed: "+err.Error(), rns.LOG_ERROR)
-			}
+		if list != nil {
+			list.handleInbound(m)
+		}
+		if (hooks != nil && hooks.cfg.OnInbound != "") || webhooks != nil {
+			msg := describeInbound(m, written)
+			hooks.inbound(msg)
+			webhooks.deliver(msg)
 		}
 	})
 
 	// Print "ready" line like lxmd.
 	rns.Log("LXMF Router ready to receive on "+rns.PrettyHexRep(node.DeliveryDestination().Hash()), rns.LOG_NOTICE)
 
-	if forcePropagationNode {
-		activeConfig.EnablePropagationNode = true
-	}
 	if activeConfig.EnablePropagationNode {
 		_ = router.EnablePropagation()
 		if router.PropagationDestination != nil {
@@ -328,25 +400,15 @@ func deferredStartJobs() {
 	if activeConfig.EnablePropagationNode && activeConfig.NodeAnnounceAtStart {
 		r.AnnouncePropagationNode()
 	}
-	lastPeerAnnounce = time.Now()
-	lastNodeAnnounce = time.Now()
-	go jobs()
+	// Periodic announces are scheduled by runcore (see Options.AnnounceInterval).
 }
 
-func jobs() {
-	for {
-		if node != nil && node.Router() != nil && node.DeliveryDestination() != nil {
-			if activeConfig.PeerAnnounceInterval > 0 && time.Since(lastPeerAnnounce) >= activeConfig.PeerAnnounceInterval {
-				node.Router().Announce(node.DeliveryDestination().Hash(), nil)
-				lastPeerAnnounce = time.Now()
-			}
-			if activeConfig.EnablePropagationNode && activeConfig.NodeAnnounceInterval > 0 && time.Since(lastNodeAnnounce) >= activeConfig.NodeAnnounceInterval {
-				node.Router().AnnouncePropagationNode()
-				lastNodeAnnounce = time.Now()
-			}
-		}
-		time.Sleep(jobsInterval)
+// announceIntervalOption maps lxmd's "0 = never" to runcore's "negative = disabled".
+func announceIntervalOption(d time.Duration) time.Duration {
+	if d <= 0 {
+		return -1
 	}
+	return d
 }
 
 func fileExists(path string) bool {
@@ -358,9 +420,14 @@ func main() {
 	configDir := flag.String("config", "", "path to config directory (lxmd-compatible layout)")
 	rnsConfigDir := flag.String("rnsconfig", "", "path to alternative Reticulum config directory (optional)")
 	propagationNode := flag.Bool("propagation-node", false, "run as an LXMF Propagation Node")
-	onInbound := flag.String("on-inbound", "", "command run when a message is received (arg: message file path)")
+	onInbound := flag.String("on-inbound", "", "command run when a message is received (arg: message file path; see [hooks])")
 	service := flag.Bool("service", false, "log to file (Reticulum logdest)")
 	resetLXMF := flag.Bool("reset-lxmf", false, "remove LXMF transient state under config dir before starting")
+	listMode := flag.Bool("list", false, "run as a mailing list (see [list] in the config)")
+	importPaths := flag.String("import", "", "import LXMF message files or directories (comma-separated) into the message store and exit")
+	exportDir := flag.String("export", "", "export stored conversations to this directory and exit")
+	exportFormat := flag.String("export-format", "maildir", "export format: maildir, jsonl or html")
+	exportPeers := flag.String("export-peers", "", "destination hashes (comma-separated) to export (default: all)")
 	example := flag.Bool("exampleconfig", false, "print verbose configuration example and exit")
 	version := flag.Bool("version", false, "print version and exit")
 
@@ -382,5 +449,6 @@ func main() {
 	}
 
 	// If rnsconfig is empty, runcore.Start will use configDir/rns with an inline default.
-	programSetup(*configDir, *rnsConfigDir, *propagationNode, *onInbound, verboseCount, quietCount, *service, *resetLXMF)
+	programSetup(*configDir, *rnsConfigDir, *propagationNode, *onInbound, verboseCount, quietCount, *service, *resetLXMF, *listMode,
+		newArchiveJob(*importPaths, *exportDir, *exportFormat, *exportPeers))
 }
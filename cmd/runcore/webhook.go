@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+// Webhook ([webhook] in the config). Every inbound message, and the delivery outcome of
+// every message the daemon sends, is POSTed as JSON (the document hooks get on stdin) to
+// url. With a secret, requests carry X-Runcore-Timestamp (unix seconds) and
+// X-Runcore-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">. Failed deliveries
+// are retried with doubling backoff; what still fails is written to the dead-letter
+// directory. With listen set, POST /send on that address sends a message; requests must
+// then be signed the same way when a secret is configured, with a timestamp within
+// webhookSendMaxSkew and a signature not seen before, and without a secret the endpoint
+// only listens on loopback addresses.
+
+const (
+	webhookQueueLimit   = 1024
+	webhookMaxBackoff   = 10 * time.Minute
+	webhookSendMaxBytes = 1 << 20
+	webhookSignature    = "X-Runcore-Signature"
+	webhookTimestamp    = "X-Runcore-Timestamp"
+	// webhookSendMaxSkew is how far a /send timestamp may be from now. Signatures are
+	// remembered for twice that (as long as their timestamp can pass) to refuse replays.
+	webhookSendMaxSkew = 5 * time.Minute
+)
+
+type webhookConfig struct {
+	URL        string
+	Secret     string
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	DeadLetter string
+	Listen     string
+}
+
+type webhookDelivery struct {
+	id      string
+	event   string
+	body    []byte
+	attempt int
+}
+
+type webhookSender struct {
+	cfg    webhookConfig
+	client *http.Client
+	queue  chan webhookDelivery
+
+	seenMu sync.Mutex
+	seen   map[string]time.Time // /send signatures by arrival, for replay protection
+}
+
+// webhookSendRequest is the body of POST /send.
+type webhookSendRequest struct {
+	Destination string `json:"destination"`
+	Title       string `json:"title,omitempty"`
+	Content     string `json:"content"`
+	Method      string `json:"method,omitempty"`
+	ReplyTo     string `json:"reply_to,omitempty"`
+}
+
+func loadWebhookConfig(configDir string) (webhookConfig, bool) {
+	cfg := webhookConfig{
+		URL:        stringKey("webhook", "url", ""),
+		Secret:     stringKey("webhook", "secret", ""),
+		Timeout:    time.Duration(intKey("webhook", "timeout", 10)) * time.Second,
+		Retries:    intKey("webhook", "retries", 5),
+		Backoff:    time.Duration(intKey("webhook", "backoff", 5)) * time.Second,
+		DeadLetter: stringKey("webhook", "dead_letter", filepath.Join(configDir, "webhook_dead_letter")),
+		Listen:     stringKey("webhook", "listen", ""),
+	}
+	if cfg.URL == "" && cfg.Listen == "" {
+		return cfg, false
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 5 * time.Second
+	}
+	return cfg, true
+}
+
+func startWebhook(cfg webhookConfig) *webhookSender {
+	w := &webhookSender{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan webhookDelivery, webhookQueueLimit),
+	}
+	if cfg.URL != "" {
+		go w.sendLoop()
+		rns.Log("Webhook delivering to "+cfg.URL, rns.LOG_NOTICE)
+	}
+	if cfg.Listen != "" {
+		go w.serve()
+	}
+	return w
+}
+
+// deliver queues msg for POSTing to the webhook URL.
+func (w *webhookSender) deliver(msg hookMessage) {
+	if w == nil || w.cfg.URL == "" {
+		return
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		rns.Log("Could not encode webhook body: "+err.Error(), rns.LOG_ERROR)
+		return
+	}
+	d := webhookDelivery{
+		id:      msg.Event + "-" + msg.MessageID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		event:   msg.Event,
+		body:    body,
+		attempt: 1,
+	}
+	w.submit(d)
+}
+
+func (w *webhookSender) submit(d webhookDelivery) {
+	select {
+	case w.queue <- d:
+	default:
+		w.deadLetter(d, errors.New("queue full"))
+	}
+}
+
+// sendLoop posts one delivery at a time. A delivery being retried is queued again behind
+// newer ones, so receivers must not rely on the order (each body carries its timestamp).
+func (w *webhookSender) sendLoop() {
+	for d := range w.queue {
+		retry, err := w.post(d)
+		if err == nil {
+			continue
+		}
+		if !retry || d.attempt > w.cfg.Retries {
+			w.deadLetter(d, err)
+			continue
+		}
+		delay := w.cfg.Backoff << (d.attempt - 1)
+		if delay > webhookMaxBackoff || delay <= 0 {
+			delay = webhookMaxBackoff
+		}
+		rns.Log(fmt.Sprintf("Webhook %s failed (attempt %d), retrying in %s: %v", d.id, d.attempt, delay, err), rns.LOG_NOTICE)
+		d.attempt++
+		time.AfterFunc(delay, func() { w.submit(d) })
+	}
+}
+
+// post sends d once; retry reports whether a failure is worth retrying.
+func (w *webhookSender) post(d webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "runcore/"+lxmf.Version)
+	req.Header.Set("X-Runcore-Event", d.event)
+	req.Header.Set("X-Runcore-Delivery", d.id)
+	req.Header.Set("X-Runcore-Attempt", strconv.Itoa(d.attempt))
+	if w.cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestamp, ts)
+		req.Header.Set(webhookSignature, webhookSign(w.cfg.Secret, ts, d.body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("HTTP %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+}
+
+// deadLetter keeps an undeliverable body in the dead-letter directory for inspection or
+// replay.
+func (w *webhookSender) deadLetter(d webhookDelivery, cause error) {
+	rns.Log(fmt.Sprintf("Webhook %s undeliverable after %d attempt(s): %v", d.id, d.attempt, cause), rns.LOG_ERROR)
+	if w.cfg.DeadLetter == "" {
+		return
+	}
+	record, err := json.MarshalIndent(struct {
+		ID       string          `json:"id"`
+		URL      string          `json:"url"`
+		Error    string          `json:"error"`
+		Attempts int             `json:"attempts"`
+		Failed   int64           `json:"failed"`
+		Body     json.RawMessage `json:"body"`
+	}{d.id, w.cfg.URL, cause.Error(), d.attempt, time.Now().Unix(), d.body}, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(w.cfg.DeadLetter, 0o755); err == nil {
+			err = os.WriteFile(filepath.Join(w.cfg.DeadLetter, d.id+".json"), record, 0o644)
+		}
+	}
+	if err != nil {
+		rns.Log("Could not write webhook dead letter: "+err.Error(), rns.LOG_ERROR)
+	}
+}
+
+// webhookSign signs "<timestamp>.<body>", so a captured request cannot be replayed later
+// with a new timestamp.
+func webhookSign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSendSignature verifies a /send request's signature and timestamp and refuses
+// signatures already seen within webhookSendMaxSkew.
+func (w *webhookSender) checkSendSignature(r *http.Request, body []byte) error {
+	ts := r.Header.Get(webhookTimestamp)
+	sig := r.Header.Get(webhookSignature)
+	if !hmac.Equal([]byte(webhookSign(w.cfg.Secret, ts, body)), []byte(sig)) {
+		return errors.New("bad signature")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("bad timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > webhookSendMaxSkew || skew < -webhookSendMaxSkew {
+		return errors.New("stale timestamp")
+	}
+	w.seenMu.Lock()
+	defer w.seenMu.Unlock()
+	for s, t := range w.seen {
+		if now.Sub(t) > 2*webhookSendMaxSkew {
+			delete(w.seen, s)
+		}
+	}
+	if _, ok := w.seen[sig]; ok {
+		return errors.New("replayed request")
+	}
+	if w.seen == nil {
+		w.seen = make(map[string]time.Time)
+	}
+	w.seen[sig] = now
+	return nil
+}
+
+func (w *webhookSender) serve() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /send", w.handleSend)
+	srv := &http.Server{
+		Addr:              w.cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := checkWebhookListen(w.cfg.Listen, w.cfg.Secret); err != nil {
+		rns.Log("Webhook send endpoint not started: "+err.Error(), rns.LOG_ERROR)
+		return
+	}
+	rns.Log("Webhook send endpoint listening on "+w.cfg.Listen, rns.LOG_NOTICE)
+	if err := srv.ListenAndServe(); err != nil {
+		rns.Log("Webhook send endpoint stopped: "+err.Error(), rns.LOG_ERROR)
+	}
+}
+
+// checkWebhookListen refuses a send endpoint that anyone on the network could use: without
+// a secret it may only listen on a loopback address.
+func checkWebhookListen(listen, secret string) error {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	if secret != "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address and no secret is set", listen)
+}
+
+func (w *webhookSender) handleSend(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, webhookSendMaxBytes))
+	if err != nil {
+		writeJSONError(rw, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if w.cfg.Secret != "" {
+		if err := w.checkSendSignature(r, body); err != nil {
+			writeJSONError(rw, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	var req webhookSendRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err)
+		return
+	}
+	dest := strings.ToLower(strings.TrimSpace(req.Destination))
+	if b, err := hex.DecodeString(dest); err != nil || len(b) != lxmf.DestinationLength {
+		writeJSONError(rw, http.StatusBadRequest, errors.New("destination must be a hex destination hash"))
+		return
+	}
+	opts := runcore.SendOptions{Method: lxmf.MethodDirect, Title: req.Title, Content: req.Content}
+	switch strings.ToLower(req.Method) {
+	case "", "direct":
+	case "opportunistic":
+		opts.Method = lxmf.MethodOpportunistic
+	case "propagated":
+		opts.Method = lxmf.MethodPropagated
+	default:
+		writeJSONError(rw, http.StatusBadRequest, errors.New("unknown method "+req.Method))
+		return
+	}
+
+	var lxm *lxmf.LXMessage
+	if req.ReplyTo != "" {
+		lxm, err = node.SendReply(dest, req.ReplyTo, opts)
+	} else {
+		lxm, err = node.SendHex(dest, opts)
+	}
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, runcore.ErrPeerUnsupported) {
+			status = http.StatusConflict
+		}
+		writeJSONError(rw, status, err)
+		return
+	}
+	watchOutbound(lxm)
+	rns.Log("Webhook send to "+dest+" queued as "+messageIDHex(lxm), rns.LOG_INFO)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(rw).Encode(map[string]string{
+		"message_id": messageIDHex(lxm),
+		"state":      stateName(lxm.State),
+	})
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook receiver answering with the given statuses in turn (the
+// last one repeats) and recording every request.
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	got      chan struct{}
+}
+
+func newWebhookRecorder(t *testing.T, statuses ...int) (*webhookRecorder, *httptest.Server) {
+	r := &webhookRecorder{statuses: statuses, got: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		status := r.statuses[min(len(r.requests), len(r.statuses)-1)]
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+		rw.WriteHeader(status)
+		r.got <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *webhookRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d webhook requests", i, n)
+		}
+	}
+}
+
+func testWebhook(t *testing.T, url string, retries int) *webhookSender {
+	t.Helper()
+	return startWebhook(webhookConfig{
+		URL:        url,
+		Secret:     "s3cret",
+		Timeout:    time.Second,
+		Retries:    retries,
+		Backoff:    time.Millisecond,
+		DeadLetter: t.TempDir(),
+	})
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rec, srv := newWebhookRecorder(t, http.StatusNoContent)
+	w := testWebhook(t, srv.URL, 0)
+	w.deliver(hookMessage{Event: "inbound", MessageID: "ab", Content: "hello"})
+	rec.wait(t, 1)
+
+	req, body := rec.requests[0], rec.bodies[0]
+	ts := req.Header.Get(webhookTimestamp)
+	if unix, err := strconv.ParseInt(ts, 10, 64); err != nil || time.Since(time.Unix(unix, 0)) > time.Minute {
+		t.Fatalf("timestamp = %q", ts)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if got, want := req.Header.Get(webhookSignature), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("signature = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Runcore-Event"); got != "inbound" {
+		t.Fatalf("event header = %q", got)
+	}
+	var msg hookMessage
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Content != "hello" {
+		t.Fatalf("body = %s (%v)", body, err)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	rec, srv := newWebhookRecorder(t, http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK)
+	w := testWebhook(t, srv.URL, 3)
+	w.deliver(hookMessage{Event: "inbound", MessageID: "ab"})
+	rec.wait(t, 3)
+
+	for i, req := range rec.requests {
+		if got, want := req.Header.Get("X-Runcore-Attempt"), []string{"1", "2", "3"}[i]; got != want {
+			t.Fatalf("request %d: attempt = %q, want %q", i, got, want)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if entries, _ := os.ReadDir(w.cfg.DeadLetter); len(entries) != 0 {
+		t.Fatalf("dead letter written for a delivered webhook: %v", entries)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	rec, srv := newWebhookRecorder(t, http.StatusServiceUnavailable)
+	w := testWebhook(t, srv.URL, 2)
+	w.deliver(hookMessage{Event: "inbound", MessageID: "ab", Content: "lost"})
+	rec.wait(t, 3)
+
+	var entries []os.DirEntry
+	for deadline := time.Now().Add(5 * time.Second); len(entries) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		entries, _ = os.ReadDir(w.cfg.DeadLetter)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("dead letter entries = %d, want 1", len(entries))
+	}
+	b, err := os.ReadFile(filepath.Join(w.cfg.DeadLetter, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		Attempts int         `json:"attempts"`
+		Error    string      `json:"error"`
+		Body     hookMessage `json:"body"`
+	}
+	if err := json.Unmarshal(b, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Attempts != 3 || !strings.Contains(record.Error, "503") || record.Body.Content != "lost" {
+		t.Fatalf("dead letter = %s", b)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(rec.requests))
+	}
+}
+
+func TestWebhookSendRejectsBadSignature(t *testing.T) {
+	w := &webhookSender{cfg: webhookConfig{Secret: "s3cret"}}
+	body := []byte(`{"destination":"00112233445566778899aabbccddeeff","content":"hi"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*webhookSendMaxSkew).Unix(), 10)
+	bodyMAC := hmac.New(sha256.New, []byte("s3cret")) // the old, replayable scheme
+	bodyMAC.Write(body)
+	for name, h := range map[string]struct{ ts, sig string }{
+		"missing":      {now, ""},
+		"wrong":        {now, webhookSign("other", now, body)},
+		"garbled":      {now, "sha256=zz"},
+		"body only":    {now, "sha256=" + hex.EncodeToString(bodyMAC.Sum(nil))},
+		"other time":   {now, webhookSign("s3cret", stale, body)},
+		"stale":        {stale, webhookSign("s3cret", stale, body)},
+		"no timestamp": {"", webhookSign("s3cret", "", body)},
+	} {
+		rw := sendRequest(w, h.ts, h.sig, body)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("%s signature: status = %d, want %d", name, rw.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestWebhookSendRejectsReplay(t *testing.T) {
+	w := &webhookSender{cfg: webhookConfig{Secret: "s3cret"}}
+	// An invalid destination passes the signature check and fails right after it.
+	body := []byte(`{"destination":"zz","content":"hi"}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := webhookSign("s3cret", ts, body)
+	if rw := sendRequest(w, ts, sig, body); rw.Code != http.StatusBadRequest {
+		t.Fatalf("first request: status = %d, want %d", rw.Code, http.StatusBadRequest)
+	}
+	if rw := sendRequest(w, ts, sig, body); rw.Code != http.StatusUnauthorized || !strings.Contains(rw.Body.String(), "replayed") {
+		t.Fatalf("replayed request: status = %d (%s), want %d", rw.Code, rw.Body, http.StatusUnauthorized)
+	}
+}
+
+func sendRequest(w *webhookSender, ts, sig string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewReader(body))
+	if ts != "" {
+		req.Header.Set(webhookTimestamp, ts)
+	}
+	if sig != "" {
+		req.Header.Set(webhookSignature, sig)
+	}
+	rw := httptest.NewRecorder()
+	w.handleSend(rw, req)
+	return rw
+}
+
+func TestCheckWebhookListen(t *testing.T) {
+	for _, tc := range []struct {
+		listen, secret string
+		ok             bool
+	}{
+		{"127.0.0.1:8080", "", true},
+		{"[::1]:8080", "", true},
+		{"localhost:8080", "", true},
+		{":8080", "", false},
+		{"0.0.0.0:8080", "", false},
+		{"192.168.1.2:8080", "", false},
+		{":8080", "s3cret", true},
+		{"nonsense", "s3cret", false},
+	} {
+		if err := checkWebhookListen(tc.listen, tc.secret); (err == nil) != tc.ok {
+			t.Errorf("checkWebhookListen(%q, %q) = %v, want ok=%v", tc.listen, tc.secret, err, tc.ok)
+		}
+	}
+}
@@ -1,13 +1,13 @@
 package runcore
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/svanichkin/go-reticulum/rns"
-	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
 )
 
 type ContactAvatarInfo struct {
@@ -15,14 +15,49 @@ type ContactAvatarInfo struct {
 	Mime    string `json:"mime,omitempty"`
 	Size    int    `json:"size,omitempty"`
 	Updated int64  `json:"updated,omitempty"`
+
+	// Thumbnail variant, if the peer advertises one (see ContactAvatarThumbnailHex).
+	ThumbHashHex string `json:"thumb_hash_hex,omitempty"`
+	ThumbMime    string `json:"thumb_mime,omitempty"`
+	ThumbSize    int    `json:"thumb_size,omitempty"`
 }
 
 type ContactInfo struct {
-	DisplayName string            `json:"display_name,omitempty"`
+	DisplayName string             `json:"display_name,omitempty"`
 	Avatar      *ContactAvatarInfo `json:"avatar,omitempty"`
+	// Protocol is the peer's runcore profile protocol version (0 = legacy runcore or
+	// not runcore). Capabilities lists the runcore features the peer serves (CapAvatar, ...).
+	Protocol     int      `json:"protocol,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// ProfileHashHex identifies the peer's extended profile (see ContactProfileHex).
+	ProfileHashHex string `json:"profile_hash_hex,omitempty"`
 }
 
+// ContactInfoHex returns announce metadata for a contact. With timeout <= 0 only the
+// local identity cache is consulted; a timeout that expires yields an empty ContactInfo.
 func (n *Node) ContactInfoHex(destinationHashHex string, timeout time.Duration) (ContactInfo, error) {
+	if timeout <= 0 {
+		return n.contactInfo(nil, destinationHashHex)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info, err := n.ContactInfoHexContext(ctx, destinationHashHex)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ContactInfo{}, nil
+	}
+	return info, err
+}
+
+// ContactInfoHexContext is like ContactInfoHex but waits for announce metadata until ctx is done.
+func (n *Node) ContactInfoHexContext(ctx context.Context, destinationHashHex string) (ContactInfo, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return n.contactInfo(ctx, destinationHashHex)
+}
+
+// contactInfo only consults the identity cache when ctx is nil.
+func (n *Node) contactInfo(ctx context.Context, destinationHashHex string) (ContactInfo, error) {
 	if n == nil {
 		return ContactInfo{}, errors.New("node not started")
 	}
@@ -34,8 +69,13 @@ func (n *Node) ContactInfoHex(destinationHashHex string, timeout time.Duration)
 		return ContactInfo{}, fmt.Errorf("invalid destination hash length: got %d want %d", len(destHash), 16)
 	}
 
+	// A profile push received after the last announce supersedes the announced app-data.
+	if pushed := n.pushedAppData(hex.EncodeToString(destHash)); len(pushed) > 0 {
+		return contactInfoFromAppData(pushed), nil
+	}
+
 	var id *rns.Identity
-	if timeout <= 0 {
+	if ctx == nil {
 		id = rns.IdentityRecall(destHash)
 		if id == nil || len(id.AppData) == 0 {
 			return ContactInfo{}, nil
@@ -44,76 +84,49 @@ func (n *Node) ContactInfoHex(destinationHashHex string, timeout time.Duration)
 		// Important for macCatalyst: we can have an identity in cache without having
 		// a path/announce, which means AppData (display name + avatar metadata) is empty.
 		// Requesting a path triggers peers/routers to announce, which populates AppData.
-		rns.TransportRequestPath(destHash)
-		deadline := time.Now().Add(timeout)
-		for {
-			id = rns.IdentityRecall(destHash)
-			if id != nil && len(id.AppData) > 0 {
-				break
-			}
-			if time.Now().After(deadline) {
-				return ContactInfo{}, nil
-			}
-			time.Sleep(120 * time.Millisecond)
+		n.RequestPath(destHash)
+		id, err = n.awaitIdentity(ctx, destHash, true)
+		if err != nil {
+			return ContactInfo{}, err
 		}
 	}
 
-	var unpacked []any
-	if err := umsgpack.Unpackb(id.AppData, &unpacked); err != nil {
-		return ContactInfo{}, nil
-	}
+	return contactInfoFromAppData(id.AppData), nil
+}
+
+func contactInfoFromAppData(appData []byte) ContactInfo {
+	// Malformed app-data is counted by the decoder and yields an empty (or name-only) result,
+	// as peers we cannot parse are still valid contacts.
+	data, _ := decodeAnnounceAppData(appData)
+	out := ContactInfo{DisplayName: data.DisplayName}
 
-	out := ContactInfo{}
-	if len(unpacked) > 0 {
-		switch v := unpacked[0].(type) {
-		case []byte:
-			if len(v) > 0 {
-				out.DisplayName = string(v)
-			}
-		case string:
-			out.DisplayName = v
+	// Optional avatar metadata (runcore extension).
+	if av := data.Avatar; av != nil {
+		out.Avatar = &ContactAvatarInfo{
+			HashHex: hex.EncodeToString(av.Hash),
+			Mime:    av.Mime,
+			Size:    av.Size,
+			Updated: av.Updated,
+		}
+		if len(av.ThumbHash) > 0 {
+			out.Avatar.ThumbHashHex = hex.EncodeToString(av.ThumbHash)
+			out.Avatar.ThumbMime = av.ThumbMime
+			out.Avatar.ThumbSize = av.ThumbSize
 		}
 	}
 
-	// Optional avatar metadata (runcore extension).
-	if len(unpacked) > 2 {
-		if m, ok := unpacked[2].(map[any]any); ok {
-			av := &ContactAvatarInfo{}
-			if hv, ok := m["h"]; ok {
-				if b, ok := hv.([]byte); ok && len(b) > 0 {
-					av.HashHex = hex.EncodeToString(b)
-				}
-			}
-			if tv, ok := m["t"]; ok {
-				if s, ok := tv.(string); ok {
-					av.Mime = s
-				}
-			}
-			if sv, ok := m["s"]; ok {
-				switch n := sv.(type) {
-				case int:
-					av.Size = n
-				case int64:
-					av.Size = int(n)
-				case float64:
-					av.Size = int(n)
-				}
-			}
-			if uv, ok := m["u"]; ok {
-				switch n := uv.(type) {
-				case int64:
-					av.Updated = n
-				case int:
-					av.Updated = int64(n)
-				case float64:
-					av.Updated = int64(n)
-				}
-			}
-			if av.HashHex != "" || av.Mime != "" || av.Size != 0 || av.Updated != 0 {
-				out.Avatar = av
-			}
+	// Versioned profile map (slot 3). Peers that only announce avatar metadata predate it
+	// and serve avatars and attachments; anything else is treated as a plain LXMF client.
+	switch {
+	case data.Profile != nil:
+		out.Protocol = data.Profile.Version
+		out.Capabilities = data.Profile.Capabilities
+		if len(data.Profile.ProfileHash) > 0 {
+			out.ProfileHashHex = hex.EncodeToString(data.Profile.ProfileHash)
 		}
+	case data.Avatar != nil:
+		out.Capabilities = append([]string(nil), legacyCapabilities...)
 	}
 
-	return out, nil
+	return out
 }
@@ -0,0 +1,133 @@
+package runcore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
+)
+
+// Contact cards: lxmf://<delivery hash>?name=<display name>&key=<public key> carries the
+// public identity, so a scanned or pasted card makes the contact usable at once instead
+// of waiting for an announce or path response. The key is the 64-byte Reticulum public
+// key in unpadded URL-safe base64; the hash must match it.
+
+const (
+	contactCardScheme = "lxmf"
+	contactCardQRSize = 512
+	// maxContactCardName bounds the name taken from a card (display names are short).
+	maxContactCardName = 128
+	// identityPublicKeyLen is a Reticulum public key: X25519 (32) + Ed25519 (32).
+	identityPublicKeyLen = 64
+)
+
+// ContactCard is the identity of an LXMF contact as shared in a card.
+type ContactCard struct {
+	DestinationHashHex string `json:"destination_hash_hex"`
+	DisplayName        string `json:"display_name,omitempty"`
+	PublicKey          []byte `json:"public_key"`
+}
+
+// URI encodes the card.
+func (c ContactCard) URI() string {
+	q := url.Values{}
+	if c.DisplayName != "" {
+		q.Set("name", c.DisplayName)
+	}
+	q.Set("key", base64.RawURLEncoding.EncodeToString(c.PublicKey))
+	return contactCardScheme + "://" + c.DestinationHashHex + "?" + q.Encode()
+}
+
+// ContactCard returns this node's card.
+func (n *Node) ContactCard() (ContactCard, error) {
+	delivery := n.deliveryDest()
+	if delivery == nil || n.identity == nil {
+		return ContactCard{}, errors.New("node not started")
+	}
+	return ContactCard{
+		DestinationHashHex: hex.EncodeToString(delivery.Hash()),
+		DisplayName:        n.currentDisplayName(),
+		PublicKey:          n.identity.GetPublicKey(),
+	}, nil
+}
+
+// ContactCardQR returns this node's card URI and a PNG QR code of it.
+func (n *Node) ContactCardQR() (string, []byte, error) {
+	card, err := n.ContactCard()
+	if err != nil {
+		return "", nil, err
+	}
+	uri := card.URI()
+	png, err := qrcode.Encode(uri, qrcode.Medium, contactCardQRSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode QR code: %w", err)
+	}
+	return uri, png, nil
+}
+
+// ParseContactCard decodes and verifies a card URI.
+func ParseContactCard(uri string) (ContactCard, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return ContactCard{}, fmt.Errorf("parse contact card: %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, contactCardScheme) {
+		return ContactCard{}, fmt.Errorf("not an %s:// contact card", contactCardScheme)
+	}
+	card := ContactCard{DestinationHashHex: strings.ToLower(u.Host)}
+	if !validHashHex(card.DestinationHashHex, lxmf.DestinationLength) {
+		return ContactCard{}, errors.New("contact card: invalid destination hash")
+	}
+	q := u.Query()
+	card.PublicKey, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(q.Get("key"), "="))
+	if err != nil || len(card.PublicKey) != identityPublicKeyLen {
+		return ContactCard{}, errors.New("contact card: invalid public key")
+	}
+	destHash, _ := hex.DecodeString(card.DestinationHashHex)
+	identityHash := rns.TruncatedHash(card.PublicKey)
+	if !bytes.Equal(rns.HashFromNameAndIdentity(lxmf.AppName+".delivery", identityHash), destHash) {
+		return ContactCard{}, errors.New("contact card: key does not match destination hash")
+	}
+	card.DisplayName = strings.TrimSpace(q.Get("name"))
+	if r := []rune(card.DisplayName); len(r) > maxContactCardName {
+		card.DisplayName = string(r[:maxContactCardName])
+	}
+	return card, nil
+}
+
+// ImportContactCard verifies a card URI and seeds the identity cache with its public
+// key, so the contact can be messaged right away. The card's name is kept as announce
+// app-data until the contact's first announce replaces it; identities already known are
+// left as they are. A path request is sent in the background.
+func (n *Node) ImportContactCard(uri string) (ContactCard, error) {
+	if n == nil {
+		return ContactCard{}, errors.New("node not started")
+	}
+	card, err := ParseContactCard(uri)
+	if err != nil {
+		return ContactCard{}, err
+	}
+	destHash, _ := hex.DecodeString(card.DestinationHashHex)
+	if id := rns.IdentityRecall(destHash); id == nil {
+		var appData []byte
+		if card.DisplayName != "" {
+			// Plain LXMF announce app-data: [display_name, stamp_cost].
+			appData, _ = umsgpack.Packb([]any{[]byte(card.DisplayName), nil})
+		}
+		if err := rns.IdentityRemember(nil, destHash, card.PublicKey, appData); err != nil {
+			return ContactCard{}, fmt.Errorf("remember identity: %w", err)
+		}
+		rns.Logf(rns.LOG_DEBUG, "contact card: imported identity for %s", card.DestinationHashHex)
+		n.notifyDestination(destHash)
+	}
+	n.RequestPath(destHash)
+	return card, nil
+}
@@ -0,0 +1,103 @@
+package runcore
+
+import (
+	"encoding/hex"
+	"math"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Control messages are LXMF messages with an empty title and content whose
+// FieldCustomType names a runcore control type. They are consumed by the node and never
+// reach the inbound handler. Senders only address them to peers advertising the matching
+// capability, so plain LXMF clients never receive them.
+
+const maxControlTypeLen = 64
+
+// controlHandlers maps FieldCustomType values to handlers. Handlers only run for messages
+// with a validated signature.
+var controlHandlers = map[string]func(n *Node, m *lxmf.LXMessage){
+	profilePushType:   (*Node).handleProfilePush,
+	readReceiptType:   (*Node).handleReadReceipt,
+	reactionType:      (*Node).handleReaction,
+	editType:          (*Node).handleEdit,
+	deleteRequestType: (*Node).handleDeleteRequest,
+	groupControlType:  (*Node).handleGroupControl,
+}
+
+// handleControlMessage consumes runcore control messages; it reports whether m was one.
+func (n *Node) handleControlMessage(m *lxmf.LXMessage) bool {
+	typ, ok := LXMFField(m.Fields, lxmf.FieldCustomType)
+	if !ok {
+		return false
+	}
+	name, ok := wireText(typ, maxControlTypeLen)
+	if !ok {
+		return false
+	}
+	handler := controlHandlers[name]
+	if handler == nil {
+		return false
+	}
+	if !m.SignatureValidated {
+		rns.Logf(rns.LOG_NOTICE, "control: dropped unsigned %s src=%s", name, hex.EncodeToString(m.SourceHash))
+		return true
+	}
+	handler(n, m)
+	return true
+}
+
+// sendControl sends a control message of type typ carrying data in FieldCustomData.
+func (n *Node) sendControl(destHex, typ string, data any) error {
+	_, err := n.sendWithPropagationFallback(destHex, SendOptions{
+		Fields: map[any]any{
+			lxmf.FieldCustomType: typ,
+			lxmf.FieldCustomData: data,
+		},
+	})
+	return err
+}
+
+// sendWithPropagationFallback is send, but when delivery fails and an outbound propagation
+// node is set, the message is sent again through it: peers that are only reachable through
+// propagation nodes still get control messages.
+func (n *Node) sendWithPropagationFallback(destHex string, msg SendOptions) (*lxmf.LXMessage, error) {
+	lxm, err := n.send(destHex, msg)
+	if err != nil || msg.Method == lxmf.MethodPropagated {
+		return lxm, err
+	}
+	ChainMessageCallbacks(lxm, nil, func(*lxmf.LXMessage) {
+		router, _ := n.routerAndDelivery()
+		if router == nil || len(router.GetOutboundPropagationNode()) == 0 {
+			return
+		}
+		msg.Method = lxmf.MethodPropagated
+		if _, err := n.send(destHex, msg); err != nil {
+			rns.Logf(rns.LOG_DEBUG, "propagated retry: dest=%s err=%v", destHex, err)
+		}
+	})
+	return lxm, nil
+}
+
+// LXMFField looks up an LXMF field; keys may decode as any integer type.
+func LXMFField(fields map[any]any, key int) (any, bool) {
+	if v, ok := fields[key]; ok {
+		return v, true
+	}
+	for k, v := range fields {
+		if i, ok := LXMFFieldKey(k); ok && i == key {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// LXMFFieldKey normalizes a decoded LXMF field key, which may be any integer type.
+func LXMFFieldKey(k any) (int, bool) {
+	i, ok := wireInt(k)
+	if !ok || i < math.MinInt32 || i > math.MaxInt32 {
+		return 0, false
+	}
+	return int(i), true
+}
@@ -0,0 +1,461 @@
+package runcore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+)
+
+// Export and import of the message store. Conversations can be written as a Maildir of
+// RFC 5322 messages (one message per file, attachments as MIME parts), as JSON Lines
+// (one StoredMessage per line, <peer>.jsonl) or as HTML transcripts (<peer>.html). JSON
+// Lines and HTML exports copy attachments to attachments/<message id>/ next to them.
+// LXMF message files, as written by LXMessage.WriteToDirectory (eg lxmd's
+// storage/messages), can be imported into the store.
+
+// ExportFormat selects the output of ExportConversations.
+type ExportFormat string
+
+const (
+	ExportMaildir ExportFormat = "maildir"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportHTML    ExportFormat = "html"
+)
+
+const exportAddressDomain = "lxmf"
+
+// ExportConversations writes the stored conversations with peers (all conversations
+// when none are given) to dir and returns the number of messages written.
+func (n *Node) ExportConversations(dir string, format ExportFormat, peers ...string) (int, error) {
+	if n == nil {
+		return 0, errors.New("node not started")
+	}
+	if dir == "" {
+		return 0, errors.New("export directory missing")
+	}
+	switch format {
+	case ExportMaildir, ExportJSONL, ExportHTML:
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+	n.flushMessageStore()
+	if len(peers) == 0 {
+		peers = n.ConversationPeers()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, peer := range peers {
+		msgs, err := n.Conversation(peer, 0, 0)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", peer, err)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		switch format {
+		case ExportMaildir:
+			err = n.exportMaildir(dir, msgs)
+		case ExportJSONL:
+			err = n.exportJSONL(dir, msgs)
+		case ExportHTML:
+			err = n.exportHTML(dir, msgs)
+		}
+		if err != nil {
+			return total, fmt.Errorf("export %s: %w", msgs[0].PeerHex, err)
+		}
+		total += len(msgs)
+	}
+	return total, nil
+}
+
+// exportName is the display name of a peer (or our own, or a group's name).
+func (n *Node) exportName(hashHex string) string {
+	if hashHex == n.DestinationHashHex() {
+		return n.currentDisplayName()
+	}
+	if g, ok := n.GroupHex(hashHex); ok {
+		return g.Name
+	}
+	info, _ := n.contactInfo(nil, hashHex)
+	return info.DisplayName
+}
+
+// exportSender returns who wrote m.
+func (n *Node) exportSender(m StoredMessage) string {
+	switch {
+	case m.Outgoing:
+		return n.DestinationHashHex()
+	case m.SenderHex != "":
+		return m.SenderHex
+	}
+	return m.PeerHex
+}
+
+func (n *Node) exportAddress(hashHex string) string {
+	addr := "<" + hashHex + "@" + exportAddressDomain + ">"
+	if name := n.exportName(hashHex); name != "" {
+		return mime.QEncoding.Encode("utf-8", name) + " " + addr
+	}
+	return addr
+}
+
+func (n *Node) exportMaildir(dir string, msgs []StoredMessage) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	for _, m := range msgs {
+		if m.Deleted {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := n.writeRFC5322(&buf, m); err != nil {
+			return err
+		}
+		// Exported messages are filed as seen; the name is stable so re-exports overwrite.
+		name := fmt.Sprintf("%d.%s.runcore:2,S", m.Timestamp, m.IDHex)
+		tmp := filepath.Join(dir, "tmp", name)
+		if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "cur", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) writeRFC5322(w io.Writer, m StoredMessage) error {
+	from := n.exportSender(m)
+	to := m.PeerHex // the peer, or the group
+	if !m.Outgoing && m.SenderHex == "" {
+		to = n.DestinationHashHex()
+	}
+	subject := m.Title
+	if subject == "" {
+		subject = firstLine(m.Content, 60)
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("From", n.exportAddress(from))
+	h.Set("To", n.exportAddress(to))
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	h.Set("Date", time.Unix(m.Timestamp, 0).Format(time.RFC1123Z))
+	h.Set("Message-ID", "<"+m.IDHex+"@"+exportAddressDomain+">")
+	if m.ReplyToHex != "" {
+		h.Set("In-Reply-To", "<"+m.ReplyToHex+"@"+exportAddressDomain+">")
+		h.Set("References", "<"+m.ReplyToHex+"@"+exportAddressDomain+">")
+	}
+	h.Set("MIME-Version", "1.0")
+	h.Set("X-LXMF-Conversation", m.PeerHex)
+	if m.Outgoing {
+		h.Set("X-LXMF-State", fmt.Sprint(m.State))
+	}
+
+	var body bytes.Buffer
+	var contentType string
+	if len(m.Attachments) == 0 {
+		contentType = "text/plain; charset=utf-8"
+		if err := writeQuotedPrintable(&body, m.Content); err != nil {
+			return err
+		}
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+	} else {
+		mw := multipart.NewWriter(&body)
+		contentType = "multipart/mixed; boundary=" + mw.Boundary()
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeQuotedPrintable(part, m.Content); err != nil {
+			return err
+		}
+		for _, a := range m.Attachments {
+			data, err := os.ReadFile(n.StoredAttachmentPath(a))
+			if err != nil {
+				continue // attachment gone; keep the message
+			}
+			name := filepath.Base(a.File)
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {exportMimeType(a, name)},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeBase64Lines(part, data); err != nil {
+				return err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+	}
+	h.Set("Content-Type", contentType)
+
+	bw := bufio.NewWriter(w)
+	for _, k := range []string{"From", "To", "Subject", "Date", "Message-ID", "In-Reply-To", "References",
+		"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "X-LXMF-Conversation", "X-LXMF-State"} {
+		if v := h.Get(k); v != "" {
+			fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+		}
+	}
+	bw.WriteString("\r\n")
+	bw.Write(body.Bytes())
+	return bw.Flush()
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(s, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64Lines(w io.Writer, data []byte) error {
+	const lineLen = 76
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > lineLen {
+		if _, err := io.WriteString(w, enc[:lineLen]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[lineLen:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+func exportMimeType(a StoredAttachment, name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	switch a.Kind {
+	case "image":
+		if a.Format != "" {
+			return "image/" + strings.ToLower(a.Format)
+		}
+	case "audio":
+		if a.Mime != "" {
+			return a.Mime
+		}
+		return "audio/x-lxmf-audio"
+	}
+	return "application/octet-stream"
+}
+
+func firstLine(s string, max int) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if r := []rune(s); len(r) > max {
+		s = string(r[:max]) + "…"
+	}
+	return s
+}
+
+// copyExportAttachments copies m's attachments below dir, keeping their store-relative
+// paths.
+func (n *Node) copyExportAttachments(dir string, m StoredMessage) error {
+	for _, a := range m.Attachments {
+		src := n.StoredAttachmentPath(a)
+		if src == "" {
+			continue
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		dst := filepath.Join(dir, a.File)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) exportJSONL(dir string, msgs []StoredMessage) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range msgs {
+		if err := n.copyExportAttachments(dir, m); err != nil {
+			return err
+		}
+		for i := range m.Attachments {
+			m.Attachments[i].Path = "" // File is relative to the export directory
+		}
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(dir, msgs[0].PeerHex+".jsonl"), buf.Bytes())
+}
+
+var exportHTMLTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"time": func(ts int64) string { return time.Unix(ts, 0).Format("2006-01-02 15:04") },
+	"isImage": func(a StoredAttachment) bool {
+		return a.Kind == "image" || strings.HasPrefix(mime.TypeByExtension(filepath.Ext(a.File)), "image/")
+	},
+	"url": func(file string) template.URL { return template.URL(filepath.ToSlash(file)) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body{font-family:-apple-system,system-ui,sans-serif;max-width:46em;margin:2em auto;padding:0 1em;color:#222}
+.m{margin:.6em 0;padding:.5em .8em;border-radius:.6em;background:#f1f1f4;max-width:80%}
+.out{margin-left:auto;background:#dcebff}
+.meta{font-size:.8em;color:#777}
+.t{font-weight:600}
+.c{white-space:pre-wrap}
+.del{font-style:italic;color:#999}
+img{max-width:100%;border-radius:.4em}
+</style></head><body>
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Peer}} · {{len .Messages}} messages · exported {{.Exported}}</p>
+{{range .Messages}}<div class="m{{if .Outgoing}} out{{end}}" id="{{.IDHex}}">
+<div class="meta">{{.Sender}} · {{time .Timestamp}}{{if .Edited}} · edited{{end}}{{if .ReplyToHex}} · <a href="#{{.ReplyToHex}}">in reply</a>{{end}}</div>
+{{if .Deleted}}<div class="del">Message deleted</div>{{else}}{{if .Title}}<div class="t">{{.Title}}</div>{{end}}<div class="c">{{.Content}}</div>
+{{range .Attachments}}<div>{{if isImage .}}<img src="{{url .File}}" alt="{{.Name}}">{{else}}<a href="{{url .File}}">{{if .Name}}{{.Name}}{{else}}{{.Kind}}{{end}}</a> ({{.Size}} bytes){{end}}</div>
+{{end}}{{end}}{{with .Reactions}}<div class="meta">{{range $e, $who := .}}{{$e}} {{len $who}} {{end}}</div>{{end}}
+</div>
+{{end}}</body></html>
+`))
+
+type exportHTMLMessage struct {
+	StoredMessage
+	Sender string
+}
+
+func (n *Node) exportHTML(dir string, msgs []StoredMessage) error {
+	peer := msgs[0].PeerHex
+	title := n.exportName(peer)
+	if title == "" {
+		title = peer
+	}
+	data := struct {
+		Title, Peer, Exported string
+		Messages              []exportHTMLMessage
+	}{Title: title, Peer: peer, Exported: time.Now().Format("2006-01-02 15:04")}
+	for _, m := range msgs {
+		if err := n.copyExportAttachments(dir, m); err != nil {
+			return err
+		}
+		sender := n.exportSender(m)
+		if name := n.exportName(sender); name != "" {
+			sender = name
+		}
+		data.Messages = append(data.Messages, exportHTMLMessage{StoredMessage: m, Sender: sender})
+	}
+	var buf bytes.Buffer
+	if err := exportHTMLTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, peer+".html"), buf.Bytes())
+}
+
+// ImportMessageFiles adds LXMF message files to the message store. Directories are read
+// (not recursively); files that are not LXMF messages are skipped. Messages from or to
+// this node's delivery destination are filed under the other party; group messages of
+// known groups under the group. It returns the number of messages added.
+func (n *Node) ImportMessageFiles(paths ...string) (int, error) {
+	if n == nil {
+		return 0, errors.New("node not started")
+	}
+	self := n.DestinationHashHex()
+	var files []string
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			return 0, err
+		}
+		if !st.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(p, e.Name()))
+			}
+		}
+	}
+	added := 0
+	for _, path := range files {
+		m, err := readLXMFile(path)
+		if err != nil {
+			continue
+		}
+		if n.importMessage(self, m) {
+			added++
+		}
+	}
+	n.flushMessageStore()
+	return added, nil
+}
+
+func readLXMFile(path string) (m *lxmf.LXMessage, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer func() {
+		// UnpackFromFile does not bounds-check truncated input.
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("%s: not an LXMF message", path)
+		}
+	}()
+	return lxmf.UnpackFromFile(f)
+}
+
+func (n *Node) importMessage(self string, m *lxmf.LXMessage) bool {
+	if m == nil || len(m.SourceHash) == 0 || len(m.DestinationHash) == 0 {
+		return false
+	}
+	src := hex.EncodeToString(m.SourceHash)
+	dst := hex.EncodeToString(m.DestinationHash)
+	outgoing := src == self
+	peer := src
+	if outgoing {
+		peer = dst
+	}
+	sender, idHex := "", lxmfMessageIDHex(m)
+	if v, ok := LXMFField(m.Fields, lxmf.FieldGroup); ok {
+		if f, err := decodeGroupField(v); err == nil {
+			if _, known := n.GroupHex(f.IDHex); known {
+				peer, sender, idHex = f.IDHex, src, groupMessageIDHex(f, m)
+			}
+		}
+	}
+	sm := n.storedMessageWithID(peer, idHex, m, outgoing)
+	sm.SenderHex = sender
+	if outgoing && sm.State == 0 {
+		sm.State = int(lxmf.MessageDelivered)
+	}
+	return sm.IDHex != "" && n.storeMessage(sm)
+}
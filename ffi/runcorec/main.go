@@ -6,6 +6,12 @@ package main
 typedef void (*runcore_inbound_cb)(void* user_data, const char* src_hash_hex, const char* msg_id_hex, const char* title, const char* content);
 typedef void (*runcore_log_cb)(void* user_data, int32_t level, const char* line);
 typedef void (*runcore_message_status_cb)(void* user_data, const char* dest_hash_hex, const char* msg_id_hex, int32_t state);
+typedef void (*runcore_request_cb)(void* user_data, uint64_t request_id, const char* json);
+typedef void (*runcore_avatar_updated_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+typedef void (*runcore_presence_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+typedef void (*runcore_message_event_cb)(void* user_data, const char* dest_hash_hex, const char* json);
+typedef void (*runcore_group_event_cb)(void* user_data, const char* group_id_hex, const char* json);
+typedef void (*runcore_telemetry_cb)(void* user_data, const char* dest_hash_hex, const char* json);
 
 static inline void runcore_inbound_cb_call(runcore_inbound_cb cb, void* user_data, const char* src, const char* msg_id, const char* title, const char* content) {
   cb(user_data, src, msg_id, title, content);
@@ -16,12 +22,33 @@ static inline void runcore_log_cb_call(runcore_log_cb cb, void* user_data, int32
 static inline void runcore_message_status_cb_call(runcore_message_status_cb cb, void* user_data, const char* dest, const char* msg_id, int32_t state) {
   cb(user_data, dest, msg_id, state);
 }
+static inline void runcore_request_cb_call(runcore_request_cb cb, void* user_data, uint64_t request_id, const char* json) {
+  cb(user_data, request_id, json);
+}
+static inline void runcore_avatar_updated_cb_call(runcore_avatar_updated_cb cb, void* user_data, const char* dest, const char* json) {
+  cb(user_data, dest, json);
+}
+static inline void runcore_presence_cb_call(runcore_presence_cb cb, void* user_data, const char* dest, const char* json) {
+  cb(user_data, dest, json);
+}
+static inline void runcore_message_event_cb_call(runcore_message_event_cb cb, void* user_data, const char* dest, const char* json) {
+  cb(user_data, dest, json);
+}
+static inline void runcore_group_event_cb_call(runcore_group_event_cb cb, void* user_data, const char* group, const char* json) {
+  cb(user_data, group, json);
+}
+static inline void runcore_telemetry_cb_call(runcore_telemetry_cb cb, void* user_data, const char* dest, const char* json) {
+  cb(user_data, dest, json);
+}
 */
 import "C"
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -41,6 +68,16 @@ type nodeHandle struct {
 	userData unsafe.Pointer
 	statusCB C.runcore_message_status_cb
 	statusUD unsafe.Pointer
+	avatarCB C.runcore_avatar_updated_cb
+	avatarUD unsafe.Pointer
+	presCB   C.runcore_presence_cb
+	presUD   unsafe.Pointer
+	eventCB  C.runcore_message_event_cb
+	eventUD  unsafe.Pointer
+	groupCB  C.runcore_group_event_cb
+	groupUD  unsafe.Pointer
+	telemCB  C.runcore_telemetry_cb
+	telemUD  unsafe.Pointer
 	mu       sync.RWMutex
 }
 
@@ -52,8 +89,18 @@ var (
 	logMu       sync.RWMutex
 	logCB       C.runcore_log_cb
 	logUserData unsafe.Pointer
+
+	nextRequestID uint64 = 1
+	requests             = map[uint64]*pendingRequest{}
+	requestsMu    sync.Mutex
 )
 
+// pendingRequest is an in-flight async call started by one of the runcore_*_async exports.
+type pendingRequest struct {
+	handle uint64
+	cancel context.CancelFunc
+}
+
 func main() {}
 
 func allocCString(s string) *C.char { return C.CString(s) }
@@ -135,6 +182,101 @@ func runcore_start(configDir *C.char, displayName *C.char, loglevel C.int32_t, r
 		C.free(unsafe.Pointer(cContent))
 	})
 
+	n.SetReadReceiptHandler(func(r runcore.ReadReceipt) {
+		h.mu.RLock()
+		cb := h.statusCB
+		ud := h.statusUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		cDest := allocCString(r.DestinationHashHex)
+		cMsgID := allocCString(r.MessageIDHex)
+		C.runcore_message_status_cb_call(cb, ud, cDest, cMsgID, C.int32_t(runcore.MessageRead))
+		C.free(unsafe.Pointer(cDest))
+		C.free(unsafe.Pointer(cMsgID))
+	})
+
+	n.SetAvatarUpdatedHandler(func(a runcore.CachedAvatar) {
+		h.mu.RLock()
+		cb := h.avatarCB
+		ud := h.avatarUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		b, _ := json.Marshal(a)
+		cDest := allocCString(a.DestinationHashHex)
+		cJSON := allocCString(string(b))
+		C.runcore_avatar_updated_cb_call(cb, ud, cDest, cJSON)
+		C.free(unsafe.Pointer(cDest))
+		C.free(unsafe.Pointer(cJSON))
+	})
+
+	n.SetPresenceHandler(func(p runcore.PeerPresence) {
+		h.mu.RLock()
+		cb := h.presCB
+		ud := h.presUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		b, _ := json.Marshal(p)
+		cDest := allocCString(p.DestinationHashHex)
+		cJSON := allocCString(string(b))
+		C.runcore_presence_cb_call(cb, ud, cDest, cJSON)
+		C.free(unsafe.Pointer(cDest))
+		C.free(unsafe.Pointer(cJSON))
+	})
+
+	n.SetMessageEventHandler(func(ev runcore.MessageEvent) {
+		h.mu.RLock()
+		cb := h.eventCB
+		ud := h.eventUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		b, _ := json.Marshal(ev)
+		cDest := allocCString(ev.DestinationHashHex)
+		cJSON := allocCString(string(b))
+		C.runcore_message_event_cb_call(cb, ud, cDest, cJSON)
+		C.free(unsafe.Pointer(cDest))
+		C.free(unsafe.Pointer(cJSON))
+	})
+
+	n.SetGroupEventHandler(func(ev runcore.GroupEvent) {
+		h.mu.RLock()
+		cb := h.groupCB
+		ud := h.groupUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		b, _ := json.Marshal(ev)
+		cGroup := allocCString(ev.Group.IDHex)
+		cJSON := allocCString(string(b))
+		C.runcore_group_event_cb_call(cb, ud, cGroup, cJSON)
+		C.free(unsafe.Pointer(cGroup))
+		C.free(unsafe.Pointer(cJSON))
+	})
+
+	n.SetTelemetryHandler(func(p runcore.PeerTelemetry) {
+		h.mu.RLock()
+		cb := h.telemCB
+		ud := h.telemUD
+		h.mu.RUnlock()
+		if cb == nil {
+			return
+		}
+		b, _ := json.Marshal(p)
+		cDest := allocCString(p.DestinationHashHex)
+		cJSON := allocCString(string(b))
+		C.runcore_telemetry_cb_call(cb, ud, cDest, cJSON)
+		C.free(unsafe.Pointer(cDest))
+		C.free(unsafe.Pointer(cJSON))
+	})
+
 	nodesMu.Lock()
 	id := nextID
 	nextID++
@@ -160,6 +302,7 @@ func runcore_stop(handle C.uint64_t) C.int32_t {
 	if h == nil {
 		return 0
 	}
+	cancelRequestsForHandle(uint64(handle))
 	_ = h.node.Close()
 	if h.destHex != nil {
 		C.free(unsafe.Pointer(h.destHex))
@@ -192,6 +335,66 @@ func runcore_set_message_status_cb(handle C.uint64_t, cb C.runcore_message_statu
 	h.mu.Unlock()
 }
 
+//export runcore_set_avatar_updated_cb
+func runcore_set_avatar_updated_cb(handle C.uint64_t, cb C.runcore_avatar_updated_cb, userData unsafe.Pointer) {
+	h := getHandle(handle)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.avatarCB = cb
+	h.avatarUD = userData
+	h.mu.Unlock()
+}
+
+//export runcore_set_presence_cb
+func runcore_set_presence_cb(handle C.uint64_t, cb C.runcore_presence_cb, userData unsafe.Pointer) {
+	h := getHandle(handle)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.presCB = cb
+	h.presUD = userData
+	h.mu.Unlock()
+}
+
+//export runcore_set_message_event_cb
+func runcore_set_message_event_cb(handle C.uint64_t, cb C.runcore_message_event_cb, userData unsafe.Pointer) {
+	h := getHandle(handle)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.eventCB = cb
+	h.eventUD = userData
+	h.mu.Unlock()
+}
+
+//export runcore_set_group_event_cb
+func runcore_set_group_event_cb(handle C.uint64_t, cb C.runcore_group_event_cb, userData unsafe.Pointer) {
+	h := getHandle(handle)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.groupCB = cb
+	h.groupUD = userData
+	h.mu.Unlock()
+}
+
+//export runcore_set_telemetry_cb
+func runcore_set_telemetry_cb(handle C.uint64_t, cb C.runcore_telemetry_cb, userData unsafe.Pointer) {
+	h := getHandle(handle)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.telemCB = cb
+	h.telemUD = userData
+	h.mu.Unlock()
+}
+
 //export runcore_set_log_cb
 func runcore_set_log_cb(cb C.runcore_log_cb, userData unsafe.Pointer) {
 	logMu.Lock()
@@ -247,10 +450,10 @@ func runcore_send(handle C.uint64_t, destHashHex *C.char, title *C.char, content
 	}
 	if !rns.TransportHasPath(destHash) {
 		// Do not fail fast: queue opportunistic send and let Reticulum establish a path.
-		rns.TransportRequestPath(destHash)
+		h.node.RequestPath(destHash)
 	}
 	if !strings.EqualFold(dest, C.GoString(h.destHex)) && rns.IdentityRecall(destHash) == nil {
-		rns.TransportRequestPath(destHash)
+		h.node.RequestPath(destHash)
 		return 3
 	}
 	_, err = h.node.SendHex(dest, runcore.SendOptions{
@@ -270,7 +473,134 @@ func runcore_send_result_json(handle C.uint64_t, destHashHex *C.char, title *C.c
 	if h == nil || h.node == nil {
 		return allocCString(`{"rc":1,"error":"node not started"}`)
 	}
-	dest := C.GoString(destHashHex)
+	return sendResultJSON(h, C.GoString(destHashHex), runcore.SendOptions{
+		Method:  lxmf.MethodOpportunistic,
+		Title:   C.GoString(title),
+		Content: C.GoString(content),
+	}, h.node.SendHex)
+}
+
+//export runcore_send_reply_json
+func runcore_send_reply_json(handle C.uint64_t, destHashHex *C.char, replyToIDHex *C.char, title *C.char, content *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if replyToIDHex == nil {
+		return allocCString(`{"rc":6,"error":"invalid message id"}`)
+	}
+	replyTo := C.GoString(replyToIDHex)
+	return sendResultJSON(h, C.GoString(destHashHex), runcore.SendOptions{
+		Method:  lxmf.MethodOpportunistic,
+		Title:   C.GoString(title),
+		Content: C.GoString(content),
+	}, func(dest string, opts runcore.SendOptions) (*lxmf.LXMessage, error) {
+		return h.node.SendReply(dest, replyTo, opts)
+	})
+}
+
+//export runcore_send_audio_json
+func runcore_send_audio_json(handle C.uint64_t, destHashHex *C.char, mode C.int32_t, data *C.uchar, dataLen C.int32_t, content *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if destHashHex == nil || data == nil || dataLen <= 0 || mode <= 0 || mode > 0xff {
+		return allocCString(`{"rc":2}`)
+	}
+	return sendResultJSON(h, C.GoString(destHashHex), runcore.SendOptions{
+		Method:  lxmf.MethodDirect,
+		Content: C.GoString(content),
+		Audio:   &runcore.Audio{Mode: byte(mode), Data: C.GoBytes(unsafe.Pointer(data), C.int(dataLen))},
+	}, h.node.SendHex)
+}
+
+//export runcore_encode_paper_message_json
+func runcore_encode_paper_message_json(handle C.uint64_t, destHashHex *C.char, title *C.char, content *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if destHashHex == nil {
+		return allocCString(`{"rc":2}`)
+	}
+	uri, png, err := h.node.EncodePaperMessage(C.GoString(destHashHex), runcore.SendOptions{
+		Title:   C.GoString(title),
+		Content: C.GoString(content),
+	})
+	if err != nil {
+		b, _ := json.Marshal(map[string]any{"rc": 3, "error": err.Error()})
+		return allocCString(string(b))
+	}
+	b, _ := json.Marshal(map[string]any{
+		"rc":         0,
+		"uri":        uri,
+		"png_base64": base64.StdEncoding.EncodeToString(png),
+	})
+	return allocCString(string(b))
+}
+
+//export runcore_ingest_paper_message
+func runcore_ingest_paper_message(handle C.uint64_t, uri *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if uri == nil {
+		return 2
+	}
+	if err := h.node.IngestPaperMessage(C.GoString(uri)); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "paper message: %v", err)
+		return 3
+	}
+	return 0
+}
+
+//export runcore_contact_card_json
+func runcore_contact_card_json(handle C.uint64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	card, err := h.node.ContactCard()
+	if err != nil {
+		b, _ := json.Marshal(map[string]any{"rc": 3, "error": err.Error()})
+		return allocCString(string(b))
+	}
+	uri, png, err := h.node.ContactCardQR()
+	if err != nil {
+		b, _ := json.Marshal(map[string]any{"rc": 3, "error": err.Error()})
+		return allocCString(string(b))
+	}
+	b, _ := json.Marshal(map[string]any{
+		"rc":         0,
+		"uri":        uri,
+		"png_base64": base64.StdEncoding.EncodeToString(png),
+		"card":       card,
+	})
+	return allocCString(string(b))
+}
+
+//export runcore_import_contact_card_json
+func runcore_import_contact_card_json(handle C.uint64_t, uri *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if uri == nil {
+		return allocCString(`{"rc":2}`)
+	}
+	card, err := h.node.ImportContactCard(C.GoString(uri))
+	if err != nil {
+		b, _ := json.Marshal(map[string]any{"rc": 3, "error": err.Error()})
+		return allocCString(string(b))
+	}
+	b, _ := json.Marshal(map[string]any{"rc": 0, "card": card})
+	return allocCString(string(b))
+}
+
+// sendResultJSON sends with send and reports state changes to the status callback.
+func sendResultJSON(h *nodeHandle, dest string, opts runcore.SendOptions, send func(string, runcore.SendOptions) (*lxmf.LXMessage, error)) *C.char {
 	destHash, err := hex.DecodeString(dest)
 	if err != nil || len(destHash) != lxmf.DestinationLength {
 		b, _ := json.Marshal(map[string]any{"rc": 5, "error": "invalid destination hash"})
@@ -280,47 +610,21 @@ func runcore_send_result_json(handle C.uint64_t, destHashHex *C.char, title *C.c
 	if !rns.TransportHasPath(destHash) {
 		// Do not fail fast: queue opportunistic send and let Reticulum establish a path.
 		pathPending = true
-		rns.TransportRequestPath(destHash)
+		h.node.RequestPath(destHash)
 	}
 	if !strings.EqualFold(dest, C.GoString(h.destHex)) && rns.IdentityRecall(destHash) == nil {
-		rns.TransportRequestPath(destHash)
+		h.node.RequestPath(destHash)
 		b, _ := json.Marshal(map[string]any{"rc": 3, "error": "unknown destination identity"})
 		return allocCString(string(b))
 	}
-	msg, err := h.node.SendHex(dest, runcore.SendOptions{
-		Method:  lxmf.MethodOpportunistic,
-		Title:   C.GoString(title),
-		Content: C.GoString(content),
-	})
+	msg, err := send(dest, opts)
 	if err != nil || msg == nil {
 		b, _ := json.Marshal(map[string]any{"rc": 2, "error": fmt.Sprintf("send failed: %v", err)})
 		return allocCString(string(b))
 	}
 
-	// Attach callbacks for delivery/failed state transitions.
-	msg.RegisterDeliveryCallback(func(m *lxmf.LXMessage) {
-		if m == nil {
-			return
-		}
-		h.mu.RLock()
-		cb := h.statusCB
-		ud := h.statusUD
-		h.mu.RUnlock()
-		if cb == nil {
-			return
-		}
-		destHex := hex.EncodeToString(m.DestinationHash)
-		msgIDHex := hex.EncodeToString(m.MessageID)
-		if msgIDHex == "" && len(m.Hash) > 0 {
-			msgIDHex = hex.EncodeToString(m.Hash)
-		}
-		cDest := allocCString(destHex)
-		cMsgID := allocCString(msgIDHex)
-		C.runcore_message_status_cb_call(cb, ud, cDest, cMsgID, C.int32_t(m.State))
-		C.free(unsafe.Pointer(cDest))
-		C.free(unsafe.Pointer(cMsgID))
-	})
-	msg.RegisterFailedCallback(func(m *lxmf.LXMessage) {
+	// Report delivery/failed state transitions; chained so the message store keeps tracking them.
+	status := func(m *lxmf.LXMessage) {
 		if m == nil {
 			return
 		}
@@ -341,7 +645,8 @@ func runcore_send_result_json(handle C.uint64_t, destHashHex *C.char, title *C.c
 		C.runcore_message_status_cb_call(cb, ud, cDest, cMsgID, C.int32_t(m.State))
 		C.free(unsafe.Pointer(cDest))
 		C.free(unsafe.Pointer(cMsgID))
-	})
+	}
+	runcore.ChainMessageCallbacks(msg, status, status)
 
 	msgIDHex := hex.EncodeToString(msg.MessageID)
 	if msgIDHex == "" && len(msg.Hash) > 0 {
@@ -424,6 +729,15 @@ func runcore_interface_stats_json(handle C.uint64_t) *C.char {
 	return allocCString(h.node.InterfaceStatsJSON())
 }
 
+//export runcore_wire_stats_json
+func runcore_wire_stats_json(handle C.uint64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return nil
+	}
+	return allocCString(h.node.WireStatsJSON())
+}
+
 //export runcore_configured_interfaces_json
 func runcore_configured_interfaces_json(handle C.uint64_t) *C.char {
 	h := getHandle(handle)
@@ -450,17 +764,83 @@ func runcore_contact_info_json(handle C.uint64_t, destHashHex *C.char, timeoutMs
 	}
 	timeout := time.Duration(timeoutMs) * time.Millisecond
 	info, err := h.node.ContactInfoHex(C.GoString(destHashHex), timeout)
+	b, _ := json.Marshal(contactInfoResponse(info, err))
+	return allocCString(string(b))
+}
+
+func contactInfoResponse(info runcore.ContactInfo, err error) map[string]any {
 	resp := map[string]any{
 		"display_name": info.DisplayName,
 		"avatar":       info.Avatar,
+		"protocol":     info.Protocol,
+		"capabilities": info.Capabilities,
+	}
+	if info.ProfileHashHex != "" {
+		resp["profile_hash_hex"] = info.ProfileHashHex
 	}
 	if err != nil {
 		resp["error"] = err.Error()
 	}
-	b, _ := json.Marshal(resp)
+	return resp
+}
+
+//export runcore_set_profile_json
+func runcore_set_profile_json(handle C.uint64_t, profileJSON *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	var p runcore.Profile
+	if profileJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(profileJSON)), &p); err != nil {
+			return 2
+		}
+	}
+	if err := h.node.SetProfile(p); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_profile_json
+func runcore_profile_json(handle C.uint64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return nil
+	}
+	b, _ := json.Marshal(h.node.Profile())
+	return allocCString(string(b))
+}
+
+//export runcore_contact_profile_json
+func runcore_contact_profile_json(handle C.uint64_t, destHashHex *C.char, timeoutMs C.int32_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return nil
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	cp, err := h.node.ContactProfileHex(C.GoString(destHashHex), timeout)
+	b, _ := json.Marshal(contactProfileResponse(cp, err))
 	return allocCString(string(b))
 }
 
+func contactProfileResponse(cp runcore.ContactProfile, err error) map[string]any {
+	resp := map[string]any{
+		"status":      cp.Status,
+		"bio":         cp.Bio,
+		"pronouns":    cp.Pronouns,
+		"links":       cp.Links,
+		"updated":     cp.Updated,
+		"hash_hex":    cp.HashHex,
+		"fetched":     cp.Fetched,
+		"not_present": cp.NotPresent,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
 //export runcore_contact_avatar_json
 func runcore_contact_avatar_json(handle C.uint64_t, destHashHex *C.char, knownAvatarHashHex *C.char, timeoutMs C.int32_t) *C.char {
 	h := getHandle(handle)
@@ -473,20 +853,54 @@ func runcore_contact_avatar_json(handle C.uint64_t, destHashHex *C.char, knownAv
 	}
 	timeout := time.Duration(timeoutMs) * time.Millisecond
 	av, err := h.node.ContactAvatarDataBase64Hex(C.GoString(destHashHex), known, timeout)
-	resp := map[string]any{
-		"hash_hex":    av.HashHex,
-		"png_base64":  av.PNGBase64,
-		"mime":        av.Mime,
-		"unchanged":   av.Unchanged,
-		"not_present": av.NotPresent,
-	}
-	if err != nil {
-		resp["error"] = err.Error()
-	}
-	b, _ := json.Marshal(resp)
+	b, _ := json.Marshal(contactAvatarResponse(av, err))
 	return allocCString(string(b))
 }
 
+//export runcore_contact_avatar_path
+func runcore_contact_avatar_path(handle C.uint64_t, destHashHex *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return nil
+	}
+	path := h.node.ContactAvatarPath(C.GoString(destHashHex))
+	if path == "" {
+		return nil
+	}
+	return allocCString(path)
+}
+
+//export runcore_contact_avatar_thumbnail_json
+func runcore_contact_avatar_thumbnail_json(handle C.uint64_t, destHashHex *C.char, knownThumbHashHex *C.char, timeoutMs C.int32_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return nil
+	}
+	known := ""
+	if knownThumbHashHex != nil {
+		known = C.GoString(knownThumbHashHex)
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	av, err := h.node.ContactAvatarThumbnailHex(C.GoString(destHashHex), known, timeout)
+	b, _ := json.Marshal(contactAvatarResponse(av, err))
+	return allocCString(string(b))
+}
+
+func contactAvatarResponse(av runcore.ContactAvatarFetch, err error) map[string]any {
+	resp := map[string]any{
+		"hash_hex":    av.HashHex,
+		"png_base64":  av.PNGBase64,
+		"mime":        av.Mime,
+		"thumbnail":   av.Thumbnail,
+		"unchanged":   av.Unchanged,
+		"not_present": av.NotPresent,
+	}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
 //export runcore_store_attachment_json
 func runcore_store_attachment_json(handle C.uint64_t, mime *C.char, name *C.char, data *C.uchar, dataLen C.int32_t) *C.char {
 	h := getHandle(handle)
@@ -530,6 +944,11 @@ func runcore_contact_attachment_json(handle C.uint64_t, destHashHex *C.char, att
 	}
 	timeout := time.Duration(timeoutMs) * time.Millisecond
 	fetch, err := h.node.ContactAttachmentPathHex(C.GoString(destHashHex), C.GoString(attachmentHashHex), timeout)
+	jb, _ := json.Marshal(contactAttachmentResponse(fetch, err))
+	return allocCString(string(jb))
+}
+
+func contactAttachmentResponse(fetch runcore.AttachmentFetch, err error) map[string]any {
 	resp := map[string]any{
 		"hash_hex":    fetch.HashHex,
 		"path":        fetch.Path,
@@ -541,8 +960,159 @@ func runcore_contact_attachment_json(handle C.uint64_t, destHashHex *C.char, att
 	if err != nil {
 		resp["error"] = err.Error()
 	}
-	jb, _ := json.Marshal(resp)
-	return allocCString(string(jb))
+	return resp
+}
+
+// startRequest runs fn on a goroutine and reports its JSON result through cb.
+// timeoutMs <= 0 means no timeout (the request ends only on completion or runcore_cancel).
+func startRequest(handle C.uint64_t, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer, fn func(ctx context.Context) map[string]any) C.uint64_t {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeoutMs > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+		parentCancel := cancel
+		cancel = func() { cancelTimeout(); parentCancel() }
+	}
+
+	requestsMu.Lock()
+	id := nextRequestID
+	nextRequestID++
+	requests[id] = &pendingRequest{handle: uint64(handle), cancel: cancel}
+	requestsMu.Unlock()
+
+	go func() {
+		resp := fn(ctx)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			resp["cancelled"] = true
+		}
+		requestsMu.Lock()
+		delete(requests, id)
+		requestsMu.Unlock()
+		cancel()
+
+		if cb == nil {
+			return
+		}
+		resp["request_id"] = id
+		b, _ := json.Marshal(resp)
+		cJSON := allocCString(string(b))
+		C.runcore_request_cb_call(cb, userData, C.uint64_t(id), cJSON)
+		C.free(unsafe.Pointer(cJSON))
+	}()
+	return C.uint64_t(id)
+}
+
+func cancelRequestsForHandle(handle uint64) {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	for _, r := range requests {
+		if r.handle == handle {
+			r.cancel()
+		}
+	}
+}
+
+//export runcore_cancel
+func runcore_cancel(requestID C.uint64_t) C.int32_t {
+	requestsMu.Lock()
+	r := requests[uint64(requestID)]
+	requestsMu.Unlock()
+	if r == nil {
+		return 1
+	}
+	r.cancel()
+	return 0
+}
+
+//export runcore_contact_info_async
+func runcore_contact_info_async(handle C.uint64_t, destHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		info, err := h.node.ContactInfoHexContext(ctx, dest)
+		return contactInfoResponse(info, err)
+	})
+}
+
+//export runcore_contact_avatar_async
+func runcore_contact_avatar_async(handle C.uint64_t, destHashHex *C.char, knownAvatarHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	known := ""
+	if knownAvatarHashHex != nil {
+		known = C.GoString(knownAvatarHashHex)
+	}
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		av, err := h.node.ContactAvatarDataBase64HexContext(ctx, dest, known)
+		return contactAvatarResponse(av, err)
+	})
+}
+
+//export runcore_contact_avatar_thumbnail_async
+func runcore_contact_avatar_thumbnail_async(handle C.uint64_t, destHashHex *C.char, knownThumbHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	known := ""
+	if knownThumbHashHex != nil {
+		known = C.GoString(knownThumbHashHex)
+	}
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		av, err := h.node.ContactAvatarThumbnailHexContext(ctx, dest, known)
+		return contactAvatarResponse(av, err)
+	})
+}
+
+//export runcore_contact_attachment_async
+func runcore_contact_attachment_async(handle C.uint64_t, destHashHex *C.char, attachmentHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil || attachmentHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	hash := C.GoString(attachmentHashHex)
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		fetch, err := h.node.ContactAttachmentPathHexContext(ctx, dest, hash)
+		return contactAttachmentResponse(fetch, err)
+	})
+}
+
+//export runcore_contact_profile_async
+func runcore_contact_profile_async(handle C.uint64_t, destHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		cp, err := h.node.ContactProfileHexContext(ctx, dest)
+		return contactProfileResponse(cp, err)
+	})
+}
+
+//export runcore_wait_for_identity_async
+func runcore_wait_for_identity_async(handle C.uint64_t, destHashHex *C.char, timeoutMs C.int32_t, cb C.runcore_request_cb, userData unsafe.Pointer) C.uint64_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return 0
+	}
+	dest := C.GoString(destHashHex)
+	return startRequest(handle, timeoutMs, cb, userData, func(ctx context.Context) map[string]any {
+		_, err := h.node.WaitForIdentityHexContext(ctx, dest)
+		resp := map[string]any{"known": err == nil}
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+		return resp
+	})
 }
 
 //export runcore_set_avatar_png
@@ -579,10 +1149,517 @@ func runcore_set_avatar_image(handle C.uint64_t, mime *C.char, data *C.uchar, da
 		rns.Logf(rns.LOG_NOTICE, "set avatar image failed: %v", err)
 		return 3
 	}
-	h.node.AnnounceDeliveryWithReason("avatar_changed")
 	return 0
 }
 
+//export runcore_mark_read
+func runcore_mark_read(handle C.uint64_t, msgIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if msgIDHex == nil {
+		return 2
+	}
+	if err := h.node.MarkRead(C.GoString(msgIDHex)); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_mark_read_from
+func runcore_mark_read_from(handle C.uint64_t, srcHashHex *C.char, msgIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if srcHashHex == nil || msgIDHex == nil {
+		return 2
+	}
+	if err := h.node.MarkReadFrom(C.GoString(srcHashHex), C.GoString(msgIDHex)); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_set_read_receipts
+func runcore_set_read_receipts(handle C.uint64_t, enabled C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if err := h.node.SetReadReceipts(enabled != 0); err != nil {
+		return 2
+	}
+	return 0
+}
+
+//export runcore_set_contact_read_receipts
+func runcore_set_contact_read_receipts(handle C.uint64_t, destHashHex *C.char, policy C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	if err := h.node.SetContactReadReceipts(C.GoString(destHashHex), runcore.ReceiptPolicy(policy)); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_react
+func runcore_react(handle C.uint64_t, destHashHex *C.char, msgIDHex *C.char, emoji *C.char, remove C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil || msgIDHex == nil || emoji == nil {
+		return 2
+	}
+	return messageActionRC(h.node.React(C.GoString(destHashHex), C.GoString(msgIDHex), C.GoString(emoji), remove != 0))
+}
+
+//export runcore_edit_message
+func runcore_edit_message(handle C.uint64_t, destHashHex *C.char, msgIDHex *C.char, content *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil || msgIDHex == nil || content == nil {
+		return 2
+	}
+	return messageActionRC(h.node.EditMessage(C.GoString(destHashHex), C.GoString(msgIDHex), C.GoString(content)))
+}
+
+//export runcore_delete_message
+func runcore_delete_message(handle C.uint64_t, destHashHex *C.char, msgIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil || msgIDHex == nil {
+		return 2
+	}
+	return messageActionRC(h.node.DeleteMessage(C.GoString(destHashHex), C.GoString(msgIDHex)))
+}
+
+func messageActionRC(err error) C.int32_t {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, runcore.ErrPeerUnsupported):
+		return 4
+	default:
+		rns.Logf(rns.LOG_DEBUG, "message action failed: %v", err)
+		return 3
+	}
+}
+
+//export runcore_conversation_json
+func runcore_conversation_json(handle C.uint64_t, destHashHex *C.char, limit C.int32_t, before C.int64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return nil
+	}
+	msgs, err := h.node.Conversation(C.GoString(destHashHex), int(limit), int64(before))
+	if err != nil {
+		return nil
+	}
+	if msgs == nil {
+		msgs = []runcore.StoredMessage{}
+	}
+	b, _ := json.Marshal(msgs)
+	return allocCString(string(b))
+}
+
+//export runcore_message_json
+func runcore_message_json(handle C.uint64_t, destHashHex *C.char, msgIDHex *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil || msgIDHex == nil {
+		return nil
+	}
+	m, ok := h.node.StoredMessageHex(C.GoString(destHashHex), C.GoString(msgIDHex))
+	if !ok {
+		return nil
+	}
+	b, _ := json.Marshal(m)
+	return allocCString(string(b))
+}
+
+//export runcore_export_conversations_json
+func runcore_export_conversations_json(handle C.uint64_t, dir *C.char, format *C.char, peersCSV *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if dir == nil || format == nil {
+		return allocCString(`{"rc":2}`)
+	}
+	var peers []string
+	if peersCSV != nil {
+		peers = splitCSV(C.GoString(peersCSV))
+	}
+	count, err := h.node.ExportConversations(C.GoString(dir), runcore.ExportFormat(C.GoString(format)), peers...)
+	return allocCString(countResultJSON(count, err))
+}
+
+//export runcore_import_messages_json
+func runcore_import_messages_json(handle C.uint64_t, pathsCSV *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if pathsCSV == nil {
+		return allocCString(`{"rc":2}`)
+	}
+	count, err := h.node.ImportMessageFiles(splitCSV(C.GoString(pathsCSV))...)
+	return allocCString(countResultJSON(count, err))
+}
+
+func countResultJSON(count int, err error) string {
+	resp := map[string]any{"rc": 0, "count": count}
+	if err != nil {
+		resp["rc"] = 3
+		resp["error"] = err.Error()
+	}
+	b, _ := json.Marshal(resp)
+	return string(b)
+}
+
+func splitCSV(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+//export runcore_create_group_json
+func runcore_create_group_json(handle C.uint64_t, name *C.char, membersCSV *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	var members []string
+	if membersCSV != nil {
+		members = splitCSV(C.GoString(membersCSV))
+	}
+	g, err := h.node.CreateGroup(C.GoString(name), members)
+	resp := map[string]any{"rc": 0, "group": g}
+	if g.IDHex == "" {
+		resp = map[string]any{"rc": 2}
+	}
+	if err != nil {
+		// Invitations that failed are reported while the group still exists.
+		resp["error"] = err.Error()
+	}
+	b, _ := json.Marshal(resp)
+	return allocCString(string(b))
+}
+
+//export runcore_invite_to_group
+func runcore_invite_to_group(handle C.uint64_t, groupIDHex *C.char, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if groupIDHex == nil || destHashHex == nil {
+		return 2
+	}
+	return messageActionRC(h.node.InviteToGroup(C.GoString(groupIDHex), C.GoString(destHashHex)))
+}
+
+//export runcore_accept_group_invite
+func runcore_accept_group_invite(handle C.uint64_t, groupIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if groupIDHex == nil {
+		return 2
+	}
+	return messageActionRC(h.node.AcceptGroupInvite(C.GoString(groupIDHex)))
+}
+
+//export runcore_decline_group_invite
+func runcore_decline_group_invite(handle C.uint64_t, groupIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if groupIDHex == nil {
+		return 2
+	}
+	h.node.DeclineGroupInvite(C.GoString(groupIDHex))
+	return 0
+}
+
+//export runcore_leave_group
+func runcore_leave_group(handle C.uint64_t, groupIDHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if groupIDHex == nil {
+		return 2
+	}
+	return messageActionRC(h.node.LeaveGroup(C.GoString(groupIDHex)))
+}
+
+//export runcore_remove_group_member
+func runcore_remove_group_member(handle C.uint64_t, groupIDHex *C.char, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if groupIDHex == nil || destHashHex == nil {
+		return 2
+	}
+	return messageActionRC(h.node.RemoveGroupMember(C.GoString(groupIDHex), C.GoString(destHashHex)))
+}
+
+//export runcore_groups_json
+func runcore_groups_json(handle C.uint64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return nil
+	}
+	b, _ := json.Marshal(map[string]any{"groups": h.node.Groups(), "invites": h.node.GroupInvites()})
+	return allocCString(string(b))
+}
+
+//export runcore_send_group_json
+func runcore_send_group_json(handle C.uint64_t, groupIDHex *C.char, title *C.char, content *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return allocCString(`{"rc":1,"error":"node not started"}`)
+	}
+	if groupIDHex == nil {
+		return allocCString(`{"rc":5,"error":"invalid group id"}`)
+	}
+	sent, err := h.node.SendGroup(C.GoString(groupIDHex), runcore.SendOptions{
+		Method:  lxmf.MethodOpportunistic,
+		Title:   C.GoString(title),
+		Content: C.GoString(content),
+	})
+	ids := make([]string, 0, len(sent))
+	for _, m := range sent {
+		ids = append(ids, hex.EncodeToString(m.MessageID))
+	}
+	resp := map[string]any{"rc": 0, "message_ids": ids}
+	if len(sent) > 0 {
+		resp["message_id"] = runcore.GroupMessageIDHex(sent[0])
+	}
+	if err != nil {
+		if len(sent) == 0 {
+			resp["rc"] = 2
+		}
+		resp["error"] = err.Error()
+	}
+	b, _ := json.Marshal(resp)
+	return allocCString(string(b))
+}
+
+// parseTelemetryJSON decodes a runcore.Telemetry; nil or "" yields nil.
+func parseTelemetryJSON(js *C.char) (*runcore.Telemetry, error) {
+	if js == nil || C.GoString(js) == "" {
+		return nil, nil
+	}
+	var t runcore.Telemetry
+	if err := json.Unmarshal([]byte(C.GoString(js)), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+//export runcore_set_telemetry_json
+func runcore_set_telemetry_json(handle C.uint64_t, telemetryJSON *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	t, err := parseTelemetryJSON(telemetryJSON)
+	if err != nil {
+		return 2
+	}
+	if err := h.node.SetTelemetry(t); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_send_telemetry_json
+func runcore_send_telemetry_json(handle C.uint64_t, destHashHex *C.char, telemetryJSON *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	t, err := parseTelemetryJSON(telemetryJSON)
+	if destHashHex == nil || t == nil || err != nil {
+		return 2
+	}
+	if _, err := h.node.SendTelemetry(C.GoString(destHashHex), *t); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "send telemetry: %v", err)
+		return 3
+	}
+	return 0
+}
+
+//export runcore_request_telemetry
+func runcore_request_telemetry(handle C.uint64_t, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	if err := h.node.RequestTelemetry(C.GoString(destHashHex)); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_set_contact_telemetry_access
+func runcore_set_contact_telemetry_access(handle C.uint64_t, destHashHex *C.char, allowed C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	if err := h.node.SetContactTelemetryAccess(C.GoString(destHashHex), allowed != 0); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_set_telemetry_collector
+func runcore_set_telemetry_collector(handle C.uint64_t, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	dest := ""
+	if destHashHex != nil {
+		dest = C.GoString(destHashHex)
+	}
+	if err := h.node.SetTelemetryCollector(dest); err != nil {
+		return 2
+	}
+	return 0
+}
+
+//export runcore_telemetry_json
+func runcore_telemetry_json(handle C.uint64_t) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return nil
+	}
+	b, _ := json.Marshal(map[string]any{
+		"peers":     h.node.PeerTelemetryList(),
+		"allowed":   h.node.TelemetryAccess(),
+		"collector": h.node.TelemetryCollector(),
+	})
+	return allocCString(string(b))
+}
+
+//export runcore_open_presence
+func runcore_open_presence(handle C.uint64_t, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	if err := h.node.OpenPresence(C.GoString(destHashHex)); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_close_presence
+func runcore_close_presence(handle C.uint64_t, destHashHex *C.char) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	h.node.ClosePresence(C.GoString(destHashHex))
+	return 0
+}
+
+//export runcore_set_typing
+func runcore_set_typing(handle C.uint64_t, destHashHex *C.char, typing C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if destHashHex == nil {
+		return 2
+	}
+	if err := h.node.SetTyping(C.GoString(destHashHex), typing != 0); err != nil {
+		return 3
+	}
+	return 0
+}
+
+//export runcore_presence_json
+func runcore_presence_json(handle C.uint64_t, destHashHex *C.char) *C.char {
+	h := getHandle(handle)
+	if h == nil || h.node == nil || destHashHex == nil {
+		return nil
+	}
+	b, _ := json.Marshal(h.node.Presence(C.GoString(destHashHex)))
+	return allocCString(string(b))
+}
+
+//export runcore_set_profile_push
+func runcore_set_profile_push(handle C.uint64_t, enabled C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	h.node.SetProfilePush(enabled != 0)
+	return 0
+}
+
+//export runcore_set_avatar_image_with_thumbnail
+func runcore_set_avatar_image_with_thumbnail(handle C.uint64_t, mime *C.char, data *C.uchar, dataLen C.int32_t, thumbMime *C.char, thumbData *C.uchar, thumbLen C.int32_t) C.int32_t {
+	h := getHandle(handle)
+	if h == nil || h.node == nil {
+		return 1
+	}
+	if data == nil || dataLen <= 0 {
+		return 2
+	}
+	b := C.GoBytes(unsafe.Pointer(data), C.int(dataLen))
+	mt := ""
+	if mime != nil {
+		mt = C.GoString(mime)
+	}
+	var tb []byte
+	if thumbData != nil && thumbLen > 0 {
+		tb = C.GoBytes(unsafe.Pointer(thumbData), C.int(thumbLen))
+	}
+	tmt := ""
+	if thumbMime != nil {
+		tmt = C.GoString(thumbMime)
+	}
+	if err := h.node.SetAvatarImageWithThumbnail(mt, b, tmt, tb); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "set avatar image failed: %v", err)
+		return 3
+	}
+	return 0
+}
 
 //export runcore_clear_avatar
 func runcore_clear_avatar(handle C.uint64_t) C.int32_t {
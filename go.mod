@@ -3,6 +3,7 @@ module runcore
 go 1.25.4
 
 require (
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/svanichkin/configobj v0.0.1
 	github.com/svanichkin/go-lxmf v0.9.3
 	github.com/svanichkin/go-reticulum v1.0.4
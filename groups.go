@@ -0,0 +1,647 @@
+package runcore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Group chats. A group has a random 16-byte ID, a name, an admin (the creator's delivery
+// destination) and a member list. Group messages are ordinary LXMF messages sent to every
+// member with {"id": group_id, "m": message_id} in FIELD_GROUP; the message ID is the same
+// in every member's copy, so all members store and thread the message under one ID.
+// Membership travels as signed "runcore.group" control messages: the admin invites,
+// invitees join, members leave, and every change is published by the admin as a versioned
+// update. Control messages use the direct method (links, resources for larger member lists)
+// and are retried through the outbound propagation node when that fails; group messages use
+// the method of the SendOptions.
+
+const (
+	// CapGroups: accepts group control messages and FIELD_GROUP messages.
+	CapGroups = "groups"
+
+	groupControlType = "runcore.group"
+
+	groupIDLen       = 16
+	maxGroupMembers  = 32
+	maxGroupNameLen  = 64
+	maxGroupInvites  = 64
+	groupsConfigFile = "groups.json"
+)
+
+func init() { registerCapability(CapGroups) }
+
+// Group control operations.
+const (
+	groupOpInvite = "invite"
+	groupOpJoin   = "join"
+	groupOpLeave  = "leave"
+	groupOpUpdate = "update"
+)
+
+// Group is a group conversation.
+type Group struct {
+	IDHex    string   `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	AdminHex string   `json:"admin"`
+	Members  []string `json:"members"`
+	// Invited lists pending invitations; only the admin tracks them.
+	Invited []string `json:"invited,omitempty"`
+	Version int64    `json:"version"`
+	Created int64    `json:"created"`
+}
+
+func (g *Group) clone() Group {
+	c := *g
+	c.Members = slices.Clone(g.Members)
+	c.Invited = slices.Clone(g.Invited)
+	return c
+}
+
+// Group event kinds.
+const (
+	GroupEventInvite  = "invite"  // we were invited; see AcceptGroupInvite
+	GroupEventJoined  = "joined"  // the admin confirmed our membership
+	GroupEventUpdated = "updated" // name or members changed
+	GroupEventRemoved = "removed" // we were removed or the group was disbanded
+	GroupEventMessage = "message" // a member sent a message to the group
+)
+
+// GroupEvent reports group membership changes and group messages.
+type GroupEvent struct {
+	Kind         string `json:"kind"`
+	Group        Group  `json:"group"`
+	SenderHex    string `json:"sender,omitempty"`
+	MessageIDHex string `json:"message_id_hex,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Content      string `json:"content,omitempty"`
+}
+
+// groupState is the node's group state, persisted to Dir/groups.json.
+type groupState struct {
+	mu      sync.Mutex
+	loaded  bool
+	groups  map[string]*Group
+	invites map[string]*groupInvite
+	onEvent func(GroupEvent)
+}
+
+type groupInvite struct {
+	Group    Group `json:"group"`
+	Accepted bool  `json:"accepted,omitempty"`
+}
+
+type groupsConfig struct {
+	Groups  map[string]*Group       `json:"groups,omitempty"`
+	Invites map[string]*groupInvite `json:"invites,omitempty"`
+}
+
+func (n *Node) groupsPath() string {
+	return filepath.Join(n.opts.Dir, groupsConfigFile)
+}
+
+// loadGroupsLocked reads Dir/groups.json once; n.groups.mu is held.
+func (n *Node) loadGroupsLocked() {
+	gs := &n.groups
+	if gs.loaded {
+		return
+	}
+	gs.loaded = true
+	gs.groups = make(map[string]*Group)
+	gs.invites = make(map[string]*groupInvite)
+	b, err := os.ReadFile(n.groupsPath())
+	if err != nil {
+		return
+	}
+	var cfg groupsConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "groups: parse %s: %v", groupsConfigFile, err)
+		return
+	}
+	for k, g := range cfg.Groups {
+		if g != nil && validHashHex(k, groupIDLen) {
+			gs.groups[k] = g
+		}
+	}
+	for k, inv := range cfg.Invites {
+		if inv != nil && validHashHex(k, groupIDLen) {
+			gs.invites[k] = inv
+		}
+	}
+}
+
+// saveGroupsLocked persists groups and invites; n.groups.mu is held.
+func (n *Node) saveGroupsLocked() {
+	b, err := json.MarshalIndent(groupsConfig{Groups: n.groups.groups, Invites: n.groups.invites}, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(n.groupsPath(), b)
+	}
+	if err != nil {
+		rns.Logf(rns.LOG_NOTICE, "groups: save failed: %v", err)
+	}
+}
+
+// SetGroupEventHandler sets the callback for group events. It runs on a router goroutine
+// and must not block for long.
+func (n *Node) SetGroupEventHandler(cb func(GroupEvent)) {
+	n.groups.mu.Lock()
+	n.groups.onEvent = cb
+	n.groups.mu.Unlock()
+}
+
+func (n *Node) emitGroupEvent(ev GroupEvent) {
+	n.groups.mu.Lock()
+	cb := n.groups.onEvent
+	n.groups.mu.Unlock()
+	if cb != nil {
+		cb(ev)
+	}
+}
+
+// Groups returns the groups we are a member of.
+func (n *Node) Groups() []Group {
+	n.groups.mu.Lock()
+	defer n.groups.mu.Unlock()
+	n.loadGroupsLocked()
+	out := make([]Group, 0, len(n.groups.groups))
+	for _, g := range n.groups.groups {
+		out = append(out, g.clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	return out
+}
+
+// GroupHex returns a group we are a member of.
+func (n *Node) GroupHex(groupIDHex string) (Group, bool) {
+	n.groups.mu.Lock()
+	defer n.groups.mu.Unlock()
+	n.loadGroupsLocked()
+	g := n.groups.groups[strings.ToLower(strings.TrimSpace(groupIDHex))]
+	if g == nil {
+		return Group{}, false
+	}
+	return g.clone(), true
+}
+
+// GroupInvites returns pending invitations.
+func (n *Node) GroupInvites() []Group {
+	n.groups.mu.Lock()
+	defer n.groups.mu.Unlock()
+	n.loadGroupsLocked()
+	out := make([]Group, 0, len(n.groups.invites))
+	for _, inv := range n.groups.invites {
+		out = append(out, inv.Group.clone())
+	}
+	return out
+}
+
+// CreateGroup creates a group administered by this node and invites members.
+func (n *Node) CreateGroup(name string, members []string) (Group, error) {
+	if n == nil || n.identity == nil {
+		return Group{}, errors.New("node not started")
+	}
+	name, ok := wireText(strings.TrimSpace(name), maxGroupNameLen)
+	if !ok {
+		return Group{}, errors.New("invalid group name")
+	}
+	if len(members) >= maxGroupMembers {
+		return Group{}, errors.New("too many members")
+	}
+	id := make([]byte, groupIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return Group{}, err
+	}
+	self := n.DestinationHashHex()
+	g := &Group{
+		IDHex:    hex.EncodeToString(id),
+		Name:     name,
+		AdminHex: self,
+		Members:  []string{self},
+		Version:  1,
+		Created:  time.Now().Unix(),
+	}
+	n.groups.mu.Lock()
+	n.loadGroupsLocked()
+	n.groups.groups[g.IDHex] = g
+	n.saveGroupsLocked()
+	n.groups.mu.Unlock()
+
+	var errs []error
+	for _, m := range members {
+		if err := n.InviteToGroup(g.IDHex, m); err != nil {
+			errs = append(errs, fmt.Errorf("invite %s: %w", m, err))
+		}
+	}
+	out, _ := n.GroupHex(g.IDHex)
+	return out, errors.Join(errs...)
+}
+
+// InviteToGroup invites a contact to a group we administer.
+func (n *Node) InviteToGroup(groupIDHex, destinationHashHex string) error {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return errors.New("invalid destination hash")
+	}
+	info, err := n.contactInfo(nil, destHex)
+	if err != nil {
+		return err
+	}
+	if !info.Supports(CapGroups) {
+		return ErrPeerUnsupported
+	}
+	n.groups.mu.Lock()
+	n.loadGroupsLocked()
+	g := n.groups.groups[gid]
+	switch {
+	case g == nil:
+		n.groups.mu.Unlock()
+		return errors.New("unknown group")
+	case g.AdminHex != n.DestinationHashHex():
+		n.groups.mu.Unlock()
+		return errors.New("not the group admin")
+	case slices.Contains(g.Members, destHex):
+		n.groups.mu.Unlock()
+		return nil
+	case len(g.Members)+len(g.Invited) >= maxGroupMembers:
+		n.groups.mu.Unlock()
+		return errors.New("group is full")
+	}
+	if !slices.Contains(g.Invited, destHex) {
+		g.Invited = append(g.Invited, destHex)
+		n.saveGroupsLocked()
+	}
+	payload := groupControl(groupOpInvite, g)
+	n.groups.mu.Unlock()
+	return n.sendGroupControl(destHex, payload)
+}
+
+// AcceptGroupInvite asks the admin of an invited group to add us. The group appears in
+// Groups once the admin confirms (GroupEventJoined).
+func (n *Node) AcceptGroupInvite(groupIDHex string) error {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	n.groups.mu.Lock()
+	n.loadGroupsLocked()
+	inv := n.groups.invites[gid]
+	if inv == nil {
+		n.groups.mu.Unlock()
+		return errors.New("no such invite")
+	}
+	inv.Accepted = true
+	n.saveGroupsLocked()
+	admin := inv.Group.AdminHex
+	n.groups.mu.Unlock()
+	id, _ := hex.DecodeString(gid)
+	return n.sendGroupControl(admin, map[any]any{"op": groupOpJoin, "id": id})
+}
+
+// DeclineGroupInvite drops an invitation. The admin is not notified.
+func (n *Node) DeclineGroupInvite(groupIDHex string) {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	n.groups.mu.Lock()
+	defer n.groups.mu.Unlock()
+	n.loadGroupsLocked()
+	if n.groups.invites[gid] != nil {
+		delete(n.groups.invites, gid)
+		n.saveGroupsLocked()
+	}
+}
+
+// LeaveGroup leaves a group. When the admin leaves, the group is disbanded.
+func (n *Node) LeaveGroup(groupIDHex string) error {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	self := n.DestinationHashHex()
+	n.groups.mu.Lock()
+	n.loadGroupsLocked()
+	g := n.groups.groups[gid]
+	if g == nil {
+		n.groups.mu.Unlock()
+		return errors.New("unknown group")
+	}
+	delete(n.groups.groups, gid)
+	n.saveGroupsLocked()
+	if g.AdminHex != self {
+		n.groups.mu.Unlock()
+		id, _ := hex.DecodeString(gid)
+		return n.sendGroupControl(g.AdminHex, map[any]any{"op": groupOpLeave, "id": id})
+	}
+	recipients := slices.DeleteFunc(slices.Clone(g.Members), func(m string) bool { return m == self })
+	g.Members = nil
+	g.Invited = nil
+	g.Version++
+	payload := groupControl(groupOpUpdate, g)
+	n.groups.mu.Unlock()
+	return n.sendGroupControls(recipients, payload)
+}
+
+// RemoveGroupMember removes a member (or withdraws an invitation) from a group we administer.
+func (n *Node) RemoveGroupMember(groupIDHex, destinationHashHex string) error {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	n.groups.mu.Lock()
+	n.loadGroupsLocked()
+	g := n.groups.groups[gid]
+	if g == nil || g.AdminHex != n.DestinationHashHex() {
+		n.groups.mu.Unlock()
+		return errors.New("not the group admin")
+	}
+	if destHex == g.AdminHex {
+		n.groups.mu.Unlock()
+		return errors.New("cannot remove the admin")
+	}
+	g.Invited = slices.DeleteFunc(g.Invited, func(m string) bool { return m == destHex })
+	if !slices.Contains(g.Members, destHex) {
+		n.saveGroupsLocked()
+		n.groups.mu.Unlock()
+		return nil
+	}
+	// The removed member gets the update too, so it knows it is no longer in the group.
+	recipients := slices.DeleteFunc(slices.Clone(g.Members), func(m string) bool { return m == g.AdminHex })
+	payload := n.bumpGroupLocked(g, func() {
+		g.Members = slices.DeleteFunc(g.Members, func(m string) bool { return m == destHex })
+	})
+	n.groups.mu.Unlock()
+	return n.sendGroupControls(recipients, payload)
+}
+
+// bumpGroupLocked applies change, increments the version, saves, and returns the update
+// payload; n.groups.mu is held.
+func (n *Node) bumpGroupLocked(g *Group, change func()) map[any]any {
+	change()
+	g.Version++
+	n.saveGroupsLocked()
+	return groupControl(groupOpUpdate, g)
+}
+
+// SendGroup sends a message to every other member of a group. It returns the sent
+// messages; members that could not be sent to are reported in the error. The message is
+// stored under its group-level ID (see GroupMessageIDHex).
+func (n *Node) SendGroup(groupIDHex string, msg SendOptions) ([]*lxmf.LXMessage, error) {
+	gid := strings.ToLower(strings.TrimSpace(groupIDHex))
+	g, ok := n.GroupHex(gid)
+	if !ok {
+		return nil, errors.New("unknown group")
+	}
+	id, _ := hex.DecodeString(gid)
+	mid := make([]byte, lxmfMessageIDLen)
+	if _, err := rand.Read(mid); err != nil {
+		return nil, err
+	}
+	fields := make(map[any]any, len(msg.Fields)+1)
+	for k, v := range msg.Fields {
+		fields[k] = v
+	}
+	fields[lxmf.FieldGroup] = map[any]any{"id": id, "m": mid}
+	msg.Fields = fields
+
+	self := n.DestinationHashHex()
+	var sent []*lxmf.LXMessage
+	var errs []error
+	for _, member := range g.Members {
+		if member == self {
+			continue
+		}
+		lxm, err := n.send(member, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("send to %s: %w", member, err))
+			continue
+		}
+		n.recordPartner(member)
+		sent = append(sent, lxm)
+	}
+	if len(sent) > 0 {
+		sm := n.storedMessageWithID(gid, hex.EncodeToString(mid), sent[0], true)
+		sm.SenderHex = self
+		n.storeMessage(sm)
+	}
+	return sent, errors.Join(errs...)
+}
+
+// GroupIDHex returns the ID of the group a received group message belongs to, or "" if m
+// is not a group message.
+func GroupIDHex(m *lxmf.LXMessage) string {
+	if m == nil {
+		return ""
+	}
+	v, ok := LXMFField(m.Fields, lxmf.FieldGroup)
+	if !ok {
+		return ""
+	}
+	f, err := parseGroupField(v)
+	if err != nil {
+		return ""
+	}
+	return f.IDHex
+}
+
+// GroupMessageIDHex returns the group-level ID a sent or received group message is stored
+// under, or "" if m is not a group message.
+func GroupMessageIDHex(m *lxmf.LXMessage) string {
+	if m == nil {
+		return ""
+	}
+	v, ok := LXMFField(m.Fields, lxmf.FieldGroup)
+	if !ok {
+		return ""
+	}
+	f, err := parseGroupField(v)
+	if err != nil {
+		return ""
+	}
+	return groupMessageIDHex(f, m)
+}
+
+// groupMessageIDHex returns the group-level message ID, falling back to the LXMF message ID
+// for senders that predate it.
+func groupMessageIDHex(f groupField, m *lxmf.LXMessage) string {
+	if f.MessageIDHex != "" {
+		return f.MessageIDHex
+	}
+	return lxmfMessageIDHex(m)
+}
+
+// deliverGroupMessage stores a FIELD_GROUP message from a member of a known group and
+// reports it as a group event. It reports whether m was a group message.
+func (n *Node) deliverGroupMessage(m *lxmf.LXMessage) bool {
+	v, ok := LXMFField(m.Fields, lxmf.FieldGroup)
+	if !ok {
+		return false
+	}
+	f, err := decodeGroupField(v)
+	if err != nil {
+		return false
+	}
+	srcHex := hex.EncodeToString(m.SourceHash)
+	g, ok := n.GroupHex(f.IDHex)
+	if !ok || !slices.Contains(g.Members, srcHex) {
+		// Not a group we know (or not from a member): deliver as a direct message.
+		return false
+	}
+	sm := n.storedMessageWithID(f.IDHex, groupMessageIDHex(f, m), m, false)
+	sm.SenderHex = srcHex
+	if sm.IDHex == "" || !n.storeMessage(sm) {
+		return true
+	}
+	n.emitGroupEvent(GroupEvent{
+		Kind:         GroupEventMessage,
+		Group:        g,
+		SenderHex:    srcHex,
+		MessageIDHex: sm.IDHex,
+		Title:        sm.Title,
+		Content:      sm.Content,
+	})
+	return true
+}
+
+// handleGroupControl handles invites, joins, leaves and updates.
+func (n *Node) handleGroupControl(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	c, err := decodeGroupControl(raw)
+	if err != nil {
+		return
+	}
+	self := n.DestinationHashHex()
+	gs := &n.groups
+	gs.mu.Lock()
+	n.loadGroupsLocked()
+	g := gs.groups[c.Group.IDHex]
+
+	var ev *GroupEvent
+	var recipients []string
+	var payload map[any]any
+
+	switch c.Op {
+	case groupOpInvite, groupOpUpdate:
+		// Only the admin publishes group state, and the admin never changes.
+		if srcHex != c.Group.AdminHex || (g != nil && g.AdminHex != srcHex) {
+			break
+		}
+		if g != nil {
+			if c.Group.Version <= g.Version {
+				break
+			}
+			if !slices.Contains(c.Group.Members, self) {
+				delete(gs.groups, g.IDHex)
+				ev = &GroupEvent{Kind: GroupEventRemoved, Group: c.Group}
+			} else {
+				g.Name, g.Members, g.Version = c.Group.Name, c.Group.Members, c.Group.Version
+				ev = &GroupEvent{Kind: GroupEventUpdated, Group: g.clone()}
+			}
+			n.saveGroupsLocked()
+			break
+		}
+		inv := gs.invites[c.Group.IDHex]
+		if slices.Contains(c.Group.Members, self) {
+			if inv == nil || !inv.Accepted {
+				break // membership we never asked for
+			}
+			ng := c.Group.clone()
+			gs.groups[ng.IDHex] = &ng
+			delete(gs.invites, ng.IDHex)
+			ev = &GroupEvent{Kind: GroupEventJoined, Group: ng.clone()}
+			n.saveGroupsLocked()
+			break
+		}
+		if c.Op != groupOpInvite {
+			break
+		}
+		if inv != nil && inv.Accepted {
+			break // already accepted; wait for the admin's update
+		}
+		if inv == nil && len(gs.invites) >= maxGroupInvites {
+			break
+		}
+		gs.invites[c.Group.IDHex] = &groupInvite{Group: c.Group.clone()}
+		ev = &GroupEvent{Kind: GroupEventInvite, Group: c.Group.clone(), SenderHex: srcHex}
+		n.saveGroupsLocked()
+
+	case groupOpJoin:
+		if g == nil || g.AdminHex != self || !slices.Contains(g.Invited, srcHex) {
+			break
+		}
+		payload = n.bumpGroupLocked(g, func() {
+			g.Invited = slices.DeleteFunc(g.Invited, func(m string) bool { return m == srcHex })
+			g.Members = append(g.Members, srcHex)
+		})
+		recipients = slices.DeleteFunc(slices.Clone(g.Members), func(m string) bool { return m == self })
+		ev = &GroupEvent{Kind: GroupEventUpdated, Group: g.clone(), SenderHex: srcHex}
+
+	case groupOpLeave:
+		if g == nil || g.AdminHex != self || !slices.Contains(g.Members, srcHex) {
+			break
+		}
+		payload = n.bumpGroupLocked(g, func() {
+			g.Members = slices.DeleteFunc(g.Members, func(m string) bool { return m == srcHex })
+		})
+		recipients = slices.DeleteFunc(slices.Clone(g.Members), func(m string) bool { return m == self })
+		ev = &GroupEvent{Kind: GroupEventUpdated, Group: g.clone(), SenderHex: srcHex}
+	}
+	gs.mu.Unlock()
+
+	if payload != nil {
+		if err := n.sendGroupControls(recipients, payload); err != nil {
+			rns.Logf(rns.LOG_NOTICE, "groups: update %s: %v", c.Group.IDHex, err)
+		}
+	}
+	if ev != nil {
+		n.emitGroupEvent(*ev)
+	}
+}
+
+// groupControl builds an invite or update payload for g.
+func groupControl(op string, g *Group) map[any]any {
+	id, _ := hex.DecodeString(g.IDHex)
+	admin, _ := hex.DecodeString(g.AdminHex)
+	members := make([]any, 0, len(g.Members))
+	for _, m := range g.Members {
+		b, _ := hex.DecodeString(m)
+		members = append(members, b)
+	}
+	return map[any]any{
+		"op": op,
+		"g": map[any]any{
+			"id": id,
+			"n":  g.Name,
+			"a":  admin,
+			"m":  members,
+			"v":  g.Version,
+			"c":  g.Created,
+		},
+	}
+}
+
+// sendGroupControl sends a group control message with the direct method, falling back to
+// the outbound propagation node.
+func (n *Node) sendGroupControl(destHex string, payload map[any]any) error {
+	_, err := n.sendWithPropagationFallback(destHex, SendOptions{
+		Method: lxmf.MethodDirect,
+		Fields: map[any]any{
+			lxmf.FieldCustomType: groupControlType,
+			lxmf.FieldCustomData: payload,
+		},
+	})
+	return err
+}
+
+func (n *Node) sendGroupControls(recipients []string, payload map[any]any) error {
+	var errs []error
+	for _, r := range recipients {
+		if err := n.sendGroupControl(r, payload); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r, err))
+		}
+	}
+	return errors.Join(errs...)
+}
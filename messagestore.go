@@ -0,0 +1,583 @@
+package runcore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Message store: delivered and sent messages are kept per conversation under
+// Dir/messages/<peer>.json (the newest maxStoredMessages each), with replies, reactions,
+// edits and deletions applied to them (see actions.go). Inline attachments (files, image,
+// audio) of received messages, and of sent ones with Options.StoreOutboundAttachments, are
+// saved under Dir/messages/attachments/<peer>/<message id>/. Group conversations are stored
+// the same way under the group ID (see groups.go). Conversations are loaded on first
+// use and written back messageStoreFlushDelay after a change, and on Close.
+
+const (
+	maxStoredMessages      = 2000
+	messageStoreFlushDelay = time.Second
+)
+
+// StoredMessage is a message in the store.
+type StoredMessage struct {
+	IDHex   string `json:"id"`
+	PeerHex string `json:"peer"`
+	// SenderHex is the author of a group message (PeerHex is then the group ID).
+	SenderHex string `json:"sender,omitempty"`
+	Outgoing  bool   `json:"outgoing,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	// State is the last outbound state (lxmf.Message* or MessageRead); 0 for inbound.
+	State int `json:"state,omitempty"`
+
+	// ReplyToHex is the message this one replies to; Replies lists replies to this one.
+	ReplyToHex string   `json:"reply_to,omitempty"`
+	Replies    []string `json:"replies,omitempty"`
+
+	// Reactions maps an emoji to the destination hashes of those who reacted with it.
+	Reactions map[string][]string `json:"reactions,omitempty"`
+
+	// Edits holds previous versions, oldest first; Edited is the time of the last edit.
+	Edits  []MessageEdit `json:"edits,omitempty"`
+	Edited int64         `json:"edited,omitempty"`
+
+	// Deleted marks a message removed by its sender; title, content, edits and
+	// attachments are dropped.
+	Deleted bool `json:"deleted,omitempty"`
+
+	Attachments []StoredAttachment `json:"attachments,omitempty"`
+}
+
+// StoredAttachment is an inline attachment of a stored message.
+type StoredAttachment struct {
+	// Kind is "file", "image" or "audio"; Format is the image format or audio mode name
+	// (see AudioFormat).
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Format string `json:"format,omitempty"`
+	// Audio only: the LXMF audio mode, mime type and play time (0 if unknown).
+	AudioMode  int    `json:"audio_mode,omitempty"`
+	Mime       string `json:"mime,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	// File is relative to the message store (the store may move, eg with an app
+	// container); Path is the full path, filled in by Conversation and StoredMessageHex.
+	File string `json:"file"`
+	Path string `json:"path,omitempty"`
+	Size int    `json:"size"`
+}
+
+// MessageEdit is a previous version of an edited message.
+type MessageEdit struct {
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content"`
+	At      int64  `json:"at"`
+}
+
+func (m *StoredMessage) clone() StoredMessage {
+	c := *m
+	c.Replies = append([]string(nil), m.Replies...)
+	c.Edits = append([]MessageEdit(nil), m.Edits...)
+	c.Attachments = append([]StoredAttachment(nil), m.Attachments...)
+	if m.Reactions != nil {
+		c.Reactions = make(map[string][]string, len(m.Reactions))
+		for k, v := range m.Reactions {
+			c.Reactions[k] = append([]string(nil), v...)
+		}
+	}
+	return c
+}
+
+// messageStore is the node's message store state.
+type messageStore struct {
+	mu    sync.Mutex
+	convs map[string]*conversation
+	dirty map[string]bool
+	timer *time.Timer
+}
+
+// conversation holds one peer's messages, oldest first.
+type conversation struct {
+	msgs []*StoredMessage
+	byID map[string]*StoredMessage
+}
+
+func (n *Node) messagesDir() string {
+	return filepath.Join(n.opts.Dir, "messages")
+}
+
+func (n *Node) conversationPath(peerHex string) string {
+	return filepath.Join(n.messagesDir(), peerHex+".json")
+}
+
+// conversationLocked returns the conversation with peerHex, loading it from disk;
+// n.messages.mu is held.
+func (n *Node) conversationLocked(peerHex string) *conversation {
+	s := &n.messages
+	if c := s.convs[peerHex]; c != nil {
+		return c
+	}
+	c := &conversation{byID: make(map[string]*StoredMessage)}
+	if b, err := os.ReadFile(n.conversationPath(peerHex)); err == nil {
+		var msgs []*StoredMessage
+		if err := json.Unmarshal(b, &msgs); err != nil {
+			rns.Logf(rns.LOG_NOTICE, "message store: parse %s: %v", peerHex, err)
+		}
+		for _, m := range msgs {
+			if m == nil || m.IDHex == "" || c.byID[m.IDHex] != nil {
+				continue
+			}
+			m.PeerHex = peerHex
+			c.msgs = append(c.msgs, m)
+			c.byID[m.IDHex] = m
+		}
+	}
+	if s.convs == nil {
+		s.convs = make(map[string]*conversation)
+	}
+	s.convs[peerHex] = c
+	return c
+}
+
+// markDirtyLocked schedules a write of the conversation; n.messages.mu is held.
+func (n *Node) markDirtyLocked(peerHex string) {
+	s := &n.messages
+	if s.dirty == nil {
+		s.dirty = make(map[string]bool)
+	}
+	s.dirty[peerHex] = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(messageStoreFlushDelay, n.flushMessageStore)
+	}
+}
+
+func (n *Node) flushMessageStore() {
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.dirty) == 0 {
+		return
+	}
+	if err := os.MkdirAll(n.messagesDir(), 0o755); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "message store: %v", err)
+		return
+	}
+	for peerHex := range s.dirty {
+		c := s.convs[peerHex]
+		if c == nil {
+			continue
+		}
+		b, err := json.Marshal(c.msgs)
+		if err == nil {
+			err = writeFileAtomic(n.conversationPath(peerHex), b)
+		}
+		if err != nil {
+			rns.Logf(rns.LOG_NOTICE, "message store: save %s failed: %v", peerHex, err)
+		}
+	}
+	s.dirty = nil
+}
+
+// storeMessage adds m to its conversation unless a message with the same ID is stored.
+// It reports whether m was added.
+func (n *Node) storeMessage(m *StoredMessage) bool {
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := n.conversationLocked(m.PeerHex)
+	if c.byID[m.IDHex] != nil {
+		return false
+	}
+	// Keep timestamp order; messages may arrive late (eg via propagation nodes).
+	i := sort.Search(len(c.msgs), func(i int) bool { return c.msgs[i].Timestamp > m.Timestamp })
+	c.msgs = append(c.msgs, nil)
+	copy(c.msgs[i+1:], c.msgs[i:])
+	c.msgs[i] = m
+	c.byID[m.IDHex] = m
+	if m.ReplyToHex != "" {
+		if parent := c.byID[m.ReplyToHex]; parent != nil {
+			parent.Replies = append(parent.Replies, m.IDHex)
+		}
+	}
+	if len(c.msgs) > maxStoredMessages {
+		for _, old := range c.msgs[:len(c.msgs)-maxStoredMessages] {
+			delete(c.byID, old.IDHex)
+			if len(old.Attachments) > 0 {
+				n.removeStoredAttachments(m.PeerHex, old.IDHex)
+			}
+		}
+		c.msgs = append([]*StoredMessage(nil), c.msgs[len(c.msgs)-maxStoredMessages:]...)
+	}
+	n.markDirtyLocked(m.PeerHex)
+	return true
+}
+
+// updateMessage applies fn to a stored message; fn reports whether it changed anything.
+func (n *Node) updateMessage(peerHex, idHex string, fn func(*StoredMessage) bool) (StoredMessage, bool) {
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := n.conversationLocked(peerHex).byID[idHex]
+	if m == nil {
+		return StoredMessage{}, false
+	}
+	if fn(m) {
+		n.markDirtyLocked(peerHex)
+	}
+	return m.clone(), true
+}
+
+// storedMessageFromLXM converts m for the store, saving its inline attachments.
+func (n *Node) storedMessageFromLXM(peerHex string, m *lxmf.LXMessage, outgoing bool) *StoredMessage {
+	return n.storedMessageWithID(peerHex, lxmfMessageIDHex(m), m, outgoing)
+}
+
+// storedMessageWithID is storedMessageFromLXM with the store ID given (group messages are
+// stored under their group-level ID).
+func (n *Node) storedMessageWithID(peerHex, idHex string, m *lxmf.LXMessage, outgoing bool) *StoredMessage {
+	sm := &StoredMessage{
+		IDHex:     idHex,
+		PeerHex:   peerHex,
+		Outgoing:  outgoing,
+		Title:     m.TitleAsString(),
+		Content:   m.ContentAsString(),
+		Timestamp: int64(m.Timestamp),
+	}
+	if sm.Timestamp <= 0 {
+		sm.Timestamp = time.Now().Unix()
+	}
+	if outgoing {
+		sm.State = int(m.State)
+	}
+	if v, ok := LXMFField(m.Fields, lxmf.FieldThread); ok {
+		sm.ReplyToHex = threadMessageIDHex(v)
+	}
+	// Attachments of sent messages are the app's own files; keep copies only if asked to.
+	// Messages already stored (eg redelivered through a propagation node) are rejected by
+	// storeMessage, so their attachments are not written again.
+	if sm.IDHex != "" && (!outgoing || n.opts.StoreOutboundAttachments) && !n.hasStoredMessage(peerHex, sm.IDHex) {
+		sm.Attachments = n.saveStoredAttachments(peerHex, sm.IDHex, m.Fields)
+	}
+	return sm
+}
+
+// hasStoredMessage reports whether the conversation with peerHex holds message idHex.
+func (n *Node) hasStoredMessage(peerHex, idHex string) bool {
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return n.conversationLocked(peerHex).byID[idHex] != nil
+}
+
+// storedCopy returns a copy of m for callers, with attachment paths filled in.
+func (n *Node) storedCopy(m *StoredMessage) StoredMessage {
+	c := m.clone()
+	for i := range c.Attachments {
+		c.Attachments[i].Path = n.StoredAttachmentPath(c.Attachments[i])
+	}
+	return c
+}
+
+// storedAttachmentsDir is per conversation: group message IDs are chosen by the sender, so
+// an ID alone could name another conversation's message.
+func (n *Node) storedAttachmentsDir(peerHex, idHex string) string {
+	return filepath.Join(n.messagesDir(), storedAttachmentsRel(peerHex, idHex))
+}
+
+func storedAttachmentsRel(peerHex, idHex string) string {
+	return filepath.Join("attachments", peerHex, idHex)
+}
+
+// StoredAttachmentPath returns the full path of a stored message's attachment.
+func (n *Node) StoredAttachmentPath(a StoredAttachment) string {
+	if n == nil || a.File == "" || !filepath.IsLocal(a.File) {
+		return ""
+	}
+	return filepath.Join(n.messagesDir(), a.File)
+}
+
+// saveStoredAttachments writes the files, image and audio carried in fields.
+func (n *Node) saveStoredAttachments(peerHex, idHex string, fields map[any]any) []StoredAttachment {
+	var out []StoredAttachment
+	save := func(kind, name, format, ext string, data []byte) bool {
+		file := sanitizeAttachmentName(name)
+		if file == "" || file == "." || file == ".." {
+			file = fmt.Sprintf("%s-%d", kind, len(out)+1)
+			if ext := sanitizeAttachmentName(ext); ext != "" {
+				file += "." + ext
+			}
+		}
+		dir := n.storedAttachmentsDir(peerHex, idHex)
+		err := os.MkdirAll(dir, 0o755)
+		if err == nil {
+			err = writeFileAtomic(filepath.Join(dir, file), data)
+		}
+		if err != nil {
+			rns.Logf(rns.LOG_NOTICE, "message store: attachment of %s: %v", idHex, err)
+			return false
+		}
+		out = append(out, StoredAttachment{
+			Kind:   kind,
+			Name:   name,
+			Format: format,
+			File:   filepath.Join(storedAttachmentsRel(peerHex, idHex), file),
+			Size:   len(data),
+		})
+		return true
+	}
+	for _, a := range MessageAttachments(fields) {
+		if save(a.Kind, a.Name, a.Format, a.Ext, a.Data) && a.Kind == "audio" {
+			sa := &out[len(out)-1]
+			sa.AudioMode = a.AudioMode
+			sa.Mime = a.Mime
+			sa.DurationMs = a.Duration.Milliseconds()
+		}
+	}
+	return out
+}
+
+// MessageAttachment is a file, image or audio carried inline in LXMF fields.
+type MessageAttachment struct {
+	// Kind is "file", "image" or "audio"; Name is the file name (files), Format the image
+	// format or audio mode name (see AudioFormat), Ext a file extension for unnamed ones.
+	Kind   string
+	Name   string
+	Format string
+	Ext    string
+	// Audio only: the LXMF audio mode, mime type and play time (0 if unknown).
+	AudioMode int
+	Mime      string
+	Duration  time.Duration
+	Data      []byte
+}
+
+// MessageAttachments returns the files, image and audio in fields, in that order.
+func MessageAttachments(fields map[any]any) []MessageAttachment {
+	var out []MessageAttachment
+	if v, ok := LXMFField(fields, lxmf.FieldFileAttachments); ok {
+		list, _ := v.([]any)
+		for _, item := range list {
+			if name, data, ok := attachmentPair(item); ok {
+				out = append(out, MessageAttachment{Kind: "file", Name: name, Data: data})
+			}
+		}
+	}
+	if v, ok := LXMFField(fields, lxmf.FieldImage); ok {
+		if format, data, ok := attachmentPair(v); ok {
+			out = append(out, MessageAttachment{Kind: "image", Format: format, Ext: format, Data: data})
+		}
+	}
+	if v, ok := LXMFField(fields, lxmf.FieldAudio); ok {
+		if a, ok := AudioFromField(v); ok {
+			f := AudioFormatOrDefault(a.Mode)
+			out = append(out, MessageAttachment{
+				Kind:      "audio",
+				Format:    f.Name,
+				Ext:       f.Ext,
+				AudioMode: int(a.Mode),
+				Mime:      f.Mime,
+				Duration:  a.Duration(),
+				Data:      a.Data,
+			})
+		}
+	}
+	return out
+}
+
+// attachmentPair reads a [name-or-format, data] attachment field entry.
+func attachmentPair(v any) (string, []byte, bool) {
+	pair, ok := v.([]any)
+	if !ok || len(pair) < 2 {
+		return "", nil, false
+	}
+	data, ok := pair[1].([]byte)
+	if !ok {
+		return "", nil, false
+	}
+	switch k := pair[0].(type) {
+	case string:
+		return k, data, true
+	case []byte:
+		return string(k), data, true
+	case nil:
+		return "", data, true
+	default:
+		return fmt.Sprint(k), data, true
+	}
+}
+
+func (n *Node) removeStoredAttachments(peerHex, idHex string) {
+	if err := os.RemoveAll(n.storedAttachmentsDir(peerHex, idHex)); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "message store: remove attachments of %s: %v", idHex, err)
+	}
+}
+
+// recordInboundMessage stores a delivered message and reports replies.
+func (n *Node) recordInboundMessage(m *lxmf.LXMessage) {
+	sm := n.storedMessageFromLXM(hex.EncodeToString(m.SourceHash), m, false)
+	if sm.IDHex == "" || !n.storeMessage(sm) {
+		return
+	}
+	if sm.ReplyToHex != "" {
+		n.emitMessageEvent(MessageEvent{
+			Kind:               MessageEventReply,
+			DestinationHashHex: sm.PeerHex,
+			MessageIDHex:       sm.IDHex,
+			ActorHashHex:       sm.PeerHex,
+			ReplyToHex:         sm.ReplyToHex,
+			At:                 sm.Timestamp,
+		})
+	}
+}
+
+// recordOutboundMessage stores a sent message and follows its state. Callbacks already
+// registered on m (or registered later through chained wrappers) keep working.
+func (n *Node) recordOutboundMessage(destHex string, m *lxmf.LXMessage) {
+	sm := n.storedMessageFromLXM(destHex, m, true)
+	if sm.IDHex == "" || !n.storeMessage(sm) {
+		return
+	}
+	update := func(lxm *lxmf.LXMessage) {
+		state := int(lxm.State)
+		n.updateMessage(destHex, sm.IDHex, func(s *StoredMessage) bool {
+			if s.State == state || s.State == MessageRead {
+				return false
+			}
+			s.State = state
+			return true
+		})
+	}
+	ChainMessageCallbacks(m, update, update)
+}
+
+// ChainMessageCallbacks adds delivery and failure callbacks to m without replacing the
+// ones already registered. Either may be nil.
+func ChainMessageCallbacks(m *lxmf.LXMessage, delivered, failed func(*lxmf.LXMessage)) {
+	if m == nil {
+		return
+	}
+	if delivered != nil {
+		prev := m.DeliveryCallback
+		m.RegisterDeliveryCallback(func(lxm *lxmf.LXMessage) {
+			if prev != nil {
+				prev(lxm)
+			}
+			delivered(lxm)
+		})
+	}
+	if failed != nil {
+		prev := m.FailedCallback
+		m.RegisterFailedCallback(func(lxm *lxmf.LXMessage) {
+			if prev != nil {
+				prev(lxm)
+			}
+			failed(lxm)
+		})
+	}
+}
+
+// threadMessageIDHex reads a FIELD_THREAD value: a message ID as bytes or hex.
+func threadMessageIDHex(v any) string {
+	switch t := v.(type) {
+	case []byte:
+		if len(t) == lxmfMessageIDLen {
+			return hex.EncodeToString(t)
+		}
+	case string:
+		s := strings.ToLower(strings.TrimSpace(t))
+		if validHashHex(s, lxmfMessageIDLen) {
+			return s
+		}
+	}
+	return ""
+}
+
+// StoredMessageHex returns a stored message of the conversation with a peer.
+func (n *Node) StoredMessageHex(peerHashHex, messageIDHex string) (StoredMessage, bool) {
+	peerHex := strings.ToLower(strings.TrimSpace(peerHashHex))
+	idHex := strings.ToLower(strings.TrimSpace(messageIDHex))
+	if n == nil || !validHashHex(peerHex, lxmf.DestinationLength) {
+		return StoredMessage{}, false
+	}
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := n.conversationLocked(peerHex).byID[idHex]
+	if m == nil {
+		return StoredMessage{}, false
+	}
+	return n.storedCopy(m), true
+}
+
+// Conversation returns up to limit stored messages exchanged with a peer, oldest first.
+// With before > 0 only messages older than that unix time are returned (for paging back).
+// limit <= 0 returns all of them.
+func (n *Node) Conversation(peerHashHex string, limit int, before int64) ([]StoredMessage, error) {
+	if n == nil {
+		return nil, errors.New("node not started")
+	}
+	peerHex := strings.ToLower(strings.TrimSpace(peerHashHex))
+	if !validHashHex(peerHex, lxmf.DestinationLength) {
+		return nil, errors.New("invalid destination hash")
+	}
+	s := &n.messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := n.conversationLocked(peerHex).msgs
+	end := len(msgs)
+	if before > 0 {
+		end = sort.Search(len(msgs), func(i int) bool { return msgs[i].Timestamp >= before })
+	}
+	start := 0
+	if limit > 0 && end-limit > start {
+		start = end - limit
+	}
+	out := make([]StoredMessage, 0, end-start)
+	for _, m := range msgs[start:end] {
+		out = append(out, n.storedCopy(m))
+	}
+	return out, nil
+}
+
+// ConversationPeers returns the destination hashes (and group IDs) of all stored conversations.
+func (n *Node) ConversationPeers() []string {
+	if n == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	entries, _ := os.ReadDir(n.messagesDir())
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if ok && validHashHex(name, lxmf.DestinationLength) {
+			seen[name] = true
+		}
+	}
+	n.messages.mu.Lock()
+	for k, c := range n.messages.convs {
+		if len(c.msgs) > 0 {
+			seen[k] = true
+		}
+	}
+	n.messages.mu.Unlock()
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -2,11 +2,13 @@ package runcore
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"os"
 	"path/filepath"
@@ -15,6 +17,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/svanichkin/configobj"
 	"github.com/svanichkin/go-lxmf/lxmf"
@@ -51,35 +54,108 @@ type Options struct {
 	// ResetRNSConfig overwrites generated Dir/rns/config with the embedded template.
 	// Has no effect if RNSConfigDir is set.
 	ResetRNSConfig bool
+
+	// AnnounceInterval is the base period between delivery announces.
+	// Zero reads [lxmf] announce_interval (minutes) from Dir/config, falling back to 15m.
+	// Negative disables periodic announces (profile changes still announce).
+	AnnounceInterval time.Duration
+
+	// AnnounceMaxInterval caps the backoff applied while announce app-data is unchanged
+	// (default: 8x AnnounceInterval).
+	AnnounceMaxInterval time.Duration
+
+	// AnnounceJitter randomizes every period by up to ±fraction (default: 0.1, max: 0.5).
+	AnnounceJitter float64
+
+	// PropagationAnnounceInterval is the period between propagation node announces. Set it
+	// only when running a propagation node (lxmf.LXMRouter.EnablePropagation); zero or
+	// negative never announces one. Announces are skipped while propagation is not enabled.
+	PropagationAnnounceInterval time.Duration
+
+	// ProfilePush sends profile changes (display name, avatar, profile) as a control message
+	// to conversation partners seen within ProfilePushWindow (default: 7 days) that support
+	// it, for peers that may miss announces (eg behind propagation nodes).
+	ProfilePush       bool
+	ProfilePushWindow time.Duration
+
+	// StoreOutboundAttachments also keeps copies of the attachments of sent messages in the
+	// message store (received ones are always kept).
+	StoreOutboundAttachments bool
 }
 
+// Node is a running Reticulum+LXMF instance. All methods are safe for concurrent use;
+// callbacks (inbound handler, request handlers) run on Reticulum goroutines.
 type Node struct {
 	opts Options
 
 	reticulum *rns.Reticulum
 	identity  *rns.Identity
+	rnsConfig *rnsConfigModel
 
 	storageDir string
 
+	// stateMu guards router, deliveryDestIn, profileDestIn, the on* callbacks and displayName.
+	// Use the snapshot accessors (currentRouter, deliveryDest, ...) from network goroutines.
+	stateMu         sync.RWMutex
 	router          *lxmf.LXMRouter
 	deliveryDestIn  *rns.Destination
 	profileDestIn   *rns.Destination
 	onInbound       func(*lxmf.LXMessage)
+	onAvatarUpdated func(CachedAvatar)
+	onMessageEvent  func(MessageEvent)
+	displayName     string
+
+	// lifecycleMu serializes Restart and Close; profileMu serializes profile writers
+	// (display name, avatar) including their on-disk copies.
+	lifecycleMu sync.Mutex
+	profileMu   sync.Mutex
+
+	// routerMu serializes runcore's calls into the LXMF router that touch its queues and
+	// delivery caches (HandleOutbound, loopback LXMDelivery, ExitHandler): the router does
+	// not lock them itself.
+	routerMu sync.Mutex
+
+	// avatar and userProfile are replaced wholesale on change; readers never see a partial update.
+	avatar      atomic.Pointer[avatarState]
+	userProfile atomic.Pointer[userProfileState]
+
+	peerProfilesMu sync.Mutex
+
+	// avatarCacheMu guards the contact avatar cache under Dir/avatars, its in-memory
+	// indexes (nil until first loaded) and avatarRefreshing.
+	avatarCacheMu    sync.Mutex
+	avatarIndexes    map[string]avatarCacheIndex
+	avatarRefreshing map[string]bool
+
+	// partnersMu guards partners and pushTimer (profile push); pushedMu guards pushed.
+	profilePush atomic.Bool
+	partnersMu  sync.Mutex
+	partners    map[string]time.Time
+	pushTimer   *time.Timer
+	pushedMu    sync.Mutex
+	pushed      map[string]pushedProfile
+
+	receipts  readReceipts
+	presence  presence
+	messages  messageStore
+	groups    groupState
+	telemetry telemetryState
+
 	announceMu      sync.Mutex
 	announces       map[string]AnnounceEntry
 	announceHandler *announceLogger
+	waiters         *hashWaiters
 
-	displayName      string
-	avatarPNG        []byte
-	avatarHash       []byte
-	avatarMTime      int64
-	avatarMime       string
 	announceStop     chan struct{}
 	announceStopOnce sync.Once
 
+	deliverySchedule    *announceSchedule
+	propagationSchedule *announceSchedule
+
 	networkResetMu sync.Mutex
 	ifaceStateMu   sync.Mutex
 	ifaceOfflineAt map[string]time.Time
+	ifaceStalled   bool
 	lastIfaceReset time.Time
 
 	announceInFlight int32
@@ -161,31 +237,41 @@ func Start(opts Options) (*Node, error) {
 		displayName:    opts.DisplayName,
 		announces:      make(map[string]AnnounceEntry),
 		ifaceOfflineAt: make(map[string]time.Time),
+		announceStop:   make(chan struct{}),
+		waiters:        newHashWaiters(),
 	}
 
+	n.startRNSConfigModel()
+
 	// Load optional avatar from disk (app-managed).
 	_ = n.loadAvatarFromDisk()
+	n.loadPartners()
+	n.profilePush.Store(opts.ProfilePush)
+	n.loadReadReceiptsConfig()
+	if err := n.loadUserProfileFromDisk(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		rns.Logf(rns.LOG_NOTICE, "load profile: %v", err)
+	}
 	if err := n.initProfileDestination(); err != nil {
 		return nil, err
 	}
 	n.initAnnounceHandler()
-	router.RegisterDeliveryCallback(func(m *lxmf.LXMessage) {
-		if n.onInbound != nil && m != nil {
-			n.onInbound(m)
-		}
-	})
+	router.RegisterDeliveryCallback(n.deliverInbound)
 
-	// Best-effort periodic announce (helps peers discover us even if multicast is flaky).
-	n.startPeriodicAnnounce(60 * time.Second)
+	// Periodic announces with jitter and backoff (helps peers discover us even if multicast is flaky).
+	n.startAnnounceSchedules()
 	n.startInterfaceWatchdog()
 	return n, nil
 }
 
 func (n *Node) Reticulum() *rns.Reticulum { return n.reticulum }
 func (n *Node) Identity() *rns.Identity   { return n.identity }
-func (n *Node) Router() *lxmf.LXMRouter   { return n.router }
+
+// Router returns the current LXMF router. It changes on Restart, so do not cache it.
+func (n *Node) Router() *lxmf.LXMRouter { return n.currentRouter() }
+
+// DeliveryDestination returns the current LXMF delivery destination. It changes on Restart.
 func (n *Node) DeliveryDestination() *rns.Destination {
-	return n.deliveryDestIn
+	return n.deliveryDest()
 }
 func (n *Node) ConfigDir() string { return n.opts.Dir }
 
@@ -217,33 +303,13 @@ type configuredInterfaceEntry struct {
 
 // ConfiguredInterfacesJSON returns interfaces from the Reticulum config file (including disabled ones).
 func (n *Node) ConfiguredInterfacesJSON() string {
-	if n == nil || n.reticulum == nil || n.reticulum.ConfigPath == "" {
+	if n == nil || n.reticulum == nil || n.rnsConfig == nil {
 		return `{"interfaces":[],"error":"reticulum not started"}`
 	}
-	cfg, err := configobj.Load(n.reticulum.ConfigPath)
+	out, err := n.rnsConfig.configured()
 	if err != nil {
 		return `{"interfaces":[],"error":"failed to load reticulum config"}`
 	}
-	if !cfg.HasSection("interfaces") {
-		return `{"interfaces":[]}`
-	}
-	sec := cfg.Section("interfaces")
-	names := sec.Sections()
-	sort.Strings(names)
-	out := make([]configuredInterfaceEntry, 0, len(names))
-	for _, name := range names {
-		s := sec.Subsection(name)
-		typ, _ := s.Get("type")
-		enabled := false
-		if v, ok := s.Get("interface_enabled"); ok {
-			enabled = parseTruthyString(v)
-		} else if v, ok := s.Get("enabled"); ok {
-			enabled = parseTruthyString(v)
-		} else if v, ok := s.Get("enable"); ok {
-			enabled = parseTruthyString(v)
-		}
-		out = append(out, configuredInterfaceEntry{Name: name, Type: typ, Enabled: enabled})
-	}
 	resp := map[string]any{"interfaces": out}
 	b, _ := json.Marshal(resp)
 	return string(b)
@@ -267,11 +333,23 @@ func (n *Node) Close() error {
 	if n == nil {
 		return nil
 	}
+	n.lifecycleMu.Lock()
+	defer n.lifecycleMu.Unlock()
 	if n.announceStop != nil {
 		n.announceStopOnce.Do(func() { close(n.announceStop) })
 	}
-	if n.router != nil {
-		n.router.ExitHandler()
+	n.partnersMu.Lock()
+	if n.pushTimer != nil {
+		n.pushTimer.Stop()
+	}
+	n.partnersMu.Unlock()
+	n.savePartners()
+	n.flushReadReceiptsNow()
+	n.flushMessageStore()
+	if router := n.currentRouter(); router != nil {
+		n.routerMu.Lock()
+		router.ExitHandler()
+		n.routerMu.Unlock()
 	}
 	if n.announceHandler != nil {
 		rns.DeregisterAnnounceHandler(n.announceHandler)
@@ -283,7 +361,7 @@ func (n *Node) Close() error {
 // SetInterfaceEnabled updates the Reticulum config and halts/resumes the interface by name.
 // Name must match the interface section name under [interfaces] (eg "Default Interface").
 func (n *Node) SetInterfaceEnabled(name string, enabled bool) error {
-	if n == nil || n.reticulum == nil || n.reticulum.ConfigPath == "" {
+	if n == nil || n.reticulum == nil || n.rnsConfig == nil {
 		return errors.New("reticulum not started")
 	}
 	name = strings.TrimSpace(name)
@@ -291,17 +369,16 @@ func (n *Node) SetInterfaceEnabled(name string, enabled bool) error {
 		return errors.New("missing interface name")
 	}
 
-	cfg, err := configobj.Load(n.reticulum.ConfigPath)
+	err := n.rnsConfig.update(func(cfg *configobj.Config) error {
+		if !cfg.HasSection("interfaces") {
+			cfg.Section("interfaces")
+		}
+		ifcSec := cfg.Section("interfaces").Subsection(name)
+		ifcSec.Set("interface_enabled", ternaryString(enabled, "Yes", "No"))
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("load reticulum config: %w", err)
-	}
-	if !cfg.HasSection("interfaces") {
-		cfg.Section("interfaces")
-	}
-	ifcSec := cfg.Section("interfaces").Subsection(name)
-	ifcSec.Set("interface_enabled", ternaryString(enabled, "Yes", "No"))
-	if err := cfg.Save(n.reticulum.ConfigPath); err != nil {
-		return fmt.Errorf("save reticulum config: %w", err)
+		return err
 	}
 
 	// Apply without restart when possible.
@@ -330,54 +407,126 @@ func (n *Node) Restart() error {
 	if n.identity == nil {
 		return errors.New("identity missing")
 	}
-	if n.profileDestIn == nil {
-		if err := n.initProfileDestination(); err != nil {
-			return err
-		}
-	}
+	n.lifecycleMu.Lock()
+	defer n.lifecycleMu.Unlock()
 	if n.storageDir == "" {
 		n.storageDir = filepath.Join(n.opts.Dir, "storage")
 	}
 
-	if n.router != nil {
-		n.router.ExitHandler()
-		n.router = nil
-		n.deliveryDestIn = nil
+	n.flushReadReceiptsNow()
+
+	// Detach the old router first so concurrent senders fail fast instead of
+	// handing messages to a router that is shutting down.
+	n.stateMu.Lock()
+	old := n.router
+	n.router = nil
+	n.deliveryDestIn = nil
+	n.stateMu.Unlock()
+	if old != nil {
+		n.routerMu.Lock()
+		old.ExitHandler()
+		n.routerMu.Unlock()
 	}
 
 	router, err := lxmf.NewLXMRouter(n.identity, n.storageDir)
 	if err != nil {
 		return fmt.Errorf("start lxmf router: %w", err)
 	}
-	delivery := router.RegisterDeliveryIdentity(n.identity, n.displayName, n.opts.DeliveryStampCost)
+	// Hold profileMu from reading the name until the router is published, so a
+	// SetDisplayName during the restart is not lost to the old name.
+	n.profileMu.Lock()
+	name := n.currentDisplayName()
+	delivery := router.RegisterDeliveryIdentity(n.identity, name, n.opts.DeliveryStampCost)
 	if delivery == nil {
+		n.profileMu.Unlock()
 		router.ExitHandler()
 		return errors.New("register delivery identity failed")
 	}
 
+	// Configure the router before publishing it: senders use it as soon as it is set.
+	router.RegisterDeliveryCallback(n.deliverInbound)
+
+	n.stateMu.Lock()
 	n.router = router
 	n.deliveryDestIn = delivery
+	n.stateMu.Unlock()
+	n.profileMu.Unlock()
 
-	router.RegisterDeliveryCallback(func(m *lxmf.LXMessage) {
-		if n.onInbound != nil && m != nil {
-			n.onInbound(m)
-		}
-	})
+	if err := n.initProfileDestination(); err != nil {
+		return err
+	}
 
 	// Best-effort re-announce on restart.
-	n.AnnounceDeliveryWithReason("restart")
+	n.requestAnnounce("restart")
 	return nil
 }
 
+// SetInboundHandler sets the callback for delivered messages. It may be called at any
+// time; the callback runs on a router goroutine and must not block for long.
 func (n *Node) SetInboundHandler(cb func(*lxmf.LXMessage)) {
+	n.stateMu.Lock()
 	n.onInbound = cb
+	n.stateMu.Unlock()
+}
+
+func (n *Node) deliverInbound(m *lxmf.LXMessage) {
+	if m == nil || n.handleControlMessage(m) || n.handleTelemetry(m) {
+		return
+	}
+	n.recordPartner(hex.EncodeToString(m.SourceHash))
+	n.trackInboundMessage(m)
+	if n.deliverGroupMessage(m) {
+		return
+	}
+	n.recordInboundMessage(m)
+	n.stateMu.RLock()
+	cb := n.onInbound
+	n.stateMu.RUnlock()
+	if cb != nil {
+		cb(m)
+	}
+}
+
+func (n *Node) currentRouter() *lxmf.LXMRouter {
+	if n == nil {
+		return nil
+	}
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+	return n.router
+}
+
+func (n *Node) deliveryDest() *rns.Destination {
+	if n == nil {
+		return nil
+	}
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+	return n.deliveryDestIn
+}
+
+// routerAndDelivery returns a consistent router/destination pair (both nil while restarting).
+func (n *Node) routerAndDelivery() (*lxmf.LXMRouter, *rns.Destination) {
+	if n == nil {
+		return nil, nil
+	}
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+	return n.router, n.deliveryDestIn
+}
+
+func (n *Node) currentDisplayName() string {
+	n.stateMu.RLock()
+	defer n.stateMu.RUnlock()
+	return n.displayName
 }
 
 func (n *Node) DestinationHashHex() string {
-	if n.deliveryDestIn == nil {
+	dest := n.deliveryDest()
+	if dest == nil {
 		return ""
 	}
-	return hex.EncodeToString(n.deliveryDestIn.Hash())
+	return hex.EncodeToString(dest.Hash())
 }
 
 type SendOptions struct {
@@ -387,59 +536,105 @@ type SendOptions struct {
 	Fields        map[any]any
 	Title         string
 	Content       string
+	// Audio, if set, is sent in FIELD_AUDIO (replacing one in Fields).
+	Audio *Audio
 }
 
 func (n *Node) SendHex(destinationHashHex string, msg SendOptions) (*lxmf.LXMessage, error) {
-	if n == nil || n.router == nil || n.deliveryDestIn == nil {
+	lxm, err := n.send(destinationHashHex, msg)
+	if err == nil {
+		destHex := strings.ToLower(destinationHashHex)
+		n.recordPartner(destHex)
+		n.trackOutboundMessage(destHex, lxm)
+		n.recordOutboundMessage(destHex, lxm)
+	}
+	return lxm, err
+}
+
+// send is SendHex without recording a conversation partner (control messages).
+func (n *Node) send(destinationHashHex string, msg SendOptions) (*lxmf.LXMessage, error) {
+	router, delivery := n.routerAndDelivery()
+	if router == nil || delivery == nil {
 		return nil, errors.New("node not started")
 	}
 	if msg.Method == 0 {
 		msg.Method = lxmf.MethodOpportunistic
 	}
-	destHash, err := hex.DecodeString(destinationHashHex)
+	outDest, destHash, err := n.outboundDestination(delivery, destinationHashHex)
 	if err != nil {
-		return nil, fmt.Errorf("decode destination hash: %w", err)
-	}
-	if len(destHash) != lxmf.DestinationLength {
-		return nil, fmt.Errorf("invalid destination hash length: got %d want %d", len(destHash), lxmf.DestinationLength)
-	}
-
-	var remoteIdentity *rns.Identity
-	if bytes.Equal(destHash, n.deliveryDestIn.Hash()) {
-		remoteIdentity = n.identity
-	} else {
-		remoteIdentity = rns.IdentityRecall(destHash)
-	}
-	if remoteIdentity == nil {
-		return nil, errors.New("unknown destination identity (need an announce from the peer before you can send)")
+		return nil, err
 	}
-	outDest, err := rns.NewDestination(remoteIdentity, rns.DestinationOUT, rns.DestinationSINGLE, lxmf.AppName, "delivery")
-	if err != nil {
-		return nil, fmt.Errorf("create outbound destination: %w", err)
+	fields := msg.Fields
+	if msg.Audio != nil {
+		if err := msg.Audio.validate(); err != nil {
+			return nil, err
+		}
+		fields = maps.Clone(msg.Fields)
+		if fields == nil {
+			fields = map[any]any{}
+		}
+		fields[lxmf.FieldAudio] = msg.Audio.field()
 	}
 
-	lxm, err := lxmf.NewLXMessage(outDest, n.deliveryDestIn, msg.Content, msg.Title, msg.Fields, msg.Method, nil, nil, msg.StampCost, msg.IncludeTicket)
+	lxm, err := lxmf.NewLXMessage(outDest, delivery, msg.Content, msg.Title, fields, msg.Method, nil, nil, msg.StampCost, msg.IncludeTicket)
 	if err != nil {
 		return nil, err
 	}
 
 	// Special-case: allow "send to self" even when there are no Reticulum interfaces.
-	// We loop the message back into the router as an inbound delivery.
-	if bytes.Equal(destHash, n.deliveryDestIn.Hash()) {
+	// The message is unpacked like an inbound delivery and handed to the router's delivery
+	// callback directly: the router's own delivery path is not safe for concurrent use
+	// and keeps no state that matters for our own messages.
+	if bytes.Equal(destHash, delivery.Hash()) {
 		if err := lxm.Pack(false); err != nil {
 			return nil, err
 		}
-		ok := n.router.LXMDelivery(lxm.Packed, rns.DestinationSINGLE, nil, nil, msg.Method, true, false)
-		if !ok {
+		in, err := lxmf.UnpackFromBytes(lxm.Packed, msg.Method)
+		if err != nil || in == nil {
 			return nil, errors.New("local loopback delivery failed")
 		}
+		in.Method = msg.Method
+		in.TransportEncrypted = true
+		in.TransportEncryption = lxmf.EncryptionDescriptionEC
+		if router.DeliveryCallback != nil {
+			router.DeliveryCallback(in)
+		}
 		return lxm, nil
 	}
 
-	n.router.HandleOutbound(lxm)
+	n.routerMu.Lock()
+	router.HandleOutbound(lxm)
+	n.routerMu.Unlock()
 	return lxm, nil
 }
 
+// outboundDestination returns the outbound lxmf.delivery destination for a peer (or for
+// ourselves) and its hash.
+func (n *Node) outboundDestination(delivery *rns.Destination, destinationHashHex string) (*rns.Destination, []byte, error) {
+	destHash, err := hex.DecodeString(destinationHashHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode destination hash: %w", err)
+	}
+	if len(destHash) != lxmf.DestinationLength {
+		return nil, nil, fmt.Errorf("invalid destination hash length: got %d want %d", len(destHash), lxmf.DestinationLength)
+	}
+
+	var remoteIdentity *rns.Identity
+	if bytes.Equal(destHash, delivery.Hash()) {
+		remoteIdentity = n.identity
+	} else {
+		remoteIdentity = rns.IdentityRecall(destHash)
+	}
+	if remoteIdentity == nil {
+		return nil, nil, errors.New("unknown destination identity (need an announce from the peer before you can send)")
+	}
+	outDest, err := rns.NewDestination(remoteIdentity, rns.DestinationOUT, rns.DestinationSINGLE, lxmf.AppName, "delivery")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create outbound destination: %w", err)
+	}
+	return outDest, destHash, nil
+}
+
 func (n *Node) startInterfaceWatchdog() {
 	if n == nil {
 		return
@@ -447,13 +642,17 @@ func (n *Node) startInterfaceWatchdog() {
 	// Watchdog: iOS can leave sockets half-dead after suspend/resume.
 	// If all enabled interfaces remain offline for a short window, we hard-reset
 	// enabled interfaces (halt+resume) to recreate sockets.
+	changes, cancel := n.InterfaceConfigChanges()
 	go func() {
+		defer cancel()
 		t := time.NewTicker(2 * time.Second)
 		defer t.Stop()
 		for {
 			select {
 			case <-t.C:
 				n.maybeResetInterfacesOnStall("watchdog")
+			case <-changes:
+				n.pruneInterfaceOfflineState()
 			case <-n.announceStop:
 				return
 			}
@@ -506,8 +705,21 @@ func (n *Node) maybeResetInterfacesOnStall(reason string) {
 		}
 	}
 	lastReset := n.lastIfaceReset
+	recovered := false
+	if anyOnline {
+		recovered = n.ifaceStalled
+		n.ifaceStalled = false
+	} else if longestOffline >= 6*time.Second {
+		n.ifaceStalled = true
+	}
 	n.ifaceStateMu.Unlock()
 
+	// Connectivity came back after a stall: peers may have missed our announces.
+	if recovered {
+		rns.Logf(rns.LOG_DEBUG, "%s: interfaces recovered, requesting announce", reason)
+		n.requestAnnounce("interface_recovered")
+	}
+
 	// Trigger reset only if *everything enabled* is offline for a bit.
 	if anyOnline {
 		return
@@ -526,15 +738,30 @@ func (n *Node) maybeResetInterfacesOnStall(reason string) {
 	n.resetEnabledInterfaces(reason)
 }
 
+// pruneInterfaceOfflineState forgets offline timers for interfaces that are no longer enabled.
+func (n *Node) pruneInterfaceOfflineState() {
+	enabled := map[string]bool{}
+	for _, cfg := range n.enabledInterfaceConfigs() {
+		enabled[strings.TrimSpace(cfg.Name)] = true
+	}
+	n.ifaceStateMu.Lock()
+	for name := range n.ifaceOfflineAt {
+		if !enabled[name] {
+			delete(n.ifaceOfflineAt, name)
+		}
+	}
+	n.ifaceStateMu.Unlock()
+}
+
 func (n *Node) AnnounceDelivery() {
-	if n == nil || n.router == nil || n.deliveryDestIn == nil {
+	if n.deliveryDest() == nil {
 		return
 	}
 	n.AnnounceDeliveryWithReason("manual")
 }
 
 func (n *Node) AnnounceDeliveryWithReason(reason string) {
-	if n == nil || n.router == nil || n.deliveryDestIn == nil {
+	if n.deliveryDest() == nil {
 		return
 	}
 	reason = strings.TrimSpace(reason)
@@ -548,7 +775,7 @@ func (n *Node) AnnounceDeliveryWithReason(reason string) {
 	}
 
 	stopCh := n.announceStop
-	destHex := hex.EncodeToString(n.deliveryDestIn.Hash())
+	destHex := n.DestinationHashHex()
 
 	// Announce can happen early (before interfaces are online) and produces noisy
 	// "No interfaces could process the outbound packet" logs. We wait briefly for
@@ -619,9 +846,11 @@ func (n *Node) AnnounceDeliveryWithReason(reason string) {
 		// matching lxmf.Router.GetAnnounceAppData() format.
 		appData := n.announceAppData()
 
-		pkt := n.deliveryDestIn.Announce(appData, false, nil, nil, false)
-		if pkt != nil {
-			_ = pkt.Send()
+		// Re-read the destination: a Restart may have replaced it while we waited.
+		if dest := n.deliveryDest(); dest != nil {
+			if pkt := dest.Announce(appData, false, nil, nil, false); pkt != nil {
+				_ = pkt.Send()
+			}
 		}
 
 		atomic.StoreInt32(&n.announceInFlight, 0)
@@ -779,38 +1008,12 @@ func (n *Node) announceReady(preferDeadline time.Time) (bool, []string, []string
 	return true, enabled, online, offline
 }
 
+// enabledInterfaceConfigs reads the cached config model; it never touches the disk.
 func (n *Node) enabledInterfaceConfigs() []configuredInterfaceEntry {
-	if n == nil || n.reticulum == nil || n.reticulum.ConfigPath == "" {
-		return nil
-	}
-	cfg, err := configobj.Load(n.reticulum.ConfigPath)
-	if err != nil {
-		return nil
-	}
-	if !cfg.HasSection("interfaces") {
+	if n == nil || n.rnsConfig == nil {
 		return nil
 	}
-	sec := cfg.Section("interfaces")
-	names := sec.Sections()
-	sort.Strings(names)
-
-	out := make([]configuredInterfaceEntry, 0, len(names))
-	for _, name := range names {
-		s := sec.Subsection(name)
-		typ, _ := s.Get("type")
-		enabled := false
-		if v, ok := s.Get("interface_enabled"); ok {
-			enabled = parseTruthyString(v)
-		} else if v, ok := s.Get("enabled"); ok {
-			enabled = parseTruthyString(v)
-		} else if v, ok := s.Get("enable"); ok {
-			enabled = parseTruthyString(v)
-		}
-		if enabled {
-			out = append(out, configuredInterfaceEntry{Name: name, Type: typ, Enabled: true})
-		}
-	}
-	return out
+	return n.rnsConfig.enabled()
 }
 
 func (n *Node) interfaceOnlineMaps() (map[string]bool, map[string]bool) {
@@ -880,43 +1083,55 @@ func (n *Node) hasAnyOnlineInterface() bool {
 	return false
 }
 
-func (n *Node) startPeriodicAnnounce(interval time.Duration) {
-	if n == nil {
-		return
-	}
-	if interval <= 0 {
-		return
-	}
-	if n.announceStop != nil {
-		return
-	}
-	n.announceStop = make(chan struct{})
-	t := time.NewTicker(interval)
-	go func() {
-		defer t.Stop()
-		for {
-			select {
-			case <-t.C:
-				n.AnnounceDeliveryWithReason("periodic")
-			case <-n.announceStop:
-				return
-			}
-		}
-	}()
-}
-
-// SetDisplayName updates LXMF announce app-data (display_name) for this node.
-// Call AnnounceDelivery() after setting to broadcast changes.
+// SetDisplayName updates LXMF announce app-data (display_name) for this node
+// and re-announces if the name changed.
 func (n *Node) SetDisplayName(name string) error {
-	if n == nil || n.deliveryDestIn == nil {
+	// Not the delivery destination: Restart detaches it while the router is replaced.
+	if n == nil || n.identity == nil {
 		return errors.New("node not started")
 	}
+	n.profileMu.Lock()
+	defer n.profileMu.Unlock()
+	n.stateMu.Lock()
+	changed := n.displayName != name
 	n.displayName = name
+	n.stateMu.Unlock()
 	// Keep on-disk config in sync with the profile name for UI/diagnostics.
 	_ = UpdateLXMFDisplayName(n.opts.Dir, name)
+	if changed {
+		n.requestAnnounce("profile_changed")
+		n.scheduleProfilePush()
+	}
 	return nil
 }
 
+// avatarState is an immutable snapshot of the local avatar. It is replaced as a whole
+// on change, so data must never be modified after publication.
+type avatarState struct {
+	data  []byte
+	hash  []byte
+	mtime int64
+	mime  string
+
+	// Optional AvatarThumbnailSize thumbnail, served for /avatar requests with a small "px".
+	thumb     []byte
+	thumbHash []byte
+	thumbMime string
+}
+
+var emptyAvatar = &avatarState{}
+
+// avatarSnapshot returns the current avatar; never nil.
+func (n *Node) avatarSnapshot() *avatarState {
+	if n == nil {
+		return emptyAvatar
+	}
+	if av := n.avatar.Load(); av != nil {
+		return av
+	}
+	return emptyAvatar
+}
+
 func (n *Node) SetAvatarPNG(png []byte) error {
 	return n.SetAvatarImage("", png)
 }
@@ -925,7 +1140,15 @@ func (n *Node) SetAvatarHEIC(heic []byte) error {
 	return n.SetAvatarImage("image/heic", heic)
 }
 
+// SetAvatarImage sets the avatar. For PNG and JPEG a thumbnail is generated; use
+// SetAvatarImageWithThumbnail to supply one for other formats.
 func (n *Node) SetAvatarImage(mime string, data []byte) error {
+	return n.SetAvatarImageWithThumbnail(mime, data, "", nil)
+}
+
+// SetAvatarImageWithThumbnail sets the avatar and an app-generated thumbnail (at most
+// AvatarThumbnailSize px, 64 KiB). An empty thumb generates one if the format allows.
+func (n *Node) SetAvatarImageWithThumbnail(mime string, data []byte, thumbMime string, thumb []byte) error {
 	if n == nil {
 		return errors.New("node not started")
 	}
@@ -939,60 +1162,146 @@ func (n *Node) SetAvatarImage(mime string, data []byte) error {
 	if mime == "" {
 		return errors.New("unknown avatar mime")
 	}
+	if len(thumb) > maxAvatarThumbBytes {
+		return errors.New("avatar thumbnail too large")
+	}
 	sum := sha256.Sum256(data)
-	n.avatarPNG = append([]byte(nil), data...)
-	n.avatarHash = append([]byte(nil), sum[:16]...)
-	n.avatarMTime = time.Now().Unix()
-	n.avatarMime = mime
-	return n.saveAvatarToDisk()
-}
+	av := &avatarState{
+		data:  append([]byte(nil), data...),
+		hash:  append([]byte(nil), sum[:16]...),
+		mtime: time.Now().Unix(),
+		mime:  mime,
+	}
+	if len(thumb) > 0 {
+		thumbMime = strings.TrimSpace(thumbMime)
+		if thumbMime == "" {
+			thumbMime = detectAvatarMime(thumb)
+		}
+		if thumbMime == "" {
+			return errors.New("unknown avatar thumbnail mime")
+		}
+		av.setThumb(append([]byte(nil), thumb...), thumbMime)
+	} else {
+		av.generateThumb()
+	}
 
+	n.profileMu.Lock()
+	defer n.profileMu.Unlock()
+	prev := n.avatarSnapshot()
+	changed := !bytes.Equal(prev.hash, av.hash) || prev.mime != av.mime || !bytes.Equal(prev.thumbHash, av.thumbHash)
+	if err := n.saveAvatarToDisk(av); err != nil {
+		return err
+	}
+	n.avatar.Store(av)
+	if changed {
+		n.requestAnnounce("avatar_changed")
+		n.scheduleProfilePush()
+	}
+	return nil
+}
 
 func (n *Node) ClearAvatar() error {
 	if n == nil {
 		return errors.New("node not started")
 	}
-	n.avatarPNG = nil
-	n.avatarHash = nil
-	n.avatarMTime = 0
-	n.avatarMime = ""
+	n.profileMu.Lock()
+	defer n.profileMu.Unlock()
+	changed := len(n.avatarSnapshot().hash) > 0
+	n.avatar.Store(emptyAvatar)
 	_ = os.Remove(n.avatarPath())
 	_ = os.Remove(n.avatarMimePath())
+	_ = os.Remove(n.avatarThumbPath())
+	_ = os.Remove(n.avatarThumbMimePath())
+	if changed {
+		n.requestAnnounce("avatar_cleared")
+		n.scheduleProfilePush()
+	}
 	return nil
 }
 
 func (n *Node) announceAppData() []byte {
-	// Mirrors lxmf.Router.GetAnnounceAppData(): msgpack([display_name_bytes, stamp_cost?])
+	// Mirrors lxmf.Router.GetAnnounceAppData(): msgpack([display_name_bytes, stamp_cost?]),
+	// extended with runcore slots: [.., avatar_meta?, profile_info].
 	var displayNameBytes []byte
-	if n.displayName != "" {
-		displayNameBytes = []byte(n.displayName)
+	if name := n.currentDisplayName(); name != "" {
+		displayNameBytes = []byte(name)
 	}
 	var stampCost any
 	if n.opts.DeliveryStampCost != nil && *n.opts.DeliveryStampCost > 0 && *n.opts.DeliveryStampCost < 255 {
 		stampCost = *n.opts.DeliveryStampCost
 	}
 
-	var avatar any
-	if len(n.avatarHash) > 0 {
-		mime := n.avatarMime
+	var avatar map[any]any
+	if av := n.avatarSnapshot(); len(av.hash) > 0 {
+		mime := av.mime
 		if mime == "" {
 			mime = "image/png"
 		}
 		avatar = map[any]any{
-			"h": n.avatarHash,     // bytes
-			"t": mime,             // mime
-			"s": len(n.avatarPNG), // size
-			"u": n.avatarMTime,    // updated (unix)
+			"h": av.hash,      // bytes
+			"t": mime,         // mime
+			"s": len(av.data), // size
+			"u": av.mtime,     // updated (unix)
+		}
+		if len(av.thumbHash) > 0 {
+			avatar["th"] = av.thumbHash // thumbnail hash
+			avatar["tt"] = av.thumbMime
+			avatar["ts"] = len(av.thumb)
 		}
 	}
 
-	data, err := umsgpack.Packb([]any{displayNameBytes, stampCost, avatar})
-	if err != nil {
-		return nil
+	// Slot 3 is the versioned runcore profile map (docs/PROFILE.md); LXMF clients ignore it.
+	profile := n.localProfileInfo().announceWire()
+	pack := func() []byte {
+		slot2 := any(nil)
+		if avatar != nil {
+			slot2 = avatar
+		}
+		data, err := umsgpack.Packb([]any{displayNameBytes, stampCost, slot2, profile})
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+
+	// Delivery announces carry a ratchet, so the app-data has to fit in what is left of one
+	// packet after it. Drop the least useful parts first: peers still see an avatar without
+	// its thumbnail, and fetch it on demand without the avatar map.
+	const budget = maxAnnounceAppDataLen - announceRatchetLen
+	data := pack()
+	if len(data) > budget && avatar != nil {
+		delete(avatar, "th")
+		delete(avatar, "tt")
+		delete(avatar, "ts")
+		if data = pack(); len(data) > budget {
+			avatar = nil
+			data = pack()
+		}
+	}
+	for len(data) > budget && len(displayNameBytes) > 0 {
+		displayNameBytes = truncateUTF8(displayNameBytes, len(displayNameBytes)-(len(data)-budget))
+		data = pack()
+	}
+	if len(data) > budget {
+		rns.Logf(rns.LOG_NOTICE, "announce app-data is %d bytes, over the %d byte budget", len(data), budget)
 	}
 	return data
 }
 
+// truncateUTF8 cuts b to at most limit bytes without splitting a UTF-8 sequence.
+func truncateUTF8(b []byte, limit int) []byte {
+	if limit <= 0 {
+		return nil
+	}
+	if len(b) <= limit {
+		return b
+	}
+	for limit > 0 && !utf8.RuneStart(b[limit]) {
+		limit--
+	}
+	return b[:limit]
+}
+
 func (n *Node) avatarPath() string {
 	return filepath.Join(n.opts.Dir, "avatar.bin")
 }
@@ -1001,6 +1310,34 @@ func (n *Node) avatarMimePath() string {
 	return filepath.Join(n.opts.Dir, "avatar.mime")
 }
 
+func (n *Node) avatarThumbPath() string {
+	return filepath.Join(n.opts.Dir, "avatar_thumb.bin")
+}
+
+func (n *Node) avatarThumbMimePath() string {
+	return filepath.Join(n.opts.Dir, "avatar_thumb.mime")
+}
+
+func (av *avatarState) setThumb(thumb []byte, mime string) {
+	sum := sha256.Sum256(thumb)
+	av.thumb = thumb
+	av.thumbHash = append([]byte(nil), sum[:16]...)
+	av.thumbMime = mime
+}
+
+// generateThumb fills in a thumbnail when the avatar can be decoded and is larger than one.
+func (av *avatarState) generateThumb() {
+	thumb, mime, err := makeAvatarThumbnail(av.data)
+	if err != nil {
+		rns.Logf(rns.LOG_DEBUG, "avatar thumbnail: %v", err)
+		return
+	}
+	if len(thumb) >= len(av.data) {
+		return
+	}
+	av.setThumb(thumb, mime)
+}
+
 func (n *Node) loadAvatarFromDisk() error {
 	path := n.avatarPath()
 	b, err := os.ReadFile(path)
@@ -1014,28 +1351,49 @@ func (n *Node) loadAvatarFromDisk() error {
 		}
 	}
 	sum := sha256.Sum256(b)
-	n.avatarPNG = b
-	n.avatarHash = append([]byte(nil), sum[:16]...)
+	av := &avatarState{
+		data: b,
+		hash: append([]byte(nil), sum[:16]...),
+	}
 	if st, err := os.Stat(path); err == nil {
-		n.avatarMTime = st.ModTime().Unix()
+		av.mtime = st.ModTime().Unix()
+	}
+	av.mime = strings.TrimSpace(string(readFileOrNil(n.avatarMimePath())))
+	if av.mime == "" {
+		av.mime = detectAvatarMime(b)
 	}
-	n.avatarMime = strings.TrimSpace(string(readFileOrNil(n.avatarMimePath())))
-	if n.avatarMime == "" {
-		n.avatarMime = detectAvatarMime(b)
+	if thumb, err := os.ReadFile(n.avatarThumbPath()); err == nil && len(thumb) > 0 && len(thumb) <= maxAvatarThumbBytes {
+		mime := strings.TrimSpace(string(readFileOrNil(n.avatarThumbMimePath())))
+		if mime == "" {
+			mime = detectAvatarMime(thumb)
+		}
+		av.setThumb(thumb, mime)
+	} else {
+		av.generateThumb()
 	}
+	n.avatar.Store(av)
 	return nil
 }
 
-func (n *Node) saveAvatarToDisk() error {
-	if len(n.avatarPNG) == 0 {
+func (n *Node) saveAvatarToDisk(av *avatarState) error {
+	if av == nil || len(av.data) == 0 {
 		return nil
 	}
-	if err := os.WriteFile(n.avatarPath(), n.avatarPNG, 0o644); err != nil {
+	if err := os.WriteFile(n.avatarPath(), av.data, 0o644); err != nil {
 		return err
 	}
-	if n.avatarMime != "" {
-		_ = os.WriteFile(n.avatarMimePath(), []byte(n.avatarMime), 0o644)
+	if av.mime != "" {
+		_ = os.WriteFile(n.avatarMimePath(), []byte(av.mime), 0o644)
 	}
+	if len(av.thumb) == 0 {
+		_ = os.Remove(n.avatarThumbPath())
+		_ = os.Remove(n.avatarThumbMimePath())
+		return nil
+	}
+	if err := os.WriteFile(n.avatarThumbPath(), av.thumb, 0o644); err != nil {
+		return err
+	}
+	_ = os.WriteFile(n.avatarThumbMimePath(), []byte(av.thumbMime), 0o644)
 	return nil
 }
 
@@ -1067,7 +1425,24 @@ func readFileOrNil(path string) []byte {
 	return b
 }
 
+// WaitForIdentityHex waits for the identity of a destination (timeout <= 0 waits forever).
 func (n *Node) WaitForIdentityHex(destinationHashHex string, timeout time.Duration) (*rns.Identity, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	id, err := n.WaitForIdentityHexContext(ctx, destinationHashHex)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errors.New("timeout waiting for destination identity")
+	}
+	return id, err
+}
+
+// WaitForIdentityHexContext waits for the identity of a destination until ctx is done,
+// requesting a path if it is not known yet.
+func (n *Node) WaitForIdentityHexContext(ctx context.Context, destinationHashHex string) (*rns.Identity, error) {
 	destHash, err := hex.DecodeString(destinationHashHex)
 	if err != nil {
 		return nil, fmt.Errorf("decode destination hash: %w", err)
@@ -1075,9 +1450,12 @@ func (n *Node) WaitForIdentityHex(destinationHashHex string, timeout time.Durati
 	if len(destHash) != lxmf.DestinationLength {
 		return nil, fmt.Errorf("invalid destination hash length: got %d want %d", len(destHash), lxmf.DestinationLength)
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	// Fast-path: allow "send to self" without requiring any announce/recall.
-	if n != nil && n.deliveryDestIn != nil && bytes.Equal(destHash, n.deliveryDestIn.Hash()) {
+	if dest := n.deliveryDest(); dest != nil && bytes.Equal(destHash, dest.Hash()) {
 		if n.identity != nil {
 			return n.identity, nil
 		}
@@ -1085,20 +1463,11 @@ func (n *Node) WaitForIdentityHex(destinationHashHex string, timeout time.Durati
 
 	// If we don't have the identity yet, try querying the network for a path/identity.
 	// This makes "add contact by hash → send" work without requiring a prior announce.
-	if rns.IdentityRecall(destHash) == nil {
-		rns.TransportRequestPath(destHash)
-	}
-
-	deadline := time.Now().Add(timeout)
-	for {
-		if id := rns.IdentityRecall(destHash); id != nil {
-			return id, nil
-		}
-		if timeout > 0 && time.Now().After(deadline) {
-			return nil, errors.New("timeout waiting for destination identity")
-		}
-		time.Sleep(100 * time.Millisecond)
+	if id := rns.IdentityRecall(destHash); id != nil {
+		return id, nil
 	}
+	n.RequestPath(destHash)
+	return n.awaitIdentity(ctx, destHash, false)
 }
 
 func prepareRNSConfigDir(opts Options) (string, error) {
@@ -0,0 +1,184 @@
+package runcore
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+)
+
+// Reticulum is a process-wide singleton, so the package tests share one Node. It has no
+// interfaces: it sends to itself (looped back locally), and inbound delivery is also
+// driven through deliverInbound the way the router callback calls it.
+//
+// TestNodeConcurrentUse is skipped under -race: go-lxmf v0.9.3 and go-reticulum v1.0.4
+// race internally on paths it exercises (lxmf.LXMRouter.ExitHandler against the router's
+// job loop; rns startLocalInterface against saveDestinationTable, reached via Restart),
+// and runcore cannot synchronize with either. Drop the skip once both are fixed upstream.
+
+const raceTestRNSConfig = `[reticulum]
+enable_transport = False
+share_instance = False
+instance_name = runcorerace
+
+[logging]
+loglevel = 1
+
+[interfaces]
+`
+
+var raceTestNode struct {
+	once sync.Once
+	n    *Node
+	err  error
+}
+
+// startRaceTestNode starts the node on first use and returns the same node afterwards
+// (eg with -count), as Reticulum cannot be started twice in one process.
+func startRaceTestNode(t *testing.T) *Node {
+	t.Helper()
+	raceTestNode.once.Do(func() {
+		dir, err := os.MkdirTemp("", "runcore-race-")
+		if err != nil {
+			raceTestNode.err = err
+			return
+		}
+		rnsDir := filepath.Join(dir, "rns")
+		if err := os.MkdirAll(rnsDir, 0o755); err != nil {
+			raceTestNode.err = err
+			return
+		}
+		if err := os.WriteFile(filepath.Join(rnsDir, "config"), []byte(raceTestRNSConfig), 0o644); err != nil {
+			raceTestNode.err = err
+			return
+		}
+		raceTestNode.n, raceTestNode.err = Start(Options{
+			Dir:              filepath.Join(dir, "node"),
+			RNSConfigDir:     rnsDir,
+			DisplayName:      "race",
+			LogLevel:         1,
+			AnnounceInterval: -1,
+		})
+	})
+	if raceTestNode.err != nil {
+		t.Fatal(raceTestNode.err)
+	}
+	return raceTestNode.n
+}
+
+func raceTestPNG(t *testing.T, shade uint8) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: shade, G: uint8(x * 16), B: uint8(y * 16), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// selfMessage builds a message from the node to itself, as the router would deliver it.
+func selfMessage(n *Node, content string) *lxmf.LXMessage {
+	dest := n.deliveryDest()
+	if dest == nil {
+		return nil // restarting
+	}
+	m, err := lxmf.NewLXMessage(dest, dest, content, "", nil, lxmf.MethodOpportunistic, nil, nil, nil, false)
+	if err != nil || m.Pack(false) != nil {
+		return nil
+	}
+	return m
+}
+
+func TestNodeConcurrentUse(t *testing.T) {
+	if raceEnabled {
+		t.Skip("go-lxmf v0.9.3 and go-reticulum v1.0.4 race internally; see the comment above")
+	}
+	n := startRaceTestNode(t)
+	self := n.DestinationHashHex()
+	avatars := [][]byte{raceTestPNG(t, 0x20), raceTestPNG(t, 0xe0)}
+
+	const rounds = 20
+	var wg sync.WaitGroup
+	run := func(name string, f func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if err := f(i); err != nil {
+					t.Errorf("%s %d: %v", name, i, err)
+					return
+				}
+			}
+		}()
+	}
+
+	var handled atomic.Int64
+	for s := 0; s < 2; s++ {
+		run(fmt.Sprint("send", s), func(i int) error {
+			// Sends fail while a restart has detached the router; that is the contract.
+			_, _ = n.SendHex(self, SendOptions{Content: fmt.Sprintf("message %d/%d", s, i)})
+			return nil
+		})
+	}
+	run("display name", func(i int) error {
+		return n.SetDisplayName(fmt.Sprintf("race %d", i))
+	})
+	run("avatar", func(i int) error {
+		if i%5 == 4 {
+			return n.ClearAvatar()
+		}
+		return n.SetAvatarImage("image/png", avatars[i%2])
+	})
+	run("inbound handler", func(i int) error {
+		if i%2 == 0 {
+			n.SetInboundHandler(nil)
+		} else {
+			n.SetInboundHandler(func(*lxmf.LXMessage) { handled.Add(1) })
+		}
+		return nil
+	})
+	run("deliver", func(i int) error {
+		if m := selfMessage(n, fmt.Sprintf("inbound %d", i)); m != nil {
+			n.deliverInbound(m)
+		}
+		return nil
+	})
+	run("restart", func(i int) error {
+		if i%4 != 0 {
+			return nil
+		}
+		return n.Restart()
+	})
+	run("read", func(i int) error {
+		_ = n.DestinationHashHex()
+		_ = n.announceAppData()
+		_, err := n.ContactInfoHex(self, 0)
+		return err
+	})
+	wg.Wait()
+
+	if got := n.DestinationHashHex(); got != self {
+		t.Fatalf("destination changed across restarts: %s, want %s", got, self)
+	}
+	n.SetInboundHandler(func(*lxmf.LXMessage) { handled.Add(1) })
+	before := handled.Load()
+	if _, err := n.SendHex(self, SendOptions{Content: "after"}); err != nil {
+		t.Fatalf("send after concurrent use: %v", err)
+	}
+	if handled.Load() != before+1 {
+		t.Fatal("message to self not delivered to the inbound handler")
+	}
+}
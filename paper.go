@@ -0,0 +1,107 @@
+package runcore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Paper messages: LXMF messages encrypted for the recipient and encoded as lxm:// URIs
+// (and QR codes) for offline transfer - printed, shown on a screen or passed over another
+// channel. They are stored as sent messages with state lxmf.MethodPaper (0x05). The
+// recipient ingests the URI, which delivers the message like one received over the network.
+
+// paperQRSize is the side of the generated QR code in pixels; a full-size paper message
+// needs a version 40 code (177 modules), which stays scannable at this size.
+const paperQRSize = 768
+
+// EncodePaperMessage encrypts a message for destinationHashHex and returns it as an lxm://
+// URI and as a PNG QR code of that URI. The destination's identity must be known. Paper
+// messages carry at most lxmf.PaperMDU bytes, so large fields (eg images) do not fit.
+func (n *Node) EncodePaperMessage(destinationHashHex string, msg SendOptions) (string, []byte, error) {
+	router, delivery := n.routerAndDelivery()
+	if router == nil || delivery == nil {
+		return "", nil, errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	outDest, _, err := n.outboundDestination(delivery, destHex)
+	if err != nil {
+		return "", nil, err
+	}
+	lxm, err := lxmf.NewLXMessage(outDest, delivery, msg.Content, msg.Title, msg.Fields, lxmf.MethodPaper, nil, nil, nil, false)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := lxm.Pack(false); err != nil {
+		return "", nil, fmt.Errorf("pack paper message: %w", err)
+	}
+	uri, err := lxm.AsURI(true)
+	if err != nil {
+		return "", nil, err
+	}
+	// lxmf generates paper QR codes with the lowest error correction to fit the most data.
+	png, err := qrcode.Encode(uri, qrcode.Low, paperQRSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode QR code: %w", err)
+	}
+	n.recordPartner(destHex)
+	n.recordOutboundMessage(destHex, lxm)
+	return uri, png, nil
+}
+
+// IngestPaperMessage delivers a paper message (an lxm:// URI, eg scanned from a QR code)
+// addressed to this node. It is handled like any inbound message (inbound handler,
+// message store); a message that was already received is reported as an error.
+func (n *Node) IngestPaperMessage(uri string) error {
+	router, delivery := n.routerAndDelivery()
+	if router == nil || delivery == nil {
+		return errors.New("node not started")
+	}
+	data, err := decodePaperURI(uri)
+	if err != nil {
+		return err
+	}
+	if len(data) < lxmf.LXMFOverhead {
+		return errors.New("paper message too short")
+	}
+	if !bytes.Equal(data[:lxmf.DestinationLength], delivery.Hash()) {
+		return errors.New("paper message is not addressed to this node")
+	}
+	ok, duplicate := router.LXMPropagation(data, nil, 0, nil, false, true)
+	switch {
+	case duplicate:
+		return errors.New("paper message already received")
+	case !ok:
+		return errors.New("paper message could not be delivered")
+	}
+	rns.Logf(rns.LOG_DEBUG, "paper message ingested (%d bytes)", len(data))
+	return nil
+}
+
+// decodePaperURI reverses LXMessage.AsURI: URL-safe base64 without padding after lxm://.
+// Line breaks and slashes that scanners or mail clients add are ignored.
+func decodePaperURI(uri string) ([]byte, error) {
+	s := strings.TrimSpace(uri)
+	prefix := lxmf.URISchema + "://"
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return nil, fmt.Errorf("not an %s URI", prefix)
+	}
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\n', '\r', ' ', '\t', '=':
+			return -1
+		}
+		return r
+	}, s[len(prefix):])
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode paper message: %w", err)
+	}
+	return data, nil
+}
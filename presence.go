@@ -0,0 +1,454 @@
+package runcore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Typing indicators and presence. Signals travel only as requests over an rns.Link to the
+// peer's runcore.profile destination; nothing is sent as an opportunistic packet, so idle
+// contacts cost no bandwidth. A session is opened by OpenPresence or SetTyping, sends a
+// heartbeat every presenceHeartbeat and is torn down after presenceIdleTimeout without
+// local activity (or by ClosePresence).
+
+const (
+	// CapPresence: serves /presence on the runcore.profile destination.
+	CapPresence = "presence"
+
+	profilePresenceReq = "/presence"
+
+	presenceHeartbeat   = 30 * time.Second
+	presenceOnlineTTL   = 3 * presenceHeartbeat
+	presenceTypingTTL   = 10 * time.Second
+	presenceTypingEvery = 5 * time.Second // refresh while typing continues
+	presenceIdleTimeout = 2 * time.Minute
+	presenceLinkTimeout = 15 * time.Second
+	// presenceRetryAfter keeps SetTyping from re-opening links to unreachable peers.
+	presenceRetryAfter = time.Minute
+
+	// Rate limits: outbound signals per session and inbound signals per peer
+	// (token buckets: rate per second, burst).
+	presenceSendRate    = 1.0
+	presenceSendBurst   = 3
+	presenceRecvRate    = 2.0
+	presenceRecvBurst   = 6
+	presenceSignalQueue = 4
+)
+
+func init() { registerCapability(CapPresence) }
+
+// Presence signal kinds ({"k": kind, "a": last_active}).
+const (
+	presenceTyping     = "typing"
+	presenceStop       = "stop"
+	presenceHeartbeatK = "hb"
+	presenceBye        = "bye"
+)
+
+// PeerPresence is the last known presence of a contact.
+type PeerPresence struct {
+	DestinationHashHex string `json:"destination_hash_hex"`
+	Online             bool   `json:"online"`
+	Typing             bool   `json:"typing"`
+	LastActive         int64  `json:"last_active,omitempty"`
+}
+
+// presence is the node's presence state.
+type presence struct {
+	mu         sync.Mutex
+	sessions   map[string]*presenceSession
+	peers      map[string]*peerPresence
+	failed     map[string]time.Time // link failures by destination hex
+	lastActive time.Time
+	onChange   func(PeerPresence)
+}
+
+type peerPresence struct {
+	state       PeerPresence
+	last        time.Time // last accepted signal
+	bucket      tokenBucket
+	typingTimer *time.Timer
+	onlineTimer *time.Timer
+}
+
+type presenceSession struct {
+	destHex  string
+	signals  chan string
+	cancel   context.CancelFunc
+	bucket   tokenBucket
+	typing   bool
+	lastSent time.Time // last typing signal
+	active   time.Time // last local activity for this session
+}
+
+// tokenBucket is a small rate limiter; callers serialize access.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(rate float64, burst int) bool {
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetPresenceHandler sets the callback for contact presence changes (typing, online).
+// It runs on a library goroutine and must not block for long.
+func (n *Node) SetPresenceHandler(cb func(PeerPresence)) {
+	n.presence.mu.Lock()
+	n.presence.onChange = cb
+	n.presence.mu.Unlock()
+}
+
+// Presence returns the last known presence of a contact.
+func (n *Node) Presence(destinationHashHex string) PeerPresence {
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	n.presence.mu.Lock()
+	defer n.presence.mu.Unlock()
+	if p := n.presence.peers[destHex]; p != nil {
+		return p.state
+	}
+	return PeerPresence{DestinationHashHex: destHex}
+}
+
+// OpenPresence starts (or refreshes) a presence session with a contact, eg while its chat
+// is open. Peers without CapPresence are skipped silently.
+func (n *Node) OpenPresence(destinationHashHex string) error {
+	_, err := n.presenceSession(destinationHashHex)
+	return err
+}
+
+// ClosePresence ends the presence session with a contact and tells it we left.
+func (n *Node) ClosePresence(destinationHashHex string) {
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	n.presence.mu.Lock()
+	s := n.presence.sessions[destHex]
+	n.presence.mu.Unlock()
+	if s != nil {
+		s.enqueue(presenceBye)
+	}
+}
+
+// SetTyping reports that the user started or stopped typing to a contact.
+func (n *Node) SetTyping(destinationHashHex string, typing bool) error {
+	s, err := n.presenceSession(destinationHashHex)
+	if err != nil || s == nil {
+		return err
+	}
+	n.presence.mu.Lock()
+	send := ""
+	switch {
+	case typing && (!s.typing || time.Since(s.lastSent) >= presenceTypingEvery):
+		send = presenceTyping
+	case !typing && s.typing:
+		send = presenceStop
+	}
+	if send != "" {
+		s.typing = typing
+		s.lastSent = time.Now()
+	}
+	n.presence.mu.Unlock()
+	if send != "" {
+		s.enqueue(send)
+	}
+	return nil
+}
+
+// presenceSession returns the session for a contact, starting one if needed. It returns
+// nil without error for peers that do not advertise CapPresence.
+func (n *Node) presenceSession(destinationHashHex string) (*presenceSession, error) {
+	if n == nil || n.identity == nil {
+		return nil, errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return nil, errors.New("invalid destination hash")
+	}
+	now := time.Now()
+	p := &n.presence
+	p.mu.Lock()
+	p.lastActive = now
+	if s := p.sessions[destHex]; s != nil {
+		s.active = now
+		p.mu.Unlock()
+		return s, nil
+	}
+	if t, ok := p.failed[destHex]; ok {
+		if time.Since(t) < presenceRetryAfter {
+			p.mu.Unlock()
+			return nil, nil
+		}
+		delete(p.failed, destHex)
+	}
+	p.mu.Unlock()
+
+	if info, _ := n.contactInfo(nil, destHex); !info.Supports(CapPresence) {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s := p.sessions[destHex]; s != nil {
+		return s, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &presenceSession{
+		destHex: destHex,
+		signals: make(chan string, presenceSignalQueue),
+		cancel:  cancel,
+		active:  now,
+	}
+	if p.sessions == nil {
+		p.sessions = make(map[string]*presenceSession)
+	}
+	p.sessions[destHex] = s
+	go n.runPresenceSession(ctx, s)
+	return s, nil
+}
+
+func (s *presenceSession) enqueue(kind string) {
+	select {
+	case s.signals <- kind:
+	default:
+		// Queue full: the link is slow; dropping ephemeral signals is fine.
+	}
+}
+
+// runPresenceSession owns the link of one session and serializes its requests.
+func (n *Node) runPresenceSession(ctx context.Context, s *presenceSession) {
+	defer func() {
+		s.cancel()
+		n.presence.mu.Lock()
+		if n.presence.sessions[s.destHex] == s {
+			delete(n.presence.sessions, s.destHex)
+		}
+		n.presence.mu.Unlock()
+	}()
+
+	link, closed, err := n.openPresenceLink(ctx, s.destHex)
+	if err != nil {
+		rns.Logf(rns.LOG_DEBUG, "presence: link to %s failed: %v", s.destHex, err)
+		n.presence.mu.Lock()
+		if n.presence.failed == nil {
+			n.presence.failed = make(map[string]time.Time)
+		}
+		for dest, t := range n.presence.failed {
+			if time.Since(t) >= presenceRetryAfter {
+				delete(n.presence.failed, dest)
+			}
+		}
+		n.presence.failed[s.destHex] = time.Now()
+		n.presence.mu.Unlock()
+		return
+	}
+	defer link.Teardown()
+	n.presence.mu.Lock()
+	delete(n.presence.failed, s.destHex)
+	n.presence.mu.Unlock()
+
+	send := func(kind string) bool {
+		n.presence.mu.Lock()
+		ok := kind == presenceBye || s.bucket.allow(presenceSendRate, presenceSendBurst)
+		lastActive := n.presence.lastActive.Unix()
+		n.presence.mu.Unlock()
+		if !ok {
+			return true
+		}
+		rr := link.Request(profilePresenceReq, map[any]any{"k": kind, "a": lastActive}, nil, nil, nil, presenceLinkTimeout.Seconds())
+		return rr != nil
+	}
+
+	send(presenceHeartbeatK)
+	hb := time.NewTicker(presenceHeartbeat)
+	defer hb.Stop()
+	for {
+		select {
+		case kind := <-s.signals:
+			if !send(kind) {
+				return
+			}
+			if kind == presenceBye {
+				return
+			}
+		case <-hb.C:
+			n.presence.mu.Lock()
+			idle := time.Since(s.active) >= presenceIdleTimeout
+			n.presence.mu.Unlock()
+			if idle {
+				send(presenceBye)
+				return
+			}
+			if !send(presenceHeartbeatK) {
+				return
+			}
+		case <-closed:
+			return
+		case <-ctx.Done():
+			return
+		case <-n.announceStop:
+			send(presenceBye)
+			return
+		}
+	}
+}
+
+func (n *Node) openPresenceLink(ctx context.Context, destHex string) (*rns.Link, <-chan struct{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, presenceLinkTimeout)
+	defer cancel()
+	id, err := n.WaitForIdentityHexContext(ctx, destHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	outDest, err := rns.NewDestination(id, rns.DestinationOUT, rns.DestinationSINGLE, profileAppName, profileAspect)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rns.TransportHasPath(outDest.Hash()) {
+		n.RequestPath(outDest.Hash())
+		n.awaitPath(ctx, outDest.Hash())
+	}
+	link, closed, err := openLink(ctx, outDest)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The peer maps our identity to our delivery destination.
+	link.Identify(n.identity)
+	return link, closed, nil
+}
+
+func (n *Node) registerPresenceRequestHandler(dest *rns.Destination) error {
+	if n == nil || dest == nil {
+		return nil
+	}
+	return dest.RegisterRequestHandler(
+		profilePresenceReq,
+		func(path string, data any, requestID []byte, linkID []byte, remoteIdentity *rns.Identity, requestedAt time.Time) any {
+			if remoteIdentity == nil {
+				return map[any]any{"ok": false, "error": "identify first"}
+			}
+			sig, err := decodePresenceSignal(data)
+			if err != nil {
+				return map[any]any{"ok": false, "error": "bad request"}
+			}
+			peerDest, err := rns.NewDestination(remoteIdentity, rns.DestinationOUT, rns.DestinationSINGLE, lxmf.AppName, "delivery")
+			if err != nil {
+				return map[any]any{"ok": false}
+			}
+			n.applyPresenceSignal(hex.EncodeToString(peerDest.Hash()), sig)
+			return map[any]any{"ok": true}
+		},
+		rns.DestinationALLOW_ALL,
+		nil,
+		true,
+	)
+}
+
+// applyPresenceSignal updates a peer's presence and schedules expiry of typing/online.
+func (n *Node) applyPresenceSignal(destHex string, sig presenceSignal) {
+	p := &n.presence
+	p.mu.Lock()
+	if p.peers == nil {
+		p.peers = make(map[string]*peerPresence)
+	}
+	pp := p.peers[destHex]
+	if pp == nil {
+		pp = &peerPresence{state: PeerPresence{DestinationHashHex: destHex}}
+		p.peers[destHex] = pp
+	}
+	if !pp.bucket.allow(presenceRecvRate, presenceRecvBurst) && sig.Kind != presenceBye {
+		p.mu.Unlock()
+		rns.Logf(rns.LOG_DEBUG, "presence: rate limited %s", destHex)
+		return
+	}
+	pp.last = time.Now()
+	prev := pp.state
+	st := &pp.state
+	st.Online = sig.Kind != presenceBye
+	st.Typing = sig.Kind == presenceTyping
+	if sig.LastActive > 0 && sig.LastActive <= time.Now().Unix() {
+		st.LastActive = sig.LastActive
+	}
+	resetTimer(&pp.typingTimer, presenceTypingTTL, st.Typing, func() {
+		n.expirePresence(destHex, func(s *PeerPresence) { s.Typing = false })
+	})
+	// Also armed after a bye: the peer is forgotten once its online TTL passes, but not
+	// sooner, so its rate limit cannot be reset by alternating signals and byes.
+	resetTimer(&pp.onlineTimer, presenceOnlineTTL, true, func() { n.forgetPresence(destHex) })
+	cur := *st
+	cb := p.onChange
+	p.mu.Unlock()
+	if cb != nil && cur != prev {
+		cb(cur)
+	}
+}
+
+func (n *Node) expirePresence(destHex string, update func(*PeerPresence)) {
+	p := &n.presence
+	p.mu.Lock()
+	pp := p.peers[destHex]
+	if pp == nil {
+		p.mu.Unlock()
+		return
+	}
+	prev := pp.state
+	update(&pp.state)
+	cur := pp.state
+	cb := p.onChange
+	p.mu.Unlock()
+	if cb != nil && cur != prev {
+		cb(cur)
+	}
+}
+
+// forgetPresence drops a peer no signal was accepted from for presenceOnlineTTL, reporting
+// it offline if it was not already.
+func (n *Node) forgetPresence(destHex string) {
+	p := &n.presence
+	p.mu.Lock()
+	pp := p.peers[destHex]
+	if pp == nil || time.Since(pp.last) < presenceOnlineTTL {
+		p.mu.Unlock()
+		return // re-armed by a newer signal
+	}
+	resetTimer(&pp.typingTimer, 0, false, nil)
+	delete(p.peers, destHex)
+	prev := pp.state
+	cur := prev
+	cur.Online, cur.Typing = false, false
+	cb := p.onChange
+	p.mu.Unlock()
+	if cb != nil && cur != prev {
+		cb(cur)
+	}
+}
+
+// resetTimer stops *t and, if arm, starts it again with d and fn.
+func resetTimer(t **time.Timer, d time.Duration, arm bool, fn func()) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+	if arm {
+		*t = time.AfterFunc(d, fn)
+	}
+}
@@ -2,11 +2,14 @@ package runcore
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/svanichkin/go-lxmf/lxmf"
@@ -24,26 +27,49 @@ func (n *Node) initProfileDestination() error {
 	if n == nil || n.identity == nil {
 		return errors.New("node not started")
 	}
-	if n.profileDestIn != nil {
-		return nil
-	}
-	dest, err := rns.NewDestination(n.identity, rns.DestinationIN, rns.DestinationSINGLE, profileAppName, profileAspect)
-	if err != nil {
-		return fmt.Errorf("create profile destination: %w", err)
-	}
-	if err := n.registerAvatarRequestHandler(dest); err != nil {
-		return fmt.Errorf("register avatar handler on profile dest: %w", err)
-	}
-	if err := n.registerAttachmentRequestHandler(dest); err != nil {
-		return fmt.Errorf("register attachment handler on profile dest: %w", err)
+	n.stateMu.RLock()
+	profile := n.profileDestIn
+	delivery := n.deliveryDestIn
+	n.stateMu.RUnlock()
+
+	// The profile destination lives for the whole process; the delivery destination is
+	// replaced on Restart, so its handlers are registered on every call.
+	if profile == nil {
+		dest, err := rns.NewDestination(n.identity, rns.DestinationIN, rns.DestinationSINGLE, profileAppName, profileAspect)
+		if err != nil {
+			return fmt.Errorf("create profile destination: %w", err)
+		}
+		if err := n.registerAvatarRequestHandler(dest); err != nil {
+			return fmt.Errorf("register avatar handler on profile dest: %w", err)
+		}
+		if err := n.registerAttachmentRequestHandler(dest); err != nil {
+			return fmt.Errorf("register attachment handler on profile dest: %w", err)
+		}
+		if err := n.registerCapabilitiesRequestHandler(dest); err != nil {
+			return fmt.Errorf("register capabilities handler on profile dest: %w", err)
+		}
+		if err := n.registerProfileRequestHandler(dest); err != nil {
+			return fmt.Errorf("register profile handler on profile dest: %w", err)
+		}
+		if err := n.registerPresenceRequestHandler(dest); err != nil {
+			return fmt.Errorf("register presence handler on profile dest: %w", err)
+		}
+		n.stateMu.Lock()
+		n.profileDestIn = dest
+		n.stateMu.Unlock()
 	}
-	n.profileDestIn = dest
-	if err := n.registerAvatarRequestHandler(n.deliveryDestIn); err != nil {
+	if err := n.registerAvatarRequestHandler(delivery); err != nil {
 		return fmt.Errorf("register avatar handler on delivery dest: %w", err)
 	}
-	if err := n.registerAttachmentRequestHandler(n.deliveryDestIn); err != nil {
+	if err := n.registerAttachmentRequestHandler(delivery); err != nil {
 		return fmt.Errorf("register attachment handler on delivery dest: %w", err)
 	}
+	if err := n.registerCapabilitiesRequestHandler(delivery); err != nil {
+		return fmt.Errorf("register capabilities handler on delivery dest: %w", err)
+	}
+	if err := n.registerProfileRequestHandler(delivery); err != nil {
+		return fmt.Errorf("register profile handler on delivery dest: %w", err)
+	}
 	return nil
 }
 
@@ -61,49 +87,57 @@ func (n *Node) registerAvatarRequestHandler(dest *rns.Destination) error {
 			if remoteIdentity != nil {
 				remoteHex = remoteIdentity.HexHash
 			}
-			var knownHash []byte
-			if m, ok := reqData.(map[any]any); ok {
-				if hv, ok := m["h"]; ok {
-					if b, ok := hv.([]byte); ok && len(b) > 0 {
-						knownHash = b
-					}
-				}
+			req, err := decodeAvatarRequest(reqData)
+			if err != nil {
+				rns.Logf(rns.LOG_NOTICE, "avatar req: rejected remote=%s err=%v", remoteHex, err)
+				return map[any]any{"ok": false, "error": "bad request"}
 			}
 
-			hash := append([]byte(nil), n.avatarHash...)
-			avatarData := append([]byte(nil), n.avatarPNG...)
-			mtime := n.avatarMTime
-			mime := n.avatarMime
+			av := n.avatarSnapshot()
+			hash := av.hash
+			avatarData := av.data
+			mtime := av.mtime
+			mime := av.mime
 			if mime == "" {
 				mime = detectAvatarMime(avatarData)
 			}
+			pixels := 0
+			// Slow-link peers ask for a small size; serve the thumbnail when we have one.
+			if req.Pixels > 0 && req.Pixels <= AvatarThumbnailSize && len(av.thumb) > 0 {
+				hash, avatarData, mime, pixels = av.thumbHash, av.thumb, av.thumbMime, AvatarThumbnailSize
+			}
 
 			if len(hash) == 0 || len(avatarData) == 0 {
 				rns.Logf(rns.LOG_NOTICE, "avatar req: none available remote=%s", remoteHex)
 				return map[any]any{"ok": false}
 			}
-			if len(knownHash) > 0 && bytes.Equal(knownHash, hash) {
+			meta := map[any]any{"h": hash, "t": mime, "s": len(avatarData), "u": mtime}
+			if pixels > 0 {
+				meta["px"] = pixels
+			}
+			if len(req.Known) > 0 && bytes.Equal(req.Known, hash) {
 				rns.Logf(rns.LOG_NOTICE, "avatar req: unchanged remote=%s size=%d", remoteHex, len(avatarData))
-				return map[any]any{"ok": true, "unchanged": true, "h": hash, "t": mime, "s": len(avatarData), "u": mtime}
+				meta["ok"] = true
+				meta["unchanged"] = true
+				return meta
 			}
 			link := findActiveLink(linkID)
 			if link == nil {
 				rns.Logf(rns.LOG_NOTICE, "avatar req: link not found remote=%s", remoteHex)
 				return map[any]any{"ok": false, "error": "link not found"}
 			}
-			meta := map[any]any{
-				"kind": profileAvatarRes,
-				"h":    hash,
-				"t":    mime,
-				"s":    len(avatarData),
-				"u":    mtime,
+			resMeta := map[any]any{"kind": profileAvatarRes}
+			for k, v := range meta {
+				resMeta[k] = v
 			}
-			if _, err := rns.NewResource(avatarData, nil, link, meta, true, false, nil, nil, nil, 0, nil, nil, false, 0); err != nil {
+			if _, err := rns.NewResource(avatarData, nil, link, resMeta, true, false, nil, nil, nil, 0, nil, nil, false, 0); err != nil {
 				rns.Logf(rns.LOG_NOTICE, "avatar req: resource send failed remote=%s err=%v", remoteHex, err)
 				return map[any]any{"ok": false, "error": "resource send failed"}
 			}
-			rns.Logf(rns.LOG_NOTICE, "avatar req: resource queued remote=%s size=%d", remoteHex, len(avatarData))
-			return map[any]any{"ok": true, "h": hash, "t": mime, "s": len(avatarData), "u": mtime, "resource": true}
+			rns.Logf(rns.LOG_NOTICE, "avatar req: resource queued remote=%s size=%d px=%d", remoteHex, len(avatarData), pixels)
+			meta["ok"] = true
+			meta["resource"] = true
+			return meta
 		},
 		rns.DestinationALLOW_ALL,
 		nil,
@@ -116,20 +150,105 @@ type ContactAvatarFetch struct {
 	DataBase64 string `json:"data_base64,omitempty"`
 	PNGBase64  string `json:"png_base64,omitempty"`
 	Mime       string `json:"mime,omitempty"`
+	Thumbnail  bool   `json:"thumbnail,omitempty"`
 	Unchanged  bool   `json:"unchanged,omitempty"`
 	NotPresent bool   `json:"not_present,omitempty"`
 	Error      string `json:"error,omitempty"`
 }
 
 func (n *Node) ContactAvatarDataBase64Hex(destinationHashHex string, knownAvatarHashHex string, timeout time.Duration) (ContactAvatarFetch, error) {
-	if n == nil || n.identity == nil {
-		return ContactAvatarFetch{}, errors.New("node not started")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.ContactAvatarDataBase64HexContext(ctx, destinationHashHex, knownAvatarHashHex)
+}
+
+// ContactAvatarDataBase64HexContext fetches a contact's avatar over a link until ctx is done.
+// Cancelling ctx tears down the link and any in-flight resource transfer.
+func (n *Node) ContactAvatarDataBase64HexContext(ctx context.Context, destinationHashHex string, knownAvatarHashHex string) (ContactAvatarFetch, error) {
+	return n.contactAvatar(ctx, destinationHashHex, knownAvatarHashHex, 0)
+}
+
+// ContactAvatarThumbnailHex fetches a contact's avatar thumbnail (AvatarThumbnailSize px).
+// Peers without a thumbnail (or older runcore versions) send the full image; Thumbnail
+// in the result tells which one arrived.
+func (n *Node) ContactAvatarThumbnailHex(destinationHashHex string, knownThumbHashHex string, timeout time.Duration) (ContactAvatarFetch, error) {
 	if timeout <= 0 {
 		timeout = 5 * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.ContactAvatarThumbnailHexContext(ctx, destinationHashHex, knownThumbHashHex)
+}
+
+// ContactAvatarThumbnailHexContext is like ContactAvatarThumbnailHex but fetches until ctx is done.
+func (n *Node) ContactAvatarThumbnailHexContext(ctx context.Context, destinationHashHex string, knownThumbHashHex string) (ContactAvatarFetch, error) {
+	return n.contactAvatar(ctx, destinationHashHex, knownThumbHashHex, AvatarThumbnailSize)
+}
+
+// contactAvatar returns the avatar variant for pixels (0 = full image). It is served from
+// the avatar cache while the peer still announces the cached hash, otherwise fetched.
+func (n *Node) contactAvatar(ctx context.Context, destinationHashHex string, knownAvatarHashHex string, pixels int) (ContactAvatarFetch, error) {
+	if n == nil || n.identity == nil {
+		return ContactAvatarFetch{}, errors.New("node not started")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return ContactAvatarFetch{}, errors.New("invalid destination hash")
+	}
+	if cached, ok := n.lookupCachedAvatar(destHex, pixels); ok {
+		info, _ := n.contactInfo(nil, destHex)
+		if av := info.Avatar; av != nil {
+			announced := av.HashHex
+			if cached.Thumbnail {
+				announced = av.ThumbHashHex
+			}
+			// A cached full image stands in for a thumbnail only if the peer has none.
+			wantThumb := pixels > 0 && !cached.Thumbnail && av.ThumbHashHex != ""
+			if announced == cached.HashHex && !wantThumb {
+				return cachedAvatarFetch(cached, knownAvatarHashHex)
+			}
+		}
+	}
+	return n.fetchContactAvatar(ctx, destHex, knownAvatarHashHex, pixels)
+}
 
-	id, err := n.WaitForIdentityHex(destinationHashHex, timeout)
+// fetchContactAvatar fetches over the network and updates the avatar cache. Without a
+// caller hash the cached one is sent, so an unchanged avatar is not transferred again.
+func (n *Node) fetchContactAvatar(ctx context.Context, destHex string, knownAvatarHashHex string, pixels int) (ContactAvatarFetch, error) {
+	cached, haveCache := n.lookupCachedAvatar(destHex, pixels)
+	reqKnown := knownAvatarHashHex
+	if reqKnown == "" && haveCache {
+		reqKnown = cached.HashHex
+	}
+	fetch, err := n.fetchAvatarFromPeer(ctx, destHex, reqKnown, pixels)
+	if err != nil {
+		return ContactAvatarFetch{}, err
+	}
+	switch {
+	case fetch.NotPresent:
+		n.clearCachedAvatar(destHex)
+	case fetch.Unchanged:
+		if haveCache && strings.EqualFold(fetch.HashHex, cached.HashHex) {
+			n.touchCachedAvatar(destHex, cached)
+			if !strings.EqualFold(knownAvatarHashHex, fetch.HashHex) {
+				return cachedAvatarFetch(cached, knownAvatarHashHex)
+			}
+		}
+	default:
+		n.storeCachedAvatar(destHex, fetch)
+	}
+	return fetch, nil
+}
+
+func (n *Node) fetchAvatarFromPeer(ctx context.Context, destinationHashHex string, knownAvatarHashHex string, pixels int) (ContactAvatarFetch, error) {
+
+	id, err := n.WaitForIdentityHexContext(ctx, destinationHashHex)
 	if err != nil {
 		return ContactAvatarFetch{}, err
 	}
@@ -146,17 +265,22 @@ func (n *Node) ContactAvatarDataBase64Hex(destinationHashHex string, knownAvatar
 		{app: lxmf.AppName, aspect: "delivery", label: "lxmf.delivery"},
 		{app: profileAppName, aspect: profileAspect, label: "runcore.profile"},
 	}
-	for _, spec := range destinations {
+	for i, spec := range destinations {
 		rns.Logf(rns.LOG_NOTICE, "avatar fetch: try %s dest=%s", spec.label, destinationHashHex)
 		outDest, err := rns.NewDestination(id, rns.DestinationOUT, rns.DestinationSINGLE, spec.app, spec.aspect)
 		if err != nil {
 			lastErr = fmt.Errorf("create %s outbound destination: %w", spec.label, err)
 			continue
 		}
-		resp, err := n.fetchAvatarViaDestination(outDest, knownAvatarHashHex, timeout)
+		attemptCtx, cancel := attemptContext(ctx, len(destinations)-i)
+		resp, err := n.fetchAvatarViaDestination(attemptCtx, outDest, knownAvatarHashHex, pixels)
+		cancel()
 		if err == nil {
 			return resp, nil
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ContactAvatarFetch{}, ctxErr
+		}
 		lastErr = err
 	}
 	if lastErr != nil {
@@ -165,58 +289,31 @@ func (n *Node) ContactAvatarDataBase64Hex(destinationHashHex string, knownAvatar
 	return ContactAvatarFetch{}, errors.New("avatar request failed")
 }
 
-func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHashHex string, timeout time.Duration) (ContactAvatarFetch, error) {
+func (n *Node) fetchAvatarViaDestination(ctx context.Context, outDest *rns.Destination, knownAvatarHashHex string, pixels int) (ContactAvatarFetch, error) {
 	if outDest == nil {
 		return ContactAvatarFetch{}, errors.New("nil destination")
 	}
+	timeout := requestTimeout(ctx, 5*time.Second)
 
 	// If we don't have a path yet, link establishment will usually just time out.
 	// This is common on macCatalyst when multicast announce reception is flaky.
 	if !rns.TransportHasPath(outDest.Hash()) {
 		rns.Logf(rns.LOG_NOTICE, "avatar fetch: no path yet, requesting path dest=%s", hex.EncodeToString(outDest.Hash()))
-		rns.TransportRequestPath(outDest.Hash())
-		waitDeadline := time.Now().Add(minDuration(timeout, 4*time.Second))
-		for !rns.TransportHasPath(outDest.Hash()) && time.Now().Before(waitDeadline) {
-			time.Sleep(150 * time.Millisecond)
-		}
-		if rns.TransportHasPath(outDest.Hash()) {
+		n.RequestPath(outDest.Hash())
+		pathCtx, cancel := context.WithTimeout(ctx, minDuration(timeout, 4*time.Second))
+		if n.awaitPath(pathCtx, outDest.Hash()) {
 			rns.Logf(rns.LOG_NOTICE, "avatar fetch: path acquired dest=%s", hex.EncodeToString(outDest.Hash()))
 		}
+		cancel()
 	}
 
-	established := make(chan struct{})
-	closed := make(chan struct{})
-	link, err := rns.NewOutgoingLink(outDest, -1, func(*rns.Link) {
-		select {
-		case <-established:
-		default:
-			close(established)
-		}
-	}, func(*rns.Link) {
-		select {
-		case <-closed:
-		default:
-			close(closed)
-		}
-	})
+	link, _, err := openLink(ctx, outDest)
 	if err != nil {
-		rns.Logf(rns.LOG_NOTICE, "avatar fetch: open link failed: %v", err)
-		return ContactAvatarFetch{}, fmt.Errorf("open link: %w", err)
+		rns.Logf(rns.LOG_NOTICE, "avatar fetch: link failed: %v", err)
+		return ContactAvatarFetch{}, err
 	}
 	defer link.Teardown()
 
-	deadline := time.NewTimer(timeout)
-	defer deadline.Stop()
-	select {
-	case <-established:
-	case <-closed:
-		rns.Logf(rns.LOG_NOTICE, "avatar fetch: link closed before establishment")
-		return ContactAvatarFetch{}, errors.New("link closed before establishment")
-	case <-deadline.C:
-		rns.Logf(rns.LOG_NOTICE, "avatar fetch: link establish timeout")
-		return ContactAvatarFetch{}, errors.New("timeout establishing link")
-	}
-
 	// Provide caller identity (optional, but useful for allow-lists in the future).
 	link.Identify(n.identity)
 
@@ -226,6 +323,9 @@ func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHa
 			req["h"] = b
 		}
 	}
+	if pixels > 0 {
+		req["px"] = pixels
+	}
 
 	respCh := make(chan any, 1)
 	failCh := make(chan struct{}, 1)
@@ -253,41 +353,39 @@ func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHa
 	var respHash []byte
 	var respMime string
 	var respUnchanged bool
+	var respThumb bool
 
 	for {
 		select {
 		case resp := <-respCh:
-			switch v := resp.(type) {
-			case map[any]any:
-				ok, _ := v["ok"].(bool)
-				if !ok {
-					rns.Logf(rns.LOG_NOTICE, "avatar fetch: not present")
-					return ContactAvatarFetch{NotPresent: true}, nil
-				}
-				respUnchanged, _ = v["unchanged"].(bool)
-				if hv, ok := v["h"].([]byte); ok {
-					respHash = append([]byte(nil), hv...)
-				}
-				if tv, ok := v["t"].(string); ok {
-					respMime = tv
-				}
-				if respUnchanged {
-					out := ContactAvatarFetch{
-						HashHex:   hex.EncodeToString(respHash),
-						Mime:      respMime,
-						Unchanged: true,
-					}
-					rns.Logf(rns.LOG_NOTICE, "avatar fetch: unchanged")
-					return out, nil
-				}
-			case []byte:
+			v, err := decodeAvatarResponse(resp)
+			if err != nil {
+				rns.Logf(rns.LOG_NOTICE, "avatar fetch: %v", err)
+				return ContactAvatarFetch{}, err
+			}
+			if v.Raw != nil {
 				// Compatibility: handler may return raw bytes.
-				rns.Logf(rns.LOG_NOTICE, "avatar fetch: ok raw size=%d", len(v))
-				b64 := base64.StdEncoding.EncodeToString(v)
+				rns.Logf(rns.LOG_NOTICE, "avatar fetch: ok raw size=%d", len(v.Raw))
+				b64 := base64.StdEncoding.EncodeToString(v.Raw)
 				return ContactAvatarFetch{DataBase64: b64, PNGBase64: b64}, nil
-			default:
-				rns.Logf(rns.LOG_NOTICE, "avatar fetch: unexpected response %T", resp)
-				return ContactAvatarFetch{}, errors.New("unexpected avatar response type")
+			}
+			if !v.OK {
+				rns.Logf(rns.LOG_NOTICE, "avatar fetch: not present")
+				return ContactAvatarFetch{NotPresent: true}, nil
+			}
+			respUnchanged = v.Unchanged
+			respHash = v.Meta.Hash
+			respMime = v.Meta.Mime
+			respThumb = v.Meta.Pixels > 0
+			if respUnchanged {
+				out := ContactAvatarFetch{
+					HashHex:   hex.EncodeToString(respHash),
+					Mime:      respMime,
+					Thumbnail: respThumb,
+					Unchanged: true,
+				}
+				rns.Logf(rns.LOG_NOTICE, "avatar fetch: unchanged")
+				return out, nil
 			}
 		case res := <-resCh:
 			if res == nil {
@@ -296,16 +394,18 @@ func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHa
 			if res.Status() != rns.ResourceComplete {
 				return ContactAvatarFetch{}, errors.New("avatar resource failed")
 			}
-			meta := res.Metadata()
-			kind, _ := meta["kind"].(string)
-			if kind != "" && kind != profileAvatarRes {
-				return ContactAvatarFetch{}, errors.New("unexpected avatar resource kind")
+			meta, err := decodeAvatarResourceMeta(res.Metadata())
+			if err != nil {
+				return ContactAvatarFetch{}, err
+			}
+			if len(meta.Hash) > 0 {
+				respHash = meta.Hash
 			}
-			if hv, ok := meta["h"].([]byte); ok && len(hv) > 0 {
-				respHash = append([]byte(nil), hv...)
+			if meta.Mime != "" {
+				respMime = meta.Mime
 			}
-			if tv, ok := meta["t"].(string); ok && tv != "" {
-				respMime = tv
+			if meta.Pixels > 0 {
+				respThumb = true
 			}
 			data, err := os.ReadFile(res.DataFile())
 			if err != nil {
@@ -314,6 +414,7 @@ func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHa
 			out := ContactAvatarFetch{
 				HashHex:   hex.EncodeToString(respHash),
 				Mime:      respMime,
+				Thumbnail: respThumb,
 				Unchanged: false,
 			}
 			if len(data) > 0 {
@@ -326,13 +427,35 @@ func (n *Node) fetchAvatarViaDestination(outDest *rns.Destination, knownAvatarHa
 		case <-failCh:
 			rns.Logf(rns.LOG_NOTICE, "avatar fetch: request failed")
 			return ContactAvatarFetch{}, errors.New("avatar request failed")
-		case <-deadline.C:
-			rns.Logf(rns.LOG_NOTICE, "avatar fetch: request timeout")
-			return ContactAvatarFetch{}, errors.New("avatar request timeout")
+		case <-ctx.Done():
+			rns.Logf(rns.LOG_NOTICE, "avatar fetch: request aborted: %v", ctx.Err())
+			return ContactAvatarFetch{}, ctx.Err()
 		}
 	}
 }
 
+// attemptContext derives the context for one of attemptsLeft fallback attempts: it gets an
+// equal share of the time left on ctx, so a timeout on one destination still leaves time
+// for the next.
+func attemptContext(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || attemptsLeft <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(attemptsLeft))
+}
+
+// requestTimeout converts the time left on ctx into a link request timeout.
+func requestTimeout(ctx context.Context, def time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left > 0 {
+			return left
+		}
+		return time.Millisecond
+	}
+	return def
+}
+
 func minDuration(a, b time.Duration) time.Duration {
 	if a <= 0 {
 		return b
@@ -343,6 +466,51 @@ func minDuration(a, b time.Duration) time.Duration {
 	return b
 }
 
+// openLink opens a link to outDest and waits until it is established. The returned
+// channel is closed when the link closes; callers tear the link down when done.
+//
+// A link given up while still pending is not torn down here: go-reticulum v1.0.4 tears
+// it down from its watchdog on establishment timeout while holding the link lock, so a
+// Teardown racing that would block for good. It is left to that timeout, or torn down
+// from the established callback if it comes up after all.
+func openLink(ctx context.Context, outDest *rns.Destination) (*rns.Link, <-chan struct{}, error) {
+	established := make(chan struct{})
+	closed := make(chan struct{})
+	var mu sync.Mutex
+	abandoned := false
+	var once, closeOnce sync.Once
+	link, err := rns.NewOutgoingLink(outDest, -1, func(l *rns.Link) {
+		once.Do(func() { close(established) })
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			go l.Teardown()
+		}
+	}, func(*rns.Link) {
+		closeOnce.Do(func() { close(closed) })
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("open link: %w", err)
+	}
+	select {
+	case <-established:
+		return link, closed, nil
+	case <-closed:
+		return nil, nil, errors.New("link closed before establishment")
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	abandoned = true
+	mu.Unlock()
+	select {
+	case <-established:
+		// Established while giving up; the callback may have run before abandoned was set.
+		link.Teardown()
+	default:
+	}
+	return nil, nil, ctx.Err()
+}
+
 func findActiveLink(linkID []byte) *rns.Link {
 	if len(linkID) == 0 {
 		return nil
@@ -0,0 +1,228 @@
+package runcore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Profile push: announces may not reach peers that are only reachable through propagation
+// nodes, so with Options.ProfilePush a profile change is also sent as an LXMF control
+// message to recent conversation partners. The message carries the announce app-data in
+// FieldCustomData (see control.go). It is only sent to peers advertising CapProfilePush.
+
+const (
+	// CapProfilePush: accepts profile push control messages.
+	CapProfilePush = "profile_push"
+
+	profilePushType = "runcore.profile"
+
+	defaultProfilePushWindow = 7 * 24 * time.Hour
+	// profilePushDelay coalesces bursts of changes (eg name and avatar set together).
+	profilePushDelay         = 10 * time.Second
+	maxProfilePushRecipients = 32
+	maxConversationPartners  = 1024
+	// maxPushedProfiles caps the pushed profiles kept; the oldest is dropped first.
+	maxPushedProfiles = 1024
+)
+
+func init() { registerCapability(CapProfilePush) }
+
+// pushedProfile is announce app-data received in a profile push. It stands in for the
+// announced app-data until the next announce from that peer.
+type pushedProfile struct {
+	appData  []byte
+	received time.Time
+}
+
+// recordPartner notes a conversation partner for profile pushes.
+func (n *Node) recordPartner(destHex string) {
+	if n == nil || destHex == "" || destHex == n.DestinationHashHex() {
+		return
+	}
+	n.partnersMu.Lock()
+	defer n.partnersMu.Unlock()
+	if n.partners == nil {
+		n.partners = make(map[string]time.Time)
+	}
+	n.partners[destHex] = time.Now()
+	if len(n.partners) > maxConversationPartners {
+		oldest, oldestAt := "", time.Time{}
+		for k, t := range n.partners {
+			if oldest == "" || t.Before(oldestAt) {
+				oldest, oldestAt = k, t
+			}
+		}
+		delete(n.partners, oldest)
+	}
+}
+
+func (n *Node) partnersPath() string {
+	return filepath.Join(n.opts.Dir, "partners.json")
+}
+
+// loadPartners restores conversation partners (unix seconds by destination hex).
+func (n *Node) loadPartners() {
+	b, err := os.ReadFile(n.partnersPath())
+	if err != nil {
+		return
+	}
+	var saved map[string]int64
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return
+	}
+	n.partnersMu.Lock()
+	defer n.partnersMu.Unlock()
+	if n.partners == nil {
+		n.partners = make(map[string]time.Time)
+	}
+	for k, ts := range saved {
+		if validHashHex(k, lxmf.DestinationLength) {
+			n.partners[k] = time.Unix(ts, 0)
+		}
+	}
+}
+
+func (n *Node) savePartners() {
+	n.partnersMu.Lock()
+	saved := make(map[string]int64, len(n.partners))
+	for k, t := range n.partners {
+		saved[k] = t.Unix()
+	}
+	n.partnersMu.Unlock()
+	if len(saved) == 0 {
+		return
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(n.partnersPath(), b); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "profile push: save partners failed: %v", err)
+	}
+}
+
+// recentPartners returns partners seen within window, most recent first.
+func (n *Node) recentPartners(window time.Duration) []string {
+	cutoff := time.Now().Add(-window)
+	n.partnersMu.Lock()
+	defer n.partnersMu.Unlock()
+	var out []string
+	for k, t := range n.partners {
+		if t.After(cutoff) {
+			out = append(out, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return n.partners[out[i]].After(n.partners[out[j]]) })
+	return out
+}
+
+// SetProfilePush enables or disables profile push at runtime (see Options.ProfilePush).
+func (n *Node) SetProfilePush(enabled bool) {
+	if n != nil {
+		n.profilePush.Store(enabled)
+	}
+}
+
+// scheduleProfilePush sends the current profile to recent partners after profilePushDelay.
+// It is a no-op unless profile push is enabled.
+func (n *Node) scheduleProfilePush() {
+	if n == nil || !n.profilePush.Load() {
+		return
+	}
+	n.partnersMu.Lock()
+	defer n.partnersMu.Unlock()
+	if n.pushTimer != nil {
+		n.pushTimer.Stop()
+	}
+	n.pushTimer = time.AfterFunc(profilePushDelay, n.pushProfile)
+}
+
+func (n *Node) pushProfile() {
+	select {
+	case <-n.announceStop:
+		return
+	default:
+	}
+	window := n.opts.ProfilePushWindow
+	if window <= 0 {
+		window = defaultProfilePushWindow
+	}
+	appData := n.announceAppData()
+	if len(appData) == 0 {
+		return
+	}
+	sent := 0
+	for _, destHex := range n.recentPartners(window) {
+		if sent >= maxProfilePushRecipients {
+			break
+		}
+		info, err := n.contactInfo(nil, destHex)
+		if err != nil || !info.Supports(CapProfilePush) {
+			continue
+		}
+		if err := n.sendControl(destHex, profilePushType, appData); err != nil {
+			rns.Logf(rns.LOG_DEBUG, "profile push: dest=%s err=%v", destHex, err)
+			continue
+		}
+		sent++
+	}
+	if sent > 0 {
+		rns.Logf(rns.LOG_NOTICE, "profile push: sent to %d contact(s)", sent)
+	}
+}
+
+// handleProfilePush handles a profile push control message. Valid pushes replace the
+// sender's announced app-data until its next announce, and cached avatars are refreshed
+// in the background.
+func (n *Node) handleProfilePush(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	appData, ok := raw.([]byte)
+	if !ok {
+		countWireError(wireErrorf(wireAnnounceAppData, "profile push: want bytes, got %T", raw))
+		return
+	}
+	data, err := decodeAnnounceAppData(appData)
+	if err != nil {
+		return
+	}
+	n.pushedMu.Lock()
+	if n.pushed == nil {
+		n.pushed = make(map[string]pushedProfile)
+	}
+	n.pushed[srcHex] = pushedProfile{appData: append([]byte(nil), appData...), received: time.Now()}
+	for len(n.pushed) > maxPushedProfiles {
+		oldest := ""
+		for k, p := range n.pushed {
+			if oldest == "" || p.received.Before(n.pushed[oldest].received) {
+				oldest = k
+			}
+		}
+		delete(n.pushed, oldest)
+	}
+	n.pushedMu.Unlock()
+	rns.Logf(rns.LOG_DEBUG, "profile push: received src=%s name=%q", srcHex, data.DisplayName)
+	n.refreshCachedAvatar(srcHex, data)
+}
+
+// pushedAppData returns app-data from a profile push not yet superseded by an announce.
+func (n *Node) pushedAppData(destHex string) []byte {
+	n.pushedMu.Lock()
+	defer n.pushedMu.Unlock()
+	return n.pushed[destHex].appData
+}
+
+// forgetPushedProfile drops a pushed profile when the peer announces: the announce is
+// at least as recent, whether or not it matches the push.
+func (n *Node) forgetPushedProfile(destHex string) {
+	n.pushedMu.Lock()
+	defer n.pushedMu.Unlock()
+	delete(n.pushed, destHex)
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package runcore
+
+const raceEnabled = false
@@ -0,0 +1,363 @@
+package runcore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+// Read receipts: MarkRead queues the message ID for its sender, and after
+// readReceiptDelay one control message per sender carries {"ids": [message_id...], "t": ts}.
+// They are sent only when enabled (globally and for the contact) and only to peers
+// advertising CapReadReceipts. Receipts for messages we sent to that peer are reported
+// with state MessageRead.
+
+const (
+	// CapReadReceipts: accepts read receipt control messages.
+	CapReadReceipts = "read_receipts"
+
+	// MessageRead is the outbound message state reported for read receipts. It extends the
+	// lxmf.Message* states and is never set on lxmf.LXMessage.State.
+	MessageRead = 0x10
+
+	readReceiptType = "runcore.read"
+
+	readReceiptDelay       = 2 * time.Second
+	maxReadReceiptIDs      = 64
+	maxTrackedMessages     = 4096
+	lxmfMessageIDLen       = 32
+	readReceiptsConfigFile = "read_receipts.json"
+)
+
+func init() { registerCapability(CapReadReceipts) }
+
+// ReceiptPolicy overrides the global read receipt setting for one contact.
+type ReceiptPolicy int
+
+const (
+	ReceiptDefault ReceiptPolicy = iota // follow SetReadReceipts
+	ReceiptAlways
+	ReceiptNever
+)
+
+// ReadReceipt reports that a peer read a message we sent.
+type ReadReceipt struct {
+	DestinationHashHex string `json:"destination_hash_hex"`
+	MessageIDHex       string `json:"message_id_hex"`
+	ReadAt             int64  `json:"read_at"`
+}
+
+// readReceipts is the node's read receipt state.
+type readReceipts struct {
+	mu       sync.Mutex
+	enabled  bool
+	contacts map[string]ReceiptPolicy
+
+	// inbound maps received message IDs to their source, outbound sent IDs to their
+	// destination (both bounded, oldest evicted first).
+	inbound  messageIndex
+	outbound messageIndex
+
+	queue map[string][]string // source hex -> message IDs to acknowledge
+	timer *time.Timer
+
+	onRead func(ReadReceipt)
+}
+
+type messageIndex struct {
+	peers map[string]string
+	order []string
+}
+
+func (x *messageIndex) add(msgID, peer string) {
+	if x.peers == nil {
+		x.peers = make(map[string]string)
+	}
+	if _, ok := x.peers[msgID]; !ok {
+		x.order = append(x.order, msgID)
+	}
+	x.peers[msgID] = peer
+	for len(x.order) > maxTrackedMessages {
+		delete(x.peers, x.order[0])
+		x.order = x.order[1:]
+	}
+}
+
+type readReceiptsConfig struct {
+	Enabled  bool            `json:"enabled"`
+	Contacts map[string]bool `json:"contacts,omitempty"`
+}
+
+func (n *Node) readReceiptsPath() string {
+	return filepath.Join(n.opts.Dir, readReceiptsConfigFile)
+}
+
+func (n *Node) loadReadReceiptsConfig() {
+	b, err := os.ReadFile(n.readReceiptsPath())
+	if err != nil {
+		return
+	}
+	var cfg readReceiptsConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "read receipts: parse %s: %v", readReceiptsConfigFile, err)
+		return
+	}
+	r := &n.receipts
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enabled = cfg.Enabled
+	r.contacts = make(map[string]ReceiptPolicy, len(cfg.Contacts))
+	for k, on := range cfg.Contacts {
+		if on {
+			r.contacts[k] = ReceiptAlways
+		} else {
+			r.contacts[k] = ReceiptNever
+		}
+	}
+}
+
+// saveReadReceiptsConfigLocked persists the privacy settings; r.mu is held.
+func (n *Node) saveReadReceiptsConfigLocked() error {
+	r := &n.receipts
+	cfg := readReceiptsConfig{Enabled: r.enabled, Contacts: map[string]bool{}}
+	for k, p := range r.contacts {
+		cfg.Contacts[k] = p == ReceiptAlways
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(n.readReceiptsPath(), b)
+}
+
+// SetReadReceipts enables or disables sending read receipts (default: disabled).
+// The setting is persisted under Dir.
+func (n *Node) SetReadReceipts(enabled bool) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	n.receipts.mu.Lock()
+	defer n.receipts.mu.Unlock()
+	n.receipts.enabled = enabled
+	return n.saveReadReceiptsConfigLocked()
+}
+
+// SetContactReadReceipts overrides the global read receipt setting for one contact.
+func (n *Node) SetContactReadReceipts(destinationHashHex string, policy ReceiptPolicy) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return errors.New("invalid destination hash")
+	}
+	if policy < ReceiptDefault || policy > ReceiptNever {
+		return errors.New("invalid receipt policy")
+	}
+	r := &n.receipts
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.contacts == nil {
+		r.contacts = make(map[string]ReceiptPolicy)
+	}
+	if policy == ReceiptDefault {
+		delete(r.contacts, destHex)
+	} else {
+		r.contacts[destHex] = policy
+	}
+	return n.saveReadReceiptsConfigLocked()
+}
+
+// sendsReceiptsToLocked reports whether receipts go to destHex; r.mu is held.
+func (r *readReceipts) sendsReceiptsToLocked(destHex string) bool {
+	switch r.contacts[destHex] {
+	case ReceiptAlways:
+		return true
+	case ReceiptNever:
+		return false
+	default:
+		return r.enabled
+	}
+}
+
+// SetReadReceiptHandler sets the callback for read receipts of messages we sent.
+// It runs on a router goroutine and must not block for long.
+func (n *Node) SetReadReceiptHandler(cb func(ReadReceipt)) {
+	n.receipts.mu.Lock()
+	n.receipts.onRead = cb
+	n.receipts.mu.Unlock()
+}
+
+func (n *Node) trackInboundMessage(m *lxmf.LXMessage) {
+	id := lxmfMessageIDHex(m)
+	if id == "" {
+		return
+	}
+	n.receipts.mu.Lock()
+	n.receipts.inbound.add(id, hex.EncodeToString(m.SourceHash))
+	n.receipts.mu.Unlock()
+}
+
+func (n *Node) trackOutboundMessage(destHex string, m *lxmf.LXMessage) {
+	id := lxmfMessageIDHex(m)
+	if id == "" {
+		return
+	}
+	n.receipts.mu.Lock()
+	n.receipts.outbound.add(id, destHex)
+	n.receipts.mu.Unlock()
+}
+
+func lxmfMessageIDHex(m *lxmf.LXMessage) string {
+	if m == nil {
+		return ""
+	}
+	if len(m.MessageID) > 0 {
+		return hex.EncodeToString(m.MessageID)
+	}
+	return hex.EncodeToString(m.Hash)
+}
+
+// MarkRead records that the user read a received message and, if read receipts are
+// enabled for its sender, queues a receipt. The message must have been received by this
+// node since it started; use MarkReadFrom for older messages.
+func (n *Node) MarkRead(messageIDHex string) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	id := strings.ToLower(strings.TrimSpace(messageIDHex))
+	n.receipts.mu.Lock()
+	src, ok := n.receipts.inbound.peers[id]
+	n.receipts.mu.Unlock()
+	if !ok {
+		return errors.New("unknown message")
+	}
+	return n.MarkReadFrom(src, id)
+}
+
+// MarkReadFrom is MarkRead for a message whose sender the host already knows.
+func (n *Node) MarkReadFrom(sourceHashHex, messageIDHex string) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	src := strings.ToLower(strings.TrimSpace(sourceHashHex))
+	id := strings.ToLower(strings.TrimSpace(messageIDHex))
+	if !validHashHex(src, lxmf.DestinationLength) {
+		return errors.New("invalid source hash")
+	}
+	if !validHashHex(id, lxmfMessageIDLen) {
+		return errors.New("invalid message id")
+	}
+	r := &n.receipts
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.sendsReceiptsToLocked(src) {
+		return nil
+	}
+	for _, queued := range r.queue[src] {
+		if queued == id {
+			return nil
+		}
+	}
+	if r.queue == nil {
+		r.queue = make(map[string][]string)
+	}
+	r.queue[src] = append(r.queue[src], id)
+	if r.timer == nil {
+		r.timer = time.AfterFunc(readReceiptDelay, n.flushReadReceipts)
+	}
+	return nil
+}
+
+// flushReadReceiptsNow sends queued receipts without waiting for readReceiptDelay, before
+// Close or Restart take the router away.
+func (n *Node) flushReadReceiptsNow() {
+	n.receipts.mu.Lock()
+	if n.receipts.timer != nil {
+		n.receipts.timer.Stop()
+	}
+	n.receipts.mu.Unlock()
+	n.flushReadReceipts()
+}
+
+func (n *Node) flushReadReceipts() {
+	r := &n.receipts
+	r.mu.Lock()
+	queue := r.queue
+	r.queue = nil
+	r.timer = nil
+	r.mu.Unlock()
+
+	now := time.Now().Unix()
+	for src, ids := range queue {
+		info, err := n.contactInfo(nil, src)
+		if err != nil || !info.Supports(CapReadReceipts) {
+			rns.Logf(rns.LOG_DEBUG, "read receipts: %s does not accept receipts", src)
+			continue
+		}
+		for len(ids) > 0 {
+			chunk := ids
+			if len(chunk) > maxReadReceiptIDs {
+				chunk = chunk[:maxReadReceiptIDs]
+			}
+			ids = ids[len(chunk):]
+			raw := make([]any, 0, len(chunk))
+			for _, id := range chunk {
+				b, _ := hex.DecodeString(id)
+				raw = append(raw, b)
+			}
+			if err := n.sendControl(src, readReceiptType, map[any]any{"ids": raw, "t": now}); err != nil {
+				rns.Logf(rns.LOG_NOTICE, "read receipts: send to %s failed: %v", src, err)
+			}
+		}
+	}
+}
+
+// handleReadReceipt reports receipts for messages we sent to the receipt's sender.
+func (n *Node) handleReadReceipt(m *lxmf.LXMessage) {
+	srcHex := hex.EncodeToString(m.SourceHash)
+	raw, _ := LXMFField(m.Fields, lxmf.FieldCustomData)
+	rc, err := decodeReadReceipt(raw)
+	if err != nil {
+		return
+	}
+	readAt := rc.ReadAt
+	if readAt == 0 {
+		readAt = time.Now().Unix()
+	}
+	r := &n.receipts
+	r.mu.Lock()
+	cb := r.onRead
+	var accepted []string
+	for _, id := range rc.IDs {
+		idHex := hex.EncodeToString(id)
+		// Ignore receipts for messages we did not send to this peer.
+		if r.outbound.peers[idHex] == srcHex {
+			accepted = append(accepted, idHex)
+		}
+	}
+	r.mu.Unlock()
+	for _, idHex := range accepted {
+		n.updateMessage(srcHex, idHex, func(s *StoredMessage) bool {
+			if s.State == MessageRead {
+				return false
+			}
+			s.State = MessageRead
+			return true
+		})
+	}
+	if cb == nil {
+		return
+	}
+	for _, idHex := range accepted {
+		cb(ReadReceipt{DestinationHashHex: srcHex, MessageIDHex: idHex, ReadAt: readAt})
+	}
+}
@@ -0,0 +1,233 @@
+package runcore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/configobj"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+const rnsConfigWatchInterval = 3 * time.Second
+
+// rnsConfigModel is an in-memory view of the Reticulum config ([interfaces] only).
+// runcore's own writes go through update(); external edits are picked up by watch(),
+// which only stats the file and re-parses when size or mtime changed.
+type rnsConfigModel struct {
+	path string
+
+	// updateMu serializes update's load, edit and save so concurrent edits are not lost.
+	updateMu sync.Mutex
+
+	mu         sync.RWMutex
+	interfaces []configuredInterfaceEntry
+	modTime    time.Time
+	size       int64
+	loadErr    error
+
+	subsMu sync.Mutex
+	subs   map[chan struct{}]struct{}
+}
+
+func newRNSConfigModel(path string) *rnsConfigModel {
+	m := &rnsConfigModel{
+		path: path,
+		subs: make(map[chan struct{}]struct{}),
+	}
+	_ = m.reload()
+	return m
+}
+
+// reload re-parses the file and notifies subscribers if the interface set changed.
+func (m *rnsConfigModel) reload() error {
+	if m == nil || m.path == "" {
+		return errors.New("no reticulum config path")
+	}
+	st, statErr := os.Stat(m.path)
+	cfg, err := configobj.Load(m.path)
+	var ifaces []configuredInterfaceEntry
+	if err == nil {
+		ifaces = parseConfiguredInterfaces(cfg)
+	}
+
+	m.mu.Lock()
+	changed := err != nil || !sameInterfaceEntries(m.interfaces, ifaces)
+	if err == nil {
+		m.interfaces = ifaces
+	}
+	m.loadErr = err
+	if statErr == nil {
+		m.modTime = st.ModTime()
+		m.size = st.Size()
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.notify()
+	}
+	return err
+}
+
+// update loads the config from disk, applies fn, saves it and refreshes the model.
+func (m *rnsConfigModel) update(fn func(cfg *configobj.Config) error) error {
+	if m == nil || m.path == "" {
+		return errors.New("no reticulum config path")
+	}
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+	cfg, err := configobj.Load(m.path)
+	if err != nil {
+		return fmt.Errorf("load reticulum config: %w", err)
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	if err := cfg.Save(m.path); err != nil {
+		return fmt.Errorf("save reticulum config: %w", err)
+	}
+	return m.reload()
+}
+
+// watch polls the file metadata until stop is closed.
+func (m *rnsConfigModel) watch(stop <-chan struct{}) {
+	if m == nil {
+		return
+	}
+	t := time.NewTicker(rnsConfigWatchInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			st, err := os.Stat(m.path)
+			if err != nil {
+				continue
+			}
+			m.mu.RLock()
+			same := st.ModTime().Equal(m.modTime) && st.Size() == m.size
+			m.mu.RUnlock()
+			if same {
+				continue
+			}
+			if err := m.reload(); err != nil {
+				rns.Logf(rns.LOG_NOTICE, "rns config changed on disk but failed to parse: %v", err)
+				continue
+			}
+			rns.Logf(rns.LOG_DEBUG, "rns config reloaded after external change")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// configured returns all interfaces (including disabled ones), sorted by name.
+func (m *rnsConfigModel) configured() ([]configuredInterfaceEntry, error) {
+	if m == nil {
+		return nil, errors.New("no reticulum config")
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.interfaces == nil && m.loadErr != nil {
+		return nil, m.loadErr
+	}
+	return append([]configuredInterfaceEntry(nil), m.interfaces...), nil
+}
+
+func (m *rnsConfigModel) enabled() []configuredInterfaceEntry {
+	all, err := m.configured()
+	if err != nil {
+		return nil
+	}
+	out := make([]configuredInterfaceEntry, 0, len(all))
+	for _, e := range all {
+		if e.Enabled {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// subscribe returns a channel that receives a value whenever the interface set changes.
+// Notifications are coalesced; call cancel to unsubscribe.
+func (m *rnsConfigModel) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	m.subsMu.Lock()
+	m.subs[ch] = struct{}{}
+	m.subsMu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.subsMu.Lock()
+			delete(m.subs, ch)
+			m.subsMu.Unlock()
+		})
+	}
+}
+
+func (m *rnsConfigModel) notify() {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func parseConfiguredInterfaces(cfg *configobj.Config) []configuredInterfaceEntry {
+	if cfg == nil || !cfg.HasSection("interfaces") {
+		return []configuredInterfaceEntry{}
+	}
+	sec := cfg.Section("interfaces")
+	names := sec.Sections()
+	sort.Strings(names)
+	out := make([]configuredInterfaceEntry, 0, len(names))
+	for _, name := range names {
+		s := sec.Subsection(name)
+		typ, _ := s.Get("type")
+		enabled := false
+		if v, ok := s.Get("interface_enabled"); ok {
+			enabled = parseTruthyString(v)
+		} else if v, ok := s.Get("enabled"); ok {
+			enabled = parseTruthyString(v)
+		} else if v, ok := s.Get("enable"); ok {
+			enabled = parseTruthyString(v)
+		}
+		out = append(out, configuredInterfaceEntry{Name: name, Type: typ, Enabled: enabled})
+	}
+	return out
+}
+
+func sameInterfaceEntries(a, b []configuredInterfaceEntry) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// InterfaceConfigChanges returns a channel signalled whenever the configured interface set
+// changes (runcore's own edits or external writes to rns/config). Call cancel when done.
+func (n *Node) InterfaceConfigChanges() (<-chan struct{}, func()) {
+	if n == nil || n.rnsConfig == nil {
+		ch := make(chan struct{})
+		return ch, func() {}
+	}
+	return n.rnsConfig.subscribe()
+}
+
+func (n *Node) startRNSConfigModel() {
+	if n == nil || n.reticulum == nil || n.reticulum.ConfigPath == "" {
+		return
+	}
+	n.rnsConfig = newRNSConfigModel(n.reticulum.ConfigPath)
+	go n.rnsConfig.watch(n.announceStop)
+}
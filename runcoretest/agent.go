@@ -0,0 +1,274 @@
+package runcoretest
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+
+	"runcore"
+)
+
+const defaultOpTimeout = 30 * time.Second
+
+// agent is the child-process side of the control channel.
+type agent struct {
+	node *runcore.Node
+	dir  string
+
+	outMu sync.Mutex
+	out   *json.Encoder
+}
+
+// RunAgent starts a runcore node from args and serves control requests read from in,
+// writing responses and events to out. It returns after OpShutdown or when in is closed.
+// cmd/runcore-testnode wires in to stdin and out to fd 3.
+func RunAgent(args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("runcore-testnode", flag.ContinueOnError)
+	dir := fs.String("dir", "", "runcore state directory")
+	rnsDir := fs.String("rnsconfig", "", "Reticulum config directory")
+	name := fs.String("name", "", "display name")
+	logLevel := fs.Int("loglevel", 2, "Reticulum log level (0..7)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("missing -dir")
+	}
+
+	node, err := runcore.Start(runcore.Options{
+		Dir:          *dir,
+		RNSConfigDir: *rnsDir,
+		DisplayName:  *name,
+		LogLevel:     *logLevel,
+		// Tests announce explicitly; periodic announces only add noise.
+		AnnounceInterval:            -1,
+		PropagationAnnounceInterval: -1,
+	})
+	if err != nil {
+		return fmt.Errorf("start node: %w", err)
+	}
+	defer node.Close()
+
+	a := &agent{node: node, dir: *dir, out: json.NewEncoder(out)}
+	node.SetInboundHandler(func(m *lxmf.LXMessage) {
+		a.emit(EventInbound, Inbound{
+			SourceHex:    hex.EncodeToString(m.SourceHash),
+			MessageIDHex: messageIDHex(m),
+			Title:        m.TitleAsString(),
+			Content:      m.ContentAsString(),
+		})
+	})
+	a.emit(EventReady, a.info())
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var req Request
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			rns.Logf(rns.LOG_ERROR, "runcoretest: bad request: %v", err)
+			continue
+		}
+		if req.Op == OpShutdown {
+			a.respond(req.ID, nil, nil)
+			return nil
+		}
+		// Requests may block (waits, fetches), so each one runs on its own goroutine.
+		// Pending requests are abandoned on shutdown.
+		go func() {
+			res, err := a.handle(req)
+			a.respond(req.ID, res, err)
+		}()
+	}
+	return sc.Err()
+}
+
+func (a *agent) handle(req Request) (any, error) {
+	switch req.Op {
+	case OpInfo:
+		return a.info(), nil
+	case OpAnnounce:
+		a.node.AnnounceDeliveryWithReason("test")
+		return nil, nil
+	case OpWaitIdentity:
+		var args destArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		ctx, cancel := opContext(args.TimeoutMs)
+		defer cancel()
+		_, err := a.node.WaitForIdentityHexContext(ctx, args.DestHex)
+		return nil, err
+	case OpSend:
+		var args sendArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return a.send(args)
+	case OpSetDisplayName:
+		var args nameArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, a.node.SetDisplayName(args.Name)
+	case OpSetAvatar:
+		var args blobArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, a.node.SetAvatarImage(args.Mime, args.Data)
+	case OpClearAvatar:
+		return nil, a.node.ClearAvatar()
+	case OpContactInfo:
+		var args destArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		ctx, cancel := opContext(args.TimeoutMs)
+		defer cancel()
+		return a.node.ContactInfoHexContext(ctx, args.DestHex)
+	case OpContactAvatar:
+		var args avatarArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		ctx, cancel := opContext(args.TimeoutMs)
+		defer cancel()
+		return a.node.ContactAvatarDataBase64HexContext(ctx, args.DestHex, args.KnownHashHex)
+	case OpStoreAttachment:
+		var args blobArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		return a.node.StoreOutgoingAttachment(args.Data, args.Mime, args.Name)
+	case OpFetchAttachment:
+		var args attachmentArgs
+		if err := decodeArgs(req, &args); err != nil {
+			return nil, err
+		}
+		ctx, cancel := opContext(args.TimeoutMs)
+		defer cancel()
+		fetch, err := a.node.ContactAttachmentPathHexContext(ctx, args.DestHex, args.HashHex)
+		if err != nil {
+			return nil, err
+		}
+		res := attachmentResult{Fetch: fetch}
+		if fetch.Path != "" {
+			if res.Data, err = os.ReadFile(fetch.Path); err != nil {
+				return nil, fmt.Errorf("read attachment: %w", err)
+			}
+		}
+		return res, nil
+	case OpRestart:
+		return a.info(), a.node.Restart()
+	default:
+		return nil, fmt.Errorf("unknown op %q", req.Op)
+	}
+}
+
+func (a *agent) send(args sendArgs) (any, error) {
+	ctx, cancel := opContext(args.TimeoutMs)
+	defer cancel()
+	if _, err := a.node.WaitForIdentityHexContext(ctx, args.DestHex); err != nil {
+		return nil, fmt.Errorf("wait for identity: %w", err)
+	}
+	msg, err := a.node.SendHex(args.DestHex, runcore.SendOptions{
+		Method:  lxmf.MethodOpportunistic,
+		Title:   args.Title,
+		Content: args.Content,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := func(m *lxmf.LXMessage) {
+		if m == nil {
+			return
+		}
+		a.emit(EventOutboundStatus, OutboundStatus{
+			DestHex:      hex.EncodeToString(m.DestinationHash),
+			MessageIDHex: messageIDHex(m),
+			State:        int(m.State),
+		})
+	}
+	msg.RegisterDeliveryCallback(status)
+	msg.RegisterFailedCallback(status)
+	return sendResult{MessageIDHex: messageIDHex(msg)}, nil
+}
+
+func (a *agent) info() Info {
+	info := Info{DestHex: a.node.DestinationHashHex(), Dir: a.dir}
+	if id := a.node.Identity(); id != nil {
+		info.IdentityHex = id.HexHash
+	}
+	return info
+}
+
+func (a *agent) respond(id uint64, res any, err error) {
+	msg := Message{ID: id}
+	if err != nil {
+		msg.Error = err.Error()
+	} else if res != nil {
+		b, merr := json.Marshal(res)
+		if merr != nil {
+			msg.Error = fmt.Sprintf("encode result: %v", merr)
+		} else {
+			msg.Result = b
+		}
+	}
+	a.write(msg)
+}
+
+func (a *agent) emit(event string, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		rns.Logf(rns.LOG_ERROR, "runcoretest: encode %s event: %v", event, err)
+		return
+	}
+	a.write(Message{Event: event, Data: b})
+}
+
+func (a *agent) write(msg Message) {
+	a.outMu.Lock()
+	defer a.outMu.Unlock()
+	if err := a.out.Encode(msg); err != nil {
+		rns.Logf(rns.LOG_ERROR, "runcoretest: write control message: %v", err)
+	}
+}
+
+func decodeArgs(req Request, v any) error {
+	if len(req.Args) == 0 {
+		return fmt.Errorf("%s: missing args", req.Op)
+	}
+	if err := json.Unmarshal(req.Args, v); err != nil {
+		return fmt.Errorf("%s: decode args: %w", req.Op, err)
+	}
+	return nil
+}
+
+func opContext(timeoutMs int64) (context.Context, context.CancelFunc) {
+	timeout := defaultOpTimeout
+	if timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func messageIDHex(m *lxmf.LXMessage) string {
+	if m == nil {
+		return ""
+	}
+	if len(m.MessageID) > 0 {
+		return hex.EncodeToString(m.MessageID)
+	}
+	return hex.EncodeToString(m.Hash)
+}
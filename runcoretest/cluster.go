@@ -0,0 +1,608 @@
+package runcoretest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"runcore"
+)
+
+// BinaryEnv overrides the child binary. If unset, cmd/runcore-testnode is built once per
+// Cluster with "go build", which must run from inside the runcore module (as go test does).
+const BinaryEnv = "RUNCORE_TESTNODE"
+
+const testnodePackage = "runcore/cmd/runcore-testnode"
+
+// Config describes a cluster. Node 0 runs a TCP server interface with transport enabled;
+// every other node connects to it with a TCP client interface, so all nodes can reach each other.
+type Config struct {
+	// Nodes is the number of nodes to start (default: 2).
+	Nodes int
+
+	// Dir holds the per-node state directories. If empty, a temporary directory is
+	// created and removed on Close.
+	Dir string
+
+	// Binary is the child executable. Defaults to $RUNCORE_TESTNODE, else a fresh build.
+	Binary string
+
+	// LogLevel is the Reticulum log level of the children (default: 2).
+	LogLevel int
+
+	// Logf receives child log output and harness diagnostics (eg testing.T.Logf). Optional.
+	Logf func(format string, args ...any)
+
+	// StartTimeout bounds the wait for every child to report ready (default: 30s).
+	StartTimeout time.Duration
+}
+
+// Cluster is a set of runcore nodes running as child processes.
+type Cluster struct {
+	cfg     Config
+	dir     string
+	tempDir bool
+	nodes   []*Node
+}
+
+// Node is the parent-side handle of one child process. Methods are safe for concurrent use.
+type Node struct {
+	Index       int
+	Name        string
+	Dir         string
+	DestHex     string
+	IdentityHex string
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	logf  func(format string, args ...any)
+
+	writeMu sync.Mutex
+	nextID  atomic.Uint64
+
+	mu       sync.Mutex
+	pending  map[uint64]chan Message
+	inbound  []Inbound
+	statuses []OutboundStatus
+	changed  chan struct{}
+	exitErr  error
+	exited   bool
+}
+
+// NewCluster writes configs for cfg.Nodes nodes, starts them and waits until all are ready.
+func NewCluster(ctx context.Context, cfg Config) (*Cluster, error) {
+	if cfg.Nodes <= 0 {
+		cfg.Nodes = 2
+	}
+	if cfg.LogLevel == 0 {
+		cfg.LogLevel = 2
+	}
+	if cfg.StartTimeout <= 0 {
+		cfg.StartTimeout = 30 * time.Second
+	}
+	if cfg.Logf == nil {
+		cfg.Logf = func(string, ...any) {}
+	}
+
+	c := &Cluster{cfg: cfg, dir: cfg.Dir}
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "runcoretest-")
+		if err != nil {
+			return nil, fmt.Errorf("create cluster dir: %w", err)
+		}
+		c.dir = dir
+		c.tempDir = true
+	}
+
+	bin, err := c.binary(ctx)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	port, err := freeLoopbackPort()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	for i := 0; i < cfg.Nodes; i++ {
+		n, err := c.startNode(bin, i, port)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.nodes = append(c.nodes, n)
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, cfg.StartTimeout)
+	defer cancel()
+	for _, n := range c.nodes {
+		info, err := n.Info(readyCtx)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("node %d not ready: %w", n.Index, err)
+		}
+		n.DestHex = info.DestHex
+		n.IdentityHex = info.IdentityHex
+	}
+	return c, nil
+}
+
+// Node returns the i-th node.
+func (c *Cluster) Node(i int) *Node { return c.nodes[i] }
+
+// Nodes returns all nodes in start order.
+func (c *Cluster) Nodes() []*Node { return append([]*Node(nil), c.nodes...) }
+
+// Dir returns the directory holding the per-node state directories.
+func (c *Cluster) Dir() string { return c.dir }
+
+// ConnectAll announces every node and waits until each node knows every other identity.
+func (c *Cluster) ConnectAll(ctx context.Context) error {
+	for _, n := range c.nodes {
+		if err := n.Announce(ctx); err != nil {
+			return err
+		}
+	}
+	for _, n := range c.nodes {
+		for _, peer := range c.nodes {
+			if peer == n {
+				continue
+			}
+			if err := n.WaitIdentity(ctx, peer.DestHex); err != nil {
+				return fmt.Errorf("node %d: wait for node %d: %w", n.Index, peer.Index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close shuts all nodes down (killing any that do not exit promptly) and removes the
+// temporary directory if the cluster created one.
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, n := range c.nodes {
+		if err := n.shutdown(5 * time.Second); err != nil {
+			errs = append(errs, fmt.Errorf("node %d: %w", n.Index, err))
+		}
+	}
+	c.nodes = nil
+	if c.tempDir {
+		_ = os.RemoveAll(c.dir)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Cluster) binary(ctx context.Context) (string, error) {
+	if c.cfg.Binary != "" {
+		return c.cfg.Binary, nil
+	}
+	if bin := strings.TrimSpace(os.Getenv(BinaryEnv)); bin != "" {
+		return bin, nil
+	}
+	bin := filepath.Join(c.dir, "runcore-testnode")
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", bin, testnodePackage)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("build %s: %w\n%s", testnodePackage, err, out)
+	}
+	return bin, nil
+}
+
+func (c *Cluster) startNode(bin string, index, port int) (*Node, error) {
+	name := fmt.Sprintf("node%d", index)
+	dir := filepath.Join(c.dir, name)
+	rnsDir := filepath.Join(dir, "rns")
+	if err := os.MkdirAll(rnsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s dir: %w", name, err)
+	}
+	if _, err := runcore.EnsureLXMDConfigWithDisplayName(dir, name); err != nil {
+		return nil, fmt.Errorf("write %s config: %w", name, err)
+	}
+	if err := os.WriteFile(filepath.Join(rnsDir, "config"), []byte(loopbackRNSConfig(index, port, c.cfg.LogLevel)), 0o644); err != nil {
+		return nil, fmt.Errorf("write %s rns config: %w", name, err)
+	}
+
+	ctrlR, ctrlW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("control pipe: %w", err)
+	}
+	cmd := exec.Command(bin,
+		"-dir", dir,
+		"-rnsconfig", rnsDir,
+		"-name", name,
+		"-loglevel", fmt.Sprint(c.cfg.LogLevel),
+	)
+	cmd.ExtraFiles = []*os.File{ctrlW} // fd 3 in the child
+	logf := c.cfg.Logf
+	cmd.Stdout = &lineLogger{prefix: name, logf: logf}
+	cmd.Stderr = cmd.Stdout
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		ctrlR.Close()
+		ctrlW.Close()
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		ctrlR.Close()
+		ctrlW.Close()
+		return nil, fmt.Errorf("start %s: %w", name, err)
+	}
+	ctrlW.Close()
+
+	n := &Node{
+		Index:   index,
+		Name:    name,
+		Dir:     dir,
+		cmd:     cmd,
+		stdin:   stdin,
+		logf:    logf,
+		pending: make(map[uint64]chan Message),
+		changed: make(chan struct{}),
+	}
+	go n.readLoop(ctrlR)
+	return n, nil
+}
+
+// loopbackRNSConfig returns a Reticulum config for node index in a star around node 0.
+func loopbackRNSConfig(index, port, logLevel int) string {
+	var iface string
+	if index == 0 {
+		iface = fmt.Sprintf(`  [[Test Hub]]
+    type = TCPServerInterface
+    interface_enabled = Yes
+    listen_ip = 127.0.0.1
+    listen_port = %d
+`, port)
+	} else {
+		iface = fmt.Sprintf(`  [[Test Client]]
+    type = TCPClientInterface
+    interface_enabled = Yes
+    target_host = 127.0.0.1
+    target_port = %d
+`, port)
+	}
+	transport := "False"
+	if index == 0 {
+		transport = "True"
+	}
+	return fmt.Sprintf(`[reticulum]
+enable_transport = %s
+share_instance = False
+instance_name = runcoretest%d
+
+[logging]
+loglevel = %d
+
+[interfaces]
+%s`, transport, index, logLevel, iface)
+}
+
+func freeLoopbackPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("pick loopback port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Call sends op with args and decodes the result into result (if non-nil).
+func (n *Node) Call(ctx context.Context, op string, args, result any) error {
+	req := Request{ID: n.nextID.Add(1), Op: op}
+	if args != nil {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("%s: encode args: %w", op, err)
+		}
+		req.Args = b
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan Message, 1)
+	n.mu.Lock()
+	if n.exited {
+		err := n.exitErr
+		n.mu.Unlock()
+		return fmt.Errorf("%s: node %d exited: %v", op, n.Index, err)
+	}
+	n.pending[req.ID] = ch
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, req.ID)
+		n.mu.Unlock()
+	}()
+
+	n.writeMu.Lock()
+	_, err = n.stdin.Write(append(line, '\n'))
+	n.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: write request: %w", op, err)
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("%s: node %d exited", op, n.Index)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s: %s", op, msg.Error)
+		}
+		if result != nil && len(msg.Result) > 0 {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("%s: decode result: %w", op, err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+}
+
+// Info returns the node's destination and identity hashes.
+func (n *Node) Info(ctx context.Context) (Info, error) {
+	var info Info
+	err := n.Call(ctx, OpInfo, nil, &info)
+	return info, err
+}
+
+// Announce triggers a delivery announce (sent once an interface is online).
+func (n *Node) Announce(ctx context.Context) error {
+	return n.Call(ctx, OpAnnounce, nil, nil)
+}
+
+// WaitIdentity waits until the node knows the identity of destHex.
+func (n *Node) WaitIdentity(ctx context.Context, destHex string) error {
+	return n.Call(ctx, OpWaitIdentity, destArgs{DestHex: destHex, TimeoutMs: timeoutMs(ctx)}, nil)
+}
+
+// Send sends an opportunistic message and returns its message id.
+func (n *Node) Send(ctx context.Context, destHex, title, content string) (string, error) {
+	var res sendResult
+	err := n.Call(ctx, OpSend, sendArgs{DestHex: destHex, Title: title, Content: content, TimeoutMs: timeoutMs(ctx)}, &res)
+	return res.MessageIDHex, err
+}
+
+// SetDisplayName changes the node's display name (re-announces if it changed).
+func (n *Node) SetDisplayName(ctx context.Context, name string) error {
+	return n.Call(ctx, OpSetDisplayName, nameArgs{Name: name}, nil)
+}
+
+// SetAvatar sets the node's avatar image.
+func (n *Node) SetAvatar(ctx context.Context, mime string, data []byte) error {
+	return n.Call(ctx, OpSetAvatar, blobArgs{Mime: mime, Data: data}, nil)
+}
+
+// ClearAvatar removes the node's avatar.
+func (n *Node) ClearAvatar(ctx context.Context) error {
+	return n.Call(ctx, OpClearAvatar, nil, nil)
+}
+
+// ContactInfo returns announce metadata the node has for destHex.
+func (n *Node) ContactInfo(ctx context.Context, destHex string) (runcore.ContactInfo, error) {
+	var info runcore.ContactInfo
+	err := n.Call(ctx, OpContactInfo, destArgs{DestHex: destHex, TimeoutMs: timeoutMs(ctx)}, &info)
+	return info, err
+}
+
+// ContactAvatar fetches the avatar of destHex.
+func (n *Node) ContactAvatar(ctx context.Context, destHex, knownHashHex string) (runcore.ContactAvatarFetch, error) {
+	var res runcore.ContactAvatarFetch
+	err := n.Call(ctx, OpContactAvatar, avatarArgs{DestHex: destHex, KnownHashHex: knownHashHex, TimeoutMs: timeoutMs(ctx)}, &res)
+	return res, err
+}
+
+// StoreAttachment stores data as an outgoing attachment that peers can fetch by hash.
+func (n *Node) StoreAttachment(ctx context.Context, mime, name string, data []byte) (runcore.AttachmentInfo, error) {
+	var info runcore.AttachmentInfo
+	err := n.Call(ctx, OpStoreAttachment, blobArgs{Mime: mime, Name: name, Data: data}, &info)
+	return info, err
+}
+
+// FetchAttachment fetches an attachment from destHex and returns its contents.
+func (n *Node) FetchAttachment(ctx context.Context, destHex, hashHex string) ([]byte, runcore.AttachmentFetch, error) {
+	var res attachmentResult
+	err := n.Call(ctx, OpFetchAttachment, attachmentArgs{DestHex: destHex, HashHex: hashHex, TimeoutMs: timeoutMs(ctx)}, &res)
+	return res.Data, res.Fetch, err
+}
+
+// Restart restarts the node's LXMF router.
+func (n *Node) Restart(ctx context.Context) error {
+	return n.Call(ctx, OpRestart, nil, nil)
+}
+
+// WaitInbound returns the first received message matching match (nil matches any),
+// including messages received before the call. Each message is returned at most once.
+func (n *Node) WaitInbound(ctx context.Context, match func(Inbound) bool) (Inbound, error) {
+	for {
+		n.mu.Lock()
+		for i, m := range n.inbound {
+			if match == nil || match(m) {
+				n.inbound = append(n.inbound[:i], n.inbound[i+1:]...)
+				n.mu.Unlock()
+				return m, nil
+			}
+		}
+		changed, exited, exitErr := n.changed, n.exited, n.exitErr
+		n.mu.Unlock()
+		if exited {
+			return Inbound{}, fmt.Errorf("node %d exited: %v", n.Index, exitErr)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Inbound{}, ctx.Err()
+		}
+	}
+}
+
+// WaitOutboundStatus waits for a status event of messageIDHex with one of the given states
+// (eg lxmf.MessageDelivered, lxmf.MessageFailed).
+func (n *Node) WaitOutboundStatus(ctx context.Context, messageIDHex string, states ...int) (OutboundStatus, error) {
+	for {
+		n.mu.Lock()
+		for _, s := range n.statuses {
+			if !strings.EqualFold(s.MessageIDHex, messageIDHex) {
+				continue
+			}
+			for _, want := range states {
+				if s.State == want {
+					n.mu.Unlock()
+					return s, nil
+				}
+			}
+		}
+		changed, exited, exitErr := n.changed, n.exited, n.exitErr
+		n.mu.Unlock()
+		if exited {
+			return OutboundStatus{}, fmt.Errorf("node %d exited: %v", n.Index, exitErr)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return OutboundStatus{}, ctx.Err()
+		}
+	}
+}
+
+func (n *Node) readLoop(r *os.File) {
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var msg Message
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			n.logf("%s: bad control line: %v", n.Name, err)
+			continue
+		}
+		if msg.ID != 0 {
+			n.mu.Lock()
+			ch := n.pending[msg.ID]
+			n.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+			continue
+		}
+		n.handleEvent(msg)
+	}
+
+	err := n.cmd.Wait()
+	n.mu.Lock()
+	n.exited = true
+	n.exitErr = err
+	for id, ch := range n.pending {
+		close(ch)
+		delete(n.pending, id)
+	}
+	close(n.changed)
+	n.changed = make(chan struct{})
+	n.mu.Unlock()
+}
+
+func (n *Node) handleEvent(msg Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch msg.Event {
+	case EventInbound:
+		var in Inbound
+		if err := json.Unmarshal(msg.Data, &in); err != nil {
+			n.logf("%s: bad inbound event: %v", n.Name, err)
+			return
+		}
+		n.inbound = append(n.inbound, in)
+	case EventOutboundStatus:
+		var st OutboundStatus
+		if err := json.Unmarshal(msg.Data, &st); err != nil {
+			n.logf("%s: bad status event: %v", n.Name, err)
+			return
+		}
+		n.statuses = append(n.statuses, st)
+	case EventReady:
+		return
+	default:
+		n.logf("%s: unknown event %q", n.Name, msg.Event)
+		return
+	}
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) shutdown(grace time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	_ = n.Call(ctx, OpShutdown, nil, nil)
+	_ = n.stdin.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			n.mu.Lock()
+			exited, changed := n.exited, n.changed
+			n.mu.Unlock()
+			if exited {
+				close(done)
+				return
+			}
+			<-changed
+		}
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		_ = n.cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("killed after %s", grace)
+	}
+}
+
+// timeoutMs forwards the caller's deadline to blocking child operations.
+func timeoutMs(ctx context.Context) int64 {
+	if d, ok := ctx.Deadline(); ok {
+		if ms := time.Until(d).Milliseconds(); ms > 0 {
+			return ms
+		}
+		return 1
+	}
+	return 0
+}
+
+// lineLogger forwards child output to Logf line by line.
+type lineLogger struct {
+	prefix string
+	logf   func(format string, args ...any)
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.logf("%s: %s", l.prefix, bytes.TrimRight(l.buf[:i], "\r"))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
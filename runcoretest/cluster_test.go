@@ -0,0 +1,162 @@
+package runcoretest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"runcore/runcoretest"
+)
+
+func startCluster(t *testing.T, ctx context.Context, nodes int) *runcoretest.Cluster {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("starts runcore child processes")
+	}
+	c, err := runcoretest.NewCluster(ctx, runcoretest.Config{Nodes: nodes, Logf: t.Logf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Logf("close cluster: %v", err)
+		}
+	})
+	return c
+}
+
+// fetchTimeout bounds one link fetch, so a link that never comes up leaves time for the
+// fallback destination within the test's deadline.
+const fetchTimeout = 30 * time.Second
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestClusterAttachment(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	c := startCluster(t, ctx, 2)
+	a, b := c.Node(0), c.Node(1)
+	if err := c.ConnectAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("attachment payload\n")
+	att, err := b.StoreAttachment(ctx, "text/plain", "note.txt", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := b.Send(ctx, a.DestHex, "", att.HashHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := a.WaitInbound(ctx, func(m runcoretest.Inbound) bool {
+		return strings.EqualFold(m.MessageIDHex, id)
+	})
+	if err != nil {
+		t.Fatalf("wait for message %s: %v", id, err)
+	}
+	if !strings.EqualFold(in.SourceHex, b.DestHex) || in.Content != att.HashHex {
+		t.Fatalf("received %+v, want content %s from %s", in, att.HashHex, b.DestHex)
+	}
+
+	fctx, fcancel := context.WithTimeout(ctx, fetchTimeout)
+	data, fetch, err := a.FetchAttachment(fctx, in.SourceHex, in.Content)
+	fcancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetch.NotPresent || !bytes.Equal(data, payload) {
+		t.Fatalf("fetched %q (%+v), want %q", data, fetch, payload)
+	}
+	if fetch.Name != "note.txt" || fetch.Mime != "text/plain" {
+		t.Fatalf("fetched metadata %+v", fetch)
+	}
+
+	fctx, fcancel = context.WithTimeout(ctx, fetchTimeout)
+	_, fetch, err = a.FetchAttachment(fctx, in.SourceHex, strings.Repeat("00", 32))
+	fcancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fetch.NotPresent {
+		t.Fatalf("unknown attachment: %+v, want not present", fetch)
+	}
+}
+
+func TestClusterAvatar(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	c := startCluster(t, ctx, 2)
+	a, b := c.Node(0), c.Node(1)
+
+	avatar := testPNG(t)
+	if err := a.SetAvatar(ctx, "image/png", avatar); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConnectAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// B may know A from an announce sent before the avatar was set.
+	info, err := b.ContactInfo(ctx, a.DestHex)
+	for err == nil && (info.Avatar == nil || info.Avatar.HashHex == "") {
+		if err = a.Announce(ctx); err != nil {
+			break
+		}
+		select {
+		case <-time.After(500 * time.Millisecond):
+			info, err = b.ContactInfo(ctx, a.DestHex)
+		case <-ctx.Done():
+			err = fmt.Errorf("contact info %+v has no avatar: %w", info, ctx.Err())
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fctx, fcancel := context.WithTimeout(ctx, fetchTimeout)
+	fetch, err := b.ContactAvatar(fctx, a.DestHex, "")
+	fcancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetch.Error != "" || fetch.NotPresent || fetch.HashHex != info.Avatar.HashHex {
+		t.Fatalf("avatar fetch %+v, want hash %s", fetch, info.Avatar.HashHex)
+	}
+	data, err := base64.StdEncoding.DecodeString(fetch.DataBase64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, avatar) {
+		t.Fatalf("fetched avatar of %d bytes, want the %d bytes set", len(data), len(avatar))
+	}
+
+	fctx, fcancel = context.WithTimeout(ctx, fetchTimeout)
+	fetch, err = b.ContactAvatar(fctx, a.DestHex, info.Avatar.HashHex)
+	fcancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fetch.Unchanged {
+		t.Fatalf("avatar fetch with known hash %+v, want unchanged", fetch)
+	}
+}
@@ -0,0 +1,128 @@
+// Package runcoretest runs several runcore nodes side by side for integration tests.
+//
+// go-reticulum is a process-wide singleton, so every node runs in its own child process
+// (cmd/runcore-testnode). The children are wired together over loopback TCP interfaces
+// with generated Reticulum configs, and scripted over a line-based JSON control channel
+// (requests on stdin, responses and events on fd 3; stdout/stderr carry logs):
+//
+//	c, err := runcoretest.NewCluster(ctx, runcoretest.Config{Nodes: 2})
+//	if err != nil { t.Fatal(err) }
+//	defer c.Close()
+//	a, b := c.Node(0), c.Node(1)
+//	_ = c.ConnectAll(ctx)
+//	att, _ := a.StoreAttachment(ctx, "text/plain", "note.txt", []byte("hi"))
+//	_, _ = a.Send(ctx, b.DestHex, "", att.HashHex)
+//	in, _ := b.WaitInbound(ctx, nil)
+//	data, _, _ := b.FetchAttachment(ctx, in.SourceHex, in.Content)
+package runcoretest
+
+import (
+	"encoding/json"
+
+	"runcore"
+)
+
+// Control operations understood by the child process.
+const (
+	OpInfo            = "info"
+	OpAnnounce        = "announce"
+	OpWaitIdentity    = "wait_identity"
+	OpSend            = "send"
+	OpSetDisplayName  = "set_display_name"
+	OpSetAvatar       = "set_avatar"
+	OpClearAvatar     = "clear_avatar"
+	OpContactInfo     = "contact_info"
+	OpContactAvatar   = "contact_avatar"
+	OpStoreAttachment = "store_attachment"
+	OpFetchAttachment = "fetch_attachment"
+	OpRestart         = "restart"
+	OpShutdown        = "shutdown"
+)
+
+// Events emitted by the child process without a request.
+const (
+	EventReady          = "ready"
+	EventInbound        = "inbound"
+	EventOutboundStatus = "outbound_status"
+)
+
+// Request is one control command sent to a child (one JSON object per line).
+type Request struct {
+	ID   uint64          `json:"id"`
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// Message is one line emitted by a child: either a response (ID != 0) or an event.
+type Message struct {
+	ID     uint64          `json:"id,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// Info describes a running child node.
+type Info struct {
+	DestHex     string `json:"dest_hex"`
+	IdentityHex string `json:"identity_hex"`
+	Dir         string `json:"dir"`
+}
+
+// Inbound is a message delivered to a child node.
+type Inbound struct {
+	SourceHex    string `json:"source_hex"`
+	MessageIDHex string `json:"message_id_hex"`
+	Title        string `json:"title"`
+	Content      string `json:"content"`
+}
+
+// OutboundStatus reports an LXMF state transition of a message sent with OpSend.
+type OutboundStatus struct {
+	DestHex      string `json:"dest_hex"`
+	MessageIDHex string `json:"message_id_hex"`
+	State        int    `json:"state"`
+}
+
+type destArgs struct {
+	DestHex   string `json:"dest_hex"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"`
+}
+
+type sendArgs struct {
+	DestHex   string `json:"dest_hex"`
+	Title     string `json:"title,omitempty"`
+	Content   string `json:"content,omitempty"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"`
+}
+
+type sendResult struct {
+	MessageIDHex string `json:"message_id_hex"`
+}
+
+type nameArgs struct {
+	Name string `json:"name"`
+}
+
+type blobArgs struct {
+	Mime string `json:"mime,omitempty"`
+	Name string `json:"name,omitempty"`
+	Data []byte `json:"data"`
+}
+
+type avatarArgs struct {
+	DestHex      string `json:"dest_hex"`
+	KnownHashHex string `json:"known_hash_hex,omitempty"`
+	TimeoutMs    int64  `json:"timeout_ms,omitempty"`
+}
+
+type attachmentArgs struct {
+	DestHex   string `json:"dest_hex"`
+	HashHex   string `json:"hash_hex"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"`
+}
+
+type attachmentResult struct {
+	Fetch runcore.AttachmentFetch `json:"fetch"`
+	Data  []byte                  `json:"data,omitempty"`
+}
@@ -0,0 +1,214 @@
+package runcore
+
+import (
+	"crypto/sha256"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/configobj"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+const (
+	defaultAnnounceInterval      = 15 * time.Minute
+	defaultAnnounceBackoffFactor = 8
+	defaultAnnounceJitter        = 0.1
+)
+
+// announceSchedule drives one periodic announce (delivery or propagation).
+// While the fingerprint of the announced data stays unchanged, the period doubles
+// up to max; any change (or an explicit kick) resets it to base.
+type announceSchedule struct {
+	label  string
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+	kick   chan string
+
+	mu       sync.Mutex
+	current  time.Duration
+	lastSum  [32]byte
+	haveLast bool
+}
+
+func newAnnounceSchedule(label string, base, max time.Duration, jitter float64) *announceSchedule {
+	if max < base {
+		max = base
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 0.5 {
+		jitter = 0.5
+	}
+	return &announceSchedule{
+		label:   label,
+		base:    base,
+		max:     max,
+		jitter:  jitter,
+		kick:    make(chan string, 1),
+		current: base,
+	}
+}
+
+// nextDelay returns the current period with random jitter applied.
+func (s *announceSchedule) nextDelay() time.Duration {
+	s.mu.Lock()
+	d := s.current
+	s.mu.Unlock()
+	if s.jitter > 0 {
+		f := 1 + s.jitter*(2*rand.Float64()-1)
+		d = time.Duration(float64(d) * f)
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// observe records the fingerprint announced on a periodic tick and adjusts the period.
+func (s *announceSchedule) observe(fingerprint []byte) {
+	sum := sha256.Sum256(fingerprint)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.haveLast && sum == s.lastSum {
+		next := s.current * 2
+		if next > s.max {
+			next = s.max
+		}
+		s.current = next
+	} else {
+		s.current = s.base
+	}
+	s.lastSum = sum
+	s.haveLast = true
+}
+
+// reset drops the backoff, remembering fingerprint as the last announced state.
+func (s *announceSchedule) reset(fingerprint []byte) {
+	s.mu.Lock()
+	s.current = s.base
+	if fingerprint != nil {
+		s.lastSum = sha256.Sum256(fingerprint)
+		s.haveLast = true
+	}
+	s.mu.Unlock()
+}
+
+// request asks the schedule to announce right away. Never blocks; a pending kick absorbs later ones.
+func (s *announceSchedule) request(reason string) {
+	select {
+	case s.kick <- reason:
+	default:
+	}
+}
+
+// run blocks until stop is closed. fingerprint may be nil to disable backoff.
+func (s *announceSchedule) run(stop <-chan struct{}, fingerprint func() []byte, fire func(reason string)) {
+	timer := time.NewTimer(s.nextDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if fingerprint != nil {
+				s.observe(fingerprint())
+			}
+			fire("periodic")
+		case reason := <-s.kick:
+			var fp []byte
+			if fingerprint != nil {
+				fp = fingerprint()
+			}
+			s.reset(fp)
+			fire(reason)
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-stop:
+			return
+		}
+		next := s.nextDelay()
+		rns.Logf(rns.LOG_DEBUG, "Announce schedule %s: next in %s", s.label, next.Round(time.Second))
+		timer.Reset(next)
+	}
+}
+
+// resolveAnnounceInterval applies Options overrides on top of the lxmd-style config.
+// A negative result disables the schedule.
+func resolveAnnounceInterval(opt time.Duration, cfg *configobj.Config, section string, def time.Duration) time.Duration {
+	if opt != 0 {
+		return opt
+	}
+	if cfg == nil || !cfg.HasSection(section) {
+		return def
+	}
+	sec := cfg.Section(section)
+	if _, ok := sec.Get("announce_interval"); !ok {
+		return def
+	}
+	minutes, err := sec.AsInt("announce_interval")
+	if err != nil {
+		return def
+	}
+	// lxmd semantics: 0 disables periodic announces.
+	if minutes <= 0 {
+		return -1
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func (n *Node) startAnnounceSchedules() {
+	if n == nil {
+		return
+	}
+	cfg, _, err := LoadLXMDConfig(n.opts.Dir)
+	if err != nil {
+		cfg = nil
+	}
+	jitter := n.opts.AnnounceJitter
+	if jitter == 0 {
+		jitter = defaultAnnounceJitter
+	}
+
+	if base := resolveAnnounceInterval(n.opts.AnnounceInterval, cfg, "lxmf", defaultAnnounceInterval); base > 0 {
+		max := n.opts.AnnounceMaxInterval
+		if max <= 0 {
+			max = base * defaultAnnounceBackoffFactor
+		}
+		n.deliverySchedule = newAnnounceSchedule("delivery", base, max, jitter)
+		rns.Logf(rns.LOG_DEBUG, "Announce schedule delivery: base=%s max=%s jitter=%.2f", base, max, jitter)
+		go n.deliverySchedule.run(n.announceStop, n.announceAppData, n.AnnounceDeliveryWithReason)
+	}
+
+	// Only nodes running a propagation node set PropagationAnnounceInterval.
+	if base := n.opts.PropagationAnnounceInterval; base > 0 {
+		// Propagation app-data carries a timebase, so it never compares equal: no backoff.
+		n.propagationSchedule = newAnnounceSchedule("propagation", base, base, jitter)
+		go n.propagationSchedule.run(n.announceStop, nil, n.announcePropagationWithReason)
+	}
+}
+
+// requestAnnounce re-announces the delivery destination now and restarts its backoff.
+func (n *Node) requestAnnounce(reason string) {
+	if n == nil {
+		return
+	}
+	if n.deliverySchedule != nil {
+		n.deliverySchedule.request(reason)
+		return
+	}
+	n.AnnounceDeliveryWithReason(reason)
+}
+
+func (n *Node) announcePropagationWithReason(reason string) {
+	router := n.currentRouter()
+	if router == nil || !router.PropagationNode || router.PropagationDestination == nil {
+		return
+	}
+	rns.Logf(rns.LOG_NOTICE, "Announce tx propagation dest=%s reason=%s", rns.PrettyHexRep(router.PropagationDestination.Hash()), reason)
+	router.AnnouncePropagationNode()
+}
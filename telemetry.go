@@ -0,0 +1,517 @@
+package runcore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
+)
+
+// Telemetry in Sideband's format: FIELD_TELEMETRY holds msgpack {sensor_id: packed_sensor}
+// (time, location and battery are understood), FIELD_TELEMETRY_STREAM a collector's
+// relayed telemetry of other peers, and a FIELD_COMMANDS telemetry request asks for ours.
+// The last telemetry of each peer is kept in Dir/telemetry.json. Streams are only accepted
+// from the collector set with SetTelemetryCollector, or in answer to a RequestTelemetry.
+// Requests are answered with the telemetry set by SetTelemetry, only for contacts allowed
+// with SetContactTelemetryAccess (nobody by default). Messages carrying nothing but
+// telemetry or commands never reach the inbound handler.
+
+const (
+	// Sideband sensor IDs (sideband/sense.py).
+	sensorTime     = 0x01
+	sensorLocation = 0x02
+	sensorBattery  = 0x04
+
+	// commandTelemetryRequest is Sideband's Commands.TELEMETRY_REQUEST.
+	commandTelemetryRequest = 0x01
+
+	maxTelemetryPeers   = 1024
+	telemetryConfigFile = "telemetry.json"
+	// telemetryRequestWindow is how long a peer we asked may answer with a stream.
+	telemetryRequestWindow = time.Hour
+)
+
+// Telemetry is a set of sensor readings. Nil sensors are absent.
+type Telemetry struct {
+	Time     int64              `json:"time,omitempty"` // unix seconds of the readings
+	Location *TelemetryLocation `json:"location,omitempty"`
+	Battery  *TelemetryBattery  `json:"battery,omitempty"`
+}
+
+// TelemetryLocation is a position fix.
+type TelemetryLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"` // meters
+	Speed     float64 `json:"speed"`    // km/h
+	Bearing   float64 `json:"bearing"`  // degrees
+	Accuracy  float64 `json:"accuracy"` // meters
+	UpdatedAt int64   `json:"updated_at,omitempty"`
+}
+
+// TelemetryBattery is the battery state.
+type TelemetryBattery struct {
+	ChargePercent float64  `json:"charge_percent"`
+	Charging      bool     `json:"charging"`
+	Temperature   *float64 `json:"temperature,omitempty"` // degrees Celsius
+}
+
+// PeerTelemetry is the last telemetry received for a peer. ViaHex is set when a
+// collector relayed it rather than the peer itself.
+type PeerTelemetry struct {
+	DestinationHashHex string `json:"destination_hash_hex"`
+	Telemetry
+	ReceivedAt int64  `json:"received_at"`
+	ViaHex     string `json:"via_hex,omitempty"`
+}
+
+// telemetryState is the node's telemetry state; peers, allowed and collector persist to
+// Dir/telemetry.json, own and requested only live in memory.
+type telemetryState struct {
+	mu        sync.Mutex
+	loaded    bool
+	own       *Telemetry
+	peers     map[string]PeerTelemetry
+	allowed   map[string]bool
+	collector string
+	requested map[string]time.Time // peers sent a request, by time of the request
+	onUpdate  func(PeerTelemetry)
+}
+
+type telemetryConfig struct {
+	Peers     map[string]PeerTelemetry `json:"peers,omitempty"`
+	Allowed   []string                 `json:"allowed,omitempty"`
+	Collector string                   `json:"collector,omitempty"`
+}
+
+func (t Telemetry) validate() error {
+	if t.Time < 0 {
+		return errors.New("invalid telemetry time")
+	}
+	if l := t.Location; l != nil {
+		if l.Latitude < -90 || l.Latitude > 90 || l.Longitude < -180 || l.Longitude > 180 {
+			return errors.New("coordinates out of range")
+		}
+		for _, f := range []float64{l.Altitude, l.Speed, l.Bearing, l.Accuracy} {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return errors.New("invalid location")
+			}
+		}
+	}
+	if b := t.Battery; b != nil && (b.ChargePercent < 0 || b.ChargePercent > 100) {
+		return errors.New("invalid battery charge")
+	}
+	return nil
+}
+
+// pack encodes t like Sideband's Telemeter.packed(). Values outside the range of the
+// wire format are clamped.
+func (t Telemetry) pack() ([]byte, error) {
+	m := map[any]any{}
+	ts := t.Time
+	if ts == 0 {
+		ts = time.Now().Unix()
+	}
+	m[sensorTime] = ts
+	if l := t.Location; l != nil {
+		i32 := func(f, scale float64) []byte {
+			return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(f*scale))))))
+		}
+		updated := l.UpdatedAt
+		if updated == 0 {
+			updated = ts
+		}
+		m[sensorLocation] = []any{
+			i32(l.Latitude, 1e6),
+			i32(l.Longitude, 1e6),
+			i32(l.Altitude, 1e2),
+			binary.BigEndian.AppendUint32(nil, uint32(math.Max(0, math.Min(math.MaxUint32, math.Round(l.Speed*1e2))))),
+			i32(l.Bearing, 1e2),
+			binary.BigEndian.AppendUint16(nil, uint16(math.Max(0, math.Min(math.MaxUint16, math.Round(l.Accuracy*1e2))))),
+			updated,
+		}
+	}
+	if b := t.Battery; b != nil {
+		var temp any
+		if b.Temperature != nil {
+			temp = *b.Temperature
+		}
+		m[sensorBattery] = []any{math.Round(b.ChargePercent*10) / 10, b.Charging, temp}
+	}
+	return umsgpack.Packb(m)
+}
+
+func (n *Node) telemetryPath() string {
+	return filepath.Join(n.opts.Dir, telemetryConfigFile)
+}
+
+// loadTelemetryLocked reads Dir/telemetry.json once; n.telemetry.mu is held.
+func (n *Node) loadTelemetryLocked() {
+	ts := &n.telemetry
+	if ts.loaded {
+		return
+	}
+	ts.loaded = true
+	ts.peers = make(map[string]PeerTelemetry)
+	ts.allowed = make(map[string]bool)
+	ts.requested = make(map[string]time.Time)
+	b, err := os.ReadFile(n.telemetryPath())
+	if err != nil {
+		return
+	}
+	var cfg telemetryConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "telemetry: parse %s: %v", telemetryConfigFile, err)
+		return
+	}
+	for k, p := range cfg.Peers {
+		if validHashHex(k, lxmf.DestinationLength) {
+			p.DestinationHashHex = k
+			ts.peers[k] = p
+		}
+	}
+	for _, k := range cfg.Allowed {
+		if validHashHex(k, lxmf.DestinationLength) {
+			ts.allowed[k] = true
+		}
+	}
+	if validHashHex(cfg.Collector, lxmf.DestinationLength) {
+		ts.collector = cfg.Collector
+	}
+}
+
+// saveTelemetryLocked persists peer telemetry and access; n.telemetry.mu is held.
+func (n *Node) saveTelemetryLocked() error {
+	ts := &n.telemetry
+	cfg := telemetryConfig{Peers: ts.peers, Collector: ts.collector}
+	for k := range ts.allowed {
+		cfg.Allowed = append(cfg.Allowed, k)
+	}
+	sort.Strings(cfg.Allowed)
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(n.telemetryPath(), b)
+}
+
+// SetTelemetry sets the telemetry sent in answer to requests from allowed contacts
+// (not persisted). Nil stops answering. A zero Time is sent as the time of the answer.
+func (n *Node) SetTelemetry(t *Telemetry) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	if t != nil {
+		if err := t.validate(); err != nil {
+			return err
+		}
+		c := *t
+		t = &c
+	}
+	n.telemetry.mu.Lock()
+	n.telemetry.own = t
+	n.telemetry.mu.Unlock()
+	return nil
+}
+
+// SetContactTelemetryAccess allows or denies a contact to request our telemetry. The
+// setting is persisted under Dir.
+func (n *Node) SetContactTelemetryAccess(destinationHashHex string, allowed bool) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if !validHashHex(destHex, lxmf.DestinationLength) {
+		return errors.New("invalid destination hash")
+	}
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	if allowed {
+		ts.allowed[destHex] = true
+	} else {
+		delete(ts.allowed, destHex)
+	}
+	return n.saveTelemetryLocked()
+}
+
+// TelemetryAccess returns the contacts allowed to request our telemetry.
+func (n *Node) TelemetryAccess() []string {
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	out := make([]string, 0, len(ts.allowed))
+	for k := range ts.allowed {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SetTelemetryCollector sets the collector whose telemetry streams (other peers'
+// telemetry it relays) are accepted at any time; "" clears it. The setting is persisted
+// under Dir.
+func (n *Node) SetTelemetryCollector(destinationHashHex string) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	if destHex != "" && !validHashHex(destHex, lxmf.DestinationLength) {
+		return errors.New("invalid destination hash")
+	}
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	ts.collector = destHex
+	return n.saveTelemetryLocked()
+}
+
+// TelemetryCollector returns the collector set with SetTelemetryCollector, or "".
+func (n *Node) TelemetryCollector() string {
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	return ts.collector
+}
+
+// SendTelemetry sends t to a peer in FIELD_TELEMETRY. It is sent whatever the access
+// settings, and not stored in the conversation.
+func (n *Node) SendTelemetry(destinationHashHex string, t Telemetry) (*lxmf.LXMessage, error) {
+	if n == nil {
+		return nil, errors.New("node not started")
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	packed, err := t.pack()
+	if err != nil {
+		return nil, err
+	}
+	return n.send(strings.ToLower(strings.TrimSpace(destinationHashHex)), SendOptions{
+		Fields: map[any]any{lxmf.FieldTelemetry: packed},
+	})
+}
+
+// RequestTelemetry asks a peer for its telemetry with a Sideband telemetry request. Peers
+// that allow us answer with a telemetry message, reported to the telemetry handler; a
+// collector may answer with a stream, accepted within telemetryRequestWindow.
+func (n *Node) RequestTelemetry(destinationHashHex string) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	_, err := n.send(destHex, SendOptions{
+		Fields: map[any]any{lxmf.FieldCommands: []any{
+			map[any]any{commandTelemetryRequest: []any{time.Now().Unix(), false}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	now := time.Now()
+	for k, at := range ts.requested {
+		if now.Sub(at) > telemetryRequestWindow {
+			delete(ts.requested, k)
+		}
+	}
+	ts.requested[destHex] = now
+	return nil
+}
+
+// acceptTelemetryStream reports whether a stream from srcHex is accepted: it is the
+// collector, or a peer we asked within telemetryRequestWindow (one stream per request).
+func (n *Node) acceptTelemetryStream(srcHex string) bool {
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	if srcHex == ts.collector {
+		return true
+	}
+	at, ok := ts.requested[srcHex]
+	if !ok {
+		return false
+	}
+	delete(ts.requested, srcHex)
+	return time.Since(at) <= telemetryRequestWindow
+}
+
+// PeerTelemetry returns the last telemetry received for a peer.
+func (n *Node) PeerTelemetry(destinationHashHex string) (PeerTelemetry, bool) {
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	ts := &n.telemetry
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n.loadTelemetryLocked()
+	p, ok := ts.peers[destHex]
+	return p, ok
+}
+
+// PeerTelemetryList returns the last telemetry of every peer, most recent first.
+func (n *Node) PeerTelemetryList() []PeerTelemetry {
+	ts := &n.telemetry
+	ts.mu.Lock()
+	n.loadTelemetryLocked()
+	out := make([]PeerTelemetry, 0, len(ts.peers))
+	for _, p := range ts.peers {
+		out = append(out, p)
+	}
+	ts.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ReceivedAt > out[j].ReceivedAt })
+	return out
+}
+
+// SetTelemetryHandler sets the callback for telemetry received from peers. It runs on a
+// router goroutine and must not block for long.
+func (n *Node) SetTelemetryHandler(cb func(PeerTelemetry)) {
+	n.telemetry.mu.Lock()
+	n.telemetry.onUpdate = cb
+	n.telemetry.mu.Unlock()
+}
+
+// handleTelemetry records telemetry carried by m and answers telemetry requests. It
+// reports whether m carried nothing else, so it is not delivered as a message.
+func (n *Node) handleTelemetry(m *lxmf.LXMessage) bool {
+	tv, hasTelemetry := LXMFField(m.Fields, lxmf.FieldTelemetry)
+	sv, hasStream := LXMFField(m.Fields, lxmf.FieldTelemetryStream)
+	cv, hasCommands := LXMFField(m.Fields, lxmf.FieldCommands)
+	if !hasTelemetry && !hasStream && !hasCommands {
+		return false
+	}
+	only := telemetryOnly(m)
+	srcHex := hex.EncodeToString(m.SourceHash)
+	if !m.SignatureValidated {
+		rns.Logf(rns.LOG_NOTICE, "telemetry: ignored unsigned telemetry src=%s", srcHex)
+		return only
+	}
+	now := time.Now().Unix()
+	var updates []PeerTelemetry
+	if hasTelemetry {
+		if t, err := decodeTelemetry(tv); err == nil {
+			updates = append(updates, PeerTelemetry{DestinationHashHex: srcHex, Telemetry: t, ReceivedAt: now})
+		}
+	}
+	if hasStream {
+		if !n.acceptTelemetryStream(srcHex) {
+			rns.Logf(rns.LOG_NOTICE, "telemetry: ignored unrequested stream src=%s", srcHex)
+		} else if entries, err := decodeTelemetryStream(sv); err == nil {
+			self := n.DestinationHashHex()
+			for _, e := range entries {
+				peerHex := hex.EncodeToString(e.Source)
+				if peerHex == self || peerHex == srcHex {
+					continue
+				}
+				if e.Telemetry.Time == 0 {
+					e.Telemetry.Time = e.Timestamp
+				}
+				updates = append(updates, PeerTelemetry{DestinationHashHex: peerHex, Telemetry: e.Telemetry, ReceivedAt: now, ViaHex: srcHex})
+			}
+		}
+	}
+	n.storePeerTelemetry(updates)
+	if hasCommands {
+		if req, err := decodeTelemetryRequest(cv); err == nil && req {
+			n.answerTelemetryRequest(srcHex)
+		}
+	}
+	return only
+}
+
+// telemetryOnly reports whether m has no title, content or fields other than telemetry,
+// commands and appearance (which Sideband adds to telemetry messages).
+func telemetryOnly(m *lxmf.LXMessage) bool {
+	if m.TitleAsString() != "" || strings.TrimSpace(m.ContentAsString()) != "" {
+		return false
+	}
+	for k := range m.Fields {
+		switch id, _ := wireInt(k); id {
+		case lxmf.FieldTelemetry, lxmf.FieldTelemetryStream, lxmf.FieldCommands, lxmf.FieldIconAppearance:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// storePeerTelemetry keeps each of updates as the peer's last telemetry, saving once.
+// Relayed telemetry only replaces older readings.
+func (n *Node) storePeerTelemetry(updates []PeerTelemetry) {
+	if len(updates) == 0 {
+		return
+	}
+	ts := &n.telemetry
+	ts.mu.Lock()
+	n.loadTelemetryLocked()
+	var stored []PeerTelemetry
+	for _, p := range updates {
+		if old, ok := ts.peers[p.DestinationHashHex]; ok && p.ViaHex != "" && old.Time >= p.Time {
+			continue
+		}
+		ts.peers[p.DestinationHashHex] = p
+		stored = append(stored, p)
+	}
+	if len(stored) == 0 {
+		ts.mu.Unlock()
+		return
+	}
+	for len(ts.peers) > maxTelemetryPeers {
+		oldest := ""
+		for k, q := range ts.peers {
+			if oldest == "" || q.ReceivedAt < ts.peers[oldest].ReceivedAt {
+				oldest = k
+			}
+		}
+		delete(ts.peers, oldest)
+	}
+	if err := n.saveTelemetryLocked(); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "telemetry: save failed: %v", err)
+	}
+	cb := ts.onUpdate
+	ts.mu.Unlock()
+	for _, p := range stored {
+		rns.Logf(rns.LOG_DEBUG, "telemetry: update for %s", p.DestinationHashHex)
+		if cb != nil {
+			cb(p)
+		}
+	}
+}
+
+// answerTelemetryRequest sends our telemetry to srcHex if it is allowed to ask for it.
+func (n *Node) answerTelemetryRequest(srcHex string) {
+	ts := &n.telemetry
+	ts.mu.Lock()
+	n.loadTelemetryLocked()
+	allowed := ts.allowed[srcHex]
+	own := ts.own
+	ts.mu.Unlock()
+	if !allowed {
+		rns.Logf(rns.LOG_NOTICE, "telemetry: refused request from %s", srcHex)
+		return
+	}
+	if own == nil {
+		rns.Logf(rns.LOG_DEBUG, "telemetry: request from %s, but no telemetry set", srcHex)
+		return
+	}
+	if _, err := n.SendTelemetry(srcHex, *own); err != nil {
+		rns.Logf(rns.LOG_NOTICE, "telemetry: answer to %s failed: %v", srcHex, err)
+	}
+}
@@ -0,0 +1,184 @@
+package runcore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// AvatarThumbnailSize is the edge length (px) of generated avatar thumbnails.
+	AvatarThumbnailSize = 64
+
+	// CapAvatarThumbnail: /avatar accepts {"px": n} and serves a thumbnail when n <= its size.
+	CapAvatarThumbnail = "avatar_thumb"
+
+	maxAvatarThumbBytes = 64 << 10
+	// maxThumbnailSourcePixels bounds decoding of app-supplied avatars (memory): 16 MP is
+	// 64 MiB as RGBA.
+	maxThumbnailSourcePixels = 16 << 20
+	thumbnailJPEGQuality     = 80
+)
+
+func init() { registerCapability(CapAvatarThumbnail) }
+
+// makeAvatarThumbnail center-crops data to a square and scales it down to
+// AvatarThumbnailSize. Only PNG and JPEG can be decoded (pure Go); other formats
+// (eg HEIC) need an app-supplied thumbnail (SetAvatarImageWithThumbnail).
+// Opaque images are encoded as JPEG, others as PNG.
+func makeAvatarThumbnail(data []byte) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode avatar: %w", err)
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, "", fmt.Errorf("unsupported avatar format %q", format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxThumbnailSourcePixels {
+		return nil, "", fmt.Errorf("avatar dimensions %dx%d out of range", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode avatar: %w", err)
+	}
+
+	thumb := scaleSquare(src, AvatarThumbnailSize)
+	var buf bytes.Buffer
+	mime := "image/png"
+	if thumb.Opaque() {
+		mime = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("encode thumbnail: %w", err)
+	}
+	if buf.Len() > maxAvatarThumbBytes {
+		return nil, "", errors.New("thumbnail too large")
+	}
+	return buf.Bytes(), mime, nil
+}
+
+// scaleSquare crops the centered square of src and box-filters it to size x size.
+// Images smaller than size are scaled up (nearest neighbour).
+func scaleSquare(src image.Image, size int) *image.NRGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	sumRow := rowSummer(src)
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		sy0 := y0 + dy*side/size
+		sy1 := y0 + (dy+1)*side/size
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for dx := 0; dx < size; dx++ {
+			sx0 := x0 + dx*side/size
+			sx1 := x0 + (dx+1)*side/size
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			// Average premultiplied values, then un-premultiply.
+			var acc [4]uint64
+			for sy := sy0; sy < sy1; sy++ {
+				sumRow(&acc, sy, sx0, sx1)
+			}
+			n := uint64((sy1 - sy0) * (sx1 - sx0))
+			r, g, bl, a := acc[0]/n, acc[1]/n, acc[2]/n, acc[3]/n
+			c := color.NRGBA{A: uint8(a >> 8)}
+			if a > 0 {
+				c.R = uint8((r * 0xffff / a) >> 8)
+				c.G = uint8((g * 0xffff / a) >> 8)
+				c.B = uint8((bl * 0xffff / a) >> 8)
+			}
+			dst.SetNRGBA(dx, dy, c)
+		}
+	}
+	return dst
+}
+
+// rowSummer returns a function adding the premultiplied 16-bit RGBA of the pixels [x0, x1)
+// of row y to acc. The types the PNG and JPEG decoders return are read from their pixel
+// buffers; anything else goes through At.
+func rowSummer(src image.Image) func(acc *[4]uint64, y, x0, x1 int) {
+	switch img := src.(type) {
+	case *image.RGBA:
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			p := img.Pix[img.PixOffset(x0, y):img.PixOffset(x1, y)]
+			for i := 0; i+3 < len(p); i += 4 {
+				acc[0] += uint64(p[i]) * 0x101
+				acc[1] += uint64(p[i+1]) * 0x101
+				acc[2] += uint64(p[i+2]) * 0x101
+				acc[3] += uint64(p[i+3]) * 0x101
+			}
+		}
+	case *image.NRGBA:
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			p := img.Pix[img.PixOffset(x0, y):img.PixOffset(x1, y)]
+			for i := 0; i+3 < len(p); i += 4 {
+				a := uint64(p[i+3]) * 0x101
+				acc[0] += uint64(p[i]) * a / 0xff
+				acc[1] += uint64(p[i+1]) * a / 0xff
+				acc[2] += uint64(p[i+2]) * a / 0xff
+				acc[3] += a
+			}
+		}
+	case *image.Gray:
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			for _, v := range img.Pix[img.PixOffset(x0, y):img.PixOffset(x1, y)] {
+				c := uint64(v) * 0x101
+				acc[0] += c
+				acc[1] += c
+				acc[2] += c
+				acc[3] += 0xffff
+			}
+		}
+	case *image.YCbCr:
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			for x := x0; x < x1; x++ {
+				ci := img.COffset(x, y)
+				r, g, b := color.YCbCrToRGB(img.Y[img.YOffset(x, y)], img.Cb[ci], img.Cr[ci])
+				acc[0] += uint64(r) * 0x101
+				acc[1] += uint64(g) * 0x101
+				acc[2] += uint64(b) * 0x101
+				acc[3] += 0xffff
+			}
+		}
+	case *image.Paletted:
+		palette := make([][4]uint64, 256)
+		for i, c := range img.Palette {
+			r, g, b, a := c.RGBA()
+			palette[i] = [4]uint64{uint64(r), uint64(g), uint64(b), uint64(a)}
+		}
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			for _, v := range img.Pix[img.PixOffset(x0, y):img.PixOffset(x1, y)] {
+				c := palette[v]
+				acc[0] += c[0]
+				acc[1] += c[1]
+				acc[2] += c[2]
+				acc[3] += c[3]
+			}
+		}
+	default:
+		return func(acc *[4]uint64, y, x0, x1 int) {
+			for x := x0; x < x1; x++ {
+				r, g, b, a := src.At(x, y).RGBA()
+				acc[0] += uint64(r)
+				acc[1] += uint64(g)
+				acc[2] += uint64(b)
+				acc[3] += uint64(a)
+			}
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package runcore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+const (
+	profileFieldsReq = "/profile"
+
+	// CapProfile: serves /profile (status, bio, pronouns, links).
+	CapProfile = "profile"
+
+	profileHashLen = 16
+)
+
+func init() { registerCapability(CapProfile) }
+
+// Profile holds the extended profile fields published via /profile.
+// Peers detect changes by the hash advertised in announces (like avatars).
+type Profile struct {
+	Status   string        `json:"status,omitempty"`
+	Bio      string        `json:"bio,omitempty"`
+	Pronouns string        `json:"pronouns,omitempty"`
+	Links    []ProfileLink `json:"links,omitempty"`
+	Updated  int64         `json:"updated,omitempty"`
+}
+
+type ProfileLink struct {
+	Label string `json:"label,omitempty"`
+	URL   string `json:"url"`
+}
+
+// ContactProfile is a peer's profile as cached under Dir/profiles.
+type ContactProfile struct {
+	Profile
+	HashHex    string `json:"hash_hex,omitempty"`
+	Fetched    int64  `json:"fetched,omitempty"`
+	NotPresent bool   `json:"not_present,omitempty"`
+}
+
+func (p Profile) isZero() bool {
+	return p.Status == "" && p.Bio == "" && p.Pronouns == "" && len(p.Links) == 0
+}
+
+// normalized trims fields and drops empty links.
+func (p Profile) normalized() Profile {
+	out := Profile{
+		Status:   strings.TrimSpace(p.Status),
+		Bio:      strings.TrimSpace(p.Bio),
+		Pronouns: strings.TrimSpace(p.Pronouns),
+		Updated:  p.Updated,
+	}
+	for _, l := range p.Links {
+		l.Label = strings.TrimSpace(l.Label)
+		l.URL = strings.TrimSpace(l.URL)
+		if l.URL != "" {
+			out.Links = append(out.Links, l)
+		}
+	}
+	return out
+}
+
+func (p Profile) validate() error {
+	if !validProfileText(p.Status, maxProfileStatusLen, false) {
+		return fmt.Errorf("status: at most %d bytes of single-line text", maxProfileStatusLen)
+	}
+	if !validProfileText(p.Bio, maxProfileBioLen, true) {
+		return fmt.Errorf("bio: at most %d bytes of text", maxProfileBioLen)
+	}
+	if !validProfileText(p.Pronouns, maxProfilePronounsLen, false) {
+		return fmt.Errorf("pronouns: at most %d bytes of single-line text", maxProfilePronounsLen)
+	}
+	if len(p.Links) > maxProfileLinks {
+		return fmt.Errorf("at most %d links", maxProfileLinks)
+	}
+	for _, l := range p.Links {
+		if !validProfileText(l.Label, maxProfileLinkLabelLen, false) || !validProfileText(l.URL, maxProfileLinkURLLen, false) {
+			return errors.New("invalid link")
+		}
+	}
+	return nil
+}
+
+func validProfileText(s string, maxLen int, multiline bool) bool {
+	if s == "" {
+		return true
+	}
+	_, ok := wireTextOpt(s, maxLen, multiline)
+	return ok
+}
+
+// hash identifies the profile content (Updated excluded): sha256 of its JSON, truncated.
+// The JSON is spelled out in docs/PROFILE.md; HTML escaping is off, as json.Marshal's
+// \u0026 for "&" (common in link URLs) is not something other implementations produce.
+func (p Profile) hash() []byte {
+	if p.isZero() {
+		return nil
+	}
+	p.Updated = 0
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(p)
+	sum := sha256.Sum256(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return sum[:profileHashLen]
+}
+
+// wire returns the /profile response fields.
+func (p Profile) wire() map[any]any {
+	links := make([]any, 0, len(p.Links))
+	for _, l := range p.Links {
+		links = append(links, []any{l.Label, l.URL})
+	}
+	return map[any]any{
+		"status":   p.Status,
+		"bio":      p.Bio,
+		"pronouns": p.Pronouns,
+		"links":    links,
+		"u":        p.Updated,
+	}
+}
+
+// userProfileState is an immutable snapshot of the local profile.
+type userProfileState struct {
+	profile Profile
+	hash    []byte
+}
+
+var emptyUserProfile = &userProfileState{}
+
+func (n *Node) userProfileSnapshot() *userProfileState {
+	if n == nil {
+		return emptyUserProfile
+	}
+	if p := n.userProfile.Load(); p != nil {
+		return p
+	}
+	return emptyUserProfile
+}
+
+// Profile returns this node's extended profile.
+func (n *Node) Profile() Profile {
+	p := n.userProfileSnapshot().profile
+	p.Links = append([]ProfileLink(nil), p.Links...)
+	return p
+}
+
+// SetProfile replaces this node's extended profile, persists it to Dir/profile.json and
+// re-announces if the content changed. An empty Profile clears it.
+func (n *Node) SetProfile(p Profile) error {
+	if n == nil {
+		return errors.New("node not started")
+	}
+	p = p.normalized()
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	n.profileMu.Lock()
+	defer n.profileMu.Unlock()
+	prev := n.userProfileSnapshot()
+	hash := p.hash()
+	if bytes.Equal(prev.hash, hash) {
+		return nil
+	}
+	if p.isZero() {
+		if err := os.Remove(n.userProfilePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove profile: %w", err)
+		}
+		n.userProfile.Store(emptyUserProfile)
+	} else {
+		p.Updated = time.Now().Unix()
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(n.userProfilePath(), b); err != nil {
+			return fmt.Errorf("save profile: %w", err)
+		}
+		n.userProfile.Store(&userProfileState{profile: p, hash: hash})
+	}
+	n.requestAnnounce("profile_changed")
+	n.scheduleProfilePush()
+	return nil
+}
+
+func (n *Node) userProfilePath() string {
+	return filepath.Join(n.opts.Dir, "profile.json")
+}
+
+func (n *Node) loadUserProfileFromDisk() error {
+	b, err := os.ReadFile(n.userProfilePath())
+	if err != nil {
+		return err
+	}
+	var p Profile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return fmt.Errorf("parse profile: %w", err)
+	}
+	p = p.normalized()
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if !p.isZero() {
+		n.userProfile.Store(&userProfileState{profile: p, hash: p.hash()})
+	}
+	return nil
+}
+
+func (n *Node) registerProfileRequestHandler(dest *rns.Destination) error {
+	if n == nil || dest == nil {
+		return nil
+	}
+	return dest.RegisterRequestHandler(
+		profileFieldsReq,
+		func(path string, data any, requestID []byte, linkID []byte, remoteIdentity *rns.Identity, requestedAt time.Time) any {
+			remoteHex := ""
+			if remoteIdentity != nil {
+				remoteHex = remoteIdentity.HexHash
+			}
+			known, err := decodeProfileRequest(data)
+			if err != nil {
+				rns.Logf(rns.LOG_NOTICE, "profile req: rejected remote=%s err=%v", remoteHex, err)
+				return map[any]any{"ok": false, "error": "bad request"}
+			}
+			st := n.userProfileSnapshot()
+			if len(st.hash) == 0 {
+				return map[any]any{"ok": false}
+			}
+			if bytes.Equal(known, st.hash) {
+				return map[any]any{"ok": true, "unchanged": true, "h": st.hash}
+			}
+			resp := st.profile.wire()
+			resp["ok"] = true
+			resp["h"] = st.hash
+			rns.Logf(rns.LOG_DEBUG, "profile req: served remote=%s", remoteHex)
+			return resp
+		},
+		rns.DestinationALLOW_ALL,
+		nil,
+		true,
+	)
+}
+
+func (n *Node) peerProfilePath(destHex string) string {
+	return filepath.Join(n.opts.Dir, "profiles", strings.ToLower(destHex)+".json")
+}
+
+func (n *Node) cachedContactProfile(destHex string) (ContactProfile, bool) {
+	n.peerProfilesMu.Lock()
+	defer n.peerProfilesMu.Unlock()
+	b, err := os.ReadFile(n.peerProfilePath(destHex))
+	if err != nil {
+		return ContactProfile{}, false
+	}
+	var cp ContactProfile
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return ContactProfile{}, false
+	}
+	return cp, true
+}
+
+func (n *Node) storeContactProfile(destHex string, cp ContactProfile) error {
+	n.peerProfilesMu.Lock()
+	defer n.peerProfilesMu.Unlock()
+	path := n.peerProfilePath(destHex)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// ContactProfileHex returns a contact's extended profile. With timeout <= 0 only the local
+// cache is consulted; otherwise the profile is fetched when the announced hash differs
+// from the cached one.
+func (n *Node) ContactProfileHex(destinationHashHex string, timeout time.Duration) (ContactProfile, error) {
+	if timeout <= 0 {
+		return n.contactProfile(nil, destinationHashHex)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.contactProfile(ctx, destinationHashHex)
+}
+
+// ContactProfileHexContext is like ContactProfileHex but fetches until ctx is done.
+func (n *Node) ContactProfileHexContext(ctx context.Context, destinationHashHex string) (ContactProfile, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return n.contactProfile(ctx, destinationHashHex)
+}
+
+// contactProfile only consults the cache when ctx is nil.
+func (n *Node) contactProfile(ctx context.Context, destinationHashHex string) (ContactProfile, error) {
+	if n == nil || n.identity == nil {
+		return ContactProfile{}, errors.New("node not started")
+	}
+	destHex := strings.ToLower(strings.TrimSpace(destinationHashHex))
+	destHash, err := hex.DecodeString(destHex)
+	if err != nil || len(destHash) != lxmf.DestinationLength {
+		return ContactProfile{}, errors.New("invalid destination hash")
+	}
+
+	cached, haveCache := n.cachedContactProfile(destHex)
+	info, _ := n.contactInfo(nil, destHex)
+	if haveCache && (info.ProfileHashHex == "" || info.ProfileHashHex == cached.HashHex) {
+		return cached, nil
+	}
+	if ctx == nil {
+		if haveCache {
+			return cached, nil
+		}
+		return ContactProfile{NotPresent: info.Protocol > 0 && !info.Supports(CapProfile)}, nil
+	}
+	if info.Protocol > 0 && !info.Supports(CapProfile) {
+		return ContactProfile{NotPresent: true}, nil
+	}
+
+	id, err := n.WaitForIdentityHexContext(ctx, destHex)
+	if err != nil {
+		return ContactProfile{}, err
+	}
+	req := map[any]any{}
+	if b, err := hex.DecodeString(cached.HashHex); err == nil && len(b) > 0 {
+		req["h"] = b
+	}
+
+	var lastErr error
+	for _, spec := range []struct{ app, aspect string }{
+		{lxmf.AppName, "delivery"},
+		{profileAppName, profileAspect},
+	} {
+		outDest, err := rns.NewDestination(id, rns.DestinationOUT, rns.DestinationSINGLE, spec.app, spec.aspect)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		raw, err := n.linkRequest(ctx, outDest, profileFieldsReq, req, 5*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ContactProfile{}, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		resp, err := decodeProfileResponse(raw)
+		if err != nil {
+			return ContactProfile{}, err
+		}
+		switch {
+		case !resp.OK:
+			return ContactProfile{NotPresent: true}, nil
+		case resp.Unchanged:
+			cached.Fetched = time.Now().Unix()
+			_ = n.storeContactProfile(destHex, cached)
+			return cached, nil
+		}
+		// The hash is what announces advertise and what the cache is keyed on, so it has to
+		// match the profile actually served.
+		hash := resp.Profile.hash()
+		if !bytes.Equal(hash, resp.Hash) {
+			return ContactProfile{}, errors.New("profile hash mismatch")
+		}
+		if info.ProfileHashHex != "" && info.ProfileHashHex != hex.EncodeToString(hash) {
+			return ContactProfile{}, errors.New("profile does not match the announced hash")
+		}
+		cp := ContactProfile{
+			Profile: resp.Profile,
+			HashHex: hex.EncodeToString(hash),
+			Fetched: time.Now().Unix(),
+		}
+		if err := n.storeContactProfile(destHex, cp); err != nil {
+			rns.Logf(rns.LOG_NOTICE, "profile cache: store %s failed: %v", destHex, err)
+		}
+		return cp, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("profile request failed")
+	}
+	return ContactProfile{}, lastErr
+}
+
+// writeFileAtomic writes via a temp file and rename, so readers never see partial content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
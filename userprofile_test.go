@@ -0,0 +1,20 @@
+package runcore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// TestProfileHashJSON pins the profile hash to the JSON spelled out in docs/PROFILE.md.
+func TestProfileHashJSON(t *testing.T) {
+	p := Profile{
+		Bio:     "a <b> & \"c\"\n\u00e9\u2028",
+		Links:   []ProfileLink{{URL: "https://example.org/?a=1&b=2"}},
+		Updated: 1700000000,
+	}
+	want := sha256.Sum256([]byte(`{"bio":"a <b> & \"c\"\n` + "\u00e9" + `\u2028","links":[{"url":"https://example.org/?a=1&b=2"}]}`))
+	if got := p.hash(); !bytes.Equal(got, want[:profileHashLen]) {
+		t.Fatalf("hash = %x, want %x", got, want[:profileHashLen])
+	}
+}
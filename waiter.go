@@ -0,0 +1,161 @@
+package runcore
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/svanichkin/go-reticulum/rns"
+)
+
+const (
+	// pathRequestMinInterval de-duplicates TransportRequestPath for the same destination.
+	pathRequestMinInterval = 5 * time.Second
+
+	// waiterRecheckInterval is a slow safety net for state that changes without an announce
+	// (eg identities learned from inbound messages).
+	waiterRecheckInterval = 1 * time.Second
+)
+
+// hashWaiters wakes goroutines waiting for an identity or path of a destination hash.
+// It is fed by the announce handler (announces and path responses).
+type hashWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+	pathReq map[string]time.Time
+}
+
+func newHashWaiters() *hashWaiters {
+	return &hashWaiters{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+		pathReq: make(map[string]time.Time),
+	}
+}
+
+// register returns a channel closed on the next notify for key. cancel is idempotent.
+func (w *hashWaiters) register(key string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	w.mu.Lock()
+	set := w.waiters[key]
+	if set == nil {
+		set = make(map[chan struct{}]struct{})
+		w.waiters[key] = set
+	}
+	set[ch] = struct{}{}
+	w.mu.Unlock()
+	return ch, func() {
+		w.mu.Lock()
+		if set := w.waiters[key]; set != nil {
+			delete(set, ch)
+			if len(set) == 0 {
+				delete(w.waiters, key)
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *hashWaiters) notify(key string) {
+	w.mu.Lock()
+	set := w.waiters[key]
+	delete(w.waiters, key)
+	delete(w.pathReq, key)
+	w.mu.Unlock()
+	for ch := range set {
+		close(ch)
+	}
+}
+
+// shouldRequestPath reports whether a path request for key is due and records it.
+func (w *hashWaiters) shouldRequestPath(key string) bool {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if last, ok := w.pathReq[key]; ok && now.Sub(last) < pathRequestMinInterval {
+		return false
+	}
+	if len(w.pathReq) > 256 {
+		for k, t := range w.pathReq {
+			if now.Sub(t) >= pathRequestMinInterval {
+				delete(w.pathReq, k)
+			}
+		}
+	}
+	w.pathReq[key] = now
+	return true
+}
+
+// RequestPath asks the network for a path to destHash unless one was requested recently.
+// Returns true if a request was sent.
+func (n *Node) RequestPath(destHash []byte) bool {
+	if n == nil || len(destHash) == 0 {
+		return false
+	}
+	if n.waiters != nil && !n.waiters.shouldRequestPath(string(destHash)) {
+		return false
+	}
+	rns.TransportRequestPath(destHash)
+	return true
+}
+
+func (n *Node) notifyDestination(destHash []byte) {
+	if n == nil || n.waiters == nil || len(destHash) == 0 {
+		return
+	}
+	n.waiters.notify(string(destHash))
+}
+
+// awaitDestination blocks until ready returns true, waking on announces for destHash.
+func (n *Node) awaitDestination(ctx context.Context, destHash []byte, ready func() bool) error {
+	if ready() {
+		return nil
+	}
+	if n == nil || n.waiters == nil {
+		return context.Canceled
+	}
+	key := string(destHash)
+	recheck := time.NewTicker(waiterRecheckInterval)
+	defer recheck.Stop()
+	for {
+		// Register before re-checking so a notify in between is not lost.
+		ch, cancel := n.waiters.register(key)
+		if ready() {
+			cancel()
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-recheck.C:
+		case <-ctx.Done():
+			cancel()
+			rns.Logf(rns.LOG_DEBUG, "wait for %s: %v", hex.EncodeToString(destHash), ctx.Err())
+			return ctx.Err()
+		}
+		cancel()
+		if ready() {
+			return nil
+		}
+	}
+}
+
+// awaitIdentity waits for an identity of destHash. If needAppData is set, the identity must
+// also carry announce app-data (display name, avatar metadata).
+func (n *Node) awaitIdentity(ctx context.Context, destHash []byte, needAppData bool) (*rns.Identity, error) {
+	var id *rns.Identity
+	err := n.awaitDestination(ctx, destHash, func() bool {
+		id = rns.IdentityRecall(destHash)
+		return id != nil && (!needAppData || len(id.AppData) > 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// awaitPath waits until Transport knows a path to destHash.
+func (n *Node) awaitPath(ctx context.Context, destHash []byte) bool {
+	return n.awaitDestination(ctx, destHash, func() bool {
+		return rns.TransportHasPath(destHash)
+	}) == nil
+}
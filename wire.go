@@ -0,0 +1,1398 @@
+package runcore
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/svanichkin/go-lxmf/lxmf"
+	"github.com/svanichkin/go-reticulum/rns"
+	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
+)
+
+// Decoders for runcore's wire structures. Everything here parses attacker-controlled
+// msgpack, so each parser checks sizes before unpacking, checks the type of every
+// known key, and ignores unknown keys (forward compatibility).
+//
+// parseX only validates; decodeX also counts rejections (WireDecodeErrors /
+// Node.WireStatsJSON). Use parseX where foreign input is expected, eg announces of
+// other applications seen by the announce handler.
+
+const (
+	// announcePayloadLen is what an announce carries besides app-data: public key (64), name
+	// hash (10), random hash (10) and signature (64). Announces with a ratchet (all LXMF
+	// delivery announces) carry announceRatchetLen more.
+	announcePayloadLen = 64 + 10 + 10 + 64
+	announceRatchetLen = 32
+	// maxAnnounceAppDataLen is the most app-data one announce packet can carry (316 bytes).
+	maxAnnounceAppDataLen = rns.DefaultMTU - rns.HEADER_MAXSIZE - rns.IFAC_MIN_SIZE - announcePayloadLen
+	maxDisplayNameLen     = 256
+	maxMimeLen            = 128
+	maxAttachmentNameLen  = 255
+	maxWireErrorLen       = 256
+	maxAvatarBytes        = 8 << 20
+	maxAttachmentBytes    = 256 << 20
+	maxCapabilities       = 32
+	maxCapabilityLen      = 32
+	maxProtocolVersion    = 1 << 16
+	maxAvatarPixels       = 1 << 14
+
+	maxProfileStatusLen    = 256
+	maxProfileBioLen       = 2048
+	maxProfilePronounsLen  = 64
+	maxProfileLinks        = 8
+	maxProfileLinkLabelLen = 64
+	maxProfileLinkURLLen   = 512
+
+	maxTelemetryLen           = 16 << 10
+	maxTelemetryStreamEntries = 256
+	maxCommands               = 16
+
+	maxMsgpackDepth = 32
+
+	avatarHashLen     = 16 // truncated sha256 (see SetAvatarImage)
+	attachmentHashLen = 32 // sha256 (see StoreOutgoingAttachment)
+)
+
+// Decoder names used as keys in WireDecodeErrors.
+const (
+	wireAnnounceAppData   = "announce_app_data"
+	wireAvatarMeta        = "avatar_meta"
+	wireAvatarRequest     = "avatar_request"
+	wireAvatarResponse    = "avatar_response"
+	wireAttachmentMeta    = "attachment_meta"
+	wireAttachmentRequest = "attachment_request"
+	wireAttachmentResp    = "attachment_response"
+	wireProfileInfo       = "profile_info"
+	wireProfileRequest    = "profile_request"
+	wireProfileResponse   = "profile_response"
+	wireReadReceipt       = "read_receipt"
+	wirePresenceSignal    = "presence_signal"
+	wireMessageAction     = "message_action"
+	wireGroupControl      = "group_control"
+	wireGroupField        = "group_field"
+	wireTelemetry         = "telemetry"
+	wireTelemetryStream   = "telemetry_stream"
+	wireCommands          = "commands"
+)
+
+var wireDecoders = []string{
+	wireAnnounceAppData,
+	wireAvatarMeta,
+	wireAvatarRequest,
+	wireAvatarResponse,
+	wireAttachmentMeta,
+	wireAttachmentRequest,
+	wireAttachmentResp,
+	wireProfileInfo,
+	wireProfileRequest,
+	wireProfileResponse,
+	wireReadReceipt,
+	wirePresenceSignal,
+	wireMessageAction,
+	wireGroupControl,
+	wireGroupField,
+	wireTelemetry,
+	wireTelemetryStream,
+	wireCommands,
+}
+
+var wireErrorCounts = func() map[string]*atomic.Uint64 {
+	m := make(map[string]*atomic.Uint64, len(wireDecoders))
+	for _, name := range wireDecoders {
+		m[name] = new(atomic.Uint64)
+	}
+	return m
+}()
+
+// WireDecodeError reports rejected wire input.
+type WireDecodeError struct {
+	Decoder string
+	Reason  string
+}
+
+func (e *WireDecodeError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Decoder, e.Reason)
+}
+
+// errWireEmpty marks absent (not malformed) input; it is not counted.
+var errWireEmpty = errors.New("empty wire input")
+
+func wireErrorf(decoder, format string, args ...any) error {
+	return &WireDecodeError{Decoder: decoder, Reason: fmt.Sprintf(format, args...)}
+}
+
+// countWireError records a rejection by the decoder named in err, once per error joined
+// into err. Absent input is not counted.
+func countWireError(err error) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			countWireError(e)
+		}
+		return err
+	}
+	var werr *WireDecodeError
+	if errors.As(err, &werr) {
+		if c := wireErrorCounts[werr.Decoder]; c != nil {
+			c.Add(1)
+		}
+		rns.Logf(rns.LOG_DEBUG, "wire: %v", err)
+	}
+	return err
+}
+
+// WireDecodeErrors returns the number of rejected inputs per decoder since process start.
+func WireDecodeErrors() map[string]uint64 {
+	out := make(map[string]uint64, len(wireErrorCounts))
+	for name, c := range wireErrorCounts {
+		out[name] = c.Load()
+	}
+	return out
+}
+
+// WireStatsJSON returns WireDecodeErrors as JSON ({"decode_errors":{...}}).
+func (n *Node) WireStatsJSON() string {
+	b, err := json.Marshal(map[string]any{"decode_errors": WireDecodeErrors()})
+	if err != nil {
+		return `{"decode_errors":{},"error":"marshal failed"}`
+	}
+	return string(b)
+}
+
+// announceData is decoded LXMF delivery announce app-data:
+// msgpack([display_name, stamp_cost, avatar_meta?, profile_info?]) or legacy raw UTF-8
+// display name. See docs/PROFILE.md.
+type announceData struct {
+	DisplayName string
+	StampCost   int // 0 = none
+	Avatar      *avatarMeta
+	Profile     *profileInfo
+}
+
+// avatarMeta describes an avatar (announce slot 2, /avatar response, avatar resource metadata).
+type avatarMeta struct {
+	Hash    []byte
+	Mime    string
+	Size    int
+	Updated int64
+	Pixels  int // set when the described variant is a thumbnail of this edge length
+
+	// Thumbnail variant (announce slot 2 only).
+	ThumbHash []byte
+	ThumbMime string
+	ThumbSize int
+}
+
+// attachmentMeta describes an attachment (/attachment response, attachment resource metadata).
+type attachmentMeta struct {
+	Hash    []byte
+	Mime    string
+	Name    string
+	Size    int
+	Updated int64
+}
+
+// parseAnnounceAppData validates announce app-data. A malformed avatar or profile map only
+// drops that map (the error is still returned); other errors reject the whole input.
+func parseAnnounceAppData(b []byte) (announceData, error) {
+	if len(b) == 0 {
+		return announceData{}, errWireEmpty
+	}
+	if len(b) > maxAnnounceAppDataLen {
+		return announceData{}, wireErrorf(wireAnnounceAppData, "too large (%d bytes)", len(b))
+	}
+	// Like LXMF's display_name_from_app_data: anything that is not a msgpack array
+	// (fixarray 0x90-0x9f, array16 0xdc) is a legacy raw UTF-8 display name.
+	if !(b[0] >= 0x90 && b[0] <= 0x9f) && b[0] != 0xdc {
+		name, ok := wireText(b, maxDisplayNameLen)
+		if !ok {
+			return announceData{}, wireErrorf(wireAnnounceAppData, "invalid legacy display name")
+		}
+		return announceData{DisplayName: name}, nil
+	}
+
+	var unpacked []any
+	if err := wireUnpack(b, &unpacked); err != nil {
+		return announceData{}, wireErrorf(wireAnnounceAppData, "unpack: %v", err)
+	}
+	var out announceData
+	if len(unpacked) > 0 && unpacked[0] != nil {
+		name, ok := wireText(unpacked[0], maxDisplayNameLen)
+		if !ok {
+			return announceData{}, wireErrorf(wireAnnounceAppData, "invalid display name (%T)", unpacked[0])
+		}
+		out.DisplayName = name
+	}
+	if len(unpacked) > 1 && unpacked[1] != nil {
+		cost, ok := wireInt(unpacked[1])
+		if !ok || cost < 0 || cost > 255 {
+			return announceData{}, wireErrorf(wireAnnounceAppData, "invalid stamp cost")
+		}
+		out.StampCost = int(cost)
+	}
+	// The avatar and profile maps are independent: one that fails to parse (eg from a
+	// newer format) must not hide the other.
+	var errs []error
+	if len(unpacked) > 2 && unpacked[2] != nil {
+		if meta, err := parseAvatarMeta(unpacked[2]); err != nil {
+			errs = append(errs, err)
+		} else if len(meta.Hash) > 0 {
+			out.Avatar = &meta
+		}
+	}
+	if len(unpacked) > 3 && unpacked[3] != nil {
+		if info, err := parseProfileInfo(unpacked[3]); err != nil {
+			errs = append(errs, err)
+		} else {
+			out.Profile = &info
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+// parseProfileInfo validates {"v": version, "b": bitmask?, "c": [capability, ...]?}: announces
+// carry the bitmask, /capabilities the names. Capabilities are returned sorted and
+// de-duplicated; unknown bits are ignored.
+func parseProfileInfo(v any) (profileInfo, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return profileInfo{}, wireErrorf(wireProfileInfo, "not a map (%T)", v)
+	}
+	var err error
+	version, ok := wireInt(m["v"])
+	if !ok || version < 0 || version > maxProtocolVersion {
+		return profileInfo{}, wireErrorf(wireProfileInfo, "invalid version")
+	}
+	out := profileInfo{Version: int(version)}
+	if out.ProfileHash, err = wireHashField(m, "p", profileHashLen); err != nil {
+		return profileInfo{}, wireErrorf(wireProfileInfo, "%v", err)
+	}
+	seen := map[string]struct{}{}
+	add := func(name string) {
+		if _, dup := seen[name]; !dup {
+			seen[name] = struct{}{}
+			out.Capabilities = append(out.Capabilities, name)
+		}
+	}
+	if raw, present := m["b"]; present && raw != nil {
+		bits, ok := wireInt(raw)
+		if !ok || bits < 0 {
+			return profileInfo{}, wireErrorf(wireProfileInfo, "invalid capability bitmask")
+		}
+		for i, name := range capabilityBits {
+			if bits&(1<<i) != 0 {
+				add(name)
+			}
+		}
+	}
+	if raw, present := m["c"]; present && raw != nil {
+		list, ok := raw.([]any)
+		if !ok {
+			return profileInfo{}, wireErrorf(wireProfileInfo, "capabilities: want array, got %T", raw)
+		}
+		if len(list) > maxCapabilities {
+			return profileInfo{}, wireErrorf(wireProfileInfo, "too many capabilities (%d)", len(list))
+		}
+		for _, item := range list {
+			name, ok := wireText(item, maxCapabilityLen)
+			if !ok || !validCapabilityName(name) {
+				return profileInfo{}, wireErrorf(wireProfileInfo, "invalid capability")
+			}
+			add(name)
+		}
+	}
+	sort.Strings(out.Capabilities)
+	return out, nil
+}
+
+// parseProfileRequest validates /profile request data: nil or {"h": known_hash?}.
+func parseProfileRequest(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, wireErrorf(wireProfileRequest, "not a map (%T)", v)
+	}
+	h, err := wireHashField(m, "h", profileHashLen)
+	if err != nil {
+		return nil, wireErrorf(wireProfileRequest, "%v", err)
+	}
+	return h, nil
+}
+
+// profileResponse is a decoded /profile response.
+type profileResponse struct {
+	OK        bool
+	Unchanged bool
+	Hash      []byte
+	Profile   Profile
+}
+
+func parseProfileResponse(v any) (profileResponse, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "not a map (%T)", v)
+	}
+	var out profileResponse
+	var err error
+	if out.OK, err = wireBoolField(m, "ok"); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if !out.OK {
+		return out, nil
+	}
+	if out.Unchanged, err = wireBoolField(m, "unchanged"); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if out.Hash, err = wireHashField(m, "h", profileHashLen); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if len(out.Hash) == 0 {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "missing hash")
+	}
+	if out.Unchanged {
+		return out, nil
+	}
+	p := &out.Profile
+	if p.Status, err = wireTextField(m, "status", maxProfileStatusLen); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if p.Pronouns, err = wireTextField(m, "pronouns", maxProfilePronounsLen); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if bio, present := m["bio"]; present && bio != nil {
+		s, ok := wireTextOpt(bio, maxProfileBioLen, true)
+		if !ok {
+			return profileResponse{}, wireErrorf(wireProfileResponse, "invalid bio")
+		}
+		p.Bio = s
+	}
+	if p.Updated, err = wireTimeField(m, "u"); err != nil {
+		return profileResponse{}, wireErrorf(wireProfileResponse, "%v", err)
+	}
+	if raw, present := m["links"]; present && raw != nil {
+		list, ok := raw.([]any)
+		if !ok || len(list) > maxProfileLinks {
+			return profileResponse{}, wireErrorf(wireProfileResponse, "invalid links")
+		}
+		for _, item := range list {
+			pair, ok := item.([]any)
+			if !ok || len(pair) != 2 {
+				return profileResponse{}, wireErrorf(wireProfileResponse, "invalid link")
+			}
+			label, ok1 := wireTextOpt(pair[0], maxProfileLinkLabelLen, false)
+			url, ok2 := wireTextOpt(pair[1], maxProfileLinkURLLen, false)
+			if !ok1 || !ok2 || url == "" {
+				return profileResponse{}, wireErrorf(wireProfileResponse, "invalid link")
+			}
+			p.Links = append(p.Links, ProfileLink{Label: label, URL: url})
+		}
+	}
+	return out, nil
+}
+
+// readReceipt is a decoded read receipt control payload.
+type readReceipt struct {
+	IDs    [][]byte
+	ReadAt int64
+}
+
+// parseReadReceipt validates {"ids": [message_id...], "t": read_at}.
+func parseReadReceipt(v any) (readReceipt, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return readReceipt{}, wireErrorf(wireReadReceipt, "not a map (%T)", v)
+	}
+	var out readReceipt
+	var err error
+	if out.ReadAt, err = wireTimeField(m, "t"); err != nil {
+		return readReceipt{}, wireErrorf(wireReadReceipt, "%v", err)
+	}
+	list, ok := m["ids"].([]any)
+	if !ok || len(list) == 0 || len(list) > maxReadReceiptIDs {
+		return readReceipt{}, wireErrorf(wireReadReceipt, "invalid ids")
+	}
+	for _, item := range list {
+		id, ok := item.([]byte)
+		if !ok || len(id) != lxmfMessageIDLen {
+			return readReceipt{}, wireErrorf(wireReadReceipt, "invalid message id")
+		}
+		out.IDs = append(out.IDs, append([]byte(nil), id...))
+	}
+	return out, nil
+}
+
+// presenceSignal is a decoded /presence request.
+type presenceSignal struct {
+	Kind       string
+	LastActive int64
+}
+
+// parsePresenceSignal validates {"k": kind, "a": last_active}.
+func parsePresenceSignal(v any) (presenceSignal, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return presenceSignal{}, wireErrorf(wirePresenceSignal, "not a map (%T)", v)
+	}
+	var out presenceSignal
+	var err error
+	if out.Kind, err = wireTextField(m, "k", 16); err != nil {
+		return presenceSignal{}, wireErrorf(wirePresenceSignal, "%v", err)
+	}
+	switch out.Kind {
+	case presenceTyping, presenceStop, presenceHeartbeatK, presenceBye:
+	default:
+		return presenceSignal{}, wireErrorf(wirePresenceSignal, "unknown kind %q", out.Kind)
+	}
+	if out.LastActive, err = wireTimeField(m, "a"); err != nil {
+		return presenceSignal{}, wireErrorf(wirePresenceSignal, "%v", err)
+	}
+	return out, nil
+}
+
+// messageAction is a decoded reaction, edit or delete control payload.
+type messageAction struct {
+	ID         []byte
+	Emoji      string
+	Remove     bool
+	Content    string
+	HasContent bool
+	At         int64
+}
+
+// parseMessageAction validates {"id": message_id, "e"?: emoji, "r"?: bool, "c"?: str, "u"?: ts}.
+func parseMessageAction(v any) (messageAction, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return messageAction{}, wireErrorf(wireMessageAction, "not a map (%T)", v)
+	}
+	var out messageAction
+	var err error
+	if out.ID, err = wireHashField(m, "id", lxmfMessageIDLen); err != nil {
+		return messageAction{}, wireErrorf(wireMessageAction, "%v", err)
+	}
+	if out.ID == nil {
+		return messageAction{}, wireErrorf(wireMessageAction, "missing id")
+	}
+	if out.Emoji, err = wireTextField(m, "e", maxReactionLen); err != nil {
+		return messageAction{}, wireErrorf(wireMessageAction, "%v", err)
+	}
+	if out.Remove, err = wireBoolField(m, "r"); err != nil {
+		return messageAction{}, wireErrorf(wireMessageAction, "%v", err)
+	}
+	if c, ok := m["c"]; ok {
+		if out.Content, ok = wireTextOpt(c, maxMessageEditLen, true); !ok {
+			return messageAction{}, wireErrorf(wireMessageAction, "invalid content")
+		}
+		out.HasContent = true
+	}
+	if out.At, err = wireTimeField(m, "u"); err != nil {
+		return messageAction{}, wireErrorf(wireMessageAction, "%v", err)
+	}
+	return out, nil
+}
+
+// groupControlMsg is a decoded group control payload. For join and leave only
+// Group.IDHex is set.
+type groupControlMsg struct {
+	Op    string
+	Group Group
+}
+
+// parseGroupControl validates {"op": str, "g": {"id", "n", "a", "m": [dest...], "v", "c"}}
+// (invite, update) and {"op": str, "id": bin(16)} (join, leave).
+func parseGroupControl(v any) (groupControlMsg, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "not a map (%T)", v)
+	}
+	var out groupControlMsg
+	var err error
+	if out.Op, err = wireTextField(m, "op", 16); err != nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "%v", err)
+	}
+	switch out.Op {
+	case groupOpJoin, groupOpLeave:
+		id, err := wireHashField(m, "id", groupIDLen)
+		if err != nil || id == nil {
+			return groupControlMsg{}, wireErrorf(wireGroupControl, "invalid group id")
+		}
+		out.Group.IDHex = hex.EncodeToString(id)
+		return out, nil
+	case groupOpInvite, groupOpUpdate:
+	default:
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "unknown op %q", out.Op)
+	}
+	g, ok := m["g"].(map[any]any)
+	if !ok {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "missing group")
+	}
+	id, err := wireHashField(g, "id", groupIDLen)
+	if err != nil || id == nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "invalid group id")
+	}
+	admin, err := wireHashField(g, "a", lxmf.DestinationLength)
+	if err != nil || admin == nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "invalid admin")
+	}
+	out.Group.IDHex = hex.EncodeToString(id)
+	out.Group.AdminHex = hex.EncodeToString(admin)
+	if out.Group.Name, err = wireTextField(g, "n", maxGroupNameLen); err != nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "%v", err)
+	}
+	if out.Group.Version, err = wireTimeField(g, "v"); err != nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "%v", err)
+	}
+	if out.Group.Created, err = wireTimeField(g, "c"); err != nil {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "%v", err)
+	}
+	list, ok := g["m"].([]any)
+	if (!ok && g["m"] != nil) || len(list) > maxGroupMembers {
+		return groupControlMsg{}, wireErrorf(wireGroupControl, "invalid members")
+	}
+	out.Group.Members = []string{}
+	for _, item := range list {
+		b, ok := item.([]byte)
+		if !ok || len(b) != lxmf.DestinationLength {
+			return groupControlMsg{}, wireErrorf(wireGroupControl, "invalid member")
+		}
+		if h := hex.EncodeToString(b); !slices.Contains(out.Group.Members, h) {
+			out.Group.Members = append(out.Group.Members, h)
+		}
+	}
+	return out, nil
+}
+
+// groupField is a decoded FIELD_GROUP.
+type groupField struct {
+	IDHex        string
+	MessageIDHex string // group-level message ID; empty from senders that predate it
+}
+
+// parseGroupField validates FIELD_GROUP ({"id": bin(16), "m": bin(32)?}).
+func parseGroupField(v any) (groupField, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return groupField{}, wireErrorf(wireGroupField, "not a map (%T)", v)
+	}
+	id, err := wireHashField(m, "id", groupIDLen)
+	if err != nil || id == nil {
+		return groupField{}, wireErrorf(wireGroupField, "invalid group id")
+	}
+	mid, err := wireHashField(m, "m", lxmfMessageIDLen)
+	if err != nil {
+		return groupField{}, wireErrorf(wireGroupField, "invalid message id")
+	}
+	return groupField{IDHex: hex.EncodeToString(id), MessageIDHex: hex.EncodeToString(mid)}, nil
+}
+
+// parseTelemetry validates Sideband telemetry: msgpack {sensor_id: packed_sensor}, either
+// still packed (FIELD_TELEMETRY) or already unpacked. Sensors other than time, location and
+// battery are ignored, as are sensors packed as nil (no data).
+func parseTelemetry(v any) (Telemetry, error) {
+	var m map[any]any
+	switch t := v.(type) {
+	case []byte:
+		if len(t) == 0 {
+			return Telemetry{}, errWireEmpty
+		}
+		if len(t) > maxTelemetryLen {
+			return Telemetry{}, wireErrorf(wireTelemetry, "too large (%d bytes)", len(t))
+		}
+		if err := wireUnpack(t, &m); err != nil {
+			return Telemetry{}, wireErrorf(wireTelemetry, "unpack: %v", err)
+		}
+	case map[any]any:
+		m = t
+	default:
+		return Telemetry{}, wireErrorf(wireTelemetry, "not a map (%T)", v)
+	}
+	var out Telemetry
+	for k, sv := range m {
+		sid, ok := wireInt(k)
+		if !ok || sv == nil {
+			continue
+		}
+		switch sid {
+		case sensorTime:
+			ts, ok := wireInt(sv)
+			if !ok || ts < 0 {
+				return Telemetry{}, wireErrorf(wireTelemetry, "invalid time")
+			}
+			out.Time = ts
+		case sensorLocation:
+			loc, err := parseTelemetryLocation(sv)
+			if err != nil {
+				return Telemetry{}, wireErrorf(wireTelemetry, "location: %v", err)
+			}
+			out.Location = loc
+		case sensorBattery:
+			bat, err := parseTelemetryBattery(sv)
+			if err != nil {
+				return Telemetry{}, wireErrorf(wireTelemetry, "battery: %v", err)
+			}
+			out.Battery = bat
+		}
+	}
+	return out, nil
+}
+
+// telemetryLocationLayout is Sideband's packed location: big-endian latitude and longitude
+// (int32, 1e-6 degrees), altitude (int32, cm), speed (uint32, 1/100 km/h), bearing (int32,
+// 1/100 degrees) and accuracy (uint16, cm), followed by the update time.
+var telemetryLocationLayout = [6]struct {
+	size   int
+	signed bool
+	scale  float64
+}{{4, true, 1e6}, {4, true, 1e6}, {4, true, 1e2}, {4, false, 1e2}, {4, true, 1e2}, {2, false, 1e2}}
+
+func parseTelemetryLocation(v any) (*TelemetryLocation, error) {
+	list, ok := v.([]any)
+	if !ok || len(list) < len(telemetryLocationLayout) {
+		return nil, fmt.Errorf("not a list (%T)", v)
+	}
+	var vals [len(telemetryLocationLayout)]float64
+	for i, f := range telemetryLocationLayout {
+		b, ok := list[i].([]byte)
+		if !ok || len(b) != f.size {
+			return nil, fmt.Errorf("invalid value %d", i)
+		}
+		switch {
+		case f.size == 2:
+			vals[i] = float64(binary.BigEndian.Uint16(b))
+		case f.signed:
+			vals[i] = float64(int32(binary.BigEndian.Uint32(b)))
+		default:
+			vals[i] = float64(binary.BigEndian.Uint32(b))
+		}
+		vals[i] /= f.scale
+	}
+	loc := &TelemetryLocation{
+		Latitude:  vals[0],
+		Longitude: vals[1],
+		Altitude:  vals[2],
+		Speed:     vals[3],
+		Bearing:   vals[4],
+		Accuracy:  vals[5],
+	}
+	if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 {
+		return nil, errors.New("coordinates out of range")
+	}
+	if len(list) > 6 && list[6] != nil {
+		ts, ok := wireInt(list[6])
+		if !ok || ts < 0 {
+			return nil, errors.New("invalid update time")
+		}
+		loc.UpdatedAt = ts
+	}
+	return loc, nil
+}
+
+// parseTelemetryBattery validates [charge_percent, charging, temperature?].
+func parseTelemetryBattery(v any) (*TelemetryBattery, error) {
+	list, ok := v.([]any)
+	if !ok || len(list) < 2 {
+		return nil, fmt.Errorf("not a list (%T)", v)
+	}
+	charge, ok := wireFloat(list[0])
+	if !ok || charge < 0 || charge > 100 {
+		return nil, errors.New("invalid charge")
+	}
+	bat := &TelemetryBattery{ChargePercent: charge}
+	if list[1] != nil {
+		if bat.Charging, ok = list[1].(bool); !ok {
+			return nil, errors.New("invalid charging state")
+		}
+	}
+	if len(list) > 2 && list[2] != nil {
+		temp, ok := wireFloat(list[2])
+		if !ok {
+			return nil, errors.New("invalid temperature")
+		}
+		bat.Temperature = &temp
+	}
+	return bat, nil
+}
+
+// telemetryStreamEntry is one peer's telemetry relayed by a Sideband collector.
+type telemetryStreamEntry struct {
+	Source    []byte
+	Timestamp int64
+	Telemetry Telemetry
+}
+
+// parseTelemetryStream validates FIELD_TELEMETRY_STREAM: [[source_hash, timestamp,
+// packed_telemetry, appearance?], ...].
+func parseTelemetryStream(v any) ([]telemetryStreamEntry, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, wireErrorf(wireTelemetryStream, "not a list (%T)", v)
+	}
+	if len(list) > maxTelemetryStreamEntries {
+		return nil, wireErrorf(wireTelemetryStream, "too many entries (%d)", len(list))
+	}
+	out := make([]telemetryStreamEntry, 0, len(list))
+	for _, item := range list {
+		e, ok := item.([]any)
+		if !ok || len(e) < 3 {
+			return nil, wireErrorf(wireTelemetryStream, "invalid entry")
+		}
+		src, ok := e[0].([]byte)
+		if !ok || len(src) != lxmf.DestinationLength {
+			return nil, wireErrorf(wireTelemetryStream, "invalid source hash")
+		}
+		ts, ok := wireInt(e[1])
+		if !ok || ts < 0 {
+			return nil, wireErrorf(wireTelemetryStream, "invalid timestamp")
+		}
+		t, err := parseTelemetry(e[2])
+		if err != nil {
+			return nil, wireErrorf(wireTelemetryStream, "%v", err)
+		}
+		out = append(out, telemetryStreamEntry{Source: append([]byte(nil), src...), Timestamp: ts, Telemetry: t})
+	}
+	return out, nil
+}
+
+// parseTelemetryRequest validates FIELD_COMMANDS ([{command_id: args}, ...]) and reports
+// whether it holds a telemetry request. Its argument is a timebase or [timebase,
+// collector_request]; runcore only keeps the latest telemetry, so both are ignored.
+func parseTelemetryRequest(v any) (bool, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return false, wireErrorf(wireCommands, "not a list (%T)", v)
+	}
+	if len(list) > maxCommands {
+		return false, wireErrorf(wireCommands, "too many commands (%d)", len(list))
+	}
+	found := false
+	for _, item := range list {
+		cmd, ok := item.(map[any]any)
+		if !ok {
+			return false, wireErrorf(wireCommands, "invalid command (%T)", item)
+		}
+		for k := range cmd {
+			if id, ok := wireInt(k); ok && id == commandTelemetryRequest {
+				found = true
+			}
+		}
+	}
+	return found, nil
+}
+
+func validCapabilityName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAvatarMeta validates {"h": hash, "t": mime, "s": size, "u": updated, "px"?: edge,
+// "th"?: thumb_hash, "tt"?: thumb_mime, "ts"?: thumb_size}.
+func parseAvatarMeta(v any) (avatarMeta, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "not a map (%T)", v)
+	}
+	var out avatarMeta
+	var err error
+	if out.Hash, err = wireHashField(m, "h", avatarHashLen); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.Mime, err = wireMimeField(m, "t"); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.Size, err = wireSizeField(m, "s", maxAvatarBytes); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.Updated, err = wireTimeField(m, "u"); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.Pixels, err = wireSizeField(m, "px", maxAvatarPixels); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.ThumbHash, err = wireHashField(m, "th", avatarHashLen); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.ThumbMime, err = wireMimeField(m, "tt"); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	if out.ThumbSize, err = wireSizeField(m, "ts", maxAvatarThumbBytes); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	return out, nil
+}
+
+// avatarRequest is a decoded /avatar request.
+type avatarRequest struct {
+	Known  []byte // hash the requester already has
+	Pixels int    // largest edge the requester wants; 0 = full image
+}
+
+// parseAvatarRequest validates /avatar request data: nil or {"h": known_hash?, "px": edge?}.
+func parseAvatarRequest(v any) (avatarRequest, error) {
+	if v == nil {
+		return avatarRequest{}, nil
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return avatarRequest{}, wireErrorf(wireAvatarRequest, "not a map (%T)", v)
+	}
+	var out avatarRequest
+	var err error
+	if out.Known, err = wireHashField(m, "h", avatarHashLen); err != nil {
+		return avatarRequest{}, wireErrorf(wireAvatarRequest, "%v", err)
+	}
+	if out.Pixels, err = wireSizeField(m, "px", maxAvatarPixels); err != nil {
+		return avatarRequest{}, wireErrorf(wireAvatarRequest, "%v", err)
+	}
+	return out, nil
+}
+
+// avatarResponse is a decoded /avatar response. Raw is set for the legacy raw-bytes form.
+type avatarResponse struct {
+	OK        bool
+	Unchanged bool
+	Resource  bool
+	Error     string
+	Meta      avatarMeta
+	Raw       []byte
+}
+
+func parseAvatarResponse(v any) (avatarResponse, error) {
+	switch r := v.(type) {
+	case []byte:
+		if len(r) > maxAvatarBytes {
+			return avatarResponse{}, wireErrorf(wireAvatarResponse, "raw avatar too large (%d bytes)", len(r))
+		}
+		return avatarResponse{OK: true, Raw: r}, nil
+	case map[any]any:
+		var out avatarResponse
+		var err error
+		if out.OK, err = wireBoolField(r, "ok"); err != nil {
+			return avatarResponse{}, wireErrorf(wireAvatarResponse, "%v", err)
+		}
+		if out.Unchanged, err = wireBoolField(r, "unchanged"); err != nil {
+			return avatarResponse{}, wireErrorf(wireAvatarResponse, "%v", err)
+		}
+		if out.Resource, err = wireBoolField(r, "resource"); err != nil {
+			return avatarResponse{}, wireErrorf(wireAvatarResponse, "%v", err)
+		}
+		if out.Error, err = wireTextField(r, "error", maxWireErrorLen); err != nil {
+			return avatarResponse{}, wireErrorf(wireAvatarResponse, "%v", err)
+		}
+		if !out.OK {
+			return out, nil
+		}
+		if out.Meta, err = parseAvatarMeta(r); err != nil {
+			return avatarResponse{}, err
+		}
+		return out, nil
+	default:
+		return avatarResponse{}, wireErrorf(wireAvatarResponse, "unexpected type %T", v)
+	}
+}
+
+// parseAvatarResourceMeta validates avatar resource metadata ({"kind": "avatar", ...avatarMeta}).
+func parseAvatarResourceMeta(v any) (avatarMeta, error) {
+	m, ok := wireMap(v)
+	if !ok {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "not a map (%T)", v)
+	}
+	if err := wireKind(m, profileAvatarRes); err != nil {
+		return avatarMeta{}, wireErrorf(wireAvatarMeta, "%v", err)
+	}
+	return parseAvatarMeta(m)
+}
+
+// parseAttachmentMeta validates {"h": hash, "t": mime, "n": name, "s": size, "u": updated}.
+func parseAttachmentMeta(v any) (attachmentMeta, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "not a map (%T)", v)
+	}
+	var out attachmentMeta
+	var err error
+	if out.Hash, err = wireHashField(m, "h", attachmentHashLen); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	if out.Mime, err = wireMimeField(m, "t"); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	if out.Name, err = wireTextField(m, "n", maxAttachmentNameLen); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	out.Name = sanitizeAttachmentName(out.Name)
+	if out.Size, err = wireSizeField(m, "s", maxAttachmentBytes); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	if out.Updated, err = wireTimeField(m, "u"); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	return out, nil
+}
+
+// parseAttachmentRequest validates /attachment request data: {"h": hash}.
+func parseAttachmentRequest(v any) ([]byte, error) {
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, wireErrorf(wireAttachmentRequest, "not a map (%T)", v)
+	}
+	h, err := wireHashField(m, "h", attachmentHashLen)
+	if err != nil {
+		return nil, wireErrorf(wireAttachmentRequest, "%v", err)
+	}
+	if len(h) == 0 {
+		return nil, wireErrorf(wireAttachmentRequest, "missing hash")
+	}
+	return h, nil
+}
+
+// attachmentResponse is a decoded /attachment response. Raw is set for the legacy raw-bytes form.
+type attachmentResponse struct {
+	OK       bool
+	Resource bool
+	Error    string
+	Meta     attachmentMeta
+	Raw      []byte
+}
+
+func parseAttachmentResponse(v any) (attachmentResponse, error) {
+	switch r := v.(type) {
+	case []byte:
+		if len(r) > maxAttachmentBytes {
+			return attachmentResponse{}, wireErrorf(wireAttachmentResp, "raw attachment too large (%d bytes)", len(r))
+		}
+		return attachmentResponse{OK: true, Raw: r}, nil
+	case map[any]any:
+		var out attachmentResponse
+		var err error
+		if out.OK, err = wireBoolField(r, "ok"); err != nil {
+			return attachmentResponse{}, wireErrorf(wireAttachmentResp, "%v", err)
+		}
+		if out.Resource, err = wireBoolField(r, "resource"); err != nil {
+			return attachmentResponse{}, wireErrorf(wireAttachmentResp, "%v", err)
+		}
+		if out.Error, err = wireTextField(r, "error", maxWireErrorLen); err != nil {
+			return attachmentResponse{}, wireErrorf(wireAttachmentResp, "%v", err)
+		}
+		if !out.OK {
+			return out, nil
+		}
+		if out.Meta, err = parseAttachmentMeta(r); err != nil {
+			return attachmentResponse{}, err
+		}
+		return out, nil
+	default:
+		return attachmentResponse{}, wireErrorf(wireAttachmentResp, "unexpected type %T", v)
+	}
+}
+
+// parseAttachmentResourceMeta validates attachment resource metadata ({"kind": "attachment", ...}).
+func parseAttachmentResourceMeta(v any) (attachmentMeta, error) {
+	m, ok := wireMap(v)
+	if !ok {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "not a map (%T)", v)
+	}
+	if err := wireKind(m, attachmentResKind); err != nil {
+		return attachmentMeta{}, wireErrorf(wireAttachmentMeta, "%v", err)
+	}
+	return parseAttachmentMeta(m)
+}
+
+func decodeAnnounceAppData(b []byte) (announceData, error) {
+	d, err := parseAnnounceAppData(b)
+	return d, countWireError(err)
+}
+
+func decodeProfileInfo(v any) (profileInfo, error) {
+	info, err := parseProfileInfo(v)
+	return info, countWireError(err)
+}
+
+func decodeReadReceipt(v any) (readReceipt, error) {
+	r, err := parseReadReceipt(v)
+	return r, countWireError(err)
+}
+
+func decodePresenceSignal(v any) (presenceSignal, error) {
+	s, err := parsePresenceSignal(v)
+	return s, countWireError(err)
+}
+
+func decodeMessageAction(v any) (messageAction, error) {
+	a, err := parseMessageAction(v)
+	return a, countWireError(err)
+}
+
+func decodeGroupControl(v any) (groupControlMsg, error) {
+	c, err := parseGroupControl(v)
+	return c, countWireError(err)
+}
+
+func decodeGroupField(v any) (groupField, error) {
+	f, err := parseGroupField(v)
+	return f, countWireError(err)
+}
+
+func decodeTelemetry(v any) (Telemetry, error) {
+	t, err := parseTelemetry(v)
+	return t, countWireError(err)
+}
+
+func decodeTelemetryStream(v any) ([]telemetryStreamEntry, error) {
+	e, err := parseTelemetryStream(v)
+	return e, countWireError(err)
+}
+
+func decodeTelemetryRequest(v any) (bool, error) {
+	ok, err := parseTelemetryRequest(v)
+	return ok, countWireError(err)
+}
+
+func decodeProfileRequest(v any) ([]byte, error) {
+	h, err := parseProfileRequest(v)
+	return h, countWireError(err)
+}
+
+func decodeProfileResponse(v any) (profileResponse, error) {
+	r, err := parseProfileResponse(v)
+	return r, countWireError(err)
+}
+
+func decodeAvatarRequest(v any) (avatarRequest, error) {
+	r, err := parseAvatarRequest(v)
+	return r, countWireError(err)
+}
+
+func decodeAvatarResponse(v any) (avatarResponse, error) {
+	r, err := parseAvatarResponse(v)
+	return r, countWireError(err)
+}
+
+func decodeAvatarResourceMeta(v any) (avatarMeta, error) {
+	meta, err := parseAvatarResourceMeta(v)
+	return meta, countWireError(err)
+}
+
+func decodeAttachmentRequest(v any) ([]byte, error) {
+	h, err := parseAttachmentRequest(v)
+	return h, countWireError(err)
+}
+
+func decodeAttachmentResponse(v any) (attachmentResponse, error) {
+	r, err := parseAttachmentResponse(v)
+	return r, countWireError(err)
+}
+
+func decodeAttachmentResourceMeta(v any) (attachmentMeta, error) {
+	meta, err := parseAttachmentResourceMeta(v)
+	return meta, countWireError(err)
+}
+
+// wireUnpack is umsgpack.Unpackb for untrusted input. The decoder allocates arrays and maps
+// at their declared length and panics on map keys that are not hashable in Go (eg an array
+// used as a key), so the input is walked with wireCheckMsgpack first and panics are
+// reported as errors.
+func wireUnpack(b []byte, v any) (err error) {
+	if _, err := wireCheckMsgpack(b, 0); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed msgpack: %v", r)
+		}
+	}()
+	return umsgpack.Unpackb(b, v)
+}
+
+// wireCheckMsgpack skips one msgpack object and returns the bytes after it. Every length
+// must fit the remaining input (an element takes at least one byte) and containers nest
+// at most maxMsgpackDepth deep.
+func wireCheckMsgpack(b []byte, depth int) ([]byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack nested too deep")
+	}
+	if len(b) == 0 {
+		return nil, errors.New("truncated msgpack")
+	}
+	c, b := b[0], b[1:]
+	length := func(size int) (int, bool) {
+		if len(b) < size {
+			return 0, false
+		}
+		var n uint64
+		for _, x := range b[:size] {
+			n = n<<8 | uint64(x)
+		}
+		b = b[size:]
+		return int(n), n <= uint64(len(b))
+	}
+	var size, elems int
+	ok := true
+	switch {
+	case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+	case c <= 0x8f:
+		elems = 2 * int(c&0x0f)
+	case c <= 0x9f:
+		elems = int(c & 0x0f)
+	case c <= 0xbf:
+		size = int(c & 0x1f)
+	case c == 0xc4, c == 0xd9:
+		size, ok = length(1)
+	case c == 0xc5, c == 0xda:
+		size, ok = length(2)
+	case c == 0xc6, c == 0xdb:
+		size, ok = length(4)
+	case c >= 0xc7 && c <= 0xc9: // ext: length, type, data
+		size, ok = length(1 << (c - 0xc7))
+		size++
+	case c == 0xca, c == 0xcb:
+		size = 4 << (c - 0xca)
+	case c >= 0xcc && c <= 0xcf:
+		size = 1 << (c - 0xcc)
+	case c >= 0xd0 && c <= 0xd3:
+		size = 1 << (c - 0xd0)
+	case c >= 0xd4 && c <= 0xd8: // fixext: type, data
+		size = 1 + 1<<(c-0xd4)
+	case c == 0xdc:
+		elems, ok = length(2)
+	case c == 0xdd:
+		elems, ok = length(4)
+	case c == 0xde:
+		elems, ok = length(2)
+		elems *= 2
+	case c == 0xdf:
+		elems, ok = length(4)
+		elems *= 2
+	default:
+		return nil, fmt.Errorf("invalid msgpack type 0x%02x", c)
+	}
+	if !ok || size > len(b) || elems > len(b) {
+		return nil, errors.New("truncated msgpack")
+	}
+	b = b[size:]
+	for i := 0; i < elems; i++ {
+		var err error
+		if b, err = wireCheckMsgpack(b, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// wireMap accepts a msgpack map as decoded by umsgpack (map[any]any) or as returned for
+// resource metadata by go-reticulum (map[string]any).
+func wireMap(v any) (map[any]any, bool) {
+	switch m := v.(type) {
+	case map[any]any:
+		return m, true
+	case map[string]any:
+		out := make(map[any]any, len(m))
+		for k, x := range m {
+			out[k] = x
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// Field helpers. An absent key (or nil value) yields the zero value without error.
+
+func wireKind(m map[any]any, want string) error {
+	kind, err := wireTextField(m, "kind", 32)
+	if err != nil {
+		return err
+	}
+	if kind != "" && kind != want {
+		return fmt.Errorf("unexpected kind %q", kind)
+	}
+	return nil
+}
+
+func wireBoolField(m map[any]any, key string) (bool, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%q: want bool, got %T", key, v)
+	}
+	return b, nil
+}
+
+func wireTextField(m map[any]any, key string, maxLen int) (string, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := wireText(v, maxLen)
+	if !ok {
+		return "", fmt.Errorf("%q: invalid text (%T)", key, v)
+	}
+	return s, nil
+}
+
+func wireMimeField(m map[any]any, key string) (string, error) {
+	s, err := wireTextField(m, key, maxMimeLen)
+	if err != nil || s == "" {
+		return s, err
+	}
+	slash := strings.IndexByte(s, '/')
+	if slash <= 0 || slash == len(s)-1 || strings.ContainsAny(s, " \t\r\n;\"\\") {
+		return "", fmt.Errorf("%q: invalid mime %q", key, s)
+	}
+	return strings.ToLower(s), nil
+}
+
+func wireHashField(m map[any]any, key string, size int) ([]byte, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%q: want bytes, got %T", key, v)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("%q: want %d bytes, got %d", key, size, len(b))
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func wireSizeField(m map[any]any, key string, maxSize int) (int, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	n, ok := wireInt(v)
+	if !ok || n < 0 || n > int64(maxSize) {
+		return 0, fmt.Errorf("%q: invalid size", key)
+	}
+	return int(n), nil
+}
+
+func wireTimeField(m map[any]any, key string) (int64, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return 0, nil
+	}
+	n, ok := wireInt(v)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%q: invalid timestamp", key)
+	}
+	return n, nil
+}
+
+// wireText accepts msgpack str or bin holding valid UTF-8 without control characters.
+func wireText(v any, maxLen int) (string, bool) {
+	return wireTextOpt(v, maxLen, false)
+}
+
+// wireTextOpt is wireText that optionally allows newlines and tabs.
+func wireTextOpt(v any, maxLen int, multiline bool) (string, bool) {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	default:
+		return "", false
+	}
+	if len(s) > maxLen || !utf8.ValidString(s) {
+		return "", false
+	}
+	for _, r := range s {
+		if multiline && (r == '\n' || r == '\t') {
+			continue
+		}
+		if r < 0x20 || r == 0x7f {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// wireInt accepts any msgpack integer, and floats that hold an exact integer
+// (some encoders emit float64 for numbers).
+func wireInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		if uint64(n) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	case float32:
+		return wireFloatInt(float64(n))
+	case float64:
+		return wireFloatInt(n)
+	default:
+		return 0, false
+	}
+}
+
+// wireFloat accepts any finite msgpack number.
+func wireFloat(v any) (float64, bool) {
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case float32:
+		f = float64(n)
+	default:
+		i, ok := wireInt(v)
+		return float64(i), ok
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func wireFloatInt(f float64) (int64, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
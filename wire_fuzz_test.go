@@ -0,0 +1,374 @@
+package runcore
+
+import (
+	"bytes"
+	"testing"
+
+	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
+)
+
+// Fuzz targets for the wire parsers. Inputs are msgpack, unpacked the way the RNS and LXMF
+// layers hand payloads to runcore; the parsers must never panic and must not return values
+// that violate their own limits. Seeds are valid encodings produced by the senders.
+
+func fuzzSeed(f *testing.F, v any) {
+	f.Helper()
+	b, err := umsgpack.Packb(v)
+	if err != nil {
+		f.Fatalf("pack seed %#v: %v", v, err)
+	}
+	f.Add(b)
+}
+
+// fuzzWire seeds f with the msgpack encodings of seeds and runs parse on every input that
+// unpacks.
+func fuzzWire(f *testing.F, parse func(t *testing.T, v any), seeds ...any) {
+	for _, s := range seeds {
+		fuzzSeed(f, s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v any
+		if err := wireUnpack(data, &v); err != nil {
+			return
+		}
+		parse(t, v)
+	})
+}
+
+func fuzzBytes(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func fuzzProfileInfo() profileInfo {
+	return profileInfo{
+		Version:      ProfileProtocolVersion,
+		Capabilities: append([]string(nil), capabilityBits...),
+		ProfileHash:  fuzzBytes(profileHashLen, 0x11),
+	}
+}
+
+func fuzzAvatarMeta() map[any]any {
+	return map[any]any{
+		"h":  fuzzBytes(avatarHashLen, 0x22),
+		"t":  "image/png",
+		"s":  1024,
+		"u":  1700000000,
+		"th": fuzzBytes(avatarHashLen, 0x23),
+		"tt": "image/jpeg",
+		"ts": 256,
+	}
+}
+
+func fuzzAttachmentMeta() map[any]any {
+	return map[any]any{
+		"h": fuzzBytes(attachmentHashLen, 0x33),
+		"t": "application/pdf",
+		"n": "report.pdf",
+		"s": 4096,
+		"u": 1700000000,
+	}
+}
+
+func fuzzGroup() *Group {
+	return &Group{
+		IDHex:    "00112233445566778899aabbccddeeff",
+		Name:     "climbing",
+		AdminHex: "0102030405060708090a0b0c0d0e0f10",
+		Members:  []string{"0102030405060708090a0b0c0d0e0f10", "1112131415161718191a1b1c1d1e1f20"},
+		Version:  1700000001,
+		Created:  1700000000,
+	}
+}
+
+func fuzzTelemetry(f *testing.F) []byte {
+	f.Helper()
+	temp := 21.5
+	b, err := Telemetry{
+		Time:     1700000000,
+		Location: &TelemetryLocation{Latitude: 52.52, Longitude: 13.405, Altitude: 34, Speed: 5, Bearing: 90, Accuracy: 3},
+		Battery:  &TelemetryBattery{ChargePercent: 80, Charging: true, Temperature: &temp},
+	}.pack()
+	if err != nil {
+		f.Fatal(err)
+	}
+	return b
+}
+
+func FuzzParseAnnounceAppData(f *testing.F) {
+	profile := fuzzProfileInfo().announceWire()
+	for _, seed := range []any{
+		[]any{[]byte("Alice"), nil},
+		[]any{[]byte("Alice"), 8, fuzzAvatarMeta(), profile},
+		[]any{nil, nil, nil, profile},
+	} {
+		fuzzSeed(f, seed)
+	}
+	f.Add([]byte("legacy name"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d, err := parseAnnounceAppData(data)
+		if err != nil {
+			return
+		}
+		if len(d.DisplayName) > maxDisplayNameLen || d.StampCost < 0 || d.StampCost > 255 {
+			t.Fatalf("out of range result %+v", d)
+		}
+	})
+}
+
+func FuzzParseProfileInfo(f *testing.F) {
+	p := fuzzProfileInfo()
+	fuzzWire(f, func(t *testing.T, v any) {
+		info, err := parseProfileInfo(v)
+		if err != nil {
+			return
+		}
+		for _, c := range info.Capabilities {
+			if !validCapabilityName(c) {
+				t.Fatalf("invalid capability %q accepted", c)
+			}
+		}
+	}, p.wire(), p.announceWire())
+}
+
+func FuzzParseProfileRequest(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		h, err := parseProfileRequest(v)
+		if err == nil && h != nil && len(h) != profileHashLen {
+			t.Fatalf("hash length %d", len(h))
+		}
+	}, nil, map[any]any{"h": fuzzBytes(profileHashLen, 0x11)})
+}
+
+func FuzzParseProfileResponse(f *testing.F) {
+	full := Profile{
+		Status:   "on the road",
+		Bio:      "line one\nline two",
+		Pronouns: "they/them",
+		Links:    []ProfileLink{{Label: "site", URL: "https://example.org"}},
+		Updated:  1700000000,
+	}.wire()
+	full["ok"] = true
+	full["h"] = fuzzBytes(profileHashLen, 0x11)
+	fuzzWire(f, func(t *testing.T, v any) {
+		r, err := parseProfileResponse(v)
+		if err == nil && r.OK && len(r.Hash) != profileHashLen {
+			t.Fatalf("hash length %d", len(r.Hash))
+		}
+	},
+		full,
+		map[any]any{"ok": true, "unchanged": true, "h": fuzzBytes(profileHashLen, 0x11)},
+		map[any]any{"ok": false},
+	)
+}
+
+func FuzzParseReadReceipt(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		r, err := parseReadReceipt(v)
+		if err != nil {
+			return
+		}
+		if len(r.IDs) == 0 || len(r.IDs) > maxReadReceiptIDs {
+			t.Fatalf("%d ids accepted", len(r.IDs))
+		}
+	}, map[any]any{
+		"ids": []any{fuzzBytes(lxmfMessageIDLen, 0x44), fuzzBytes(lxmfMessageIDLen, 0x45)},
+		"t":   1700000000,
+	})
+}
+
+func FuzzParsePresenceSignal(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parsePresenceSignal(v)
+	},
+		map[any]any{"k": presenceTyping, "a": 1700000000},
+		map[any]any{"k": presenceHeartbeatK, "a": 1700000000},
+		map[any]any{"k": presenceBye},
+	)
+}
+
+func FuzzParseMessageAction(f *testing.F) {
+	id := fuzzBytes(lxmfMessageIDLen, 0x44)
+	fuzzWire(f, func(t *testing.T, v any) {
+		a, err := parseMessageAction(v)
+		if err == nil && len(a.ID) != lxmfMessageIDLen {
+			t.Fatalf("id length %d", len(a.ID))
+		}
+	},
+		map[any]any{"id": id, "e": "👍"},
+		map[any]any{"id": id, "e": "👍", "r": true},
+		map[any]any{"id": id, "c": "fixed typo", "u": 1700000000},
+		map[any]any{"id": id},
+	)
+}
+
+func FuzzParseGroupControl(f *testing.F) {
+	g := fuzzGroup()
+	id := fuzzBytes(groupIDLen, 0x55)
+	fuzzWire(f, func(t *testing.T, v any) {
+		msg, err := parseGroupControl(v)
+		if err == nil && len(msg.Group.Members) > maxGroupMembers {
+			t.Fatalf("%d members accepted", len(msg.Group.Members))
+		}
+	},
+		groupControl(groupOpInvite, g),
+		groupControl(groupOpUpdate, g),
+		map[any]any{"op": groupOpJoin, "id": id},
+		map[any]any{"op": groupOpLeave, "id": id},
+	)
+}
+
+func FuzzParseGroupField(f *testing.F) {
+	id := fuzzBytes(groupIDLen, 0x55)
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseGroupField(v)
+	},
+		map[any]any{"id": id, "m": fuzzBytes(lxmfMessageIDLen, 0x44)},
+		map[any]any{"id": id},
+	)
+}
+
+func FuzzParseTelemetry(f *testing.F) {
+	packed := fuzzTelemetry(f)
+	f.Add(packed)
+	fuzzSeed(f, packed) // FIELD_TELEMETRY: the packed telemetry as a bin value
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Raw bytes take the FIELD_TELEMETRY path, the unpacked value the stream path.
+		_, _ = parseTelemetry(data)
+		var v any
+		if wireUnpack(data, &v) == nil {
+			_, _ = parseTelemetry(v)
+		}
+	})
+}
+
+func FuzzParseTelemetryLocation(f *testing.F) {
+	var m map[any]any
+	if err := umsgpack.Unpackb(fuzzTelemetry(f), &m); err != nil {
+		f.Fatal(err)
+	}
+	fuzzWire(f, func(t *testing.T, v any) {
+		loc, err := parseTelemetryLocation(v)
+		if err == nil && (loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180) {
+			t.Fatalf("out of range location %+v", loc)
+		}
+	}, m[int64(sensorLocation)])
+}
+
+func FuzzParseTelemetryBattery(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		bat, err := parseTelemetryBattery(v)
+		if err == nil && (bat.ChargePercent < 0 || bat.ChargePercent > 100) {
+			t.Fatalf("out of range charge %v", bat.ChargePercent)
+		}
+	},
+		[]any{80.5, true, 21.5},
+		[]any{12, false},
+		[]any{100, nil, nil},
+	)
+}
+
+func FuzzParseTelemetryStream(f *testing.F) {
+	src := fuzzBytes(16, 0x66)
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseTelemetryStream(v)
+	},
+		[]any{[]any{src, 1700000000, fuzzTelemetry(f), []any{"person", []byte{0xff, 0xff, 0xff}, []byte{0, 0, 0}}}},
+		[]any{},
+	)
+}
+
+func FuzzParseTelemetryRequest(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseTelemetryRequest(v)
+	},
+		[]any{map[any]any{commandTelemetryRequest: 1700000000}},
+		[]any{map[any]any{commandTelemetryRequest: []any{1700000000, true}}},
+	)
+}
+
+func FuzzParseAvatarMeta(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		m, err := parseAvatarMeta(v)
+		if err == nil && (m.Size > maxAvatarBytes || m.ThumbSize > maxAvatarThumbBytes) {
+			t.Fatalf("out of range sizes %+v", m)
+		}
+	}, fuzzAvatarMeta())
+}
+
+func FuzzParseAvatarRequest(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseAvatarRequest(v)
+	},
+		nil,
+		map[any]any{"h": fuzzBytes(avatarHashLen, 0x22)},
+		map[any]any{"h": fuzzBytes(avatarHashLen, 0x22), "px": 128},
+	)
+}
+
+func FuzzParseAvatarResponse(f *testing.F) {
+	ok := fuzzAvatarMeta()
+	ok["ok"] = true
+	res := fuzzAvatarMeta()
+	res["ok"], res["resource"] = true, true
+	fuzzWire(f, func(t *testing.T, v any) {
+		r, err := parseAvatarResponse(v)
+		if err == nil && len(r.Raw) > maxAvatarBytes {
+			t.Fatalf("raw avatar of %d bytes accepted", len(r.Raw))
+		}
+	},
+		ok,
+		res,
+		map[any]any{"ok": true, "unchanged": true},
+		map[any]any{"ok": false, "error": "no avatar"},
+		[]byte("\x89PNG\r\n\x1a\n"),
+	)
+}
+
+func FuzzParseAvatarResourceMeta(f *testing.F) {
+	m := fuzzAvatarMeta()
+	m["kind"] = profileAvatarRes
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseAvatarResourceMeta(v)
+	}, m)
+}
+
+func FuzzParseAttachmentMeta(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		m, err := parseAttachmentMeta(v)
+		if err == nil && m.Size > maxAttachmentBytes {
+			t.Fatalf("attachment of %d bytes accepted", m.Size)
+		}
+	}, fuzzAttachmentMeta())
+}
+
+func FuzzParseAttachmentRequest(f *testing.F) {
+	fuzzWire(f, func(t *testing.T, v any) {
+		h, err := parseAttachmentRequest(v)
+		if err == nil && len(h) != attachmentHashLen {
+			t.Fatalf("hash length %d", len(h))
+		}
+	}, map[any]any{"h": fuzzBytes(attachmentHashLen, 0x33)})
+}
+
+func FuzzParseAttachmentResponse(f *testing.F) {
+	ok := fuzzAttachmentMeta()
+	ok["ok"], ok["resource"] = true, true
+	fuzzWire(f, func(t *testing.T, v any) {
+		r, err := parseAttachmentResponse(v)
+		if err == nil && len(r.Raw) > maxAttachmentBytes {
+			t.Fatalf("raw attachment of %d bytes accepted", len(r.Raw))
+		}
+	},
+		ok,
+		map[any]any{"ok": false, "error": "not found"},
+		[]byte("%PDF-1.7"),
+	)
+}
+
+func FuzzParseAttachmentResourceMeta(f *testing.F) {
+	m := fuzzAttachmentMeta()
+	m["kind"] = attachmentResKind
+	fuzzWire(f, func(t *testing.T, v any) {
+		_, _ = parseAttachmentResourceMeta(v)
+	}, m)
+}
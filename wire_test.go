@@ -0,0 +1,28 @@
+package runcore
+
+import (
+	"testing"
+
+	umsgpack "github.com/svanichkin/go-reticulum/rns/vendor"
+)
+
+func TestParseAnnounceAppDataBadAvatarKeepsProfile(t *testing.T) {
+	b, err := umsgpack.Packb([]any{[]byte("Alice"), nil, "not a map", fuzzProfileInfo().announceWire()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := WireDecodeErrors()[wireAvatarMeta]
+	d, err := decodeAnnounceAppData(b)
+	if err == nil {
+		t.Fatal("invalid avatar map not reported")
+	}
+	if got := WireDecodeErrors()[wireAvatarMeta]; got != before+1 {
+		t.Fatalf("avatar_meta errors = %d, want %d", got, before+1)
+	}
+	if d.DisplayName != "Alice" || d.Avatar != nil {
+		t.Fatalf("parsed %+v", d)
+	}
+	if d.Profile == nil || len(d.Profile.Capabilities) != len(capabilityBits) {
+		t.Fatalf("profile lost with the avatar: %+v", d.Profile)
+	}
+}